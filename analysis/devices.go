@@ -0,0 +1,216 @@
+package analysis
+
+import (
+	"sort"
+
+	"github.com/rsvihladremio/iostat-reporter/parser"
+)
+
+// deviceMetric is a DeviceStats value whose distribution is worked out.
+type deviceMetric struct {
+	name  string
+	unit  string
+	value func(parser.DeviceStats) float64
+	// when skips samples the value means nothing for, such as the read
+	// latency of a sample without reads
+	when func(parser.DeviceStats) bool
+}
+
+func hasReads(ds parser.DeviceStats) bool     { return ds.ReadsPerSec > 0 }
+func hasWrites(ds parser.DeviceStats) bool    { return ds.WritesPerSec > 0 }
+func hasTransfers(ds parser.DeviceStats) bool { return ds.TransfersPerSec > 0 }
+
+// deviceMetrics lists the metrics in the order they are reported.
+var deviceMetrics = []deviceMetric{
+	{name: "Read IOPS", unit: "req/s", value: func(ds parser.DeviceStats) float64 { return ds.ReadsPerSec }},
+	{name: "Write IOPS", unit: "req/s", value: func(ds parser.DeviceStats) float64 { return ds.WritesPerSec }},
+	{name: "Transfers", unit: "req/s", value: func(ds parser.DeviceStats) float64 { return ds.TransfersPerSec }},
+	{name: "Read throughput", unit: "MB/s", value: func(ds parser.DeviceStats) float64 { return ds.ReadKBPerSec / 1024 }},
+	{name: "Write throughput", unit: "MB/s", value: func(ds parser.DeviceStats) float64 { return ds.WriteKBPerSec / 1024 }},
+	{name: "Read await", unit: "ms", value: func(ds parser.DeviceStats) float64 { return ds.ReadAwaitMs }, when: hasReads},
+	{name: "Write await", unit: "ms", value: func(ds parser.DeviceStats) float64 { return ds.WriteAwaitMs }, when: hasWrites},
+	{name: "Await", unit: "ms", value: func(ds parser.DeviceStats) float64 { return ds.AwaitMs }, when: hasTransfers},
+	{name: "Queue size", unit: "aqu-sz", value: func(ds parser.DeviceStats) float64 { return ds.QueueSize }},
+	{name: "Read request size", unit: "KB", value: func(ds parser.DeviceStats) float64 { return ds.ReadReqSzKB }, when: hasReads},
+	{name: "Write request size", unit: "KB", value: func(ds parser.DeviceStats) float64 { return ds.WriteReqSzKB }, when: hasWrites},
+	{name: "Utilisation", unit: "%", value: func(ds parser.DeviceStats) float64 { return ds.UtilPct }},
+}
+
+// ruleStatistics maps the rule metrics, see deviceColumns, DeviceStatistics
+// keeps to its metric, and the factor from that metric's unit.
+var ruleStatistics = map[string]struct {
+	name  string
+	scale float64
+}{
+	"r/s":      {"Read IOPS", 1},
+	"w/s":      {"Write IOPS", 1},
+	"tps":      {"Transfers", 1},
+	"rMB/s":    {"Read throughput", 1},
+	"wMB/s":    {"Write throughput", 1},
+	"rkB/s":    {"Read throughput", 1024},
+	"wkB/s":    {"Write throughput", 1024},
+	"r_await":  {"Read await", 1},
+	"w_await":  {"Write await", 1},
+	"await":    {"Await", 1},
+	"aqu-sz":   {"Queue size", 1},
+	"rareq-sz": {"Read request size", 1},
+	"wareq-sz": {"Write request size", 1},
+	"%util":    {"Utilisation", 1},
+}
+
+// ruleDistribution returns the distribution of a rule metric of device, and
+// false if s does not keep that metric.
+func (s *DeviceStatistics) ruleDistribution(device, metric string) (metricDistribution, bool) {
+	stat, ok := ruleStatistics[metric]
+	if s == nil || !ok {
+		return metricDistribution{}, false
+	}
+	for i, m := range deviceMetrics {
+		if m.name != stat.name {
+			continue
+		}
+		if dists, ok := s.devices[device]; ok {
+			return metricDistribution{Distribution: &dists[i], scale: stat.scale}, true
+		}
+		return metricDistribution{Distribution: &Distribution{}, scale: stat.scale}, true
+	}
+	return metricDistribution{}, false
+}
+
+// MetricStats describes the distribution of one metric of one device.
+type MetricStats struct {
+	Metric string
+	Unit   string
+	Count  int
+	Mean   float64
+	StdDev float64
+	P50    float64
+	P90    float64
+	P99    float64
+	Max    float64
+	// Exact is false when the percentiles come from a sketch, see ExactLimit.
+	Exact bool
+}
+
+// DeviceMetrics is the distribution of every metric of one device. Metrics
+// no sample had a value for are left out.
+type DeviceMetrics struct {
+	Name    string
+	Metrics []MetricStats
+}
+
+// DeviceStatistics accumulates the distribution of every device metric as
+// samples stream in, so that percentiles of huge captures are not taken
+// from averaged-down data. Since-boot samples are skipped.
+type DeviceStatistics struct {
+	devices map[string][]Distribution
+}
+
+// NewDeviceStatistics returns empty DeviceStatistics.
+func NewDeviceStatistics() *DeviceStatistics {
+	return &DeviceStatistics{devices: make(map[string][]Distribution)}
+}
+
+// Add records the devices of s. Its signature suits parser.Stream, whose
+// callback it can share with a parser.Collector.
+func (s *DeviceStatistics) Add(sample parser.Sample) error {
+	for _, ds := range sample.Devices {
+		s.AddDevice(ds)
+	}
+	return nil
+}
+
+// AddDevice records one device sample.
+func (s *DeviceStatistics) AddDevice(ds parser.DeviceStats) {
+	if ds.SinceBoot {
+		return
+	}
+	dists, ok := s.devices[ds.Name]
+	if !ok {
+		dists = make([]Distribution, len(deviceMetrics))
+		s.devices[ds.Name] = dists
+	}
+	for i, m := range deviceMetrics {
+		if m.when == nil || m.when(ds) {
+			dists[i].Add(m.value(ds))
+		}
+	}
+}
+
+// Merge adds everything recorded in o, as for captures merged with
+// parser.Merge.
+func (s *DeviceStatistics) Merge(o *DeviceStatistics) {
+	if o == nil {
+		return
+	}
+	for name, od := range o.devices {
+		dists, ok := s.devices[name]
+		if !ok {
+			dists = make([]Distribution, len(deviceMetrics))
+			s.devices[name] = dists
+		}
+		for i := range od {
+			dists[i].Merge(&od[i])
+		}
+	}
+}
+
+// Device returns the statistics of one device, and false if it was never
+// recorded.
+func (s *DeviceStatistics) Device(name string) (DeviceMetrics, bool) {
+	if s == nil {
+		return DeviceMetrics{}, false
+	}
+	dists, ok := s.devices[name]
+	if !ok {
+		return DeviceMetrics{}, false
+	}
+	d := DeviceMetrics{Name: name}
+	for i, m := range deviceMetrics {
+		dist := &dists[i]
+		if dist.Count() == 0 {
+			continue
+		}
+		d.Metrics = append(d.Metrics, MetricStats{
+			Metric: m.name,
+			Unit:   m.unit,
+			Count:  dist.Count(),
+			Mean:   dist.Mean(),
+			StdDev: dist.StdDev(),
+			P50:    dist.Percentile(50),
+			P90:    dist.Percentile(90),
+			P99:    dist.Percentile(99),
+			Max:    dist.Max(),
+			Exact:  dist.Exact(),
+		})
+	}
+	return d, true
+}
+
+// Devices returns the statistics of every device, sorted by name.
+func (s *DeviceStatistics) Devices() []DeviceMetrics {
+	names := make([]string, 0, len(s.devices))
+	for name := range s.devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := make([]DeviceMetrics, 0, len(names))
+	for _, name := range names {
+		d, _ := s.Device(name)
+		out = append(out, d)
+	}
+	return out
+}
+
+// DeviceStatisticsOf works out the statistics of already parsed devices.
+func DeviceStatisticsOf(devices map[string][]parser.DeviceStats) *DeviceStatistics {
+	s := NewDeviceStatistics()
+	for name, stats := range devices {
+		for _, ds := range stats {
+			// stats of devices put together by hand may lack the name
+			ds.Name = name
+			s.AddDevice(ds)
+		}
+	}
+	return s
+}
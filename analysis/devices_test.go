@@ -0,0 +1,102 @@
+package analysis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rsvihladremio/iostat-reporter/parser"
+)
+
+// metric returns the named metric of d.
+func metric(t *testing.T, d DeviceMetrics, name string) MetricStats {
+	t.Helper()
+	for _, m := range d.Metrics {
+		if m.Metric == name {
+			return m
+		}
+	}
+	t.Fatalf("no metric %q", name)
+	return MetricStats{}
+}
+
+func TestDeviceStatistics(t *testing.T) {
+	now := time.Date(2024, 9, 4, 12, 0, 0, 0, time.UTC)
+	s := NewDeviceStatistics()
+	samples := []parser.Sample{
+		{Timestamp: now, SinceBoot: true, Devices: []parser.DeviceStats{
+			{Name: "sda", ReadsPerSec: 1000, ReadAwaitMs: 1000, SinceBoot: true},
+		}},
+		{Timestamp: now.Add(time.Second), Devices: []parser.DeviceStats{
+			{Name: "sda", ReadsPerSec: 10, ReadKBPerSec: 2048, ReadAwaitMs: 2, QueueSize: 1},
+			{Name: "sdb", WritesPerSec: 5},
+		}},
+		{Timestamp: now.Add(2 * time.Second), Devices: []parser.DeviceStats{
+			{Name: "sda", ReadsPerSec: 30, ReadKBPerSec: 1024, ReadAwaitMs: 6, QueueSize: 3},
+		}},
+		{Timestamp: now.Add(3 * time.Second), Devices: []parser.DeviceStats{
+			{Name: "sda", QueueSize: 2},
+		}},
+	}
+	for _, sample := range samples {
+		require.NoError(t, s.Add(sample))
+	}
+
+	devices := s.Devices()
+	require.Len(t, devices, 2)
+	sda := devices[0]
+	assert.Equal(t, "sda", sda.Name)
+
+	iops := metric(t, sda, "Read IOPS")
+	assert.Equal(t, 3, iops.Count)
+	assert.InDelta(t, 40.0/3, iops.Mean, 1e-9)
+	assert.InDelta(t, 30, iops.Max, 1e-9)
+	assert.True(t, iops.Exact)
+
+	// latency only counts samples with reads
+	await := metric(t, sda, "Read await")
+	assert.Equal(t, 2, await.Count)
+	assert.InDelta(t, 4, await.P50, 1e-9)
+	assert.InDelta(t, 6, await.Max, 1e-9)
+	assert.InDelta(t, 2, await.StdDev, 1e-9)
+
+	mb := metric(t, sda, "Read throughput")
+	assert.Equal(t, "MB/s", mb.Unit)
+	assert.InDelta(t, 2, mb.Max, 1e-9)
+
+	queue := metric(t, sda, "Queue size")
+	assert.InDelta(t, 2, queue.P50, 1e-9)
+	assert.InDelta(t, 2.98, queue.P99, 1e-9)
+
+	// sdb never read, so it has no read latency
+	for _, m := range devices[1].Metrics {
+		assert.NotEqual(t, "Read await", m.Metric)
+	}
+
+	_, ok := s.Device("sdc")
+	assert.False(t, ok)
+	var none *DeviceStatistics
+	_, ok = none.Device("sda")
+	assert.False(t, ok)
+}
+
+func TestDeviceStatisticsMerge(t *testing.T) {
+	devices := map[string][]parser.DeviceStats{
+		"sda": {{ReadsPerSec: 1}, {ReadsPerSec: 3}},
+	}
+	a := DeviceStatisticsOf(devices)
+	a.Merge(DeviceStatisticsOf(map[string][]parser.DeviceStats{
+		"sda": {{ReadsPerSec: 5}},
+		"sdb": {{WritesPerSec: 2}},
+	}))
+	sda, ok := a.Device("sda")
+	require.True(t, ok)
+	iops := metric(t, sda, "Read IOPS")
+	assert.Equal(t, 3, iops.Count)
+	assert.InDelta(t, 3, iops.P50, 1e-9)
+	assert.InDelta(t, 5, iops.Max, 1e-9)
+	_, ok = a.Device("sdb")
+	assert.True(t, ok)
+}
@@ -0,0 +1,180 @@
+package analysis
+
+import (
+	"math"
+	"sort"
+)
+
+// ExactLimit is how many values a Distribution keeps to work out exact
+// percentiles. Beyond it, percentiles come from a sketch whose results are
+// within SketchAccuracy of the true value.
+const ExactLimit = 20000
+
+// SketchAccuracy is the relative error of percentiles taken from a sketch.
+const SketchAccuracy = 0.01
+
+// Distribution accumulates the values of one metric as they stream in:
+// count, mean, standard deviation and maximum are always exact, percentiles
+// while there are at most ExactLimit values. The zero value is empty and
+// ready to use.
+type Distribution struct {
+	count int
+	// mean and m2 follow Welford's online algorithm
+	mean, m2 float64
+	max      float64
+	// values holds every value until there are too many for it
+	values []float64
+	sketch *sketch
+}
+
+// Add records v.
+func (d *Distribution) Add(v float64) {
+	d.count++
+	delta := v - d.mean
+	d.mean += delta / float64(d.count)
+	d.m2 += delta * (v - d.mean)
+	if d.count == 1 || v > d.max {
+		d.max = v
+	}
+	if d.sketch != nil {
+		d.sketch.add(v, 1)
+		return
+	}
+	d.values = append(d.values, v)
+	if len(d.values) > ExactLimit {
+		d.toSketch()
+	}
+}
+
+// Merge adds every value recorded in o.
+func (d *Distribution) Merge(o *Distribution) {
+	if o == nil || o.count == 0 {
+		return
+	}
+	if d.count == 0 {
+		d.max = o.max
+	}
+	// Chan et al.'s parallel form of Welford's algorithm
+	n := float64(d.count + o.count)
+	delta := o.mean - d.mean
+	d.m2 += o.m2 + delta*delta*float64(d.count)*float64(o.count)/n
+	d.mean += delta * float64(o.count) / n
+	d.count += o.count
+	d.max = math.Max(d.max, o.max)
+
+	if o.sketch != nil && d.sketch == nil {
+		d.toSketch()
+	}
+	switch {
+	case d.sketch == nil:
+		d.values = append(d.values, o.values...)
+		if len(d.values) > ExactLimit {
+			d.toSketch()
+		}
+	case o.sketch != nil:
+		d.sketch.merge(o.sketch)
+	default:
+		for _, v := range o.values {
+			d.sketch.add(v, 1)
+		}
+	}
+}
+
+// toSketch moves the values kept so far into a sketch.
+func (d *Distribution) toSketch() {
+	d.sketch = newSketch()
+	for _, v := range d.values {
+		d.sketch.add(v, 1)
+	}
+	d.values = nil
+}
+
+// Count returns how many values were recorded.
+func (d *Distribution) Count() int { return d.count }
+
+// Mean returns the mean of the values, zero for none.
+func (d *Distribution) Mean() float64 { return d.mean }
+
+// StdDev returns the population standard deviation of the values.
+func (d *Distribution) StdDev() float64 {
+	if d.count == 0 {
+		return 0
+	}
+	return math.Sqrt(d.m2 / float64(d.count))
+}
+
+// Max returns the largest value, zero for none.
+func (d *Distribution) Max() float64 { return d.max }
+
+// Exact reports whether percentiles are exact rather than from a sketch.
+func (d *Distribution) Exact() bool { return d.sketch == nil }
+
+// Percentile returns the p-th percentile of the values, see Percentile.
+func (d *Distribution) Percentile(p float64) float64 {
+	if d.sketch != nil {
+		return math.Min(d.sketch.quantile(p/100), d.max)
+	}
+	return Percentile(d.values, p)
+}
+
+// sketch is a DDSketch: values are counted in buckets whose bounds grow
+// geometrically, so that any value returned is within SketchAccuracy of a
+// value that was recorded at that rank, with memory growing only with the
+// logarithm of the range of values.
+type sketch struct {
+	gamma    float64
+	logGamma float64
+	buckets  map[int]int
+	// zeros counts values too small for a bucket, metrics are never negative
+	zeros int
+	count int
+}
+
+// minSketchValue is the smallest value given a bucket of its own.
+const minSketchValue = 1e-9
+
+func newSketch() *sketch {
+	gamma := (1 + SketchAccuracy) / (1 - SketchAccuracy)
+	return &sketch{gamma: gamma, logGamma: math.Log(gamma), buckets: make(map[int]int)}
+}
+
+func (s *sketch) add(v float64, n int) {
+	s.count += n
+	if v < minSketchValue {
+		s.zeros += n
+		return
+	}
+	s.buckets[int(math.Ceil(math.Log(v)/s.logGamma))] += n
+}
+
+func (s *sketch) merge(o *sketch) {
+	s.count += o.count
+	s.zeros += o.zeros
+	for k, n := range o.buckets {
+		s.buckets[k] += n
+	}
+}
+
+// quantile returns the value at quantile q, from 0 to 1.
+func (s *sketch) quantile(q float64) float64 {
+	if s.count == 0 {
+		return 0
+	}
+	rank := int(math.Round(q * float64(s.count-1)))
+	if rank < s.zeros {
+		return 0
+	}
+	keys := make([]int, 0, len(s.buckets))
+	for k := range s.buckets {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	seen := s.zeros
+	for _, k := range keys {
+		seen += s.buckets[k]
+		if seen > rank {
+			return 2 * math.Pow(s.gamma, float64(k)) / (s.gamma + 1)
+		}
+	}
+	return 2 * math.Pow(s.gamma, float64(keys[len(keys)-1])) / (s.gamma + 1)
+}
@@ -0,0 +1,83 @@
+package analysis
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDistributionExact(t *testing.T) {
+	var d Distribution
+	for _, v := range []float64{2, 4, 4, 4, 5, 5, 7, 9} {
+		d.Add(v)
+	}
+	assert.Equal(t, 8, d.Count())
+	assert.InDelta(t, 5, d.Mean(), 1e-9)
+	assert.InDelta(t, 2, d.StdDev(), 1e-9)
+	assert.InDelta(t, 9, d.Max(), 1e-9)
+	assert.InDelta(t, 4.5, d.Percentile(50), 1e-9)
+	assert.InDelta(t, 9, d.Percentile(100), 1e-9)
+	assert.True(t, d.Exact())
+
+	var empty Distribution
+	assert.Zero(t, empty.Percentile(99))
+	assert.Zero(t, empty.StdDev())
+}
+
+// TestDistributionSketch switches to the sketch beyond ExactLimit and stays
+// within SketchAccuracy.
+func TestDistributionSketch(t *testing.T) {
+	const n = 5 * ExactLimit
+	var d Distribution
+	for i := 1; i <= n; i++ {
+		d.Add(float64(i))
+	}
+	assert.False(t, d.Exact())
+	assert.Equal(t, n, d.Count())
+	assert.InDelta(t, float64(n+1)/2, d.Mean(), 1e-6)
+	assert.InDelta(t, n, d.Max(), 1e-9)
+	for _, p := range []float64{50, 90, 99} {
+		want := p / 100 * n
+		assert.InEpsilon(t, want, d.Percentile(p), SketchAccuracy*1.5, "p%v", p)
+	}
+	// idle samples report zero, which the sketch keeps apart
+	var idle Distribution
+	for i := 0; i < 2*ExactLimit; i++ {
+		idle.Add(0)
+	}
+	idle.Add(10)
+	assert.Zero(t, idle.Percentile(99))
+	assert.InDelta(t, 10, idle.Percentile(100), 0.1)
+}
+
+// TestDistributionMerge gives the same results as recording everything in
+// one distribution, exact or sketched.
+func TestDistributionMerge(t *testing.T) {
+	for _, n := range []int{100, 3 * ExactLimit} {
+		var whole, a, b Distribution
+		for i := 0; i < n; i++ {
+			v := math.Mod(float64(i)*7.3, 250)
+			whole.Add(v)
+			if i%3 == 0 {
+				a.Add(v)
+			} else {
+				b.Add(v)
+			}
+		}
+		a.Merge(&b)
+		assert.Equal(t, whole.Count(), a.Count())
+		assert.InDelta(t, whole.Mean(), a.Mean(), 1e-6)
+		assert.InDelta(t, whole.StdDev(), a.StdDev(), 1e-6)
+		assert.InDelta(t, whole.Max(), a.Max(), 1e-9)
+		assert.Equal(t, whole.Exact(), a.Exact())
+		assert.InEpsilon(t, whole.Percentile(90), a.Percentile(90), 2*SketchAccuracy)
+	}
+
+	var empty Distribution
+	var one Distribution
+	one.Add(3)
+	empty.Merge(&one)
+	assert.InDelta(t, 3, empty.Max(), 1e-9)
+	assert.InDelta(t, 3, empty.Percentile(50), 1e-9)
+}
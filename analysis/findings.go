@@ -0,0 +1,487 @@
+package analysis
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/rsvihladremio/iostat-reporter/parser"
+)
+
+// maxRanges caps the evidence of a finding to its longest runs.
+const maxRanges = 5
+
+// Finding is a plain-language conclusion a rule drew from a capture.
+type Finding struct {
+	Rule     string
+	Severity Severity
+	Scope    Scope
+	Metric   string
+	// Device is the device a device rule matched, empty for other scopes.
+	Device  string
+	Message string
+	// Pct is the share of samples that matched, since-boot reports left out.
+	Pct float64
+	// Ranges are the longest runs of matching samples, at most maxRanges,
+	// in time order.
+	Ranges []TimeRange
+}
+
+// TimeRange spans a run of matching samples, from the first to the last.
+type TimeRange struct {
+	Start, End time.Time
+}
+
+// Evaluate checks data against rules and returns the findings, most severe
+// and most frequent first. It fails only on an invalid rule or message.
+func Evaluate(data parser.ParsedData, rules []Rule) ([]Finding, error) {
+	e, err := NewEvaluator(rules)
+	if err != nil {
+		return nil, err
+	}
+	e.interval = data.Interval
+	for _, cs := range data.CPUs {
+		e.AddCPU(cs)
+	}
+	for _, vm := range data.VM {
+		e.AddVM(vm)
+	}
+	return e.Findings(data.Devices)
+}
+
+// Evaluator checks rules against samples as they stream in, so that long
+// captures are not judged on averaged-down data. It keeps, for the CPU,
+// vmstat and each device, the distribution of each metric a rule reads and,
+// for each rule, the samples matched and the longest runs of them.
+// Since-boot samples are skipped.
+type Evaluator struct {
+	rules []compiledRule
+	// interval joins matching samples into runs, see add
+	interval time.Duration
+	cpu, vm  *ruleTarget
+	devices  map[string]*ruleTarget
+	// stats, when shared, hold the distributions of the device metrics
+	// DeviceStatistics keeps
+	stats *DeviceStatistics
+}
+
+// NewEvaluator returns an Evaluator checking rules. It fails only on an
+// invalid rule.
+func NewEvaluator(rules []Rule) (*Evaluator, error) {
+	e := &Evaluator{devices: make(map[string]*ruleTarget)}
+	for _, rule := range rules {
+		r, err := rule.compile()
+		if err != nil {
+			return nil, err
+		}
+		e.rules = append(e.rules, r)
+	}
+	e.cpu = e.newTarget(ScopeCPU, "")
+	e.vm = e.newTarget(ScopeVM, "")
+	return e, nil
+}
+
+// ShareDeviceStatistics has e read the device metrics s keeps from s rather
+// than keep them twice. s must record the same device samples as e, and be
+// shared before any is added.
+func (e *Evaluator) ShareDeviceStatistics(s *DeviceStatistics) {
+	e.stats = s
+}
+
+// Empty returns an Evaluator checking the same rules with nothing recorded.
+func (e *Evaluator) Empty() *Evaluator {
+	out := &Evaluator{rules: e.rules, interval: e.interval, devices: make(map[string]*ruleTarget)}
+	out.cpu = out.newTarget(ScopeCPU, "")
+	out.vm = out.newTarget(ScopeVM, "")
+	return out
+}
+
+// Add records the CPU, devices and vmstat report of sample. Its signature
+// suits parser.Stream.
+func (e *Evaluator) Add(sample parser.Sample) error {
+	if sample.CPU != nil {
+		e.AddCPU(*sample.CPU)
+	}
+	for _, ds := range sample.Devices {
+		e.AddDevice(ds)
+	}
+	if sample.VM != nil {
+		e.AddVM(*sample.VM)
+	}
+	return nil
+}
+
+// AddCPU records one CPU sample.
+func (e *Evaluator) AddCPU(cs parser.CPUStats) {
+	if !cs.SinceBoot {
+		add(e.cpu, e.rules, e.interval, cs.Timestamp, cs, cpuMetrics)
+	}
+}
+
+// AddDevice records one device sample.
+func (e *Evaluator) AddDevice(ds parser.DeviceStats) {
+	if ds.SinceBoot {
+		return
+	}
+	t, ok := e.devices[ds.Name]
+	if !ok {
+		t = e.sharedTarget(ds.Name)
+		e.devices[ds.Name] = t
+	}
+	add(t, e.rules, e.interval, ds.Timestamp, ds, deviceColumns)
+}
+
+// AddVM records one vmstat report.
+func (e *Evaluator) AddVM(vm parser.VMStats) {
+	if !vm.SinceBoot {
+		add(e.vm, e.rules, e.interval, vm.Timestamp, vm, vmMetrics)
+	}
+}
+
+// Merge adds everything recorded in o, which must check the same rules, as
+// for captures merged with parser.Merge. withCPU leaves out the CPU samples
+// of o when false, as parser.Merge does for a capture whose CPU report
+// another one covers. Runs do not go on from one capture into the other.
+func (e *Evaluator) Merge(o *Evaluator, withCPU bool) {
+	if o == nil {
+		return
+	}
+	if withCPU {
+		e.cpu.merge(o.cpu)
+	}
+	e.vm.merge(o.vm)
+	for name, ot := range o.devices {
+		t, ok := e.devices[name]
+		if !ok {
+			t = e.sharedTarget(name)
+			e.devices[name] = t
+		}
+		t.merge(ot)
+	}
+}
+
+// Findings returns the findings of the samples recorded, most severe and
+// most frequent first. Device rules are checked against devices: a device
+// recorded is judged on what was recorded, any other on the samples given,
+// such as a device rolled up from others. It fails only on an invalid
+// message.
+func (e *Evaluator) Findings(devices map[string][]parser.DeviceStats) ([]Finding, error) {
+	names := make([]string, 0, len(devices))
+	for name := range devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	targets := make([]*ruleTarget, 0, len(names))
+	for _, name := range names {
+		t, ok := e.devices[name]
+		if !ok {
+			t = e.newTarget(ScopeDevice, name)
+			for _, ds := range devices[name] {
+				if !ds.SinceBoot {
+					add(t, e.rules, e.interval, ds.Timestamp, ds, deviceColumns)
+				}
+			}
+		}
+		targets = append(targets, t)
+	}
+
+	var findings []Finding
+	for i, r := range e.rules {
+		var scoped []*ruleTarget
+		switch r.Scope {
+		case ScopeCPU:
+			scoped = []*ruleTarget{e.cpu}
+		case ScopeVM:
+			scoped = []*ruleTarget{e.vm}
+		case ScopeDevice:
+			scoped = targets
+		}
+		for _, t := range scoped {
+			if t.runs[i] == nil {
+				continue
+			}
+			f, ok, err := r.finding(t, t.runs[i])
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				findings = append(findings, f)
+			}
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		if a, b := findings[i].Severity.rank(), findings[j].Severity.rank(); a != b {
+			return a > b
+		}
+		return findings[i].Pct > findings[j].Pct
+	})
+	return findings, nil
+}
+
+// ruleTarget is what an Evaluator keeps of one scope, or one device.
+type ruleTarget struct {
+	device  string
+	samples int
+	// metrics are the distributions of the metrics in needs, all of them
+	// when needs is nil, but those read from stats
+	metrics map[string]*Distribution
+	needs   map[string]bool
+	stats   *DeviceStatistics
+	// runs holds what each rule matched, by rule index, nil for a rule that
+	// does not apply
+	runs []*ruleRuns
+	// last is the time of the latest sample and minStep the smallest step
+	// between samples so far
+	last    time.Time
+	minStep time.Duration
+}
+
+// ruleRuns is what one rule matched of a ruleTarget.
+type ruleRuns struct {
+	matched int
+	// current is the run the latest sample matched, if open
+	open    bool
+	current TimeRange
+	// longest are the longest runs ended so far, at most maxRanges
+	longest []TimeRange
+}
+
+// newTarget returns an empty ruleTarget for the rules of scope that apply
+// to device.
+func (e *Evaluator) newTarget(scope Scope, device string) *ruleTarget {
+	t := &ruleTarget{device: device, metrics: make(map[string]*Distribution), runs: make([]*ruleRuns, len(e.rules))}
+	needs := make(map[string]bool)
+	for i, r := range e.rules {
+		if r.Scope != scope || (r.devices != nil && !r.devices.MatchString(device)) {
+			continue
+		}
+		t.runs[i] = &ruleRuns{}
+		names, all := r.metrics()
+		if all {
+			needs = nil
+		}
+		for _, name := range names {
+			if needs != nil {
+				needs[name] = true
+			}
+		}
+	}
+	t.needs = needs
+	return t
+}
+
+// sharedTarget returns newTarget of a device whose samples e records, read
+// from the shared DeviceStatistics if any.
+func (e *Evaluator) sharedTarget(device string) *ruleTarget {
+	t := e.newTarget(ScopeDevice, device)
+	t.stats = e.stats
+	return t
+}
+
+// add records one sample of t taken at at. A run goes on over consecutive
+// matching samples, but not across a gap: a step of more than twice
+// interval or, when that is unknown while streaming, twice the smallest
+// step so far.
+func add[T any](t *ruleTarget, rules []compiledRule, interval time.Duration, at time.Time, sample T, metrics map[string]ruleMetric[T]) {
+	step := at.Sub(t.last)
+	if t.samples > 0 && step > 0 && (t.minStep == 0 || step < t.minStep) {
+		t.minStep = step
+	}
+	if interval <= 0 {
+		interval = t.minStep
+	}
+	join := t.samples > 0 && (interval <= 0 || step <= 2*interval)
+	t.samples++
+	t.last = at
+
+	for name, m := range metrics {
+		if !t.keeps(name) || (m.when != nil && !m.when(sample)) {
+			continue
+		}
+		d, ok := t.metrics[name]
+		if !ok {
+			d = &Distribution{}
+			t.metrics[name] = d
+		}
+		d.Add(m.value(sample))
+	}
+	for i, r := range rules {
+		runs := t.runs[i]
+		if runs == nil {
+			continue
+		}
+		m := metrics[r.Metric]
+		if (m.when != nil && !m.when(sample)) || !r.match(m.value(sample)) {
+			runs.end()
+			continue
+		}
+		runs.matched++
+		if runs.open && join {
+			runs.current.End = at
+			continue
+		}
+		runs.end()
+		runs.current, runs.open = TimeRange{Start: at, End: at}, true
+	}
+}
+
+// merge adds everything recorded in o, ending the runs of both.
+func (t *ruleTarget) merge(o *ruleTarget) {
+	t.samples += o.samples
+	for name, od := range o.metrics {
+		d, ok := t.metrics[name]
+		if !ok {
+			d = &Distribution{}
+			t.metrics[name] = d
+		}
+		d.Merge(od)
+	}
+	if o.minStep > 0 && (t.minStep == 0 || o.minStep < t.minStep) {
+		t.minStep = o.minStep
+	}
+	if o.last.After(t.last) {
+		t.last = o.last
+	}
+	for i, runs := range t.runs {
+		or := o.runs[i]
+		if runs == nil || or == nil {
+			continue
+		}
+		runs.end()
+		runs.matched += or.matched
+		for _, run := range or.ranges() {
+			runs.keep(run)
+		}
+	}
+}
+
+// keeps reports whether t keeps the distribution of metric itself.
+func (t *ruleTarget) keeps(metric string) bool {
+	if t.needs != nil && !t.needs[metric] {
+		return false
+	}
+	_, shared := t.stats.ruleDistribution(t.device, metric)
+	return !shared
+}
+
+// distribution returns the distribution of a metric over t, empty when no
+// sample had it.
+func (t *ruleTarget) distribution(metric string) metricDistribution {
+	if d, ok := t.stats.ruleDistribution(t.device, metric); ok {
+		return d
+	}
+	if d, ok := t.metrics[metric]; ok {
+		return metricDistribution{Distribution: d, scale: 1}
+	}
+	return metricDistribution{Distribution: &Distribution{}, scale: 1}
+}
+
+// metricDistribution is a Distribution read in the unit of a rule metric.
+type metricDistribution struct {
+	*Distribution
+	scale float64
+}
+
+func (d metricDistribution) Mean() float64 { return d.Distribution.Mean() * d.scale }
+func (d metricDistribution) Max() float64  { return d.Distribution.Max() * d.scale }
+func (d metricDistribution) Percentile(p float64) float64 {
+	return d.Distribution.Percentile(p) * d.scale
+}
+
+// end ends the current run, if open.
+func (r *ruleRuns) end() {
+	if r.open {
+		r.keep(r.current)
+		r.open = false
+	}
+}
+
+// keep adds run to the longest runs, the earliest first among runs as long.
+func (r *ruleRuns) keep(run TimeRange) {
+	r.longest = append(r.longest, run)
+	sort.SliceStable(r.longest, func(i, j int) bool {
+		a, b := r.longest[i], r.longest[j]
+		if da, db := a.End.Sub(a.Start), b.End.Sub(b.Start); da != db {
+			return da > db
+		}
+		return a.Start.Before(b.Start)
+	})
+	r.longest = r.longest[:min(len(r.longest), maxRanges)]
+}
+
+// ranges returns the longest runs, the current one included, in time order.
+func (r *ruleRuns) ranges() []TimeRange {
+	all := *r
+	all.longest = append([]TimeRange(nil), r.longest...)
+	all.end()
+	sort.Slice(all.longest, func(i, j int) bool { return all.longest[i].Start.Before(all.longest[j].Start) })
+	return all.longest
+}
+
+// messageData is what rule messages are executed with, see Rule.Message.
+type messageData struct {
+	Device    string
+	Metric    string
+	Threshold float64
+	Pct       float64
+	Samples   int
+	Matched   int
+	Mean      float64
+	P50       float64
+	P90       float64
+	P99       float64
+	Max       float64
+}
+
+// finding reports whether the rule matched enough samples of t for a
+// finding, runs being what it matched.
+func (r compiledRule) finding(t *ruleTarget, runs *ruleRuns) (Finding, bool, error) {
+	share := pct(runs.matched, t.samples)
+	if runs.matched == 0 || share < r.MinPct {
+		return Finding{}, false, nil
+	}
+
+	dist := t.distribution(r.Metric)
+	data := messageData{
+		Device:    t.device,
+		Metric:    r.Metric,
+		Threshold: r.Threshold,
+		Pct:       share,
+		Samples:   t.samples,
+		Matched:   runs.matched,
+		Mean:      dist.Mean(),
+		P50:       dist.Percentile(50),
+		P90:       dist.Percentile(90),
+		P99:       dist.Percentile(99),
+		Max:       dist.Max(),
+	}
+	tmpl, err := r.message.Clone()
+	if err != nil {
+		return Finding{}, false, err
+	}
+	tmpl.Funcs(template.FuncMap{
+		"percentile": func(p float64, metric string) (float64, error) {
+			if _, ok := scopeMetrics[r.Scope][metric]; !ok {
+				return 0, fmt.Errorf("unknown %s metric %q", r.Scope, metric)
+			}
+			return t.distribution(metric).Percentile(p), nil
+		},
+	})
+	var msg strings.Builder
+	if err := tmpl.Execute(&msg, data); err != nil {
+		return Finding{}, false, fmt.Errorf("rule %q: %w", r.Name, err)
+	}
+
+	return Finding{
+		Rule:     r.Name,
+		Severity: r.Severity,
+		Scope:    r.Scope,
+		Metric:   r.Metric,
+		Device:   t.device,
+		Message:  strings.TrimSpace(msg.String()),
+		Pct:      share,
+		Ranges:   runs.ranges(),
+	}, true, nil
+}
@@ -0,0 +1,203 @@
+package analysis
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rsvihladremio/iostat-reporter/parser"
+)
+
+// findingsData has ten one-second samples after the since-boot one, with
+// nvme1n1 saturated in two runs and steal high at the end.
+func findingsData() parser.ParsedData {
+	now := time.Date(2024, 9, 4, 12, 0, 0, 0, time.UTC)
+	at := func(i int) time.Time { return now.Add(time.Duration(i) * time.Second) }
+	data := parser.ParsedData{
+		Interval: time.Second,
+		Devices:  map[string][]parser.DeviceStats{},
+	}
+	data.CPUs = append(data.CPUs, parser.CPUStats{Timestamp: at(0), Steal: 50, SinceBoot: true})
+	data.Devices["nvme1n1"] = append(data.Devices["nvme1n1"],
+		parser.DeviceStats{Timestamp: at(0), WritesPerSec: 1, WriteAwaitMs: 900, QueueSize: 9, SinceBoot: true})
+	for i := 1; i <= 10; i++ {
+		cs := parser.CPUStats{Timestamp: at(i), User: 10, Idle: 90}
+		if i > 8 {
+			cs.Steal, cs.Idle = 12, 78
+		}
+		data.CPUs = append(data.CPUs, cs)
+
+		ds := parser.DeviceStats{Timestamp: at(i), WritesPerSec: 100, WriteAwaitMs: 2, QueueSize: 0.2}
+		if (i >= 2 && i <= 4) || i == 7 {
+			ds.WriteAwaitMs, ds.QueueSize = 120, 4
+		}
+		data.Devices["nvme1n1"] = append(data.Devices["nvme1n1"], ds)
+		data.Devices["sda"] = append(data.Devices["sda"], parser.DeviceStats{Timestamp: at(i), ReadsPerSec: 5, ReadAwaitMs: 1})
+	}
+	return data
+}
+
+func TestEvaluateBuiltinRules(t *testing.T) {
+	findings, err := Evaluate(findingsData(), BuiltinRules())
+	require.NoError(t, err)
+
+	byRule := map[string]Finding{}
+	for _, f := range findings {
+		byRule[f.Rule+"/"+f.Device] = f
+	}
+	sat, ok := byRule["device-saturated/nvme1n1"]
+	require.True(t, ok, "findings: %+v", findings)
+	assert.Equal(t, SeverityWarning, sat.Severity)
+	assert.Equal(t, ScopeDevice, sat.Scope)
+	assert.InDelta(t, 40, sat.Pct, 1e-9)
+	assert.Equal(t, "nvme1n1 was saturated 40% of the time (aqu-sz > 1) with p99 write latency 120 ms", sat.Message)
+
+	// two runs, the since-boot sample left out
+	start := time.Date(2024, 9, 4, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, []TimeRange{
+		{Start: start.Add(2 * time.Second), End: start.Add(4 * time.Second)},
+		{Start: start.Add(7 * time.Second), End: start.Add(7 * time.Second)},
+	}, sat.Ranges)
+
+	steal, ok := byRule["cpu-steal/"]
+	require.True(t, ok)
+	assert.Empty(t, steal.Device)
+	assert.InDelta(t, 20, steal.Pct, 1e-9)
+	assert.Contains(t, steal.Message, "noisy neighbours")
+
+	_, ok = byRule["device-saturated/sda"]
+	assert.False(t, ok, "sda was never saturated")
+
+	// most severe, then most frequent first
+	for i := 1; i < len(findings); i++ {
+		a, b := findings[i-1], findings[i]
+		assert.True(t, a.Severity.rank() > b.Severity.rank() ||
+			(a.Severity == b.Severity && a.Pct >= b.Pct), "%s before %s", a.Rule, b.Rule)
+	}
+}
+
+func TestEvaluateMinPctAndDevices(t *testing.T) {
+	rules := []Rule{
+		{Name: "often", Scope: ScopeDevice, Metric: "aqu-sz", Threshold: 1, MinPct: 50, Severity: SeverityCritical, Message: "{{.Device}}"},
+		{Name: "sda-only", Scope: ScopeDevice, Metric: "r_await", Op: "<=", Threshold: 1, Devices: "sd.*", Severity: SeverityInfo,
+			Message: "{{.Device}} {{.Matched}}/{{.Samples}} p50 {{num .P50}} w {{percentile 50 \"w_await\" | num}}"},
+	}
+	findings, err := Evaluate(findingsData(), rules)
+	require.NoError(t, err)
+	require.Len(t, findings, 1)
+	assert.Equal(t, "sda 10/10 p50 1 w 0", findings[0].Message)
+	require.Len(t, findings[0].Ranges, 1)
+}
+
+func TestEvaluateRangesSplitAtGaps(t *testing.T) {
+	now := time.Date(2024, 9, 4, 12, 0, 0, 0, time.UTC)
+	data := parser.ParsedData{Interval: time.Second}
+	for _, s := range []int{0, 1, 2, 60, 61} {
+		data.CPUs = append(data.CPUs, parser.CPUStats{Timestamp: now.Add(time.Duration(s) * time.Second), Iowait: 50})
+	}
+	rules := []Rule{{Name: "io", Scope: ScopeCPU, Metric: "iowait", Threshold: 10, Severity: SeverityWarning, Message: "io"}}
+	findings, err := Evaluate(data, rules)
+	require.NoError(t, err)
+	require.Len(t, findings, 1)
+	assert.Len(t, findings[0].Ranges, 2)
+}
+
+// TestEvaluatorStreamed finds the same as Evaluate when fed one sample at a
+// time, without knowing the interval.
+func TestEvaluatorStreamed(t *testing.T) {
+	data := findingsData()
+	e, err := NewEvaluator(BuiltinRules())
+	require.NoError(t, err)
+	for i, cs := range data.CPUs {
+		sample := parser.Sample{Timestamp: cs.Timestamp, CPU: &cs}
+		for _, name := range []string{"nvme1n1", "sda"} {
+			if i < len(data.Devices[name]) && data.Devices[name][i].Timestamp.Equal(cs.Timestamp) {
+				ds := data.Devices[name][i]
+				ds.Name = name
+				sample.Devices = append(sample.Devices, ds)
+			}
+		}
+		require.NoError(t, e.Add(sample))
+	}
+	want, err := Evaluate(data, BuiltinRules())
+	require.NoError(t, err)
+	got, err := e.Findings(data.Devices)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	// a capture without devices leaves its CPU to this one
+	cpuOnly := e.Empty()
+	for i := 0; i < 10; i++ {
+		cpuOnly.AddCPU(parser.CPUStats{Steal: 50})
+	}
+	merged := e.Empty()
+	merged.Merge(e, true)
+	merged.Merge(cpuOnly, false)
+	got, err = merged.Findings(data.Devices)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
+
+// TestEvaluatorKeepsWhatRulesRead keeps only the metrics rules read, and
+// reads device metrics from shared DeviceStatistics.
+func TestEvaluatorKeepsWhatRulesRead(t *testing.T) {
+	data := findingsData()
+	rules := []Rule{
+		{Name: "queue", Scope: ScopeDevice, Metric: "aqu-sz", Threshold: 1, Severity: SeverityWarning,
+			Message: `{{.Device}} p99 {{num .P99}} w {{percentile 99 "w_await" | num}} d {{percentile 50 "d/s" | num}}`},
+		{Name: "steal", Scope: ScopeCPU, Metric: "steal", Threshold: 5, Severity: SeverityWarning, Message: "steal"},
+	}
+	want, err := Evaluate(data, rules)
+	require.NoError(t, err)
+
+	e, err := NewEvaluator(rules)
+	require.NoError(t, err)
+	stats := NewDeviceStatistics()
+	e.ShareDeviceStatistics(stats)
+	for _, cs := range data.CPUs {
+		e.AddCPU(cs)
+	}
+	for name, series := range data.Devices {
+		for _, ds := range series {
+			ds.Name = name
+			e.AddDevice(ds)
+			stats.AddDevice(ds)
+		}
+	}
+	got, err := e.Findings(data.Devices)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	assert.Equal(t, []string{"steal"}, keysOf(e.cpu.metrics))
+	// aqu-sz and w_await come from the statistics, d/s they do not keep
+	assert.Equal(t, []string{"d/s"}, keysOf(e.devices["nvme1n1"].metrics))
+}
+
+func keysOf(m map[string]*Distribution) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestEvaluatorRangesSplitAtGaps(t *testing.T) {
+	now := time.Date(2024, 9, 4, 12, 0, 0, 0, time.UTC)
+	rules := []Rule{{Name: "io", Scope: ScopeCPU, Metric: "iowait", Threshold: 10, Severity: SeverityWarning, Message: "io"}}
+	e, err := NewEvaluator(rules)
+	require.NoError(t, err)
+	for _, s := range []int{0, 1, 2, 60, 61} {
+		e.AddCPU(parser.CPUStats{Timestamp: now.Add(time.Duration(s) * time.Second), Iowait: 50})
+	}
+	findings, err := e.Findings(nil)
+	require.NoError(t, err)
+	require.Len(t, findings, 1)
+	assert.Equal(t, []TimeRange{
+		{Start: now, End: now.Add(2 * time.Second)},
+		{Start: now.Add(60 * time.Second), End: now.Add(61 * time.Second)},
+	}, findings[0].Ranges)
+}
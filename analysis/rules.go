@@ -0,0 +1,354 @@
+package analysis
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"text/template"
+	"text/template/parse"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/rsvihladremio/iostat-reporter/parser"
+)
+
+// Severity ranks a finding.
+type Severity string
+
+const (
+	SeverityInfo     Severity = "info"
+	SeverityWarning  Severity = "warning"
+	SeverityCritical Severity = "critical"
+)
+
+// rank orders severities, most severe highest, zero for an unknown one.
+func (s Severity) rank() int {
+	switch s {
+	case SeverityInfo:
+		return 1
+	case SeverityWarning:
+		return 2
+	case SeverityCritical:
+		return 3
+	}
+	return 0
+}
+
+// Scope is what a rule looks at.
+type Scope string
+
+const (
+	// ScopeCPU rules look at the CPU report of iostat, sar or mpstat.
+	ScopeCPU Scope = "cpu"
+	// ScopeDevice rules look at each device on its own.
+	ScopeDevice Scope = "device"
+	// ScopeVM rules look at vmstat reports.
+	ScopeVM Scope = "vm"
+)
+
+// Rule raises a finding when a metric crosses a threshold often enough.
+// Rules are usually loaded with ParseRules.
+type Rule struct {
+	// Name identifies the rule; a rule replaces an earlier one of the same
+	// name, see MergeRules.
+	Name  string `json:"name" yaml:"name"`
+	Scope Scope  `json:"scope" yaml:"scope"`
+	// Metric is named after the column that prints it, such as "aqu-sz",
+	// "w_await" or "steal", see Metrics.
+	Metric string `json:"metric" yaml:"metric"`
+	// Op compares each sample with Threshold: ">", ">=", "<" or "<=".
+	// It defaults to ">".
+	Op        string  `json:"op,omitempty" yaml:"op,omitempty"`
+	Threshold float64 `json:"threshold" yaml:"threshold"`
+	// MinPct is the share of samples that must match for a finding.
+	MinPct float64 `json:"min_pct,omitempty" yaml:"min_pct,omitempty"`
+	// Devices, a regular expression, limits a device rule to the devices
+	// whose whole name it matches.
+	Devices  string   `json:"devices,omitempty" yaml:"devices,omitempty"`
+	Severity Severity `json:"severity" yaml:"severity"`
+	// Message is a text/template describing the finding. It is given
+	// .Device, .Metric, .Threshold, the share of samples matching as .Pct,
+	// .Samples and .Matched, and .Mean, .P50, .P90, .P99 and .Max of the
+	// metric. num rounds a number to one decimal and percentile P "metric"
+	// works out any other percentile or metric of the same samples.
+	Message string `json:"message" yaml:"message"`
+}
+
+// ruleFile is the layout of a rules file.
+type ruleFile struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+}
+
+//go:embed rules.yaml
+var builtinRules []byte
+
+// BuiltinRules returns the rules every report is checked against.
+func BuiltinRules() []Rule {
+	rules, err := ParseRules(builtinRules)
+	if err != nil {
+		panic(fmt.Sprintf("built-in rules: %v", err))
+	}
+	return rules
+}
+
+// ParseRules reads rules from YAML or JSON: an object whose "rules" list
+// holds one object per Rule, keyed like its YAML tags. Every rule is
+// checked, so mistakes show up when the file is loaded.
+func ParseRules(data []byte) ([]Rule, error) {
+	var f ruleFile
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		dec := json.NewDecoder(bytes.NewReader(trimmed))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&f); err != nil {
+			return nil, fmt.Errorf("invalid JSON rules: %w", err)
+		}
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("invalid YAML rules: %w", err)
+		}
+	}
+	seen := make(map[string]bool, len(f.Rules))
+	for i, r := range f.Rules {
+		if _, err := r.compile(); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i+1, err)
+		}
+		if seen[r.Name] {
+			return nil, fmt.Errorf("rule %q is defined twice", r.Name)
+		}
+		seen[r.Name] = true
+	}
+	return f.Rules, nil
+}
+
+// MergeRules returns rules followed by extra, where a rule of extra replaces
+// the rule of rules with the same name in place.
+func MergeRules(rules, extra []Rule) []Rule {
+	out := append([]Rule(nil), rules...)
+	index := make(map[string]int, len(out))
+	for i, r := range out {
+		index[r.Name] = i
+	}
+	for _, r := range extra {
+		if i, ok := index[r.Name]; ok {
+			out[i] = r
+			continue
+		}
+		index[r.Name] = len(out)
+		out = append(out, r)
+	}
+	return out
+}
+
+// compiledRule is a checked Rule ready to be evaluated.
+type compiledRule struct {
+	Rule
+	match   func(v float64) bool
+	devices *regexp.Regexp
+	message *template.Template
+}
+
+func (r Rule) compile() (compiledRule, error) {
+	c := compiledRule{Rule: r}
+	if r.Name == "" {
+		return c, errors.New("rule has no name")
+	}
+	metrics, ok := scopeMetrics[r.Scope]
+	if !ok {
+		return c, fmt.Errorf("rule %q has unknown scope %q (want cpu, device or vm)", r.Name, r.Scope)
+	}
+	if _, ok := metrics[r.Metric]; !ok {
+		return c, fmt.Errorf("rule %q has unknown %s metric %q", r.Name, r.Scope, r.Metric)
+	}
+	switch t := r.Threshold; r.Op {
+	case "", ">":
+		c.match = func(v float64) bool { return v > t }
+	case ">=":
+		c.match = func(v float64) bool { return v >= t }
+	case "<":
+		c.match = func(v float64) bool { return v < t }
+	case "<=":
+		c.match = func(v float64) bool { return v <= t }
+	default:
+		return c, fmt.Errorf("rule %q has unknown op %q (want >, >=, < or <=)", r.Name, r.Op)
+	}
+	if r.Severity.rank() == 0 {
+		return c, fmt.Errorf("rule %q has unknown severity %q (want info, warning or critical)", r.Name, r.Severity)
+	}
+	if r.MinPct < 0 || r.MinPct > 100 {
+		return c, fmt.Errorf("rule %q has min_pct %v outside 0 to 100", r.Name, r.MinPct)
+	}
+	if r.Devices != "" {
+		if r.Scope != ScopeDevice {
+			return c, fmt.Errorf("rule %q limits devices but has scope %s", r.Name, r.Scope)
+		}
+		re, err := regexp.Compile("^(?:" + r.Devices + ")$")
+		if err != nil {
+			return c, fmt.Errorf("rule %q has invalid devices: %w", r.Name, err)
+		}
+		c.devices = re
+	}
+	if r.Message == "" {
+		return c, fmt.Errorf("rule %q has no message", r.Name)
+	}
+	// percentile is replaced with one over the samples evaluated
+	tmpl, err := template.New(r.Name).Option("missingkey=error").Funcs(template.FuncMap{
+		"num":        formatNumber,
+		"percentile": func(float64, string) (float64, error) { return 0, nil },
+	}).Parse(r.Message)
+	if err != nil {
+		return c, fmt.Errorf("rule %q has invalid message: %w", r.Name, err)
+	}
+	c.message = tmpl
+	return c, nil
+}
+
+// metrics returns the metrics the rule reads: its own and those its message
+// takes a percentile of. all is set when the message names a metric other
+// than by a quoted string, so that any may be read.
+func (r compiledRule) metrics() (names []string, all bool) {
+	names = []string{r.Metric}
+	var walk func(parse.Node)
+	walk = func(n parse.Node) {
+		switch n := n.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, c := range n.Nodes {
+				walk(c)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walk(&n.BranchNode)
+		case *parse.RangeNode:
+			walk(&n.BranchNode)
+		case *parse.WithNode:
+			walk(&n.BranchNode)
+		case *parse.BranchNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, c := range n.Cmds {
+				walk(c)
+			}
+		case *parse.CommandNode:
+			if id, ok := n.Args[0].(*parse.IdentifierNode); ok && id.Ident == "percentile" {
+				if len(n.Args) < 3 {
+					all = true
+				} else if s, ok := n.Args[2].(*parse.StringNode); ok {
+					names = append(names, s.Text)
+				} else {
+					all = true
+				}
+			}
+			for _, a := range n.Args {
+				walk(a)
+			}
+		}
+	}
+	for _, t := range r.message.Templates() {
+		if t.Tree != nil {
+			walk(t.Tree.Root)
+		}
+	}
+	return names, all
+}
+
+// formatNumber prints v with at most one decimal, as findings read best.
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(math.Round(v*10)/10, 'f', -1, 64)
+}
+
+// ruleMetric reads one value from a sample of its scope. when, if set, skips
+// samples the value means nothing for.
+type ruleMetric[T any] struct {
+	value func(T) float64
+	when  func(T) bool
+}
+
+// cpuMetrics are the CPU metrics rules can use, named as iostat prints them
+// without the %. busy is user, nice, system and steal together.
+var cpuMetrics = map[string]ruleMetric[parser.CPUStats]{
+	"user":   {value: func(c parser.CPUStats) float64 { return c.User }},
+	"nice":   {value: func(c parser.CPUStats) float64 { return c.Nice }},
+	"system": {value: func(c parser.CPUStats) float64 { return c.System }},
+	"iowait": {value: func(c parser.CPUStats) float64 { return c.Iowait }},
+	"steal":  {value: func(c parser.CPUStats) float64 { return c.Steal }},
+	"idle":   {value: func(c parser.CPUStats) float64 { return c.Idle }},
+	"busy":   {value: parser.CPUStats.BusyPct},
+}
+
+// deviceColumns are the device metrics rules can use, named after the
+// iostat -x columns. Throughput is also offered in MB/s.
+var deviceColumns = map[string]ruleMetric[parser.DeviceStats]{
+	"r/s":      {value: func(ds parser.DeviceStats) float64 { return ds.ReadsPerSec }},
+	"w/s":      {value: func(ds parser.DeviceStats) float64 { return ds.WritesPerSec }},
+	"d/s":      {value: func(ds parser.DeviceStats) float64 { return ds.DiscardsPerSec }},
+	"f/s":      {value: func(ds parser.DeviceStats) float64 { return ds.FlushesPerSec }},
+	"tps":      {value: func(ds parser.DeviceStats) float64 { return ds.TransfersPerSec }},
+	"rkB/s":    {value: func(ds parser.DeviceStats) float64 { return ds.ReadKBPerSec }},
+	"wkB/s":    {value: func(ds parser.DeviceStats) float64 { return ds.WriteKBPerSec }},
+	"dkB/s":    {value: func(ds parser.DeviceStats) float64 { return ds.DiscardKBPerSec }},
+	"rMB/s":    {value: func(ds parser.DeviceStats) float64 { return ds.ReadKBPerSec / 1024 }},
+	"wMB/s":    {value: func(ds parser.DeviceStats) float64 { return ds.WriteKBPerSec / 1024 }},
+	"r_await":  {value: func(ds parser.DeviceStats) float64 { return ds.ReadAwaitMs }, when: hasReads},
+	"w_await":  {value: func(ds parser.DeviceStats) float64 { return ds.WriteAwaitMs }, when: hasWrites},
+	"await":    {value: func(ds parser.DeviceStats) float64 { return ds.AwaitMs }, when: hasTransfers},
+	"rareq-sz": {value: func(ds parser.DeviceStats) float64 { return ds.ReadReqSzKB }, when: hasReads},
+	"wareq-sz": {value: func(ds parser.DeviceStats) float64 { return ds.WriteReqSzKB }, when: hasWrites},
+	"aqu-sz":   {value: func(ds parser.DeviceStats) float64 { return ds.QueueSize }},
+	"%util":    {value: func(ds parser.DeviceStats) float64 { return ds.UtilPct }},
+}
+
+// vmMetrics are the vmstat metrics rules can use, named after its columns.
+var vmMetrics = map[string]ruleMetric[parser.VMStats]{
+	"r":    {value: func(vm parser.VMStats) float64 { return vm.Running }},
+	"b":    {value: func(vm parser.VMStats) float64 { return vm.Blocked }},
+	"swpd": {value: func(vm parser.VMStats) float64 { return vm.SwapUsedKB }},
+	"free": {value: func(vm parser.VMStats) float64 { return vm.FreeKB }},
+	"si":   {value: func(vm parser.VMStats) float64 { return vm.SwapInKBPerSec }},
+	"so":   {value: func(vm parser.VMStats) float64 { return vm.SwapOutKBPerSec }},
+	"in":   {value: func(vm parser.VMStats) float64 { return vm.InterruptsPerSec }},
+	"cs":   {value: func(vm parser.VMStats) float64 { return vm.ContextSwitchesPerSec }},
+}
+
+// scopeMetrics lists the metric names of each scope.
+var scopeMetrics = map[Scope]map[string]struct{}{
+	ScopeCPU:    keys(cpuMetrics),
+	ScopeDevice: keys(deviceColumns),
+	ScopeVM:     keys(vmMetrics),
+}
+
+func keys[T any](m map[string]ruleMetric[T]) map[string]struct{} {
+	out := make(map[string]struct{}, len(m))
+	for k := range m {
+		out[k] = struct{}{}
+	}
+	return out
+}
+
+// Metrics returns the metric names rules of scope can use.
+func Metrics(scope Scope) []string {
+	names := make([]string, 0, len(scopeMetrics[scope]))
+	for name := range scopeMetrics[scope] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,78 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuiltinRules(t *testing.T) {
+	rules := BuiltinRules()
+	require.NotEmpty(t, rules)
+	for _, r := range rules {
+		_, err := r.compile()
+		assert.NoError(t, err, r.Name)
+	}
+}
+
+func TestParseRulesYAMLAndJSON(t *testing.T) {
+	yamlRules := `
+rules:
+  - name: slow-nvme
+    scope: device
+    devices: nvme.*
+    metric: w_await
+    op: ">="
+    threshold: 10
+    min_pct: 1
+    severity: critical
+    message: "{{.Device}} writes slow"
+`
+	jsonRules := `{
+	"rules": [{
+		"name": "slow-nvme", "scope": "device", "devices": "nvme.*", "metric": "w_await",
+		"op": ">=", "threshold": 10, "min_pct": 1, "severity": "critical",
+		"message": "{{.Device}} writes slow"
+	}]
+}`
+	want := []Rule{{Name: "slow-nvme", Scope: ScopeDevice, Devices: "nvme.*", Metric: "w_await", Op: ">=",
+		Threshold: 10, MinPct: 1, Severity: SeverityCritical, Message: "{{.Device}} writes slow"}}
+	for name, src := range map[string]string{"yaml": yamlRules, "json": jsonRules} {
+		rules, err := ParseRules([]byte(src))
+		require.NoError(t, err, name)
+		assert.Equal(t, want, rules, name)
+	}
+}
+
+func TestParseRulesErrors(t *testing.T) {
+	for _, src := range []string{
+		"rules: [{name: a, scope: disk, metric: aqu-sz, severity: info, message: m}]",
+		"rules: [{name: a, scope: device, metric: queue, severity: info, message: m}]",
+		"rules: [{name: a, scope: cpu, metric: steal, op: '!=', severity: info, message: m}]",
+		"rules: [{name: a, scope: cpu, metric: steal, severity: fatal, message: m}]",
+		"rules: [{name: a, scope: cpu, metric: steal, devices: sd.*, severity: info, message: m}]",
+		"rules: [{name: a, scope: cpu, metric: steal, severity: info, message: '{{.Oops'}]",
+		"rules: [{name: a, scope: cpu, metric: steal, severity: info}]",
+		"rules: [{name: a, scope: cpu, metric: steal, severity: info, message: m, colour: red}]",
+		"rules: [{name: a, scope: cpu, metric: steal, severity: info, message: m}, {name: a, scope: cpu, metric: idle, severity: info, message: m}]",
+		`{"rules": [{"name": "a", "scope": "cpu", "metric": "steal", "severity": "info", "message": "m", "colour": "red"}]}`,
+	} {
+		_, err := ParseRules([]byte(src))
+		assert.Error(t, err, src)
+	}
+}
+
+func TestMergeRules(t *testing.T) {
+	base := []Rule{{Name: "a", Threshold: 1}, {Name: "b", Threshold: 2}}
+	merged := MergeRules(base, []Rule{{Name: "c"}, {Name: "a", Threshold: 5}})
+	assert.Equal(t, []Rule{{Name: "a", Threshold: 5}, {Name: "b", Threshold: 2}, {Name: "c"}}, merged)
+	assert.Equal(t, 1.0, base[0].Threshold, "base is left alone")
+}
+
+func TestMetrics(t *testing.T) {
+	assert.Contains(t, Metrics(ScopeDevice), "aqu-sz")
+	assert.Contains(t, Metrics(ScopeCPU), "steal")
+	assert.Contains(t, Metrics(ScopeVM), "so")
+	assert.Empty(t, Metrics("disk"))
+}
@@ -0,0 +1,287 @@
+// Package analysis works out from parsed captures how often a host was
+// saturated, how its device metrics were distributed and what findings
+// rules draw from them, for the top of a report.
+package analysis
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rsvihladremio/iostat-reporter/parser"
+)
+
+// Thresholds decide when a sample counts as saturated. Samples count when
+// they are strictly above the threshold.
+type Thresholds struct {
+	// Iowait is the %iowait above which the host counts as I/O bound.
+	Iowait float64
+	// CPUBusyLow and CPUBusy are the busy percentages (user, nice, system
+	// and steal) above which the CPU counts as saturated. Both are reported,
+	// whatever the host: the low one is for hosts running two threads per
+	// core (SMT), whose siblings share a core's units well before 100%, the
+	// other for one thread per core.
+	CPUBusyLow float64
+	CPUBusy    float64
+	// QueueDepth is the aqu-sz above which a device counts as saturated.
+	QueueDepth float64
+}
+
+// DefaultThresholds returns the thresholds used unless others are given:
+// 10% iowait, 50% and 90% CPU busy and a queue depth of 1.0.
+func DefaultThresholds() Thresholds {
+	return Thresholds{Iowait: 10, CPUBusyLow: 50, CPUBusy: 90, QueueDepth: 1}
+}
+
+// ParseThresholds overrides the defaults with a comma-separated list of
+// key=value pairs, such as "iowait=20,queue=2". The keys are iowait,
+// cpu-low, cpu and queue.
+func ParseThresholds(s string) (Thresholds, error) {
+	t := DefaultThresholds()
+	if strings.TrimSpace(s) == "" {
+		return t, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return t, fmt.Errorf("threshold %q is not key=value", pair)
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil || v < 0 {
+			return t, fmt.Errorf("threshold %s has invalid value %q", key, value)
+		}
+		switch key {
+		case "iowait":
+			t.Iowait = v
+		case "cpu-low":
+			t.CPUBusyLow = v
+		case "cpu":
+			t.CPUBusy = v
+		case "queue":
+			t.QueueDepth = v
+		default:
+			return t, fmt.Errorf("unknown threshold %q (want iowait, cpu-low, cpu or queue)", key)
+		}
+	}
+	return t, nil
+}
+
+// Summary is how often a capture crossed each threshold. Percentages are
+// shares of the samples, since-boot reports left out.
+type Summary struct {
+	Thresholds Thresholds
+	// CPUSamples counts the CPU samples the percentages are taken over.
+	CPUSamples    int
+	IowaitPct     float64
+	CPUBusyLowPct float64
+	CPUBusyPct    float64
+	// Devices is sorted by name.
+	Devices []DeviceSummary
+}
+
+// DeviceSummary is how often one device was saturated and how long its
+// requests usually waited.
+type DeviceSummary struct {
+	Name    string
+	Samples int
+	// SaturatedPct is the share of samples above the queue depth threshold.
+	SaturatedPct float64
+	// P50AwaitMs is the median await of the samples with any requests, as
+	// idle samples report no latency at all.
+	P50AwaitMs float64
+}
+
+// Summarize works out the Summary of data.
+func Summarize(data parser.ParsedData, t Thresholds) Summary {
+	s := NewSummarizer(t)
+	for _, cs := range data.CPUs {
+		s.AddCPU(cs)
+	}
+	for name, stats := range data.Devices {
+		for _, ds := range stats {
+			ds.Name = name
+			s.AddDevice(ds)
+		}
+	}
+	return s.Summary()
+}
+
+// SummarizeDevice works out the DeviceSummary of one device's samples.
+func SummarizeDevice(name string, stats []parser.DeviceStats, t Thresholds) DeviceSummary {
+	s := NewSummarizer(t)
+	for _, ds := range stats {
+		ds.Name = name
+		s.AddDevice(ds)
+	}
+	d, _ := s.Device(name)
+	d.Name = name
+	return d
+}
+
+// Summarizer accumulates the Summary of a capture as samples stream in, so
+// that long captures are not summarised from averaged-down data. Since-boot
+// samples are skipped.
+type Summarizer struct {
+	thresholds        Thresholds
+	cpuSamples        int
+	iowait, low, busy int
+	devices           map[string]*deviceCounts
+}
+
+// deviceCounts is what a Summarizer keeps of one device.
+type deviceCounts struct {
+	samples, saturated int
+	awaits             Distribution
+}
+
+// NewSummarizer returns an empty Summarizer counting against t.
+func NewSummarizer(t Thresholds) *Summarizer {
+	return &Summarizer{thresholds: t, devices: make(map[string]*deviceCounts)}
+}
+
+// Thresholds returns the thresholds s counts against.
+func (s *Summarizer) Thresholds() Thresholds { return s.thresholds }
+
+// Add records the CPU and devices of sample. Its signature suits
+// parser.Stream, like DeviceStatistics.Add.
+func (s *Summarizer) Add(sample parser.Sample) error {
+	if sample.CPU != nil {
+		s.AddCPU(*sample.CPU)
+	}
+	for _, ds := range sample.Devices {
+		s.AddDevice(ds)
+	}
+	return nil
+}
+
+// AddCPU records one CPU sample.
+func (s *Summarizer) AddCPU(cs parser.CPUStats) {
+	if cs.SinceBoot {
+		return
+	}
+	t := s.thresholds
+	s.cpuSamples++
+	if cs.Iowait > t.Iowait {
+		s.iowait++
+	}
+	if cs.BusyPct() > t.CPUBusyLow {
+		s.low++
+	}
+	if cs.BusyPct() > t.CPUBusy {
+		s.busy++
+	}
+}
+
+// AddDevice records one device sample.
+func (s *Summarizer) AddDevice(ds parser.DeviceStats) {
+	if ds.SinceBoot {
+		return
+	}
+	d, ok := s.devices[ds.Name]
+	if !ok {
+		d = &deviceCounts{}
+		s.devices[ds.Name] = d
+	}
+	d.samples++
+	if ds.QueueSize > s.thresholds.QueueDepth {
+		d.saturated++
+	}
+	// idle samples report no latency at all
+	if hasTransfers(ds) {
+		d.awaits.Add(ds.AwaitMs)
+	}
+}
+
+// Merge adds everything recorded in o, as for captures merged with
+// parser.Merge. withCPU leaves out the CPU samples of o when false, as
+// parser.Merge does for a capture whose CPU report another one covers.
+func (s *Summarizer) Merge(o *Summarizer, withCPU bool) {
+	if o == nil {
+		return
+	}
+	if withCPU {
+		s.cpuSamples += o.cpuSamples
+		s.iowait += o.iowait
+		s.low += o.low
+		s.busy += o.busy
+	}
+	for name, od := range o.devices {
+		d, ok := s.devices[name]
+		if !ok {
+			d = &deviceCounts{}
+			s.devices[name] = d
+		}
+		d.samples += od.samples
+		d.saturated += od.saturated
+		d.awaits.Merge(&od.awaits)
+	}
+}
+
+// Summary returns the Summary of everything recorded, every device
+// included.
+func (s *Summarizer) Summary() Summary {
+	sum := Summary{
+		Thresholds:    s.thresholds,
+		CPUSamples:    s.cpuSamples,
+		IowaitPct:     pct(s.iowait, s.cpuSamples),
+		CPUBusyLowPct: pct(s.low, s.cpuSamples),
+		CPUBusyPct:    pct(s.busy, s.cpuSamples),
+	}
+	names := make([]string, 0, len(s.devices))
+	for name := range s.devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		d, _ := s.Device(name)
+		sum.Devices = append(sum.Devices, d)
+	}
+	return sum
+}
+
+// Device returns the DeviceSummary of one device, and false if it was never
+// recorded.
+func (s *Summarizer) Device(name string) (DeviceSummary, bool) {
+	if s == nil {
+		return DeviceSummary{}, false
+	}
+	d, ok := s.devices[name]
+	if !ok {
+		return DeviceSummary{}, false
+	}
+	return DeviceSummary{
+		Name:         name,
+		Samples:      d.samples,
+		SaturatedPct: pct(d.saturated, d.samples),
+		P50AwaitMs:   d.awaits.Percentile(50),
+	}, true
+}
+
+// Percentile returns the p-th percentile of values, interpolating between
+// the closest ranks, or zero for no values. values is not modified.
+func Percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	rank := p / 100 * float64(len(sorted)-1)
+	lo, hi := int(math.Floor(rank)), int(math.Ceil(rank))
+	if lo < 0 {
+		return sorted[0]
+	}
+	if hi >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(rank-float64(lo))
+}
+
+// pct returns n as a percentage of total, or zero for no samples.
+func pct(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total) * 100
+}
@@ -0,0 +1,133 @@
+package analysis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rsvihladremio/iostat-reporter/parser"
+)
+
+// summaryData has four CPU and device samples after the since-boot one.
+func summaryData() parser.ParsedData {
+	now := time.Date(2024, 9, 4, 12, 0, 0, 0, time.UTC)
+	at := func(i int) time.Time { return now.Add(time.Duration(i) * time.Second) }
+	return parser.ParsedData{
+		CPUs: []parser.CPUStats{
+			{Timestamp: at(0), User: 99, Iowait: 50, SinceBoot: true},
+			{Timestamp: at(1), User: 20, System: 5, Iowait: 2},
+			{Timestamp: at(2), User: 40, System: 15, Iowait: 12},
+			{Timestamp: at(3), User: 80, System: 15, Iowait: 10},
+			{Timestamp: at(4), User: 30, Steal: 25, Iowait: 30},
+		},
+		Devices: map[string][]parser.DeviceStats{
+			"sdb": {
+				{Timestamp: at(1), TransfersPerSec: 10, AwaitMs: 1, QueueSize: 0.2},
+			},
+			"sda": {
+				{Timestamp: at(0), TransfersPerSec: 100, AwaitMs: 100, QueueSize: 9, SinceBoot: true},
+				{Timestamp: at(1), TransfersPerSec: 10, AwaitMs: 2, QueueSize: 0.5},
+				{Timestamp: at(2), TransfersPerSec: 50, AwaitMs: 8, QueueSize: 1.5},
+				{Timestamp: at(3), AwaitMs: 0, QueueSize: 0},
+				{Timestamp: at(4), TransfersPerSec: 40, AwaitMs: 4, QueueSize: 1.0},
+			},
+		},
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	s := Summarize(summaryData(), DefaultThresholds())
+
+	assert.Equal(t, 4, s.CPUSamples)
+	// iowait 12 and 30, not 10 which is not above the threshold
+	assert.InDelta(t, 50, s.IowaitPct, 1e-9)
+	// busy 25, 55, 95 and 55
+	assert.InDelta(t, 75, s.CPUBusyLowPct, 1e-9)
+	assert.InDelta(t, 25, s.CPUBusyPct, 1e-9)
+
+	require.Len(t, s.Devices, 2)
+	sda := s.Devices[0]
+	assert.Equal(t, "sda", sda.Name)
+	assert.Equal(t, 4, sda.Samples)
+	assert.InDelta(t, 25, sda.SaturatedPct, 1e-9)
+	// the idle sample has no latency to speak of
+	assert.InDelta(t, 4, sda.P50AwaitMs, 1e-9)
+	assert.Equal(t, "sdb", s.Devices[1].Name)
+	assert.InDelta(t, 0, s.Devices[1].SaturatedPct, 1e-9)
+}
+
+func TestSummarizeThresholds(t *testing.T) {
+	limits, err := ParseThresholds("iowait=5, cpu=50,queue=0.4")
+	require.NoError(t, err)
+	assert.Equal(t, Thresholds{Iowait: 5, CPUBusyLow: 50, CPUBusy: 50, QueueDepth: 0.4}, limits)
+
+	s := Summarize(summaryData(), limits)
+	assert.InDelta(t, 75, s.IowaitPct, 1e-9)
+	assert.InDelta(t, 75, s.CPUBusyPct, 1e-9)
+	assert.InDelta(t, 75, s.Devices[0].SaturatedPct, 1e-9)
+
+	limits, err = ParseThresholds("cpu-low=60")
+	require.NoError(t, err)
+	assert.InDelta(t, 60, limits.CPUBusyLow, 1e-9)
+}
+
+func TestParseThresholds(t *testing.T) {
+	limits, err := ParseThresholds("")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultThresholds(), limits)
+
+	for _, bad := range []string{"iowait", "iowait=x", "queue=-1", "disk=3"} {
+		_, err := ParseThresholds(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+// TestSummarizerMerge summarises a capture streamed in two parts.
+func TestSummarizerMerge(t *testing.T) {
+	data := summaryData()
+	first, second := NewSummarizer(DefaultThresholds()), NewSummarizer(DefaultThresholds())
+	for i, cs := range data.CPUs {
+		part := first
+		if i > 2 {
+			part = second
+		}
+		sample := parser.Sample{CPU: &cs}
+		for name, stats := range data.Devices {
+			for _, ds := range stats {
+				if ds.Timestamp.Equal(cs.Timestamp) {
+					ds.Name = name
+					sample.Devices = append(sample.Devices, ds)
+				}
+			}
+		}
+		require.NoError(t, part.Add(sample))
+	}
+	first.Merge(second, true)
+	assert.Equal(t, Summarize(data, DefaultThresholds()), first.Summary())
+
+	cpuOnly := NewSummarizer(DefaultThresholds())
+	cpuOnly.AddCPU(parser.CPUStats{Iowait: 90})
+	first.Merge(cpuOnly, false)
+	assert.Equal(t, 4, first.Summary().CPUSamples)
+}
+
+func TestSummarizeEmpty(t *testing.T) {
+	s := Summarize(parser.ParsedData{}, DefaultThresholds())
+	assert.Zero(t, s.CPUSamples)
+	assert.Zero(t, s.IowaitPct)
+	assert.Empty(t, s.Devices)
+}
+
+func TestPercentile(t *testing.T) {
+	values := []float64{4, 1, 3, 2}
+	assert.InDelta(t, 2.5, Percentile(values, 50), 1e-9)
+	assert.InDelta(t, 1, Percentile(values, 0), 1e-9)
+	assert.InDelta(t, 4, Percentile(values, 100), 1e-9)
+	assert.InDelta(t, 3.7, Percentile(values, 90), 1e-9)
+	assert.InDelta(t, 7, Percentile([]float64{7}, 99), 1e-9)
+	assert.Zero(t, Percentile(nil, 50))
+	// the input keeps its order
+	assert.Equal(t, []float64{4, 1, 3, 2}, values)
+}
@@ -0,0 +1,164 @@
+// Package bundle finds iostat captures inside diagnostic bundles: tar
+// archives, plain or compressed with any format the parser reads, and zip
+// archives.
+package bundle
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/rsvihladremio/iostat-reporter/parser"
+)
+
+var (
+	zipMagic = []byte("PK\x03\x04")
+	// tarMagic sits at offset 257 of the first header, in POSIX and GNU tar
+	tarMagic  = []byte("ustar")
+	tarOffset = 257
+)
+
+// IsZip reports whether prefix, the start of an input, is a zip archive.
+func IsZip(prefix []byte) bool {
+	return bytes.HasPrefix(prefix, zipMagic)
+}
+
+// IsArchive reports whether prefix, the start of an input, is a tar or zip
+// archive. A compressed tar is recognised from its first decompressed block.
+func IsArchive(prefix []byte) bool {
+	return IsZip(prefix) || isTar(prefix)
+}
+
+func isTar(prefix []byte) bool {
+	rc, _, err := parser.Decompress(bytes.NewReader(prefix))
+	if err != nil {
+		return false
+	}
+	defer func() { _ = rc.Close() }()
+	head := make([]byte, tarOffset+len(tarMagic))
+	// a truncated compressed prefix still decodes its first block
+	if _, err := io.ReadFull(rc, head); err != nil {
+		return false
+	}
+	return bytes.Equal(head[tarOffset:], tarMagic)
+}
+
+// Walk calls fn with the name and content of every regular file in the
+// archive read from r that looks like iostat output, in archive order. Tar
+// archives are streamed; zip archives need random access and are read
+// through r when it is an io.ReaderAt such as *os.File or *io.SectionReader,
+// and buffered in memory otherwise.
+func Walk(r io.Reader, fn func(name string, r io.Reader) error) error {
+	br := bufio.NewReaderSize(r, parser.SniffLen)
+	magic, _ := br.Peek(len(zipMagic))
+	if IsZip(magic) {
+		return walkZip(br, r, fn)
+	}
+	rc, _, err := parser.Decompress(br)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rc.Close() }()
+	return walkTar(rc, fn)
+}
+
+func walkTar(r io.Reader, fn func(name string, r io.Reader) error) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("tar: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := sniffed(hdr.Name, tr, fn); err != nil {
+			return err
+		}
+	}
+}
+
+// sizedReaderAt is satisfied by *io.SectionReader and *bytes.Reader.
+type sizedReaderAt interface {
+	io.ReaderAt
+	Size() int64
+}
+
+// statReaderAt is satisfied by *os.File.
+type statReaderAt interface {
+	io.ReaderAt
+	Stat() (fs.FileInfo, error)
+}
+
+func walkZip(br *bufio.Reader, orig io.Reader, fn func(name string, r io.Reader) error) error {
+	var (
+		ra   io.ReaderAt
+		size int64
+	)
+	if s, ok := orig.(sizedReaderAt); ok {
+		ra, size = s, s.Size()
+	} else if f, ok := orig.(statReaderAt); ok {
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		ra, size = f, info.Size()
+	} else {
+		// zip keeps its directory at the end, so streamed input is buffered
+		data, err := io.ReadAll(br)
+		if err != nil {
+			return err
+		}
+		ra, size = bytes.NewReader(data), int64(len(data))
+	}
+	zr, err := zip.NewReader(ra, size)
+	if err != nil {
+		return fmt.Errorf("zip: %w", err)
+	}
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("zip: %s: %w", f.Name, err)
+		}
+		err = sniffed(f.Name, rc, fn)
+		_ = rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sniffed calls fn when the file looks like iostat output.
+func sniffed(name string, r io.Reader, fn func(name string, r io.Reader) error) error {
+	br := bufio.NewReaderSize(r, parser.SniffLen)
+	prefix, _ := br.Peek(parser.SniffLen)
+	if !parser.Sniff(prefix) {
+		return nil
+	}
+	return fn(name, br)
+}
+
+// NodeName names the data set of an archive member after its directory,
+// e.g. "bundle/node-1" for "bundle/node-1/iostat.txt". Members at the top
+// level are named after themselves.
+func NodeName(member string) string {
+	member = strings.TrimPrefix(path.Clean("/"+member), "/")
+	if dir := path.Dir(member); dir != "." {
+		return dir
+	}
+	return member
+}
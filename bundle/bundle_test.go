@@ -0,0 +1,112 @@
+package bundle
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rsvihladremio/iostat-reporter/parser"
+)
+
+// members is a bundle with two nodes, one of them gzipped, and files that
+// are not iostat output.
+func members(t *testing.T) map[string][]byte {
+	t.Helper()
+	capture, err := os.ReadFile(filepath.Join("..", "parser", "testdata", "sysstat-12.5.4.txt"))
+	require.NoError(t, err)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, err = zw.Write(capture)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return map[string][]byte{
+		"bundle/node-1/iostat.txt":    capture,
+		"bundle/node-1/server.log":    []byte("2024-09-04 12:07:20 INFO started\n"),
+		"bundle/node-2/iostat.txt.gz": gz.Bytes(),
+		"bundle/README":               []byte("Device list: see node-*/\n"),
+	}
+}
+
+var order = []string{"bundle/node-1/iostat.txt", "bundle/node-1/server.log", "bundle/node-2/iostat.txt.gz", "bundle/README"}
+
+func tarGz(t *testing.T, files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "bundle/node-1/", Typeflag: tar.TypeDir, Mode: 0o755}))
+	for _, name := range order {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(files[name]))}))
+		_, err := tw.Write(files[name])
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func zipped(t *testing.T, files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range order {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write(files[name])
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestWalk(t *testing.T) {
+	files := members(t)
+	for name, archive := range map[string][]byte{"tar.gz": tarGz(t, files), "zip": zipped(t, files)} {
+		t.Run(name, func(t *testing.T) {
+			assert.True(t, IsArchive(archive))
+			var found []string
+			err := Walk(bytes.NewReader(archive), func(member string, r io.Reader) error {
+				found = append(found, member)
+				p, err := parser.ParseReader(r)
+				require.NoError(t, err)
+				assert.Len(t, p.CPUs, 2)
+				return nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"bundle/node-1/iostat.txt", "bundle/node-2/iostat.txt.gz"}, found)
+		})
+	}
+}
+
+// TestWalkZipFile reads zip archives through os.File without buffering.
+func TestWalkZipFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bundle.zip")
+	require.NoError(t, os.WriteFile(path, zipped(t, members(t)), 0o600))
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer func() { _ = f.Close() }()
+	n := 0
+	require.NoError(t, Walk(f, func(string, io.Reader) error { n++; return nil }))
+	assert.Equal(t, 2, n)
+}
+
+func TestIsArchive(t *testing.T) {
+	capture := members(t)["bundle/node-1/iostat.txt"]
+	assert.False(t, IsArchive(capture))
+	assert.False(t, IsArchive(members(t)["bundle/node-2/iostat.txt.gz"]))
+	assert.False(t, IsArchive(nil))
+	// a prefix is enough, even of a compressed tar
+	assert.True(t, IsArchive(tarGz(t, members(t))[:600]))
+}
+
+func TestNodeName(t *testing.T) {
+	assert.Equal(t, "bundle/node-1", NodeName("bundle/node-1/iostat.txt"))
+	assert.Equal(t, "node-1", NodeName("./node-1/iostat.txt"))
+	assert.Equal(t, "iostat.txt", NodeName("iostat.txt"))
+}
@@ -0,0 +1,161 @@
+package cloud
+
+import (
+	"time"
+
+	"github.com/rsvihladremio/iostat-reporter/parser"
+)
+
+// Dimension is what a burst bucket holds credits for.
+type Dimension string
+
+const (
+	// DimensionIOPS buckets hold one credit per request.
+	DimensionIOPS Dimension = "IOPS"
+	// DimensionThroughput buckets hold one credit per MiB.
+	DimensionThroughput Dimension = "MiB/s"
+)
+
+// rate returns the rate of ds a bucket of d spends credits at.
+func (d Dimension) rate(ds parser.DeviceStats) float64 {
+	if d == DimensionThroughput {
+		return ThroughputMBps(ds)
+	}
+	return IOPS(ds)
+}
+
+// Bucket is the burst-credit bucket of a volume. It fills at the baseline
+// rate less what the volume does, so the volume spends credits to run
+// above its baseline, up to the burst rate, until the bucket is empty.
+type Bucket struct {
+	Dimension Dimension
+	// Capacity is the number of credits of a full bucket.
+	Capacity float64
+	Baseline float64
+	Burst    float64
+}
+
+// gp2Credits is the bucket of EBS gp2: 5.4 million I/O credits, enough for
+// 30 minutes at 3000 IOPS.
+func gp2Credits(_ Volume, l Limits) []Bucket {
+	return []Bucket{{Dimension: DimensionIOPS, Capacity: 5.4e6, Baseline: l.IOPS, Burst: l.BurstIOPS}}
+}
+
+// hddCredits is the bucket of EBS st1 and sc1: 1 TiB of throughput credits
+// per TiB of the volume.
+func hddCredits(v Volume, l Limits) []Bucket {
+	return []Bucket{{Dimension: DimensionThroughput, Capacity: v.SizeGiB * 1024, Baseline: l.ThroughputMBps, Burst: l.BurstThroughputMBps}}
+}
+
+// azureCredits are the buckets of Azure credit-based bursting: 30 minutes at
+// the burst rate for both IOPS and throughput.
+func azureCredits(_ Volume, l Limits) []Bucket {
+	const seconds = 30 * 60
+	return []Bucket{
+		{Dimension: DimensionIOPS, Capacity: (l.BurstIOPS - l.IOPS) * seconds, Baseline: l.IOPS, Burst: l.BurstIOPS},
+		{Dimension: DimensionThroughput, Capacity: (l.BurstThroughputMBps - l.ThroughputMBps) * seconds,
+			Baseline: l.ThroughputMBps, Burst: l.BurstThroughputMBps},
+	}
+}
+
+// Buckets returns the burst buckets of v, none for a volume that does not
+// burst, such as a large gp2 volume or one whose caps were given.
+func (v Volume) Buckets() ([]Bucket, error) {
+	l, err := v.Limits()
+	if err != nil {
+		return nil, err
+	}
+	t, _ := LookupType(v.Type)
+	if t.buckets == nil {
+		return nil, nil
+	}
+	var out []Bucket
+	for _, b := range t.buckets(v, l) {
+		if b.Burst > b.Baseline && b.Capacity > 0 {
+			out = append(out, b)
+		}
+	}
+	return out, nil
+}
+
+// CreditPoint is the balance of a bucket after one sample.
+type CreditPoint struct {
+	Timestamp time.Time
+	Balance   float64
+	// Pct is the balance as a percentage of the capacity.
+	Pct float64
+}
+
+// Simulation is how the balance of a bucket went over a capture.
+type Simulation struct {
+	Bucket Bucket
+	Start  float64
+	// Points has a point per sample, since-boot reports left out.
+	Points     []CreditPoint
+	MinBalance float64
+	// Depleted is when the bucket first ran empty, zero if it never did.
+	Depleted time.Time
+}
+
+// Simulate replays stats through b, starting with start credits. Each
+// sample spends or earns credits over the time since the one before; a
+// sample after a break in the timeline only over its segment's interval,
+// as nothing is known of the time in between. The first sample counts
+// over its segment's interval as well.
+func Simulate(b Bucket, start float64, stats []parser.DeviceStats, segments []parser.Segment) Simulation {
+	balance := min(max(start, 0), b.Capacity)
+	sim := Simulation{Bucket: b, Start: balance, MinBalance: balance}
+	var prev time.Time
+	for _, ds := range stats {
+		if ds.SinceBoot {
+			continue
+		}
+		step := ds.Timestamp.Sub(prev)
+		if prev.IsZero() || crossesBreak(segments, prev, ds.Timestamp) {
+			step = segmentInterval(segments, ds.Timestamp)
+		}
+		prev = ds.Timestamp
+		if step < 0 {
+			step = 0
+		}
+
+		// credits go at the rate above the baseline, or come back below it
+		net := b.Baseline - b.Dimension.rate(ds)
+		after := balance + net*step.Seconds()
+		if after <= 0 && balance > 0 && sim.Depleted.IsZero() {
+			// the bucket ran empty part way through the step
+			emptyAfter := time.Duration(balance / -net * float64(time.Second))
+			sim.Depleted = ds.Timestamp.Add(emptyAfter - step)
+		}
+		balance = min(max(after, 0), b.Capacity)
+		if balance == 0 && sim.Depleted.IsZero() && net < 0 {
+			sim.Depleted = ds.Timestamp.Add(-step)
+		}
+		sim.MinBalance = min(sim.MinBalance, balance)
+		sim.Points = append(sim.Points, CreditPoint{Timestamp: ds.Timestamp, Balance: balance, Pct: balance / b.Capacity * 100})
+	}
+	return sim
+}
+
+// crossesBreak reports whether a segment other than the first starts after
+// from and no later than to.
+func crossesBreak(segments []parser.Segment, from, to time.Time) bool {
+	for _, seg := range segments {
+		if seg.Break != parser.BreakNone && seg.Start.After(from) && !seg.Start.After(to) {
+			return true
+		}
+	}
+	return to.Before(from)
+}
+
+// segmentInterval returns the interval of the segment holding t, zero when
+// no segment does.
+func segmentInterval(segments []parser.Segment, t time.Time) time.Duration {
+	var interval time.Duration
+	for _, seg := range segments {
+		if !seg.Start.After(t) {
+			interval = seg.Interval
+		}
+	}
+	return interval
+}
@@ -0,0 +1,160 @@
+package cloud
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rsvihladremio/iostat-reporter/parser"
+)
+
+func TestBuckets(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []Bucket
+	}{
+		{"gp2:100GiB", []Bucket{{Dimension: DimensionIOPS, Capacity: 5.4e6, Baseline: 300, Burst: 3000}}},
+		{"gp2:2000GiB", nil},
+		{"gp2:100GiB:1000iops", nil},
+		{"gp3", nil},
+		{"st1:2TiB", []Bucket{{Dimension: DimensionThroughput, Capacity: 2048 * 1024, Baseline: 80, Burst: 500}}},
+		{"sc1:1TiB", []Bucket{{Dimension: DimensionThroughput, Capacity: 1024 * 1024, Baseline: 12, Burst: 80}}},
+		{"premium-ssd:100GiB", []Bucket{
+			{Dimension: DimensionIOPS, Capacity: 3000 * 1800, Baseline: 500, Burst: 3500},
+			{Dimension: DimensionThroughput, Capacity: 70 * 1800, Baseline: 100, Burst: 170},
+		}},
+		{"premium-ssd:1000GiB", nil},
+		{"standard-ssd:4096GiB", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			v, err := ParseVolume(tt.spec)
+			require.NoError(t, err)
+			got, err := v.Buckets()
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := Volume{Type: "floppy"}.Buckets()
+	assert.Error(t, err)
+}
+
+func TestSimulate(t *testing.T) {
+	t0 := time.Date(2024, 9, 4, 12, 0, 0, 0, time.UTC)
+	bucket := Bucket{Dimension: DimensionIOPS, Capacity: 1000, Baseline: 10, Burst: 100}
+	oneSecond := []parser.Segment{{Start: t0, Interval: time.Second}}
+	// sample is one second of rate IOPS at offset seconds from t0
+	type sample struct {
+		offset    float64
+		rate      float64
+		sinceBoot bool
+	}
+	const never = -1.0
+
+	tests := []struct {
+		name     string
+		bucket   Bucket
+		start    float64
+		samples  []sample
+		segments []parser.Segment
+		want     []float64
+		wantMin  float64
+		// depleted is the offset the bucket ran empty at, or never
+		depleted float64
+	}{
+		{
+			name:    "idle refills at the baseline",
+			bucket:  bucket,
+			start:   500,
+			samples: []sample{{0, 0, false}, {1, 0, false}, {2, 5, false}},
+			want:    []float64{510, 520, 525}, wantMin: 500, depleted: never,
+		},
+		{
+			name:    "balance stops at the capacity",
+			bucket:  bucket,
+			start:   5000,
+			samples: []sample{{0, 0, false}, {1, 40, false}},
+			want:    []float64{1000, 970}, wantMin: 970, depleted: never,
+		},
+		{
+			name:    "bursting runs the bucket empty part way through a sample",
+			bucket:  bucket,
+			start:   125,
+			samples: []sample{{0, 60, false}, {1, 60, false}, {2, 60, false}, {3, 0, false}},
+			want:    []float64{75, 25, 0, 10}, wantMin: 0, depleted: 1.5,
+		},
+		{
+			name:    "a nearly empty bucket runs out early in a sample",
+			bucket:  bucket,
+			start:   0,
+			samples: []sample{{0, 5, false}, {1, 10, false}, {2, 30, false}},
+			want:    []float64{5, 5, 0}, wantMin: 0, depleted: 1.25,
+		},
+		{
+			name:    "an empty bucket is depleted from the start of the first sample above the baseline",
+			bucket:  bucket,
+			start:   0,
+			samples: []sample{{1, 20, false}, {2, 20, false}},
+			want:    []float64{0, 0}, wantMin: 0, depleted: 0,
+		},
+		{
+			name:    "since-boot reports are left out",
+			bucket:  bucket,
+			start:   100,
+			samples: []sample{{0, 1000, true}, {1, 20, false}},
+			want:    []float64{90}, wantMin: 90, depleted: never,
+		},
+		{
+			name:    "a gap counts only one interval",
+			bucket:  bucket,
+			start:   100,
+			samples: []sample{{0, 0, false}, {1, 0, false}, {600, 0, false}},
+			segments: []parser.Segment{
+				{Start: t0, Interval: time.Second},
+				{Start: t0.Add(600 * time.Second), Interval: time.Second, Break: parser.BreakGap},
+			},
+			want: []float64{110, 120, 130}, wantMin: 100, depleted: never,
+		},
+		{
+			name:    "throughput buckets spend MiB",
+			bucket:  Bucket{Dimension: DimensionThroughput, Capacity: 100, Baseline: 10, Burst: 50},
+			start:   100,
+			samples: []sample{{0, 40, false}},
+			want:    []float64{70}, wantMin: 70, depleted: never,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stats []parser.DeviceStats
+			for _, s := range tt.samples {
+				ds := parser.DeviceStats{Timestamp: t0.Add(time.Duration(s.offset * float64(time.Second))), SinceBoot: s.sinceBoot}
+				if tt.bucket.Dimension == DimensionThroughput {
+					ds.ReadsPerSec, ds.ReadKBPerSec = 1, s.rate*1024
+				} else {
+					ds.ReadsPerSec = s.rate
+				}
+				stats = append(stats, ds)
+			}
+			segments := tt.segments
+			if segments == nil {
+				segments = oneSecond
+			}
+
+			sim := Simulate(tt.bucket, tt.start, stats, segments)
+			require.Len(t, sim.Points, len(tt.want))
+			for i, want := range tt.want {
+				assert.InDelta(t, want, sim.Points[i].Balance, 1e-9, "point %d", i)
+				assert.InDelta(t, want/tt.bucket.Capacity*100, sim.Points[i].Pct, 1e-9, "point %d", i)
+			}
+			assert.InDelta(t, tt.wantMin, sim.MinBalance, 1e-9)
+			if tt.depleted == never {
+				assert.True(t, sim.Depleted.IsZero(), "depleted at %v", sim.Depleted)
+			} else {
+				assert.Equal(t, t0.Add(time.Duration(tt.depleted*float64(time.Second))), sim.Depleted)
+			}
+		})
+	}
+}
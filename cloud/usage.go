@@ -0,0 +1,212 @@
+package cloud
+
+import (
+	"math"
+	"time"
+
+	"github.com/rsvihladremio/iostat-reporter/parser"
+)
+
+// A sample is at a cap from AtCap of it, and near it from NearCap.
+const (
+	AtCap   = 0.95
+	NearCap = 0.8
+)
+
+// IOPS returns the requests of ds counted against an IOPS cap: reads and
+// writes, or the transfers of captures that only report those.
+func IOPS(ds parser.DeviceStats) float64 {
+	if rw := ds.ReadsPerSec + ds.WritesPerSec; rw > 0 {
+		return rw
+	}
+	return ds.TransfersPerSec
+}
+
+// ThroughputMBps returns the MiB/s of ds counted against a throughput cap.
+func ThroughputMBps(ds parser.DeviceStats) float64 {
+	return (ds.ReadKBPerSec + ds.WriteKBPerSec) / 1024
+}
+
+// CapUsage is how much of a capture a device spent at or near the caps of
+// its volume. Percentages are shares of the samples, since-boot reports
+// left out, against the highest cap the volume could reach at the time: the
+// burst cap while it has credits, and its baseline once its burst bucket for
+// that cap ran empty, as the volume is then held to it.
+type CapUsage struct {
+	Device  string
+	Volume  Volume
+	Limits  Limits
+	Samples int
+
+	IOPSAtCapPct   float64
+	IOPSNearCapPct float64
+	// IOPSAboveBaselinePct is the share above the baseline of a volume that
+	// bursts, which spends its credits.
+	IOPSAboveBaselinePct float64
+	PeakIOPS             float64
+
+	ThroughputAtCapPct         float64
+	ThroughputNearCapPct       float64
+	ThroughputAboveBaselinePct float64
+	PeakThroughputMBps         float64
+}
+
+// Usage works out the CapUsage of one device's samples on volume v, whose
+// burst buckets start balancePct full.
+func Usage(device string, v Volume, stats []parser.DeviceStats, balancePct float64) (CapUsage, error) {
+	c, err := NewUsageCounter(device, v, balancePct)
+	if err != nil {
+		return CapUsage{}, err
+	}
+	for _, ds := range stats {
+		c.Add(ds)
+	}
+	return c.Usage(), nil
+}
+
+// UsageCounter works out the CapUsage of a device as its samples stream in,
+// so that long captures are not judged on averaged-down data.
+type UsageCounter struct {
+	usage      CapUsage
+	iops, mbps capCounter
+	// buckets are the burst buckets of the volume with their balance, which
+	// tells when the volume is held to its baseline
+	buckets []bucketBalance
+	// last is the time of the latest sample and minStep the smallest step
+	// between samples so far
+	last    time.Time
+	minStep time.Duration
+}
+
+// bucketBalance is a burst bucket and the credits left in it.
+type bucketBalance struct {
+	Bucket
+	balance float64
+}
+
+// NewUsageCounter returns an empty UsageCounter of device on volume v,
+// whose burst buckets start balancePct full.
+func NewUsageCounter(device string, v Volume, balancePct float64) (*UsageCounter, error) {
+	limits, err := v.Limits()
+	if err != nil {
+		return nil, err
+	}
+	buckets, err := v.Buckets()
+	if err != nil {
+		return nil, err
+	}
+	c := &UsageCounter{usage: CapUsage{Device: device, Volume: v, Limits: limits}}
+	for _, b := range buckets {
+		c.buckets = append(c.buckets, bucketBalance{Bucket: b, balance: min(max(b.Capacity*balancePct/100, 0), b.Capacity)})
+	}
+	return c, nil
+}
+
+// Add records one sample of the device, skipping a since-boot one.
+func (c *UsageCounter) Add(ds parser.DeviceStats) {
+	if ds.SinceBoot {
+		return
+	}
+	u, limits := &c.usage, c.usage.Limits
+	u.Samples++
+	io, tp := IOPS(ds), ThroughputMBps(ds)
+	c.iops.add(io, limits.IOPS, limits.BurstIOPS, c.empty(DimensionIOPS))
+	c.mbps.add(tp, limits.ThroughputMBps, limits.BurstThroughputMBps, c.empty(DimensionThroughput))
+	c.spend(ds)
+	u.PeakIOPS = math.Max(u.PeakIOPS, io)
+	u.PeakThroughputMBps = math.Max(u.PeakThroughputMBps, tp)
+}
+
+// empty reports whether a burst bucket of d has run empty.
+func (c *UsageCounter) empty(d Dimension) bool {
+	for _, b := range c.buckets {
+		if b.Dimension == d && b.balance <= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// spend takes the credits of ds out of the buckets, or puts them back, over
+// the time since the sample before. As in Simulate, a step longer than twice
+// the smallest one so far is a break in the capture and only counts as the
+// smallest step, nothing being known of the time in between.
+func (c *UsageCounter) spend(ds parser.DeviceStats) {
+	step := ds.Timestamp.Sub(c.last)
+	first := c.last.IsZero()
+	c.last = ds.Timestamp
+	if first || step <= 0 {
+		return
+	}
+	if c.minStep == 0 || step < c.minStep {
+		c.minStep = step
+	}
+	if step > 2*c.minStep {
+		step = c.minStep
+	}
+	for i := range c.buckets {
+		b := &c.buckets[i]
+		net := b.Baseline - b.Dimension.rate(ds)
+		b.balance = min(max(b.balance+net*step.Seconds(), 0), b.Capacity)
+	}
+}
+
+// Merge adds everything recorded in o, a counter of the same volume.
+func (c *UsageCounter) Merge(o *UsageCounter) {
+	if o == nil {
+		return
+	}
+	c.usage.Samples += o.usage.Samples
+	c.usage.PeakIOPS = math.Max(c.usage.PeakIOPS, o.usage.PeakIOPS)
+	c.usage.PeakThroughputMBps = math.Max(c.usage.PeakThroughputMBps, o.usage.PeakThroughputMBps)
+	c.iops.merge(o.iops)
+	c.mbps.merge(o.mbps)
+}
+
+// Usage returns the CapUsage of everything recorded.
+func (c *UsageCounter) Usage() CapUsage {
+	u := c.usage
+	u.IOPSAtCapPct, u.IOPSNearCapPct, u.IOPSAboveBaselinePct = c.iops.pcts(u.Samples)
+	u.ThroughputAtCapPct, u.ThroughputNearCapPct, u.ThroughputAboveBaselinePct = c.mbps.pcts(u.Samples)
+	return u
+}
+
+// capCounter counts the samples at, near and above the baseline of a cap.
+type capCounter struct {
+	at, near, above int
+}
+
+// add counts v against the burst cap, or against the baseline when the
+// volume is out of credits.
+func (c *capCounter) add(v, baseline, burst float64, outOfCredits bool) {
+	limit := math.Max(baseline, burst)
+	if outOfCredits {
+		limit = baseline
+	}
+	if limit <= 0 {
+		return
+	}
+	if v >= AtCap*limit {
+		c.at++
+	}
+	if v >= NearCap*limit {
+		c.near++
+	}
+	if burst > baseline && v > baseline {
+		c.above++
+	}
+}
+
+func (c *capCounter) merge(o capCounter) {
+	c.at += o.at
+	c.near += o.near
+	c.above += o.above
+}
+
+func (c capCounter) pcts(samples int) (at, near, above float64) {
+	if samples == 0 {
+		return 0, 0, 0
+	}
+	n := float64(samples)
+	return float64(c.at) / n * 100, float64(c.near) / n * 100, float64(c.above) / n * 100
+}
@@ -0,0 +1,126 @@
+package cloud
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/rsvihladremio/iostat-reporter/parser"
+)
+
+func TestUsage(t *testing.T) {
+	tests := []struct {
+		name  string
+		spec  string
+		stats []parser.DeviceStats
+		want  CapUsage
+	}{
+		{
+			name: "gp3 at its IOPS cap",
+			spec: "gp3",
+			stats: []parser.DeviceStats{
+				{ReadsPerSec: 9000, SinceBoot: true},
+				{ReadsPerSec: 1000, WritesPerSec: 1990, ReadKBPerSec: 64 * 1024},
+				{ReadsPerSec: 2500},
+				{TransfersPerSec: 2000},
+				{WritesPerSec: 100, WriteKBPerSec: 125 * 1024},
+			},
+			want: CapUsage{
+				Samples:      4,
+				IOPSAtCapPct: 25, IOPSNearCapPct: 50, PeakIOPS: 2990,
+				ThroughputAtCapPct: 25, ThroughputNearCapPct: 25, PeakThroughputMBps: 125,
+			},
+		},
+		{
+			name: "gp2 bursting above its baseline",
+			spec: "gp2:100GiB",
+			stats: []parser.DeviceStats{
+				{ReadsPerSec: 200},
+				{ReadsPerSec: 400},
+				{ReadsPerSec: 3000},
+				{ReadsPerSec: 2500},
+			},
+			want: CapUsage{
+				Samples:      4,
+				IOPSAtCapPct: 25, IOPSNearCapPct: 50, IOPSAboveBaselinePct: 75, PeakIOPS: 3000,
+			},
+		},
+		{
+			name: "st1 spending throughput credits",
+			spec: "st1:1TiB",
+			stats: []parser.DeviceStats{
+				{ReadsPerSec: 10, ReadKBPerSec: 30 * 1024},
+				{ReadsPerSec: 100, ReadKBPerSec: 250 * 1024},
+			},
+			want: CapUsage{
+				Samples: 2, PeakIOPS: 100,
+				ThroughputAtCapPct: 50, ThroughputNearCapPct: 50, ThroughputAboveBaselinePct: 50, PeakThroughputMBps: 250,
+			},
+		},
+		{name: "no samples", spec: "gp3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := ParseVolume(tt.spec)
+			require.NoError(t, err)
+			u, err := Usage("xvdf", v, tt.stats, 100)
+			require.NoError(t, err)
+
+			limits, _ := v.Limits()
+			tt.want.Device, tt.want.Volume, tt.want.Limits = "xvdf", v, limits
+			assert.Equal(t, tt.want, u)
+		})
+	}
+}
+
+func TestUsageUnknownType(t *testing.T) {
+	_, err := Usage("sda", Volume{Type: "floppy"}, nil, 100)
+	assert.Error(t, err)
+}
+
+// TestUsageCounterMerge counts a device streamed in two parts as one.
+func TestUsageCounterMerge(t *testing.T) {
+	v, err := ParseVolume("gp2:100GiB")
+	require.NoError(t, err)
+	stats := []parser.DeviceStats{{ReadsPerSec: 200}, {ReadsPerSec: 3000}, {ReadsPerSec: 400}, {ReadsPerSec: 2500}}
+	first, err := NewUsageCounter("xvdf", v, 100)
+	require.NoError(t, err)
+	second, err := NewUsageCounter("xvdf", v, 100)
+	require.NoError(t, err)
+	for i, ds := range stats {
+		if i < 2 {
+			first.Add(ds)
+		} else {
+			second.Add(ds)
+		}
+	}
+	first.Merge(second)
+
+	want, err := Usage("xvdf", v, stats, 100)
+	require.NoError(t, err)
+	assert.Equal(t, want, first.Usage())
+}
+
+// TestUsageOutOfCredits counts a volume out of burst credits against its
+// baseline, the cap it is then held to, until it earns credits again.
+func TestUsageOutOfCredits(t *testing.T) {
+	v, err := ParseVolume("gp2:100GiB")
+	require.NoError(t, err)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var stats []parser.DeviceStats
+	for i, r := range []float64{300, 300, 200, 300} {
+		stats = append(stats, parser.DeviceStats{Timestamp: start.Add(time.Duration(i) * time.Second), ReadsPerSec: r})
+	}
+
+	u, err := Usage("xvdf", v, stats, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 50.0, u.IOPSAtCapPct)
+	assert.Equal(t, 50.0, u.IOPSNearCapPct)
+	assert.Zero(t, u.IOPSAboveBaselinePct)
+
+	full, err := Usage("xvdf", v, stats, 100)
+	require.NoError(t, err)
+	assert.Zero(t, full.IOPSAtCapPct)
+}
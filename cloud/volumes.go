@@ -0,0 +1,320 @@
+// Package cloud knows the limits of cloud block storage volumes, so that a
+// capture can be compared with what the volume was provisioned for, and
+// simulates the burst credits of volumes that spend them.
+package cloud
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Limits are the caps of one volume. Throughput is in MiB/s, as iostat
+// reports it. A zero cap is unknown.
+type Limits struct {
+	IOPS           float64
+	ThroughputMBps float64
+	// BurstIOPS and BurstThroughputMBps are what the volume reaches while it
+	// has burst credits, zero for volumes that do not burst.
+	BurstIOPS           float64
+	BurstThroughputMBps float64
+}
+
+// MaxIOPS returns the highest IOPS the volume reaches, bursting or not.
+func (l Limits) MaxIOPS() float64 { return math.Max(l.IOPS, l.BurstIOPS) }
+
+// MaxThroughputMBps returns the highest throughput the volume reaches.
+func (l Limits) MaxThroughputMBps() float64 {
+	return math.Max(l.ThroughputMBps, l.BurstThroughputMBps)
+}
+
+// Volume is a volume of a catalogued type, as given to ParseVolume.
+type Volume struct {
+	Type    string
+	SizeGiB float64
+	// IOPS and ThroughputMBps are what was provisioned, zero for the
+	// type's default. On volumes whose limits follow from their size they
+	// override the catalogue, e.g. for a lower instance limit.
+	IOPS           float64
+	ThroughputMBps float64
+}
+
+// VolumeType is a catalogued kind of volume.
+type VolumeType struct {
+	Name        string
+	Provider    string
+	Description string
+	// limits works out the caps of a volume of the type
+	limits func(v Volume) (Limits, error)
+	// buckets, when set, returns the burst buckets of a volume of the type
+	buckets func(v Volume, l Limits) []Bucket
+}
+
+// tier is a size class of a volume type whose caps step with size.
+type tier struct {
+	maxGiB              float64
+	iops, mbps          float64
+	burstIOPS, burstMBs float64
+}
+
+// The caps below are the per-volume limits the providers published in 2024.
+// Instances cap I/O too, often lower; give the volume's iops and MBps to
+// compare against those instead.
+var catalogue = []VolumeType{
+	{Name: "gp2", Provider: "aws", Description: "EBS General Purpose SSD, 3 IOPS per GiB bursting to 3000", limits: gp2Limits, buckets: gp2Credits},
+	{Name: "gp3", Provider: "aws", Description: "EBS General Purpose SSD, 3000 IOPS and 125 MiB/s unless provisioned higher",
+		limits: provisioned(3000, 125, 16000, 1000, 500)},
+	{Name: "io1", Provider: "aws", Description: "EBS Provisioned IOPS SSD", limits: provisionedIOPS(64000, 0.256, 1000, 50)},
+	{Name: "io2", Provider: "aws", Description: "EBS Provisioned IOPS SSD (Block Express)", limits: provisionedIOPS(256000, 0.256, 4000, 500)},
+	{Name: "st1", Provider: "aws", Description: "EBS Throughput Optimized HDD, 40 MiB/s per TiB bursting to 250", limits: hddLimits(40, 250, 500, 500), buckets: hddCredits},
+	{Name: "sc1", Provider: "aws", Description: "EBS Cold HDD, 12 MiB/s per TiB bursting to 80", limits: hddLimits(12, 80, 250, 250), buckets: hddCredits},
+	{Name: "premium-ssd", Provider: "azure", Description: "Azure Premium SSD, P1 to P80 by size", limits: tiered([]tier{
+		{4, 120, 25, 3500, 170}, {8, 120, 25, 3500, 170}, {16, 120, 25, 3500, 170}, {32, 120, 25, 3500, 170},
+		{64, 240, 50, 3500, 170}, {128, 500, 100, 3500, 170}, {256, 1100, 125, 3500, 170}, {512, 2300, 150, 3500, 170},
+		{1024, 5000, 200, 0, 0}, {2048, 7500, 250, 0, 0}, {4096, 7500, 250, 0, 0}, {8192, 16000, 500, 0, 0},
+		{16384, 18000, 750, 0, 0}, {32767, 20000, 900, 0, 0},
+	}), buckets: azureCredits},
+	{Name: "premium-ssd-v2", Provider: "azure", Description: "Azure Premium SSD v2, 3000 IOPS and 125 MB/s unless provisioned higher",
+		limits: provisioned(3000, 125, 80000, 1200, 500)},
+	{Name: "standard-ssd", Provider: "azure", Description: "Azure Standard SSD, E1 to E80 by size", limits: tiered([]tier{
+		{4, 500, 100, 600, 150}, {8, 500, 100, 600, 150}, {16, 500, 100, 600, 150}, {32, 500, 100, 600, 150},
+		{64, 500, 100, 600, 150}, {128, 500, 100, 600, 150}, {256, 500, 100, 600, 150}, {512, 500, 100, 600, 150},
+		{1024, 500, 100, 600, 150}, {2048, 500, 100, 0, 0}, {4096, 500, 100, 0, 0}, {8192, 2000, 400, 0, 0},
+		{16384, 4000, 600, 0, 0}, {32767, 6000, 750, 0, 0},
+	}), buckets: azureCredits},
+	{Name: "pd-standard", Provider: "gcp", Description: "GCP standard persistent disk, read limits", limits: perGiB(0, 0.75, 0, 0.12, 7500, 1200)},
+	{Name: "pd-balanced", Provider: "gcp", Description: "GCP balanced persistent disk", limits: perGiB(3000, 6, 140, 0.28, 80000, 1200)},
+	{Name: "pd-ssd", Provider: "gcp", Description: "GCP SSD persistent disk", limits: perGiB(6000, 30, 240, 0.48, 100000, 1200)},
+	{Name: "hyperdisk-balanced", Provider: "gcp", Description: "GCP Hyperdisk Balanced, 3000 IOPS and 140 MiB/s unless provisioned higher",
+		limits: provisioned(3000, 140, 160000, 2400, 500)},
+}
+
+// Types returns the catalogue, sorted by provider and name.
+func Types() []VolumeType {
+	out := append([]VolumeType(nil), catalogue...)
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Provider != out[j].Provider {
+			return out[i].Provider < out[j].Provider
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// LookupType returns the catalogued type of that name.
+func LookupType(name string) (VolumeType, bool) {
+	for _, t := range catalogue {
+		if strings.EqualFold(t.Name, name) {
+			return t, true
+		}
+	}
+	return VolumeType{}, false
+}
+
+// typeNames lists the catalogued type names for error messages.
+func typeNames() string {
+	names := make([]string, 0, len(catalogue))
+	for _, t := range Types() {
+		names = append(names, t.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Limits works out the caps of v.
+func (v Volume) Limits() (Limits, error) {
+	t, ok := LookupType(v.Type)
+	if !ok {
+		return Limits{}, fmt.Errorf("unknown volume type %q (want one of %s)", v.Type, typeNames())
+	}
+	return t.limits(v)
+}
+
+// String returns v as ParseVolume reads it, empty for the zero Volume.
+func (v Volume) String() string {
+	parts := []string{v.Type}
+	if v.SizeGiB > 0 {
+		parts = append(parts, formatNumber(v.SizeGiB)+"GiB")
+	}
+	if v.IOPS > 0 {
+		parts = append(parts, formatNumber(v.IOPS)+"iops")
+	}
+	if v.ThroughputMBps > 0 {
+		parts = append(parts, formatNumber(v.ThroughputMBps)+"MBps")
+	}
+	return strings.Join(parts, ":")
+}
+
+func formatNumber(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+
+// ParseVolume reads a volume such as "gp3:3000iops:125MBps" or "gp2:500GiB":
+// its type followed by any of its size (GiB or TiB), provisioned IOPS
+// (iops) and throughput (MBps or MiBps, both read as MiB/s), in any order.
+// The limits are checked, so a volume missing its size fails here.
+func ParseVolume(s string) (Volume, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	v := Volume{Type: strings.ToLower(parts[0])}
+	for _, p := range parts[1:] {
+		lower := strings.ToLower(strings.TrimSpace(p))
+		unit := strings.TrimLeft(lower, "0123456789.")
+		n, err := strconv.ParseFloat(strings.TrimSuffix(lower, unit), 64)
+		if err != nil || n <= 0 {
+			return v, fmt.Errorf("volume %q has invalid %q (want e.g. 500GiB, 3000iops or 125MBps)", s, p)
+		}
+		switch unit {
+		case "gib", "gb", "g":
+			v.SizeGiB = n
+		case "tib", "tb", "t":
+			v.SizeGiB = n * 1024
+		case "iops":
+			v.IOPS = n
+		case "mbps", "mibps", "mb/s", "mib/s":
+			v.ThroughputMBps = n
+		default:
+			return v, fmt.Errorf("volume %q has unknown unit in %q (want GiB, TiB, iops or MBps)", s, p)
+		}
+	}
+	if _, err := v.Limits(); err != nil {
+		return v, err
+	}
+	return v, nil
+}
+
+// ParseMapping reads a device mapped to a volume, such as
+// "nvme1n1=gp3:3000iops:125MBps".
+func ParseMapping(s string) (string, Volume, error) {
+	device, spec, ok := strings.Cut(s, "=")
+	device = strings.TrimPrefix(strings.TrimSpace(device), "/dev/")
+	if !ok || device == "" {
+		return "", Volume{}, fmt.Errorf("volume %q is not device=type[:size][:iops][:MBps]", s)
+	}
+	v, err := ParseVolume(spec)
+	return device, v, err
+}
+
+// errNoSize is returned for types whose limits follow from their size.
+var errNoSize = errors.New("needs its size, e.g. 500GiB")
+
+// gp2Limits follows EBS gp2: 3 IOPS per GiB between 100 and 16000, bursting
+// to 3000 below 1 TiB, and 128 MiB/s up to 170 GiB, 250 MiB/s beyond.
+func gp2Limits(v Volume) (Limits, error) {
+	if v.SizeGiB <= 0 {
+		return Limits{}, fmt.Errorf("gp2 %w", errNoSize)
+	}
+	l := Limits{IOPS: math.Min(math.Max(3*v.SizeGiB, 100), 16000), ThroughputMBps: 250}
+	if v.SizeGiB <= 170 {
+		l.ThroughputMBps = 128
+	}
+	if l.IOPS < 3000 {
+		l.BurstIOPS = 3000
+	}
+	return override(l, v), nil
+}
+
+// provisioned is a type with a free baseline that can be provisioned up to
+// maxIOPS and maxMBps, at most iopsPerGiB for the size when it is known.
+func provisioned(iops, mbps, maxIOPS, maxMBps, iopsPerGiB float64) func(Volume) (Limits, error) {
+	return func(v Volume) (Limits, error) {
+		l := Limits{IOPS: iops, ThroughputMBps: mbps}
+		if v.IOPS > 0 {
+			l.IOPS = v.IOPS
+		}
+		if v.ThroughputMBps > 0 {
+			l.ThroughputMBps = v.ThroughputMBps
+		}
+		switch {
+		case l.IOPS > maxIOPS:
+			return l, fmt.Errorf("%s allows at most %s IOPS", v.Type, formatNumber(maxIOPS))
+		case l.ThroughputMBps > maxMBps:
+			return l, fmt.Errorf("%s allows at most %s MiB/s", v.Type, formatNumber(maxMBps))
+		case v.SizeGiB > 0 && l.IOPS > iops && l.IOPS > iopsPerGiB*v.SizeGiB:
+			return l, fmt.Errorf("%s allows at most %s IOPS per GiB", v.Type, formatNumber(iopsPerGiB))
+		}
+		return l, nil
+	}
+}
+
+// provisionedIOPS is a type whose IOPS must be provisioned, at most
+// iopsPerGiB for the size when it is known, with throughput following from
+// them up to maxMBps.
+func provisionedIOPS(maxIOPS, mbpsPerIOPS, maxMBps, iopsPerGiB float64) func(Volume) (Limits, error) {
+	return func(v Volume) (Limits, error) {
+		if v.IOPS <= 0 {
+			return Limits{}, fmt.Errorf("%s needs its provisioned IOPS, e.g. 10000iops", v.Type)
+		}
+		if v.IOPS > maxIOPS {
+			return Limits{}, fmt.Errorf("%s allows at most %s IOPS", v.Type, formatNumber(maxIOPS))
+		}
+		if perSize := iopsPerGiB * v.SizeGiB; v.SizeGiB > 0 && v.IOPS > perSize {
+			return Limits{}, fmt.Errorf("%s allows at most %s IOPS per GiB, %s IOPS for %s GiB",
+				v.Type, formatNumber(iopsPerGiB), formatNumber(perSize), formatNumber(v.SizeGiB))
+		}
+		l := Limits{IOPS: v.IOPS, ThroughputMBps: math.Min(v.IOPS*mbpsPerIOPS, maxMBps)}
+		if v.ThroughputMBps > 0 {
+			l.ThroughputMBps = v.ThroughputMBps
+		}
+		return l, nil
+	}
+}
+
+// hddLimits is an EBS HDD type: baseline and burst throughput per TiB, each
+// up to maxMBps, and at most maxIOPS requests.
+func hddLimits(baselinePerTiB, burstPerTiB, maxMBps, maxIOPS float64) func(Volume) (Limits, error) {
+	return func(v Volume) (Limits, error) {
+		if v.SizeGiB <= 0 {
+			return Limits{}, fmt.Errorf("%s %w", v.Type, errNoSize)
+		}
+		tib := v.SizeGiB / 1024
+		l := Limits{
+			IOPS:                maxIOPS,
+			ThroughputMBps:      math.Min(baselinePerTiB*tib, maxMBps),
+			BurstThroughputMBps: math.Min(burstPerTiB*tib, maxMBps),
+		}
+		return override(l, v), nil
+	}
+}
+
+// tiered is a type whose caps step with size: a volume gets the smallest
+// tier it fits in.
+func tiered(tiers []tier) func(Volume) (Limits, error) {
+	return func(v Volume) (Limits, error) {
+		if v.SizeGiB <= 0 {
+			return Limits{}, fmt.Errorf("%s %w", v.Type, errNoSize)
+		}
+		for _, t := range tiers {
+			if v.SizeGiB <= t.maxGiB {
+				l := Limits{IOPS: t.iops, ThroughputMBps: t.mbps, BurstIOPS: t.burstIOPS, BurstThroughputMBps: t.burstMBs}
+				return override(l, v), nil
+			}
+		}
+		return Limits{}, fmt.Errorf("%s volumes hold at most %s GiB", v.Type, formatNumber(tiers[len(tiers)-1].maxGiB))
+	}
+}
+
+// perGiB is a type whose caps grow linearly with size up to a maximum.
+func perGiB(iops, iopsPerGiB, mbps, mbpsPerGiB, maxIOPS, maxMBps float64) func(Volume) (Limits, error) {
+	return func(v Volume) (Limits, error) {
+		if v.SizeGiB <= 0 {
+			return Limits{}, fmt.Errorf("%s %w", v.Type, errNoSize)
+		}
+		l := Limits{
+			IOPS:           math.Min(iops+iopsPerGiB*v.SizeGiB, maxIOPS),
+			ThroughputMBps: math.Min(mbps+mbpsPerGiB*v.SizeGiB, maxMBps),
+		}
+		return override(l, v), nil
+	}
+}
+
+// override replaces the caps of l with those given for v, as for a lower
+// instance limit.
+func override(l Limits, v Volume) Limits {
+	if v.IOPS > 0 {
+		l.IOPS, l.BurstIOPS = v.IOPS, 0
+	}
+	if v.ThroughputMBps > 0 {
+		l.ThroughputMBps, l.BurstThroughputMBps = v.ThroughputMBps, 0
+	}
+	return l
+}
@@ -0,0 +1,123 @@
+package cloud
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseVolume(t *testing.T) {
+	tests := []struct {
+		spec string
+		want Volume
+	}{
+		{"gp3", Volume{Type: "gp3"}},
+		{"gp3:3000iops:125MBps", Volume{Type: "gp3", IOPS: 3000, ThroughputMBps: 125}},
+		{"GP2:500GiB", Volume{Type: "gp2", SizeGiB: 500}},
+		{"st1:2TiB", Volume{Type: "st1", SizeGiB: 2048}},
+		{"io2:20000iops:1000MiB/s", Volume{Type: "io2", IOPS: 20000, ThroughputMBps: 1000}},
+		{"pd-ssd:100GB", Volume{Type: "pd-ssd", SizeGiB: 100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			v, err := ParseVolume(tt.spec)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, v)
+		})
+	}
+}
+
+func TestParseVolumeErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"gp4",
+		"gp2",           // needs a size
+		"io1",           // needs IOPS
+		"gp3:fast",      // no number
+		"gp3:3000rpm",   // unknown unit
+		"gp3:-5iops",    // negative
+		"gp3:20000iops", // above the type's maximum
+		"gp3:10GiB:6000iops",
+		"io1:100GiB:64000iops", // above 50 IOPS per GiB
+		"io2:10GiB:6000iops",   // above 500 IOPS per GiB
+		"premium-ssd:40000GiB",
+	} {
+		_, err := ParseVolume(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestParseVolumeIOPSPerGiB(t *testing.T) {
+	_, err := ParseVolume("io1:100GiB:64000iops")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "at most 50 IOPS per GiB, 5000 IOPS for 100 GiB")
+
+	_, err = ParseVolume("io1:100GiB:5000iops")
+	assert.NoError(t, err)
+	_, err = ParseVolume("io2:100GiB:50000iops")
+	assert.NoError(t, err)
+}
+
+func TestParseMapping(t *testing.T) {
+	dev, v, err := ParseMapping("/dev/nvme1n1=gp3:3000iops:125MBps")
+	require.NoError(t, err)
+	assert.Equal(t, "nvme1n1", dev)
+	assert.Equal(t, "gp3:3000iops:125MBps", v.String())
+
+	for _, bad := range []string{"gp3", "=gp3", "sda=gp9"} {
+		_, _, err := ParseMapping(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		spec string
+		want Limits
+	}{
+		{"gp3", Limits{IOPS: 3000, ThroughputMBps: 125}},
+		{"gp3:16000iops:1000MBps", Limits{IOPS: 16000, ThroughputMBps: 1000}},
+		{"gp2:20GiB", Limits{IOPS: 100, ThroughputMBps: 128, BurstIOPS: 3000}},
+		{"gp2:500GiB", Limits{IOPS: 1500, ThroughputMBps: 250, BurstIOPS: 3000}},
+		{"gp2:2000GiB", Limits{IOPS: 6000, ThroughputMBps: 250}},
+		{"gp2:500GiB:1000iops", Limits{IOPS: 1000, ThroughputMBps: 250}},
+		{"io1:10000iops", Limits{IOPS: 10000, ThroughputMBps: 1000}},
+		{"io2:1000iops", Limits{IOPS: 1000, ThroughputMBps: 256}},
+		{"st1:2TiB", Limits{IOPS: 500, ThroughputMBps: 80, BurstThroughputMBps: 500}},
+		{"sc1:1TiB", Limits{IOPS: 250, ThroughputMBps: 12, BurstThroughputMBps: 80}},
+		{"premium-ssd:1000GiB", Limits{IOPS: 5000, ThroughputMBps: 200}},
+		{"premium-ssd:100GiB", Limits{IOPS: 500, ThroughputMBps: 100, BurstIOPS: 3500, BurstThroughputMBps: 170}},
+		{"standard-ssd:8192GiB", Limits{IOPS: 2000, ThroughputMBps: 400}},
+		{"pd-balanced:100GiB", Limits{IOPS: 3600, ThroughputMBps: 168}},
+		{"pd-ssd:10000GiB", Limits{IOPS: 100000, ThroughputMBps: 1200}},
+		{"hyperdisk-balanced", Limits{IOPS: 3000, ThroughputMBps: 140}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			v, err := ParseVolume(tt.spec)
+			require.NoError(t, err)
+			l, err := v.Limits()
+			require.NoError(t, err)
+			assert.InDelta(t, tt.want.IOPS, l.IOPS, 1e-9)
+			assert.InDelta(t, tt.want.ThroughputMBps, l.ThroughputMBps, 1e-9)
+			assert.InDelta(t, tt.want.BurstIOPS, l.BurstIOPS, 1e-9)
+			assert.InDelta(t, tt.want.BurstThroughputMBps, l.BurstThroughputMBps, 1e-9)
+		})
+	}
+}
+
+func TestTypes(t *testing.T) {
+	types := Types()
+	require.NotEmpty(t, types)
+	for i, vt := range types {
+		assert.NotEmpty(t, vt.Description, vt.Name)
+		got, ok := LookupType(vt.Name)
+		assert.True(t, ok)
+		assert.Equal(t, vt.Name, got.Name)
+		if i > 0 {
+			prev := types[i-1]
+			assert.True(t, prev.Provider < vt.Provider || (prev.Provider == vt.Provider && prev.Name < vt.Name))
+		}
+	}
+}
@@ -0,0 +1,206 @@
+// Package collect samples /proc/diskstats and /proc/stat the way iostat -x
+// does, for hosts without sysstat. Captures are written in iostat's own text
+// layout so iorep renders them like any other.
+package collect
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rsvihladremio/iostat-reporter/parser"
+)
+
+// Sampler turns successive /proc readings into iostat samples.
+type Sampler struct {
+	root string
+	// started is set once the since-boot report has been taken
+	started bool
+	uptime  time.Duration
+	cpu     parser.CPUCounters
+	disks   map[string]parser.DiskCounters
+}
+
+// NewSampler returns a Sampler reading proc/ and sys/ below root, which is
+// "/" on a live system.
+func NewSampler(root string) *Sampler {
+	return &Sampler{root: root}
+}
+
+func (s *Sampler) path(elem ...string) string {
+	return filepath.Join(append([]string{s.root}, elem...)...)
+}
+
+// Host describes the sampled machine for the iostat banner.
+func (s *Sampler) Host(now time.Time) (parser.HostInfo, error) {
+	host := parser.HostInfo{OS: "Linux", Date: now, Arch: arch()}
+	var err error
+	if host.Kernel, err = s.readString("proc", "sys", "kernel", "osrelease"); err != nil {
+		return host, err
+	}
+	if host.Hostname, err = s.readString("proc", "sys", "kernel", "hostname"); err != nil {
+		return host, err
+	}
+	f, err := os.Open(s.path("proc", "stat"))
+	if err != nil {
+		return host, err
+	}
+	defer func() { _ = f.Close() }()
+	_, host.CPUCount, err = parser.ParseProcStat(f)
+	return host, err
+}
+
+// Sample takes a reading at now. The first one averages everything since
+// boot, as iostat's first report does; later ones cover the time since the
+// previous reading. The interval comes from /proc/uptime, like iostat's.
+func (s *Sampler) Sample(now time.Time) (parser.Sample, error) {
+	uptime, err := s.readUptime()
+	if err != nil {
+		return parser.Sample{}, err
+	}
+	cpu, err := s.readCPU()
+	if err != nil {
+		return parser.Sample{}, err
+	}
+	disks, order, err := s.readDisks()
+	if err != nil {
+		return parser.Sample{}, err
+	}
+
+	smp := parser.Sample{Timestamp: now}
+	elapsed := uptime - s.uptime
+	cpuStats := parser.CPUStatsBetween(s.cpu, cpu)
+	cpuStats.Timestamp = now
+	smp.CPU = &cpuStats
+	for _, name := range order {
+		cur := disks[name]
+		// a device that appeared since the last reading starts from zero
+		ds := parser.DeviceStatsBetween(s.disks[name], cur, elapsed)
+		ds.Timestamp = now
+		smp.Devices = append(smp.Devices, ds)
+	}
+	if !s.started {
+		smp.SinceBoot, smp.RunStart = true, true
+		smp.CPU.SinceBoot = true
+		for i := range smp.Devices {
+			smp.Devices[i].SinceBoot = true
+		}
+	}
+
+	s.started = true
+	s.uptime, s.cpu, s.disks = uptime, cpu, disks
+	return smp, nil
+}
+
+// Run takes count samples, or samples until ctx is done when count is zero
+// or less, interval apart, and passes each to fn.
+func Run(ctx context.Context, s *Sampler, interval time.Duration, count int, fn func(parser.Sample) error) error {
+	if interval <= 0 {
+		return fmt.Errorf("interval must be positive, got %s", interval)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for i := 0; count <= 0 || i < count; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+		}
+		smp, err := s.Sample(time.Now())
+		if err != nil {
+			return err
+		}
+		if err := fn(smp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Sampler) readString(elem ...string) (string, error) {
+	b, err := os.ReadFile(s.path(elem...))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// readUptime reads the first field of /proc/uptime.
+func (s *Sampler) readUptime() (time.Duration, error) {
+	text, err := s.readString("proc", "uptime")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("empty %s", s.path("proc", "uptime"))
+	}
+	secs, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("parsing %s: %w", s.path("proc", "uptime"), err)
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+func (s *Sampler) readCPU() (parser.CPUCounters, error) {
+	f, err := os.Open(s.path("proc", "stat"))
+	if err != nil {
+		return parser.CPUCounters{}, err
+	}
+	defer func() { _ = f.Close() }()
+	cpu, _, err := parser.ParseProcStat(f)
+	return cpu, err
+}
+
+// readDisks reads the whole disks iostat reports by default, in
+// /proc/diskstats order, leaving out partitions and devices never used.
+func (s *Sampler) readDisks() (map[string]parser.DiskCounters, []string, error) {
+	f, err := os.Open(s.path("proc", "diskstats"))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() { _ = f.Close() }()
+	all, err := parser.ParseDiskstats(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	// whole disks have an entry in /sys/block; without sysfs keep everything
+	_, statErr := os.Stat(s.path("sys", "block"))
+	haveSys := statErr == nil
+
+	disks := make(map[string]parser.DiskCounters, len(all))
+	var order []string
+	for _, dc := range all {
+		if dc.Idle() {
+			continue
+		}
+		if haveSys {
+			if _, err := os.Stat(s.path("sys", "block", dc.Name)); err != nil {
+				continue
+			}
+		}
+		disks[dc.Name] = dc
+		order = append(order, dc.Name)
+	}
+	return disks, order, nil
+}
+
+// arch returns the machine name iostat prints for the running binary.
+func arch() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "x86_64"
+	case "386":
+		return "i686"
+	case "arm64":
+		return "aarch64"
+	}
+	return runtime.GOARCH
+}
@@ -0,0 +1,92 @@
+package collect
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rsvihladremio/iostat-reporter/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeRoot lays out the proc and sys files a Sampler reads below dir.
+func writeRoot(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, []byte(content), 0o644))
+	}
+}
+
+func TestSampler(t *testing.T) {
+	root := t.TempDir()
+	writeRoot(t, root, map[string]string{
+		"proc/sys/kernel/hostname":  "db1\n",
+		"proc/sys/kernel/osrelease": "6.1.0-test\n",
+		"proc/uptime":               "100.00 350.00\n",
+		"proc/stat":                 "cpu  100 0 50 800 50 0 0 0 0 0\ncpu0 50 0 25 400 25 0 0 0 0 0\ncpu1 50 0 25 400 25 0 0 0 0 0\n",
+		"proc/diskstats": "   8       0 sda 1000 0 20000 500 0 0 0 0 0 1000 500 0 0 0 0 0 0\n" +
+			"   8       1 sda1 1000 0 20000 500 0 0 0 0 0 1000 500 0 0 0 0 0 0\n" +
+			"   7       0 loop0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0\n",
+		"sys/block/sda/size":   "0\n",
+		"sys/block/loop0/size": "0\n",
+	})
+	s := NewSampler(root)
+	now := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	host, err := s.Host(now)
+	require.NoError(t, err)
+	assert.Equal(t, "db1", host.Hostname)
+	assert.Equal(t, "6.1.0-test", host.Kernel)
+	assert.Equal(t, 2, host.CPUCount)
+
+	first, err := s.Sample(now)
+	require.NoError(t, err)
+	assert.True(t, first.SinceBoot)
+	assert.True(t, first.RunStart)
+	// the partition and the idle loop device are left out
+	require.Len(t, first.Devices, 1)
+	assert.InDelta(t, 10, first.Devices[0].ReadsPerSec, 1e-9)
+	assert.InDelta(t, 5, first.CPU.Iowait, 1e-9)
+
+	writeRoot(t, root, map[string]string{
+		"proc/uptime":    "102.00 354.00\n",
+		"proc/stat":      "cpu  200 0 100 1000 100 0 0 0 0 0\n",
+		"proc/diskstats": "   8       0 sda 1100 0 22000 700 50 0 800 100 0 2000 800 0 0 0 0 0 0\n",
+	})
+	second, err := s.Sample(now.Add(2 * time.Second))
+	require.NoError(t, err)
+	assert.False(t, second.SinceBoot)
+	require.Len(t, second.Devices, 1)
+	d := second.Devices[0]
+	assert.Equal(t, "sda", d.Name)
+	assert.InDelta(t, 50, d.ReadsPerSec, 1e-9)
+	assert.InDelta(t, 500, d.ReadKBPerSec, 1e-9)
+	assert.InDelta(t, 2, d.ReadAwaitMs, 1e-9)
+	assert.InDelta(t, 25, d.WritesPerSec, 1e-9)
+	assert.InDelta(t, 50, d.UtilPct, 1e-9)
+	assert.InDelta(t, 12.5, second.CPU.Iowait, 1e-9)
+	assert.InDelta(t, 50, second.CPU.Idle, 1e-9)
+
+	// what the command writes parses back into the same numbers
+	var buf bytes.Buffer
+	require.NoError(t, WriteBanner(&buf, host))
+	require.NoError(t, WriteSample(&buf, first))
+	require.NoError(t, WriteSample(&buf, second))
+	data, err := parser.ParseIostatOutput(buf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, "db1", data.Host.Hostname)
+	require.Len(t, data.Devices["sda"], 2)
+	assert.True(t, data.Devices["sda"][0].SinceBoot)
+	assert.InDelta(t, 500, data.Devices["sda"][1].ReadKBPerSec, 1e-9)
+	assert.InDelta(t, 50, data.Devices["sda"][1].UtilPct, 1e-9)
+}
+
+func TestSamplerMissingProc(t *testing.T) {
+	_, err := NewSampler(t.TempDir()).Sample(time.Now())
+	assert.Error(t, err)
+}
@@ -0,0 +1,46 @@
+package collect
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/rsvihladremio/iostat-reporter/parser"
+)
+
+// deviceHeader is the extended device header of sysstat 12.
+const deviceHeader = "Device            r/s     rkB/s   rrqm/s  %rrqm r_await rareq-sz     w/s     wkB/s   wrqm/s  %wrqm w_await wareq-sz     d/s     dkB/s   drqm/s  %drqm d_await dareq-sz     f/s f_await  aqu-sz  %util"
+
+// WriteBanner writes the first line iostat prints.
+func WriteBanner(w io.Writer, host parser.HostInfo) error {
+	_, err := fmt.Fprintf(w, "%s %s (%s) \t%s \t_%s_\t(%d CPU)\n\n",
+		host.OS, host.Kernel, host.Hostname, host.Date.Format("01/02/06"), host.Arch, host.CPUCount)
+	return err
+}
+
+// WriteSample writes one report in the layout of iostat -x -t.
+func WriteSample(w io.Writer, s parser.Sample) error {
+	if _, err := fmt.Fprintf(w, "%s\n", s.Timestamp.Format("01/02/06 15:04:05")); err != nil {
+		return err
+	}
+	if c := s.CPU; c != nil {
+		if _, err := fmt.Fprintf(w, "avg-cpu:  %%user   %%nice %%system %%iowait  %%steal   %%idle\n        %7.2f %7.2f %7.2f %7.2f %7.2f %7.2f\n\n",
+			c.User, c.Nice, c.System, c.Iowait, c.Steal, c.Idle); err != nil {
+			return err
+		}
+	}
+	if _, err := io.WriteString(w, deviceHeader+"\n"); err != nil {
+		return err
+	}
+	for _, d := range s.Devices {
+		if _, err := fmt.Fprintf(w, "%-12s %8.2f %9.2f %8.2f %6.2f %7.2f %8.2f %7.2f %9.2f %8.2f %6.2f %7.2f %8.2f %7.2f %9.2f %8.2f %6.2f %7.2f %8.2f %7.2f %7.2f %7.2f %6.2f\n",
+			d.Name,
+			d.ReadsPerSec, d.ReadKBPerSec, d.ReadMergedPerSec, d.ReadPctMerged, d.ReadAwaitMs, d.ReadReqSzKB,
+			d.WritesPerSec, d.WriteKBPerSec, d.WriteMergedPerSec, d.WritePctMerged, d.WriteAwaitMs, d.WriteReqSzKB,
+			d.DiscardsPerSec, d.DiscardKBPerSec, d.DiscardMergedPerSec, d.DiscardPctMerged, d.DiscardAwaitMs, d.DiscardReqSzKB,
+			d.FlushesPerSec, d.FlushAwaitMs, d.QueueSize, d.UtilPct); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/rsvihladremio/iostat-reporter/collect"
+	"github.com/rsvihladremio/iostat-reporter/parser"
+)
+
+// runCollect implements "iorep collect": sample /proc like iostat -x -t and
+// write a capture iorep can render.
+func runCollect(args []string) {
+	flags := pflag.NewFlagSet("collect", pflag.ExitOnError)
+	root := flags.String("root", "/", "Directory holding proc/ and sys/, e.g. a copy of another host's")
+	interval := flags.DurationP("interval", "i", time.Second, "Time between samples")
+	count := flags.IntP("count", "c", 0, "Number of samples including the since-boot one (0 runs until interrupted)")
+	output := flags.StringP("output", "o", "-", "Capture file to write, - for stdout")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: iorep collect [flags]\n\nSample /proc/diskstats and /proc/stat like iostat -x -t.\n\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output) // #nosec G304
+		if err != nil {
+			log.Fatalf("Error creating capture file: %v", err)
+		}
+		defer func() {
+			if cerr := f.Close(); cerr != nil {
+				fmt.Fprintf(os.Stderr, "warning: failed to close capture file: %v\n", cerr)
+			}
+		}()
+		out = f
+	}
+	w := bufio.NewWriter(out)
+
+	sampler := collect.NewSampler(*root)
+	host, err := sampler.Host(time.Now())
+	if err != nil {
+		log.Fatalf("Error reading host details: %v", err)
+	}
+	if err := collect.WriteBanner(w, host); err != nil {
+		log.Fatalf("Error writing capture: %v", err)
+	}
+
+	// stop cleanly on Ctrl-C so the capture ends with a whole report
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err = collect.Run(ctx, sampler, *interval, *count, func(s parser.Sample) error {
+		if err := collect.WriteSample(w, s); err != nil {
+			return err
+		}
+		// flush every report so the capture can be followed while it grows
+		return w.Flush()
+	})
+	if err != nil {
+		log.Fatalf("Error collecting: %v", err)
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatalf("Error writing capture: %v", err)
+	}
+}
@@ -4,10 +4,14 @@ go 1.24.4
 
 require github.com/stretchr/testify v1.8.4 // for testing
 
-require github.com/spf13/pflag v1.0.7
+require (
+	github.com/klauspost/compress v1.18.0
+	github.com/spf13/pflag v1.0.7
+	github.com/ulikunitz/xz v0.5.9
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
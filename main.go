@@ -1,14 +1,22 @@
 package main
 
 import (
+	"bufio"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"github.com/spf13/pflag"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	_ "time/tzdata" // --timezone works without a system zoneinfo database
 
+	"github.com/rsvihladremio/iostat-reporter/analysis"
+	"github.com/rsvihladremio/iostat-reporter/bundle"
+	"github.com/rsvihladremio/iostat-reporter/cloud"
 	"github.com/rsvihladremio/iostat-reporter/parser" // Import the parser package
 	"github.com/rsvihladremio/iostat-reporter/reporter"
 )
@@ -17,13 +25,47 @@ var (
 	outputFile  string
 	reportTitle string
 	metadata    string
+	timeFormat  string
+	dateOrder   string
+	lenient     bool
+	maxPoints   int
+	sinceBoot   string
+	timezone    string
+	combine     string
+	format      string
+	lsblkFile   string
+	deviceView  string
+	thresholds  string
+	rulesFile   string
+	volumes     []string
+	burstStart  float64
 	Version     string = "dev" // overridden via -ldflags "-X main.Version=…"
 )
 
+// collecting is set for "iorep collect", whose flags runCollect parses.
+var collecting = len(os.Args) > 1 && os.Args[1] == "collect"
+
 func init() {
+	if collecting {
+		return
+	}
 	pflag.StringVarP(&outputFile, "output", "o", "iostat.html", "Output HTML file path")
 	pflag.StringVarP(&reportTitle, "name", "n", "Iostat Report", "Report title")
 	pflag.StringVarP(&metadata, "metadata", "m", "", "Additional metadata as JSON string")
+	pflag.StringVar(&timeFormat, "time-format", "", "Force the Go time layout of timestamp lines, e.g. '02/01/2006 03:04:05 PM' (default: detect)")
+	pflag.StringVar(&dateOrder, "date-order", "auto", "How to read ambiguous numeric dates: auto, mdy or dmy")
+	pflag.BoolVar(&lenient, "lenient", false, "Skip blocks that cannot be parsed and list them in the report instead of failing")
+	pflag.IntVar(&maxPoints, "max-points", 10000, "Maximum samples kept per chart; longer captures are averaged down to fit (0 keeps every sample)")
+	pflag.StringVar(&sinceBoot, "since-boot", "auto", "How to chart the since-boot report iostat prints first: auto, exclude, shade or include")
+	pflag.StringVar(&timezone, "timezone", "", "Show times in this timezone: local, an IANA name such as Europe/Berlin, or an offset such as +02:00 (default: as captured)")
+	pflag.StringVar(&combine, "combine", "merge", "With several inputs: merge them into one timeline, or chart them as separate hosts")
+	pflag.StringVar(&format, "format", "auto", "Input format: auto to detect it, or one of "+strings.Join(parser.Formats(), ", "))
+	pflag.StringVar(&lsblkFile, "lsblk", "", "lsblk -J output of the host, to nest devices under the disks they are built on (default: infer from device names)")
+	pflag.StringVar(&deviceView, "device-view", "nested", "How to chart partitions and dm/md devices: nested under their disks, or rollup into the disks only")
+	pflag.StringVar(&thresholds, "thresholds", "", "Saturation summary thresholds as key=value pairs, e.g. iowait=10,cpu-low=50,cpu=90,queue=1 (default: those values)")
+	pflag.StringVar(&rulesFile, "rules", "", "YAML or JSON file of findings rules added to the built-in ones; a rule named like a built-in one replaces it")
+	pflag.StringArrayVar(&volumes, "volume", nil, "Compare a device with the caps of its cloud volume, e.g. nvme1n1=gp3:3000iops:125MBps or xvdf=gp2:500GiB; repeat for more devices. Types: "+volumeTypes())
+	pflag.Float64Var(&burstStart, "burst-balance", 100, "How full, in percent, the burst-credit buckets of --volume devices are when the capture starts")
 	showVersion := pflag.Bool("version", false, "show version and exit")
 
 	pflag.Parse()
@@ -35,36 +77,307 @@ func init() {
 }
 
 func main() {
+	if collecting {
+		runCollect(os.Args[2:])
+		return
+	}
 
-	// Validate input file
-	args := pflag.Args()
-	if len(args) < 1 {
-		log.Fatal("Please provide an input file")
+	// Validate input files
+	inputs, err := expandInputs(pflag.Args())
+	if err != nil {
+		log.Fatal(err)
 	}
-	inputFile := args[0]
 
-	// Sanitize and read input file
-	cleanInput := filepath.Clean(inputFile)
-	if strings.Contains(cleanInput, "..") {
-		log.Fatalf("invalid input path: %s", inputFile)
+	sinceBootMode, err := reporter.ParseSinceBootMode(sinceBoot)
+	if err != nil {
+		log.Fatalf("Invalid --since-boot: %v", err)
 	}
-	data, err := os.ReadFile(cleanInput)
+	zone, err := reporter.ParseTimezone(timezone)
 	if err != nil {
-		log.Fatalf("Error reading input file: %v", err)
+		log.Fatalf("Invalid --timezone: %v", err)
 	}
-
-	// Parse input using the new package
-	parsedData, err := parser.ParseIostatOutput(data)
+	view, err := reporter.ParseDeviceView(deviceView)
+	if err != nil {
+		log.Fatalf("Invalid --device-view: %v", err)
+	}
+	limits, err := analysis.ParseThresholds(thresholds)
 	if err != nil {
-		log.Fatalf("Error parsing top output: %v", err)
+		log.Fatalf("Invalid --thresholds: %v", err)
+	}
+	reportOpts := []reporter.Option{reporter.WithSinceBoot(sinceBootMode), reporter.WithTimezone(zone), reporter.WithDeviceView(view), reporter.WithThresholds(limits)}
+	if lsblkFile != "" {
+		data, err := os.ReadFile(filepath.Clean(lsblkFile))
+		if err != nil {
+			log.Fatalf("Invalid --lsblk: %v", err)
+		}
+		topology, err := parser.ParseLsblk(data)
+		if err != nil {
+			log.Fatalf("Invalid --lsblk: %v", err)
+		}
+		reportOpts = append(reportOpts, reporter.WithTopology(topology))
+	}
+	if rulesFile != "" {
+		data, err := os.ReadFile(filepath.Clean(rulesFile))
+		if err != nil {
+			log.Fatalf("Invalid --rules: %v", err)
+		}
+		rules, err := analysis.ParseRules(data)
+		if err != nil {
+			log.Fatalf("Invalid --rules: %v", err)
+		}
+		reportOpts = append(reportOpts, reporter.WithRules(analysis.MergeRules(analysis.BuiltinRules(), rules)))
+	}
+	if len(volumes) > 0 {
+		mapped := make(map[string]cloud.Volume, len(volumes))
+		for _, spec := range volumes {
+			dev, vol, err := cloud.ParseMapping(spec)
+			if err != nil {
+				log.Fatalf("Invalid --volume: %v", err)
+			}
+			mapped[dev] = vol
+		}
+		if burstStart < 0 || burstStart > 100 {
+			log.Fatalf("Invalid --burst-balance %v (want 0 to 100)", burstStart)
+		}
+		reportOpts = append(reportOpts, reporter.WithVolumes(mapped), reporter.WithBurstBalance(burstStart))
+	}
+	if combine != "merge" && combine != "hosts" {
+		log.Fatalf("Invalid --combine %q (want merge or hosts)", combine)
+	}
+	order, err := parser.ParseDateOrder(dateOrder)
+	if err != nil {
+		log.Fatalf("Invalid --date-order: %v", err)
+	}
+	parseOpts := []parser.Option{parser.WithDateOrder(order)}
+	if format != "auto" {
+		if _, ok := parser.LookupFormat(format); !ok {
+			log.Fatalf("Invalid --format %q (want auto or one of %s)", format, strings.Join(parser.Formats(), ", "))
+		}
+		parseOpts = append(parseOpts, parser.WithFormat(format))
+	}
+	if timeFormat != "" {
+		parseOpts = append(parseOpts, parser.WithTimeLayout(timeFormat))
+	}
+	if lenient {
+		parseOpts = append(parseOpts, parser.WithLenient())
+	}
+
+	// Parse each input, keeping its name and hash for the header
+	var (
+		sets        []parser.ParsedData
+		setStreamed []*reporter.Streamed
+		names       []string
+		files       []reporter.File
+		// archive members are grouped into one data set per directory
+		nodes          []string
+		byNode         = make(map[string][]parser.ParsedData)
+		byNodeStreamed = make(map[string][]*reporter.Streamed)
+	)
+	for _, input := range inputs {
+		// a single file is named as before so the shasum command works in its directory
+		name := input
+		if len(inputs) == 1 && input != "-" {
+			name = filepath.Base(input)
+		}
+		if input == "-" {
+			name = "stdin"
+		}
+		captures, file, err := readInput(input, name, parseOpts, reportOpts)
+		if err != nil {
+			if len(inputs) > 1 {
+				err = fmt.Errorf("%s: %w", name, err)
+			}
+			if !lenient && !errors.Is(err, parser.ErrNoSamples) {
+				log.Fatalf("Error parsing iostat output: %v (rerun with --lenient to skip unparseable blocks)", err)
+			}
+			log.Fatalf("Error parsing iostat output: %v", err)
+		}
+		files = append(files, file)
+
+		for _, c := range captures {
+			label := name
+			if c.member != "" {
+				label = name + ":" + c.member
+			}
+			if n := len(c.data.Warnings); n > 0 {
+				fmt.Fprintf(os.Stderr, "warning: skipped %d unparseable block(s) in %s, see the report's parse warnings\n", n, label)
+			}
+			if len(inputs) > 1 || c.member != "" {
+				for i := range c.data.Warnings {
+					c.data.Warnings[i].File = label
+				}
+			}
+			if c.member == "" {
+				sets = append(sets, c.data)
+				setStreamed = append(setStreamed, c.streamed)
+				names = append(names, name)
+				continue
+			}
+			node := bundle.NodeName(c.member)
+			if len(inputs) > 1 {
+				node = name + ":" + node
+			}
+			if _, ok := byNode[node]; !ok {
+				nodes = append(nodes, node)
+			}
+			byNode[node] = append(byNode[node], c.data)
+			byNodeStreamed[node] = append(byNodeStreamed[node], c.streamed)
+		}
+	}
+
+	var hosts []reporter.Host
+	if combine == "merge" && len(sets) > 0 {
+		hosts = append(hosts, reporter.Host{Name: strings.Join(names, " + "), Data: parser.Merge(sets...), Streamed: reporter.MergeStreamed(setStreamed...)})
+	} else {
+		for i, data := range sets {
+			hosts = append(hosts, reporter.Host{Name: names[i], Data: data, Streamed: setStreamed[i]})
+		}
+	}
+	sort.Strings(nodes)
+	for _, node := range nodes {
+		hosts = append(hosts, reporter.Host{Name: node, Data: parser.Merge(byNode[node]...), Streamed: reporter.MergeStreamed(byNodeStreamed[node]...)})
+	}
+	if len(hosts) == 0 {
+		log.Fatalf("No iostat captures found in %s", strings.Join(inputs, ", "))
 	}
 
 	// Generate report
-	fileName := filepath.Base(cleanInput)
-	fileHash := fmt.Sprintf("%x", sha256.Sum256(data))
-	if err := reporter.GenerateReport(parsedData, outputFile, reportTitle, metadata, fileName, fileHash, Version); err != nil {
+	if err := reporter.GenerateHostsReport(hosts, files, outputFile, reportTitle, metadata, Version, reportOpts...); err != nil {
 		log.Fatalf("Error generating report: %v", err)
 	}
 
 	fmt.Printf("report '%s' written to %s\n", reportTitle, outputFile)
 }
+
+// expandInputs resolves glob patterns in args. "-" stands for stdin and may
+// be given once.
+func expandInputs(args []string) ([]string, error) {
+	if len(args) < 1 {
+		return nil, errors.New("Please provide an input file, a glob or - for stdin")
+	}
+	var inputs []string
+	stdin := false
+	for _, arg := range args {
+		if arg == "-" {
+			if stdin {
+				return nil, errors.New("stdin (-) can only be read once")
+			}
+			stdin = true
+			inputs = append(inputs, arg)
+			continue
+		}
+		matches := []string{arg}
+		if strings.ContainsAny(arg, "*?[") {
+			var err error
+			if matches, err = filepath.Glob(arg); err != nil {
+				return nil, fmt.Errorf("invalid glob %q: %w", arg, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match %q", arg)
+			}
+		}
+		for _, m := range matches {
+			clean := filepath.Clean(m)
+			if strings.Contains(clean, "..") {
+				return nil, fmt.Errorf("invalid input path: %s", m)
+			}
+			inputs = append(inputs, clean)
+		}
+	}
+	return inputs, nil
+}
+
+// capture is one parsed iostat capture; member names it within an archive.
+type capture struct {
+	member string
+	data   parser.ParsedData
+	// streamed covers every sample, also those data averaged down
+	streamed *reporter.Streamed
+}
+
+// readInput parses one input, a capture or an archive of captures, while
+// streaming it through the SHA-256 hash. reportOpts set what is counted
+// from every sample, see reporter.Streamed.
+func readInput(input, name string, opts []parser.Option, reportOpts []reporter.Option) ([]capture, reporter.File, error) {
+	var (
+		r    io.Reader = os.Stdin
+		file *os.File
+	)
+	if input != "-" {
+		f, err := os.Open(input)
+		if err != nil {
+			return nil, reporter.File{}, fmt.Errorf("reading input file: %w", err)
+		}
+		defer func() {
+			if cerr := f.Close(); cerr != nil {
+				fmt.Fprintf(os.Stderr, "warning: failed to close input file: %v\n", cerr)
+			}
+		}()
+		r, file = f, f
+	}
+
+	// Stream input through the hash while parsing, detecting the input format
+	hasher := sha256.New()
+	br := bufio.NewReaderSize(io.TeeReader(r, hasher), parser.SniffLen)
+	prefix, _ := br.Peek(parser.SniffLen)
+
+	var (
+		captures []capture
+		err      error
+	)
+	if bundle.IsArchive(prefix) {
+		var src io.Reader = br
+		if file != nil && bundle.IsZip(prefix) {
+			// zip needs random access; the hash still reads the file through br below
+			if st, serr := file.Stat(); serr == nil {
+				src = io.NewSectionReader(file, 0, st.Size())
+			}
+		}
+		err = bundle.Walk(src, func(member string, mr io.Reader) error {
+			c, err := parseCapture(mr, opts, reportOpts)
+			if err != nil {
+				return fmt.Errorf("%s: %w", member, err)
+			}
+			c.member = member
+			captures = append(captures, c)
+			return nil
+		})
+	} else {
+		var c capture
+		c, err = parseCapture(br, opts, reportOpts)
+		captures = []capture{c}
+	}
+	if err == nil {
+		// hash any trailing bytes the parser did not need
+		_, err = io.Copy(io.Discard, br)
+	}
+	return captures, reporter.File{Name: name, Hash: fmt.Sprintf("%x", hasher.Sum(nil))}, err
+}
+
+// parseCapture parses one capture, keeping at most --max-points samples per
+// series, and works out the device statistics, summary and findings from
+// every sample.
+func parseCapture(r io.Reader, opts []parser.Option, reportOpts []reporter.Option) (capture, error) {
+	collector := parser.NewCollector(maxPoints)
+	streamed, err := reporter.NewStreamed(reportOpts...)
+	if err != nil {
+		return capture{}, err
+	}
+	info, err := parser.Stream(r, func(s parser.Sample) error {
+		if err := streamed.Add(s); err != nil {
+			return err
+		}
+		return collector.Add(s)
+	}, opts...)
+	return capture{data: collector.Data(info), streamed: streamed}, err
+}
+
+// volumeTypes lists the cloud volume types --volume knows.
+func volumeTypes() string {
+	types := cloud.Types()
+	names := make([]string, 0, len(types))
+	for _, t := range types {
+		names = append(names, t.Provider+" "+t.Name)
+	}
+	return strings.Join(names, ", ")
+}
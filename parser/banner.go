@@ -0,0 +1,37 @@
+package parser
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// bannerPattern matches the first line iostat prints, e.g.
+//
+//	Linux 5.15.0-1051-aws (ip-10-0-1-5) 	09/04/24 	_x86_64_	(16 CPU)
+//
+// Very old sysstat releases omit the architecture and CPU count.
+var bannerPattern = regexp.MustCompile(`^(\S+)\s+(\S+)\s+\(([^)]*)\)\s+(\S+)(?:\s+_(\S+)_)?(?:\s+\((\d+) CPU\))?$`)
+
+// parseBanner parses an iostat banner line into HostInfo.
+func parseBanner(line string, order DateOrder) (HostInfo, bool) {
+	m := bannerPattern.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return HostInfo{}, false
+	}
+	date, err := parseDate(m[4], order)
+	if err != nil {
+		return HostInfo{}, false
+	}
+	host := HostInfo{
+		OS:       m[1],
+		Kernel:   m[2],
+		Hostname: m[3],
+		Date:     date,
+		Arch:     m[5],
+	}
+	if m[6] != "" {
+		host.CPUCount, _ = strconv.Atoi(m[6])
+	}
+	return host, true
+}
@@ -0,0 +1,119 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseBanner covers banners from current and old sysstat releases.
+func TestParseBanner(t *testing.T) {
+	tests := []struct {
+		line  string
+		order DateOrder
+		want  HostInfo
+		date  string
+	}{
+		{
+			line: "Linux 5.15.0-1051-aws (ip-10-0-1-5) \t09/04/24 \t_x86_64_\t(16 CPU)",
+			want: HostInfo{OS: "Linux", Kernel: "5.15.0-1051-aws", Hostname: "ip-10-0-1-5", Arch: "x86_64", CPUCount: 16},
+			date: "2024-09-04",
+		},
+		{
+			line: "Linux 4.18.0-513.el8.aarch64 (db-01.example.com) \t2024-09-04 \t_aarch64_\t(64 CPU)",
+			want: HostInfo{OS: "Linux", Kernel: "4.18.0-513.el8.aarch64", Hostname: "db-01.example.com", Arch: "aarch64", CPUCount: 64},
+			date: "2024-09-04",
+		},
+		{
+			line:  "Linux 3.10.0-1160.el7.x86_64 (web) \t04/09/2024 \t_x86_64_\t(4 CPU)",
+			order: DateOrderDMY,
+			want:  HostInfo{OS: "Linux", Kernel: "3.10.0-1160.el7.x86_64", Hostname: "web", Arch: "x86_64", CPUCount: 4},
+			date:  "2024-09-04",
+		},
+		{
+			line: "Linux 2.6.18-419.el5 (old) \t09/04/24",
+			want: HostInfo{OS: "Linux", Kernel: "2.6.18-419.el5", Hostname: "old"},
+			date: "2024-09-04",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want.Hostname, func(t *testing.T) {
+			host, ok := parseBanner(tt.line, tt.order)
+			assert.True(t, ok)
+			assert.Equal(t, tt.date, host.Date.Format("2006-01-02"))
+			host.Date = tt.want.Date
+			assert.Equal(t, tt.want, host)
+		})
+	}
+}
+
+// TestParseBannerRejects ignores lines that only look similar.
+func TestParseBannerRejects(t *testing.T) {
+	for _, line := range []string{
+		"avg-cpu:  %user   %nice %system %iowait  %steal   %idle",
+		"Device            r/s     w/s",
+		"Linux 5.15.0 (host) not-a-date _x86_64_ (16 CPU)",
+	} {
+		_, ok := parseBanner(line, DateOrderAuto)
+		assert.False(t, ok, line)
+	}
+}
+
+// TestParseIostatOutputHost fills HostInfo from the fixture banners.
+func TestParseIostatOutputHost(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "sysstat-9.0.4.txt"))
+	assert.NoError(t, err)
+	p, err := ParseIostatOutput(data)
+	assert.NoError(t, err)
+	assert.Equal(t, "legacy-host", p.Host.Hostname)
+	assert.Equal(t, "2.6.32-754.el6.x86_64", p.Host.Kernel)
+	assert.Equal(t, 4, p.Host.CPUCount)
+
+	// 2.36 user + 0.40 system + 0.01 steal on 4 CPUs
+	assert.InDelta(t, 2.77, p.CPUs[0].BusyPct(), 1e-9)
+	assert.InDelta(t, 0.1108, p.Host.CoresBusy(p.CPUs[0]), 1e-9)
+}
+
+// TestParseIostatOutputSinceBoot flags the first report after each banner.
+func TestParseIostatOutputSinceBoot(t *testing.T) {
+	banner := "Linux 5.15.0 (host) \t09/04/24 \t_x86_64_\t(16 CPU)\n\n"
+	block := func(ts string) string {
+		return ts + "\navg-cpu:  %user %idle\n 1.00 99.00\n\nDevice r/s\nsda 1.00\n\n"
+	}
+	tests := []struct {
+		name  string
+		input string
+		want  []bool
+	}{
+		{"banner", banner + block("09/04/24 12:00:00") + block("09/04/24 12:00:01"), []bool{true, false}},
+		{"logrotate continuation", block("09/04/24 12:00:00") + block("09/04/24 12:00:01"), []bool{false, false}},
+		{"restarted run", banner + block("09/04/24 12:00:00") + block("09/04/24 12:00:01") + banner + block("09/04/24 12:05:00"), []bool{true, false, true}},
+		{"-y run past midnight", banner + block("09/05/24 00:10:00") + block("09/05/24 00:10:01"), []bool{false, false}},
+		{"started just after midnight", banner + block("09/04/24 00:00:03") + block("09/04/24 00:00:13"), []bool{true, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ParseIostatOutput([]byte(tt.input))
+			assert.NoError(t, err)
+			var cpus, devs []bool
+			for i := range p.CPUs {
+				cpus = append(cpus, p.CPUs[i].SinceBoot)
+				devs = append(devs, p.Devices["sda"][i].SinceBoot)
+			}
+			assert.Equal(t, tt.want, cpus)
+			assert.Equal(t, tt.want, devs)
+		})
+	}
+}
+
+// TestParseIostatJSONSinceBoot flags the first statistics entry.
+func TestParseIostatJSONSinceBoot(t *testing.T) {
+	p, err := ParseIostatJSON([]byte(jsonSample))
+	assert.NoError(t, err)
+	assert.True(t, p.CPUs[0].SinceBoot)
+	assert.True(t, p.Devices["sda"][0].SinceBoot)
+	assert.False(t, p.CPUs[1].SinceBoot)
+	assert.False(t, p.Devices["sdb"][0].SinceBoot)
+}
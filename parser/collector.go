@@ -0,0 +1,349 @@
+package parser
+
+import "time"
+
+// Collector accumulates streamed samples into ParsedData. With a point limit,
+// adjacent samples are merged by averaging each metric whenever the limit is
+// exceeded, halving the resolution each time, so memory stays bounded however
+// long the capture is.
+type Collector struct {
+	maxPoints int
+	// width is the number of samples merged into each point
+	width  int
+	points []point
+	// regular counts the samples added to points
+	regular int
+	// sinceBoot samples are never averaged with interval samples
+	sinceBoot []sinceBootPoint
+
+	// unlimited collectors append straight to data
+	data ParsedData
+}
+
+// point holds the sums of the samples merged into it.
+type point struct {
+	timestamp time.Time
+	samples   int
+	cpu       CPUStats
+	cpus      int
+	devices   []DeviceStats
+	devCounts []int
+	devIndex  map[string]int
+	vm        VMStats
+	vms       int
+	cores     []CoreStats
+	coreCount []int
+	coreIndex map[string]int
+}
+
+// sinceBootPoint is a since-boot sample and the number of regular samples
+// that preceded it.
+type sinceBootPoint struct {
+	point
+	before int
+	unsure bool
+}
+
+// NewCollector returns a Collector keeping at most maxPoints samples per
+// series. A maxPoints of zero or less keeps every sample.
+func NewCollector(maxPoints int) *Collector {
+	return &Collector{
+		maxPoints: maxPoints,
+		width:     1,
+		data:      ParsedData{Devices: make(map[string][]DeviceStats)},
+	}
+}
+
+// Add records one sample. It never fails; the error result lets it be passed
+// to Stream directly.
+func (c *Collector) Add(s Sample) error {
+	if c.maxPoints <= 0 {
+		if s.CPU != nil {
+			c.data.CPUs = append(c.data.CPUs, *s.CPU)
+		}
+		for _, dev := range s.Devices {
+			c.data.Devices[dev.Name] = append(c.data.Devices[dev.Name], dev)
+		}
+		if s.VM != nil {
+			c.data.VM = append(c.data.VM, *s.VM)
+		}
+		for _, core := range s.Cores {
+			if c.data.Cores == nil {
+				c.data.Cores = make(map[string][]CoreStats)
+			}
+			c.data.Cores[core.CPU] = append(c.data.Cores[core.CPU], core)
+		}
+		return nil
+	}
+
+	if s.SinceBoot {
+		p := point{timestamp: s.Timestamp, devIndex: make(map[string]int)}
+		p.add(s)
+		c.sinceBoot = append(c.sinceBoot, sinceBootPoint{point: p, before: c.regular, unsure: s.SinceBootUnsure})
+		return nil
+	}
+	c.regular++
+	if n := len(c.points); n == c.maxPoints && c.points[n-1].samples >= c.width {
+		c.halve()
+	}
+	if n := len(c.points); n == 0 || c.points[n-1].samples >= c.width {
+		c.points = append(c.points, point{timestamp: s.Timestamp, devIndex: make(map[string]int)})
+	}
+	c.points[len(c.points)-1].add(s)
+	return nil
+}
+
+// add sums one sample into the point.
+func (p *point) add(s Sample) {
+	p.samples++
+	if s.CPU != nil {
+		addCPUStats(&p.cpu, *s.CPU)
+		p.cpus++
+	}
+	for _, dev := range s.Devices {
+		p.addDevice(dev, 1)
+	}
+	if s.VM != nil {
+		addVMStats(&p.vm, *s.VM)
+		p.vms++
+	}
+	for _, core := range s.Cores {
+		p.addCore(core, 1)
+	}
+}
+
+// halve merges each pair of adjacent points and doubles the point width.
+func (c *Collector) halve() {
+	merged := c.points[:0]
+	for i := 0; i < len(c.points); i += 2 {
+		p := c.points[i]
+		if i+1 < len(c.points) {
+			q := c.points[i+1]
+			p.samples += q.samples
+			addCPUStats(&p.cpu, q.cpu)
+			p.cpus += q.cpus
+			for j, dev := range q.devices {
+				p.addDevice(dev, q.devCounts[j])
+			}
+			addVMStats(&p.vm, q.vm)
+			p.vms += q.vms
+			for j, core := range q.cores {
+				p.addCore(core, q.coreCount[j])
+			}
+		}
+		merged = append(merged, p)
+	}
+	c.points = merged
+	c.width *= 2
+}
+
+// addDevice adds dev, itself the sum of count samples, to the point.
+func (p *point) addDevice(dev DeviceStats, count int) {
+	i, ok := p.devIndex[dev.Name]
+	if !ok {
+		i = len(p.devices)
+		p.devIndex[dev.Name] = i
+		p.devices = append(p.devices, DeviceStats{Name: dev.Name})
+		p.devCounts = append(p.devCounts, 0)
+	}
+	addDeviceStats(&p.devices[i], dev)
+	p.devCounts[i] += count
+}
+
+// addCore adds core, itself the sum of count samples, to the point.
+func (p *point) addCore(core CoreStats, count int) {
+	i, ok := p.coreIndex[core.CPU]
+	if !ok {
+		if p.coreIndex == nil {
+			p.coreIndex = make(map[string]int)
+		}
+		i = len(p.cores)
+		p.coreIndex[core.CPU] = i
+		p.cores = append(p.cores, CoreStats{CPU: core.CPU})
+		p.coreCount = append(p.coreCount, 0)
+	}
+	addCoreStats(&p.cores[i], core)
+	p.coreCount[i] += count
+}
+
+// Data returns everything collected so far together with the capture-wide
+// info of the stream.
+func (c *Collector) Data(info StreamInfo) ParsedData {
+	parsed := c.data
+	if c.maxPoints > 0 {
+		parsed = ParsedData{Devices: make(map[string][]DeviceStats)}
+		sb := c.sinceBoot
+		for i, p := range c.points {
+			// every point but the last holds width samples
+			for len(sb) > 0 && sb[0].before <= i*c.width {
+				sb[0].appendTo(&parsed, true, sb[0].unsure)
+				sb = sb[1:]
+			}
+			p.appendTo(&parsed, false, false)
+		}
+		for _, p := range sb {
+			p.appendTo(&parsed, true, p.unsure)
+		}
+	}
+	parsed.Host = info.Host
+	parsed.Warnings = info.Warnings
+	parsed.Interval = info.Interval
+	parsed.Segments = info.Segments
+	return parsed
+}
+
+// appendTo appends the averages of the point to parsed.
+func (p point) appendTo(parsed *ParsedData, sinceBoot, unsure bool) {
+	if p.cpus > 0 {
+		cpu := p.cpu
+		scaleCPUStats(&cpu, 1/float64(p.cpus))
+		cpu.Timestamp = p.timestamp
+		cpu.SinceBoot, cpu.SinceBootUnsure = sinceBoot, unsure
+		parsed.CPUs = append(parsed.CPUs, cpu)
+	}
+	for i, dev := range p.devices {
+		scaleDeviceStats(&dev, 1/float64(p.devCounts[i]))
+		dev.Timestamp = p.timestamp
+		dev.SinceBoot, dev.SinceBootUnsure = sinceBoot, unsure
+		parsed.Devices[dev.Name] = append(parsed.Devices[dev.Name], dev)
+	}
+	if p.vms > 0 {
+		vm := p.vm
+		scaleVMStats(&vm, 1/float64(p.vms))
+		vm.Timestamp = p.timestamp
+		vm.SinceBoot = sinceBoot
+		parsed.VM = append(parsed.VM, vm)
+	}
+	for i, core := range p.cores {
+		scaleCoreStats(&core, 1/float64(p.coreCount[i]))
+		core.Timestamp = p.timestamp
+		if parsed.Cores == nil {
+			parsed.Cores = make(map[string][]CoreStats)
+		}
+		parsed.Cores[core.CPU] = append(parsed.Cores[core.CPU], core)
+	}
+}
+
+func addCPUStats(a *CPUStats, b CPUStats) {
+	a.User += b.User
+	a.Nice += b.Nice
+	a.System += b.System
+	a.Iowait += b.Iowait
+	a.Steal += b.Steal
+	a.Idle += b.Idle
+}
+
+func scaleCPUStats(a *CPUStats, f float64) {
+	a.User *= f
+	a.Nice *= f
+	a.System *= f
+	a.Iowait *= f
+	a.Steal *= f
+	a.Idle *= f
+}
+
+func addDeviceStats(a *DeviceStats, b DeviceStats) {
+	a.ReadsPerSec += b.ReadsPerSec
+	a.ReadKBPerSec += b.ReadKBPerSec
+	a.ReadMergedPerSec += b.ReadMergedPerSec
+	a.ReadPctMerged += b.ReadPctMerged
+	a.ReadAwaitMs += b.ReadAwaitMs
+	a.ReadReqSzKB += b.ReadReqSzKB
+	a.WritesPerSec += b.WritesPerSec
+	a.WriteKBPerSec += b.WriteKBPerSec
+	a.WriteMergedPerSec += b.WriteMergedPerSec
+	a.WritePctMerged += b.WritePctMerged
+	a.WriteAwaitMs += b.WriteAwaitMs
+	a.WriteReqSzKB += b.WriteReqSzKB
+	a.DiscardsPerSec += b.DiscardsPerSec
+	a.DiscardKBPerSec += b.DiscardKBPerSec
+	a.DiscardMergedPerSec += b.DiscardMergedPerSec
+	a.DiscardPctMerged += b.DiscardPctMerged
+	a.DiscardAwaitMs += b.DiscardAwaitMs
+	a.DiscardReqSzKB += b.DiscardReqSzKB
+	a.FlushesPerSec += b.FlushesPerSec
+	a.FlushAwaitMs += b.FlushAwaitMs
+	a.TransfersPerSec += b.TransfersPerSec
+	a.ReqSzKB += b.ReqSzKB
+	a.AwaitMs += b.AwaitMs
+	a.SvcTimeMs += b.SvcTimeMs
+	a.QueueSize += b.QueueSize
+	a.UtilPct += b.UtilPct
+}
+
+func scaleDeviceStats(a *DeviceStats, f float64) {
+	a.ReadsPerSec *= f
+	a.ReadKBPerSec *= f
+	a.ReadMergedPerSec *= f
+	a.ReadPctMerged *= f
+	a.ReadAwaitMs *= f
+	a.ReadReqSzKB *= f
+	a.WritesPerSec *= f
+	a.WriteKBPerSec *= f
+	a.WriteMergedPerSec *= f
+	a.WritePctMerged *= f
+	a.WriteAwaitMs *= f
+	a.WriteReqSzKB *= f
+	a.DiscardsPerSec *= f
+	a.DiscardKBPerSec *= f
+	a.DiscardMergedPerSec *= f
+	a.DiscardPctMerged *= f
+	a.DiscardAwaitMs *= f
+	a.DiscardReqSzKB *= f
+	a.FlushesPerSec *= f
+	a.FlushAwaitMs *= f
+	a.TransfersPerSec *= f
+	a.ReqSzKB *= f
+	a.AwaitMs *= f
+	a.SvcTimeMs *= f
+	a.QueueSize *= f
+	a.UtilPct *= f
+}
+
+func addVMStats(a *VMStats, b VMStats) {
+	a.Running += b.Running
+	a.Blocked += b.Blocked
+	a.SwapUsedKB += b.SwapUsedKB
+	a.FreeKB += b.FreeKB
+	a.BuffersKB += b.BuffersKB
+	a.CacheKB += b.CacheKB
+	a.SwapInKBPerSec += b.SwapInKBPerSec
+	a.SwapOutKBPerSec += b.SwapOutKBPerSec
+	a.InterruptsPerSec += b.InterruptsPerSec
+	a.ContextSwitchesPerSec += b.ContextSwitchesPerSec
+}
+
+func scaleVMStats(a *VMStats, f float64) {
+	a.Running *= f
+	a.Blocked *= f
+	a.SwapUsedKB *= f
+	a.FreeKB *= f
+	a.BuffersKB *= f
+	a.CacheKB *= f
+	a.SwapInKBPerSec *= f
+	a.SwapOutKBPerSec *= f
+	a.InterruptsPerSec *= f
+	a.ContextSwitchesPerSec *= f
+}
+
+func addCoreStats(a *CoreStats, b CoreStats) {
+	a.User += b.User
+	a.Nice += b.Nice
+	a.System += b.System
+	a.Iowait += b.Iowait
+	a.IRQ += b.IRQ
+	a.SoftIRQ += b.SoftIRQ
+	a.Steal += b.Steal
+	a.Idle += b.Idle
+}
+
+func scaleCoreStats(a *CoreStats, f float64) {
+	a.User *= f
+	a.Nice *= f
+	a.System *= f
+	a.Iowait *= f
+	a.IRQ *= f
+	a.SoftIRQ *= f
+	a.Steal *= f
+	a.Idle *= f
+}
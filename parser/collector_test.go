@@ -0,0 +1,164 @@
+package parser
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCollectorUnlimited keeps every sample.
+func TestCollectorUnlimited(t *testing.T) {
+	c := NewCollector(0)
+	_, err := Stream(strings.NewReader(syntheticCapture(50)), c.Add)
+	assert.NoError(t, err)
+	p := c.Data(StreamInfo{})
+	assert.Len(t, p.CPUs, 50)
+	assert.Len(t, p.Devices["sda"], 50)
+}
+
+// TestCollectorBounded never holds more than maxPoints points and averages merged samples.
+func TestCollectorBounded(t *testing.T) {
+	c := NewCollector(8)
+	n := 0
+	_, err := Stream(strings.NewReader(syntheticCapture(100)), func(s Sample) error {
+		n++
+		assert.NoError(t, c.Add(s))
+		assert.LessOrEqual(t, len(c.points), 8)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 100, n)
+
+	p := c.Data(StreamInfo{Warnings: []ParseError{{Reason: ErrTruncated}}})
+	assert.Len(t, p.Warnings, 1)
+	// eight averaged points plus the since-boot report, kept apart
+	assert.LessOrEqual(t, len(p.CPUs), 9)
+	assert.Equal(t, len(p.CPUs), len(p.Devices["sda"]))
+
+	assert.True(t, p.CPUs[0].SinceBoot)
+	assert.True(t, p.Devices["sda"][0].SinceBoot)
+	assert.InDelta(t, 0, p.CPUs[0].User, 1e-9)
+
+	// 99 interval samples with width 16: the first point averages samples 1..16
+	assert.False(t, p.CPUs[1].SinceBoot)
+	assert.Equal(t, "2024-09-04 12:00:01", p.CPUs[1].Timestamp.Format("2006-01-02 15:04:05"))
+	assert.InDelta(t, 8.5, p.CPUs[1].User, 1e-9)
+	assert.InDelta(t, 8.5, p.Devices["sda"][1].ReadsPerSec, 1e-9)
+	assert.InDelta(t, 8.5, p.Devices["sdb"][1].WritesPerSec, 1e-9)
+	assert.InDelta(t, 0.5, p.Devices["sda"][1].QueueSize, 1e-9)
+	assert.Equal(t, p.CPUs[2].Timestamp, p.Devices["sda"][2].Timestamp)
+	assert.Equal(t, "2024-09-04 12:00:17", p.CPUs[2].Timestamp.Format("2006-01-02 15:04:05"))
+
+	// the unpaired tail averages what it has
+	last := p.CPUs[len(p.CPUs)-1]
+	assert.InDelta(t, 98, last.User, 1e-9)
+}
+
+// TestCollectorSinceBootOrder keeps since-boot reports where they occurred.
+func TestCollectorSinceBootOrder(t *testing.T) {
+	ts := time.Date(2024, 9, 4, 12, 0, 0, 0, time.UTC)
+	c := NewCollector(2)
+	for i := 0; i < 10; i++ {
+		cpu := CPUStats{User: float64(i)}
+		s := Sample{Timestamp: ts.Add(time.Duration(i) * time.Second), CPU: &cpu}
+		// a restarted run at sample 5
+		if i == 0 || i == 5 {
+			s.markSinceBoot(false)
+		}
+		assert.NoError(t, c.Add(s))
+	}
+	p := c.Data(StreamInfo{})
+	var got []float64
+	var flags []bool
+	for _, cpu := range p.CPUs {
+		got = append(got, cpu.User)
+		flags = append(flags, cpu.SinceBoot)
+	}
+	// regular samples 1-4 and 6-9 average to 2.5 and 7.5, width 4
+	assert.Equal(t, []float64{0, 2.5, 5, 7.5}, got)
+	assert.Equal(t, []bool{true, false, true, false}, flags)
+}
+
+// TestCollectorDeviceAppearsLater averages a device only over samples that had it.
+func TestCollectorDeviceAppearsLater(t *testing.T) {
+	c := NewCollector(1)
+	ts := time.Date(2024, 9, 4, 12, 0, 0, 0, time.UTC)
+	assert.NoError(t, c.Add(Sample{Timestamp: ts, Devices: []DeviceStats{{Name: "sda", ReadsPerSec: 4}}}))
+	assert.NoError(t, c.Add(Sample{Timestamp: ts.Add(time.Second), Devices: []DeviceStats{
+		{Name: "sda", ReadsPerSec: 2}, {Name: "sdb", ReadsPerSec: 10},
+	}}))
+	p := c.Data(StreamInfo{})
+	assert.Len(t, p.CPUs, 0)
+	assert.InDelta(t, 3, p.Devices["sda"][0].ReadsPerSec, 1e-9)
+	assert.InDelta(t, 10, p.Devices["sdb"][0].ReadsPerSec, 1e-9)
+}
+
+// TestAddScaleCoverAllFields guards addDeviceStats and scaleDeviceStats
+// against fields added to the model later.
+func TestAddScaleCoverAllFields(t *testing.T) {
+	check := func(v reflect.Value, want float64) {
+		for i := 0; i < v.NumField(); i++ {
+			if v.Field(i).Kind() == reflect.Float64 {
+				assert.Equal(t, want, v.Field(i).Float(), v.Type().Field(i).Name)
+			}
+		}
+	}
+	fill := func(v reflect.Value) {
+		for i := 0; i < v.NumField(); i++ {
+			if v.Field(i).Kind() == reflect.Float64 {
+				v.Field(i).SetFloat(1)
+			}
+		}
+	}
+
+	var dev, one DeviceStats
+	fill(reflect.ValueOf(&one).Elem())
+	addDeviceStats(&dev, one)
+	addDeviceStats(&dev, one)
+	scaleDeviceStats(&dev, 2)
+	check(reflect.ValueOf(dev), 4)
+
+	var cpu, oneCPU CPUStats
+	fill(reflect.ValueOf(&oneCPU).Elem())
+	addCPUStats(&cpu, oneCPU)
+	addCPUStats(&cpu, oneCPU)
+	scaleCPUStats(&cpu, 2)
+	check(reflect.ValueOf(cpu), 4)
+
+	var vm, oneVM VMStats
+	fill(reflect.ValueOf(&oneVM).Elem())
+	addVMStats(&vm, oneVM)
+	addVMStats(&vm, oneVM)
+	scaleVMStats(&vm, 2)
+	check(reflect.ValueOf(vm), 4)
+
+	var core, oneCore CoreStats
+	fill(reflect.ValueOf(&oneCore).Elem())
+	addCoreStats(&core, oneCore)
+	addCoreStats(&core, oneCore)
+	scaleCoreStats(&core, 2)
+	check(reflect.ValueOf(core), 4)
+}
+
+// TestCollectorVMAndCores averages vmstat and per-CPU series like the others.
+func TestCollectorVMAndCores(t *testing.T) {
+	c := NewCollector(1)
+	ts := time.Date(2024, 9, 4, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		vm := VMStats{Running: float64(i)}
+		assert.NoError(t, c.Add(Sample{
+			Timestamp: ts.Add(time.Duration(i) * time.Second),
+			VM:        &vm,
+			Cores:     []CoreStats{{CPU: "0", User: float64(10 * i)}},
+		}))
+	}
+	p := c.Data(StreamInfo{})
+	assert.Len(t, p.VM, 1)
+	assert.InDelta(t, 1.5, p.VM[0].Running, 1e-9)
+	assert.Equal(t, ts, p.VM[0].Timestamp)
+	assert.Len(t, p.Cores["0"], 1)
+	assert.InDelta(t, 15, p.Cores["0"][0].User, 1e-9)
+}
@@ -0,0 +1,169 @@
+package parser
+
+import (
+	"strconv"
+	"strings"
+)
+
+// columnAliases maps device column names printed by older sysstat releases,
+// and by the basic report without -x, onto the names current extended
+// reports use for the same value.
+var columnAliases = map[string]string{
+	"avgqu-sz":  "aqu-sz",
+	"kB_read/s": "rkB/s",
+	"kB_wrtn/s": "wkB/s",
+	"kB_dscd/s": "dkB/s",
+}
+
+// sectorColumns maps legacy columns reported in 512-byte sectors, by iostat
+// and sar, onto their KB-based equivalents.
+var sectorColumns = map[string]string{
+	"rsec/s":     "rkB/s",
+	"wsec/s":     "wkB/s",
+	"rd_sec/s":   "rkB/s",
+	"wr_sec/s":   "wkB/s",
+	"avgrq-sz":   "areq-sz",
+	"Blk_read/s": "rkB/s",
+	"Blk_wrtn/s": "wkB/s",
+}
+
+// megabyteColumns maps the throughput columns iostat -m prints in MB onto
+// their KB-based equivalents. Request sizes stay in KB with -m.
+var megabyteColumns = map[string]string{
+	"rMB/s":     "rkB/s",
+	"wMB/s":     "wkB/s",
+	"dMB/s":     "dkB/s",
+	"MB_read/s": "rkB/s",
+	"MB_wrtn/s": "wkB/s",
+	"MB_dscd/s": "dkB/s",
+}
+
+// humanUnitsKB is the size in KB of each suffix iostat -h appends to sizes
+// and throughputs: sectors, bytes, then powers of 1024.
+var humanUnitsKB = map[byte]float64{
+	's': 0.5,
+	'B': 1.0 / 1024,
+	'k': 1,
+	'M': 1 << 10,
+	'G': 1 << 20,
+	'T': 1 << 30,
+	'P': 1 << 40,
+}
+
+// parseValue parses a field printed under column, with or without -h.
+// Percentages lose their "%", and human-readable sizes such as 1.2M are
+// converted into the unit column is named after, so that
+// normaliseDeviceColumns treats them like any other value.
+func parseValue(column, field string) (float64, error) {
+	field = strings.TrimSuffix(field, "%")
+	if n := len(field); n > 1 {
+		if kb, ok := humanUnitsKB[field[n-1]]; ok {
+			v, err := strconv.ParseFloat(field[:n-1], 64)
+			return v * kb / columnUnitKB(column), err
+		}
+	}
+	return strconv.ParseFloat(field, 64)
+}
+
+// columnUnitKB returns the size in KB of one unit of a size or throughput
+// column.
+func columnUnitKB(column string) float64 {
+	if _, ok := megabyteColumns[column]; ok {
+		return 1 << 10
+	}
+	if _, ok := sectorColumns[column]; ok {
+		return 0.5
+	}
+	return 1
+}
+
+// normaliseDeviceColumns rewrites the device values of any sysstat generation
+// into the current column names, converting sectors and MB (iostat -m) into
+// KB, and deriving values old layouts do not print:
+//
+//   - rareq-sz/wareq-sz from throughput and IOPS, falling back to the combined
+//     avgrq-sz when throughput is missing
+//   - %rrqm/%wrqm from the merged and issued request rates
+//   - r_await/w_await from the combined await when the layout has no split
+//   - await as the IOPS-weighted mean of r_await and w_await when absent
+//   - tps and areq-sz across all directions when only the split is printed
+//
+// Values already present under the current name are never overwritten.
+func normaliseDeviceColumns(m map[string]float64) {
+	for legacy, canon := range columnAliases {
+		setDefault(m, legacy, canon, 1)
+	}
+	for legacy, canon := range sectorColumns {
+		setDefault(m, legacy, canon, 0.5)
+	}
+	for mb, canon := range megabyteColumns {
+		setDefault(m, mb, canon, 1<<10)
+	}
+
+	if _, ok := m["rareq-sz"]; !ok {
+		m["rareq-sz"] = requestSize(m, "r/s", "rkB/s")
+	}
+	if _, ok := m["wareq-sz"]; !ok {
+		m["wareq-sz"] = requestSize(m, "w/s", "wkB/s")
+	}
+
+	if _, ok := m["rrqm"]; !ok {
+		m["rrqm"] = mergedPct(m["rrqm/s"], m["r/s"])
+	}
+	if _, ok := m["wrqm"]; !ok {
+		m["wrqm"] = mergedPct(m["wrqm/s"], m["w/s"])
+	}
+
+	await, hasAwait := m["await"]
+	if _, ok := m["r_await"]; !ok && hasAwait && m["r/s"] > 0 {
+		m["r_await"] = await
+	}
+	if _, ok := m["w_await"]; !ok && hasAwait && m["w/s"] > 0 {
+		m["w_await"] = await
+	}
+	if !hasAwait {
+		if ops := m["r/s"] + m["w/s"]; ops > 0 {
+			m["await"] = (m["r/s"]*m["r_await"] + m["w/s"]*m["w_await"]) / ops
+		}
+	}
+
+	ops := m["r/s"] + m["w/s"] + m["d/s"]
+	if _, ok := m["tps"]; !ok {
+		m["tps"] = ops
+	}
+	if _, ok := m["areq-sz"]; !ok && ops > 0 {
+		m["areq-sz"] = (m["rkB/s"] + m["wkB/s"] + m["dkB/s"]) / ops
+	}
+}
+
+// setDefault copies m[from]*scale into m[to] unless m[to] is already set.
+func setDefault(m map[string]float64, from, to string, scale float64) {
+	v, ok := m[from]
+	if !ok {
+		return
+	}
+	if _, exists := m[to]; !exists {
+		m[to] = v * scale
+	}
+}
+
+// requestSize returns the average request size in KB for one direction.
+func requestSize(m map[string]float64, opsKey, kbKey string) float64 {
+	ops := m[opsKey]
+	if ops <= 0 {
+		return 0
+	}
+	if kb, ok := m[kbKey]; ok {
+		return kb / ops
+	}
+	return m["areq-sz"]
+}
+
+// mergedPct returns the percentage of requests merged before being issued,
+// as sysstat computes %rrqm and %wrqm.
+func mergedPct(merged, issued float64) float64 {
+	if merged+issued <= 0 {
+		return 0
+	}
+	return merged / (merged + issued) * 100
+}
@@ -0,0 +1,181 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestParseSysstatGenerations parses the same workload as printed by each
+// sysstat generation and expects identical canonical values.
+func TestParseSysstatGenerations(t *testing.T) {
+	tests := []struct {
+		fixture string
+		rAwait  float64 // first sample, sda
+		wAwait  float64
+		svctm   float64
+	}{
+		// no r_await/w_await, sectors instead of kB
+		{fixture: "sysstat-9.0.4.txt", rAwait: 2.50, wAwait: 2.50, svctm: 0.45},
+		// adds r_await/w_await and kB columns
+		{fixture: "sysstat-10.1.5.txt", rAwait: 1.00, wAwait: 2.88, svctm: 0.45},
+		// renamed aqu-sz, split request sizes and merge percentages
+		{fixture: "sysstat-11.7.3.txt", rAwait: 1.00, wAwait: 2.88, svctm: 0.45},
+		// current layout with discard and flush columns, no svctm
+		{fixture: "sysstat-12.5.4.txt", rAwait: 1.00, wAwait: 2.88},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tt.fixture))
+			assert.NoError(t, err)
+			p, err := ParseIostatOutput(data)
+			assert.NoError(t, err)
+
+			assert.Len(t, p.CPUs, 2)
+			assert.Len(t, p.Devices, 2)
+			sda := p.Devices["sda"]
+			if !assert.Len(t, sda, 2) {
+				return
+			}
+
+			first := sda[0]
+			assert.InDelta(t, 2.00, first.ReadsPerSec, 1e-6)
+			assert.InDelta(t, 8.00, first.WritesPerSec, 1e-6)
+			assert.InDelta(t, 32.00, first.ReadKBPerSec, 1e-6)
+			assert.InDelta(t, 80.00, first.WriteKBPerSec, 1e-6)
+			assert.InDelta(t, 0.31, first.ReadMergedPerSec, 1e-6)
+			assert.InDelta(t, 13.42, first.ReadPctMerged, 0.01)
+			assert.InDelta(t, 16.00, first.ReadReqSzKB, 1e-6)
+			assert.InDelta(t, 10.00, first.WriteReqSzKB, 1e-6)
+			assert.InDelta(t, 1.25, first.QueueSize, 1e-6)
+			assert.InDelta(t, 1.39, first.UtilPct, 1e-6)
+			assert.InDelta(t, tt.rAwait, first.ReadAwaitMs, 1e-6)
+			assert.InDelta(t, tt.wAwait, first.WriteAwaitMs, 1e-6)
+			assert.InDelta(t, 2.50, first.AwaitMs, 0.01)
+			assert.InDelta(t, tt.svctm, first.SvcTimeMs, 1e-6)
+
+			second := sda[1]
+			assert.InDelta(t, 38116.00, second.WriteKBPerSec, 1e-6)
+			assert.InDelta(t, 96.50, second.WriteReqSzKB, 0.01)
+			assert.InDelta(t, 0, second.ReadAwaitMs, 1e-6)
+			assert.InDelta(t, 8.65, second.WriteAwaitMs, 1e-6)
+			assert.InDelta(t, 25.19, second.WritePctMerged, 0.01)
+			assert.InDelta(t, 3.42, second.QueueSize, 1e-6)
+		})
+	}
+}
+
+// TestParseUnitModes parses the same workload printed in MB with -m and
+// human-readable with -h, and expects the KB-based values of the default
+// mode, within the precision each mode prints.
+func TestParseUnitModes(t *testing.T) {
+	tests := []struct {
+		fixture string
+		epsilon float64
+	}{
+		// MB/s columns with two decimals
+		{fixture: "sysstat-12.5.4-m.txt", epsilon: 0.05},
+		// suffixed values and percentages, device name last
+		{fixture: "sysstat-12.5.4-h.txt", epsilon: 0.02},
+		// device names on a line of their own
+		{fixture: "sysstat-10.1.5-h.txt", epsilon: 0.001},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tt.fixture))
+			assert.NoError(t, err)
+			p, err := Parse(data)
+			assert.NoError(t, err)
+
+			if assert.Len(t, p.CPUs, 2) {
+				assert.InEpsilon(t, 2.36, p.CPUs[0].User, tt.epsilon)
+				assert.InEpsilon(t, 97.20, p.CPUs[0].Idle, tt.epsilon)
+			}
+			assert.Len(t, p.Devices, 2)
+			sda := p.Devices["sda"]
+			if !assert.Len(t, sda, 2) || !assert.Len(t, p.Devices["sdb"], 2) {
+				return
+			}
+
+			first := sda[0]
+			assert.InEpsilon(t, 2.00, first.ReadsPerSec, tt.epsilon)
+			assert.InEpsilon(t, 32.00, first.ReadKBPerSec, tt.epsilon)
+			assert.InEpsilon(t, 80.00, first.WriteKBPerSec, tt.epsilon)
+			assert.InEpsilon(t, 16.00, first.ReadReqSzKB, tt.epsilon)
+			assert.InEpsilon(t, 10.00, first.WriteReqSzKB, tt.epsilon)
+			assert.InEpsilon(t, 1.25, first.QueueSize, tt.epsilon)
+			assert.InEpsilon(t, 1.39, first.UtilPct, tt.epsilon)
+
+			second := sda[1]
+			assert.InEpsilon(t, 38116.00, second.WriteKBPerSec, tt.epsilon)
+			assert.InEpsilon(t, 96.50, second.WriteReqSzKB, tt.epsilon)
+			assert.InEpsilon(t, 39.20, second.UtilPct, tt.epsilon)
+		})
+	}
+}
+
+// TestParseValue converts human-readable values into the unit of their
+// column.
+func TestParseValue(t *testing.T) {
+	tests := []struct {
+		column, field string
+		want          float64
+	}{
+		{"rkB/s", "32.00", 32},
+		{"rkB/s", "340.0k", 340},
+		{"rkB/s", "1.5M", 1536},
+		{"rkB/s", "2.0G", 2 << 20},
+		{"rkB/s", "512.0B", 0.5},
+		{"rkB/s", "8.0s", 4},
+		{"rMB/s", "1.5M", 1.5},
+		{"rsec/s", "1.0k", 2},
+		{"util", "39.2%", 39.2},
+	}
+	for _, tt := range tests {
+		got, err := parseValue(tt.column, tt.field)
+		assert.NoError(t, err, tt.field)
+		assert.InDelta(t, tt.want, got, 1e-9, "%s under %s", tt.field, tt.column)
+	}
+	_, err := parseValue("rkB/s", "1.2X")
+	assert.Error(t, err)
+}
+
+// TestNormaliseBasicReport maps the basic report, without -x, in KB, MB
+// and blocks.
+func TestNormaliseBasicReport(t *testing.T) {
+	for _, m := range []map[string]float64{
+		{"tps": 10, "kB_read/s": 40, "kB_wrtn/s": 80},
+		{"tps": 10, "MB_read/s": 40.0 / 1024, "MB_wrtn/s": 80.0 / 1024},
+		{"tps": 10, "Blk_read/s": 80, "Blk_wrtn/s": 160},
+	} {
+		normaliseDeviceColumns(m)
+		assert.InDelta(t, 40, m["rkB/s"], 1e-9)
+		assert.InDelta(t, 80, m["wkB/s"], 1e-9)
+		assert.InDelta(t, 10, m["tps"], 1e-9)
+	}
+}
+
+// TestNormaliseDeviceColumnsKeepsCanonical ensures legacy values never
+// override columns already printed under the current name.
+func TestNormaliseDeviceColumnsKeepsCanonical(t *testing.T) {
+	m := map[string]float64{
+		"r/s": 4, "rkB/s": 64, "rareq-sz": 15,
+		"aqu-sz": 2, "avgqu-sz": 9,
+		"avgrq-sz": 40,
+	}
+	normaliseDeviceColumns(m)
+	assert.InDelta(t, 15, m["rareq-sz"], 1e-6)
+	assert.InDelta(t, 2, m["aqu-sz"], 1e-6)
+	assert.InDelta(t, 20, m["areq-sz"], 1e-6)
+	assert.InDelta(t, 0, m["wareq-sz"], 1e-6)
+}
+
+// TestRequestSizeFallsBackToCombined uses avgrq-sz when throughput is missing.
+func TestRequestSizeFallsBackToCombined(t *testing.T) {
+	m := map[string]float64{"r/s": 3, "w/s": 1, "avgrq-sz": 16}
+	normaliseDeviceColumns(m)
+	assert.InDelta(t, 8, m["rareq-sz"], 1e-6)
+	assert.InDelta(t, 8, m["wareq-sz"], 1e-6)
+}
@@ -0,0 +1,57 @@
+package parser
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDecompressFixtures parses every compressed copy of a fixture and
+// expects the same result as the plain file.
+func TestDecompressFixtures(t *testing.T) {
+	plain, err := os.ReadFile(filepath.Join("testdata", "sysstat-12.5.4.txt"))
+	assert.NoError(t, err)
+	want, err := Parse(plain)
+	assert.NoError(t, err)
+
+	for ext, name := range map[string]string{".gz": "gzip", ".bz2": "bzip2", ".xz": "xz", ".zst": "zstd"} {
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", "sysstat-12.5.4.txt"+ext))
+			assert.NoError(t, err)
+
+			rc, kind, err := Decompress(bytes.NewReader(data))
+			assert.NoError(t, err)
+			assert.Equal(t, name, kind)
+			got, err := io.ReadAll(rc)
+			assert.NoError(t, err)
+			assert.NoError(t, rc.Close())
+			assert.Equal(t, plain, got)
+
+			parsed, err := Parse(data)
+			assert.NoError(t, err)
+			assert.Equal(t, want, parsed)
+		})
+	}
+}
+
+// TestDecompressPassthrough leaves plain and short input untouched.
+func TestDecompressPassthrough(t *testing.T) {
+	for _, input := range []string{"", "B", "09/04/24 12:07:20\n", jsonSample} {
+		rc, kind, err := Decompress(bytes.NewReader([]byte(input)))
+		assert.NoError(t, err)
+		assert.Empty(t, kind)
+		got, err := io.ReadAll(rc)
+		assert.NoError(t, err)
+		assert.Equal(t, input, string(got))
+	}
+}
+
+// TestDecompressCorrupt surfaces a damaged gzip header as an error.
+func TestDecompressCorrupt(t *testing.T) {
+	_, _, err := Decompress(bytes.NewReader([]byte{0x1f, 0x8b, 0x00, 0x00}))
+	assert.Error(t, err)
+}
@@ -0,0 +1,301 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A diskstats series is what a collector loop such as
+//
+//	while sleep 5; do date; cat /proc/diskstats; done
+//
+// writes: snapshots of the cumulative counters, each preceded by a date
+// line. Rates are derived from the difference between neighbouring
+// snapshots, so the first snapshot only provides the starting point.
+
+// dateLineLayouts are the layouts of date(1) output tried after the iostat
+// timestamp layouts: the C and en_US defaults, and RFC 5322 from date -R.
+var dateLineLayouts = []string{
+	time.UnixDate,
+	time.ANSIC,
+	"Mon _2 Jan 2006 15:04:05 MST",
+	"Mon 02 Jan 2006 03:04:05 PM MST",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+// ParseDiskstatsSeries parses a series of /proc/diskstats snapshots separated
+// by date lines into the structure ParseIostatOutput produces. Date lines may
+// be in any timestamp format iostat prints, the output of date(1) or
+// date -R, or seconds since the epoch from date +%s.
+func ParseDiskstatsSeries(data []byte, opts ...Option) (ParsedData, error) {
+	c := NewCollector(0)
+	info, err := StreamDiskstatsSeries(bytes.NewReader(data), c.Add, opts...)
+	return c.Data(info), err
+}
+
+// StreamDiskstatsSeries is the streaming form of ParseDiskstatsSeries: it
+// calls fn for each snapshot after the first as soon as it has been read.
+//
+// Counters that wrap around are followed across the wrap. A device that
+// appears mid-series is charted from its second snapshot, and one that
+// disappears simply ends. When every device's counters go backwards the
+// host is taken to have rebooted, which starts a new run.
+func StreamDiskstatsSeries(r io.Reader, fn func(Sample) error, opts ...Option) (StreamInfo, error) {
+	timeline := NewTimeline()
+	cfg := newConfig(opts)
+	p := &diskstatsParser{
+		cfg:        cfg,
+		timestamps: newTimestampDetector(cfg),
+		emit:       withTimeline(timeline, fn),
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+
+	info := func() StreamInfo {
+		i := StreamInfo{Warnings: p.warnings}
+		i.setTimeline(timeline)
+		return i
+	}
+	for scanner.Scan() {
+		if err := p.line(scanner.Text()); err != nil {
+			return info(), err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return info(), err
+	}
+	err := p.finish()
+	return info(), err
+}
+
+// diskSnapshot is one /proc/diskstats reading.
+type diskSnapshot struct {
+	time  time.Time
+	line  int // line of the date
+	text  string
+	disks []DiskCounters
+	names map[string]bool
+}
+
+// diskstatsParser reads a snapshot series a line at a time and emits a
+// sample for each pair of neighbouring snapshots.
+type diskstatsParser struct {
+	cfg        config
+	timestamps *timestampDetector
+	emit       func(Sample) error
+
+	lineNo int
+	cur    *diskSnapshot
+	// skipping is set when the current snapshot was abandoned in lenient mode
+	skipping bool
+
+	prev      map[string]DiskCounters
+	prevTime  time.Time
+	restart   bool
+	snapshots int
+	samples   int
+	warnings  []ParseError
+}
+
+func (p *diskstatsParser) line(raw string) error {
+	p.lineNo++
+	line := strings.TrimSpace(raw)
+	if line == "" {
+		return nil
+	}
+	if ts, ok := p.parseDate(line); ok {
+		if err := p.endSnapshot(); err != nil {
+			return err
+		}
+		p.cur = &diskSnapshot{time: ts, line: p.lineNo, text: line, names: make(map[string]bool)}
+		p.skipping = false
+		return nil
+	}
+	if p.skipping {
+		return nil
+	}
+	dc, err := parseDiskstatsLine(line)
+	switch {
+	case err != nil && isDiskstatsLine(line):
+		return p.fail(ErrMalformed, line, err.Error())
+	case err != nil:
+		return p.fail(ErrMalformed, line, "neither a date nor a /proc/diskstats line")
+	case p.cur == nil:
+		return p.fail(ErrBadTimestamp, line, "/proc/diskstats line before the first date line")
+	case p.cur.names[dc.Name]:
+		return p.fail(ErrBadTimestamp, line, fmt.Sprintf("%s appears twice in one snapshot (missing date line?)", dc.Name))
+	}
+	p.cur.names[dc.Name] = true
+	p.cur.disks = append(p.cur.disks, dc)
+	return nil
+}
+
+// parseDate returns the time on a date line, or false when line is not one.
+func (p *diskstatsParser) parseDate(line string) (time.Time, bool) {
+	if ts, ok := p.timestamps.parse(line); ok || p.cfg.timeLayout != "" {
+		return ts, ok
+	}
+	return parseDateLine(line)
+}
+
+// parseDateLine recognises the date(1) formats iostat never prints.
+func parseDateLine(line string) (time.Time, bool) {
+	for _, layout := range dateLineLayouts {
+		if ts, err := time.Parse(layout, line); err == nil {
+			return ts, true
+		}
+	}
+	// date +%s, possibly with %N
+	if !strings.ContainsAny(line, " \t") && len(line) >= 9 {
+		if secs, err := strconv.ParseFloat(line, 64); err == nil && secs > 0 {
+			return time.Unix(0, int64(secs*float64(time.Second))).UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// isDiskstatsLine reports whether line starts like a /proc/diskstats line,
+// with a major and minor number and a name.
+func isDiskstatsLine(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) < 3 {
+		return false
+	}
+	_, errMajor := strconv.Atoi(fields[0])
+	_, errMinor := strconv.Atoi(fields[1])
+	return errMajor == nil && errMinor == nil
+}
+
+// fail abandons the current snapshot. In lenient mode the problem is
+// recorded as a warning and parsing continues at the next date line.
+func (p *diskstatsParser) fail(reason error, text, detail string) error {
+	perr := ParseError{Line: p.lineNo, Text: text, Reason: reason, Detail: detail}
+	p.cur = nil
+	p.skipping = true
+	if p.cfg.lenient {
+		p.warnings = append(p.warnings, perr)
+		return nil
+	}
+	return &perr
+}
+
+// endSnapshot emits the rates between the previous snapshot and the current
+// one, which then becomes the previous one.
+func (p *diskstatsParser) endSnapshot() error {
+	snap := p.cur
+	p.cur = nil
+	if snap == nil {
+		return nil
+	}
+	if len(snap.disks) == 0 {
+		return p.failAt(snap, ErrTruncated, "date line without /proc/diskstats lines")
+	}
+	p.snapshots++
+
+	prev, prevTime := p.prev, p.prevTime
+	p.prev = make(map[string]DiskCounters, len(snap.disks))
+	for _, dc := range snap.disks {
+		p.prev[dc.Name] = dc
+	}
+	p.prevTime = snap.time
+	elapsed := snap.time.Sub(prevTime)
+	if prev == nil || elapsed <= 0 {
+		// nothing to compare with, or no way to tell how much time passed
+		return nil
+	}
+
+	s := Sample{Timestamp: snap.time}
+	common, resets := 0, 0
+	for _, cur := range snap.disks {
+		before, ok := prev[cur.Name]
+		if !ok {
+			// appeared since the last snapshot: this one is its starting point
+			continue
+		}
+		common++
+		if cur.resetSince(before) {
+			resets++
+			continue
+		}
+		// iostat leaves out devices that have never been used
+		if cur.Idle() {
+			continue
+		}
+		ds := DeviceStatsBetween(before, cur, elapsed)
+		ds.Timestamp = snap.time
+		s.Devices = append(s.Devices, ds)
+	}
+	if resets > 0 && resets == common {
+		// every counter started again: the host rebooted in between
+		p.restart = true
+	}
+	if len(s.Devices) == 0 {
+		return nil
+	}
+	if p.restart {
+		s.RunStart = true
+		p.restart = false
+	}
+	p.samples++
+	return p.emit(s)
+}
+
+// failAt reports a problem with an already finished snapshot.
+func (p *diskstatsParser) failAt(snap *diskSnapshot, reason error, detail string) error {
+	perr := ParseError{Line: snap.line, Text: snap.text, Reason: reason, Detail: detail}
+	if p.cfg.lenient {
+		p.warnings = append(p.warnings, perr)
+		return nil
+	}
+	return &perr
+}
+
+// finish closes the last snapshot and reports input that yielded no samples.
+func (p *diskstatsParser) finish() error {
+	if err := p.endSnapshot(); err != nil {
+		return err
+	}
+	if p.samples > 0 {
+		return nil
+	}
+	if len(p.warnings) > 0 {
+		return &ParseError{
+			Reason: ErrNoSamples,
+			Detail: fmt.Sprintf("all snapshots were skipped (%d warnings)", len(p.warnings)),
+		}
+	}
+	return &ParseError{
+		Reason: ErrNoSamples,
+		Detail: fmt.Sprintf("rates need two snapshots with I/O between them, found %d snapshots", p.snapshots),
+	}
+}
+
+// sniffDiskstats reports whether text starts like a diskstats series: a date
+// line followed by a /proc/diskstats line.
+func sniffDiskstats(text []byte) bool {
+	timestamps := newTimestampDetector(config{})
+	var lines []string
+	for _, line := range strings.SplitN(string(text), "\n", 64) {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+		if len(lines) == 2 {
+			break
+		}
+	}
+	if len(lines) < 2 {
+		return false
+	}
+	_, isDate := timestamps.parse(lines[0])
+	if !isDate {
+		_, isDate = parseDateLine(lines[0])
+	}
+	_, err := parseDiskstatsLine(lines[1])
+	return isDate && err == nil
+}
@@ -0,0 +1,181 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// diskstatsLine formats a /proc/diskstats line from the leading counters,
+// padding the rest with zeros.
+func diskstatsLine(major, minor int, name string, counters ...uint64) string {
+	fields := make([]string, 17)
+	for i := range fields {
+		fields[i] = "0"
+		if i < len(counters) {
+			fields[i] = fmt.Sprint(counters[i])
+		}
+	}
+	return fmt.Sprintf("%4d %7d %s %s", major, minor, name, strings.Join(fields, " "))
+}
+
+// diskstatsSeries is five snapshots five seconds apart: sda disappears after
+// the second, nvme0n1 appears in the second, sdb's read ticks wrap at 32 bits
+// and the host reboots before the fifth.
+var diskstatsSeries = strings.Join([]string{
+	"Wed Mar  6 10:00:00 UTC 2024",
+	diskstatsLine(8, 0, "sda", 1000, 0, 20000, 500, 0, 0, 0, 0, 0, 1000, 500),
+	diskstatsLine(8, 16, "sdb", 1000, 0, 8000, 4294967000, 0, 0, 0, 0, 0, 800, 800),
+	diskstatsLine(7, 0, "loop0"),
+	"Wed Mar  6 10:00:05 UTC 2024",
+	diskstatsLine(8, 0, "sda", 1500, 0, 30000, 1500, 0, 0, 0, 0, 0, 3500, 3000),
+	diskstatsLine(8, 16, "sdb", 1100, 0, 8800, 100, 0, 0, 0, 0, 0, 900, 900),
+	diskstatsLine(7, 0, "loop0"),
+	diskstatsLine(259, 0, "nvme0n1", 10, 0, 80, 10, 0, 0, 0, 0, 0, 10, 10),
+	"Wed Mar  6 10:00:10 UTC 2024",
+	diskstatsLine(8, 16, "sdb", 1100, 0, 8800, 100, 0, 0, 0, 0, 0, 900, 900),
+	diskstatsLine(259, 0, "nvme0n1", 60, 0, 480, 60, 0, 0, 0, 0, 0, 60, 60),
+	"Wed Mar  6 10:00:15 UTC 2024",
+	diskstatsLine(8, 16, "sdb", 5, 0, 40, 5, 0, 0, 0, 0, 0, 5, 5),
+	diskstatsLine(259, 0, "nvme0n1", 5, 0, 40, 5, 0, 0, 0, 0, 0, 5, 5),
+	"Wed Mar  6 10:00:20 UTC 2024",
+	diskstatsLine(8, 16, "sdb", 55, 0, 440, 55, 0, 0, 0, 0, 0, 55, 55),
+	diskstatsLine(259, 0, "nvme0n1", 55, 0, 440, 55, 0, 0, 0, 0, 0, 55, 55),
+	"",
+}, "\n")
+
+func TestParseDiskstatsSeries(t *testing.T) {
+	data, err := ParseDiskstatsSeries([]byte(diskstatsSeries))
+	require.NoError(t, err)
+	assert.Empty(t, data.CPUs)
+	assert.Equal(t, 5*time.Second, data.Interval)
+
+	// sda only has the second snapshot, compared with the first
+	require.Len(t, data.Devices["sda"], 1)
+	sda := data.Devices["sda"][0]
+	assert.Equal(t, time.Date(2024, 3, 6, 10, 0, 5, 0, time.UTC), sda.Timestamp.UTC())
+	assert.InDelta(t, 100, sda.ReadsPerSec, 1e-9)
+	assert.InDelta(t, 1000, sda.ReadKBPerSec, 1e-9)
+	assert.InDelta(t, 2, sda.ReadAwaitMs, 1e-9)
+	assert.InDelta(t, 0.5, sda.QueueSize, 1e-9)
+	assert.InDelta(t, 50, sda.UtilPct, 1e-9)
+	assert.False(t, sda.SinceBoot)
+
+	// the read ticks wrapped from 4294967000 to 100: 396 ms over 100 reads
+	sdb := data.Devices["sdb"]
+	require.Len(t, sdb, 3)
+	assert.InDelta(t, 3.96, sdb[0].ReadAwaitMs, 1e-9)
+	assert.Zero(t, sdb[1].ReadsPerSec)
+	assert.InDelta(t, 10, sdb[2].ReadsPerSec, 1e-9)
+
+	// nvme0n1 appeared in the second snapshot, so is charted from the third
+	nvme := data.Devices["nvme0n1"]
+	require.Len(t, nvme, 2)
+	assert.Equal(t, time.Date(2024, 3, 6, 10, 0, 10, 0, time.UTC), nvme[0].Timestamp.UTC())
+	assert.InDelta(t, 10, nvme[0].ReadsPerSec, 1e-9)
+
+	// never used devices are left out, as iostat does
+	assert.NotContains(t, data.Devices, "loop0")
+
+	// the reboot starts a new segment
+	require.Len(t, data.Segments, 2)
+	assert.Equal(t, BreakRestart, data.Segments[1].Break)
+}
+
+// TestParseDiskstatsSeriesDetected reads a series through the format detection.
+func TestParseDiskstatsSeriesDetected(t *testing.T) {
+	assert.True(t, Sniff([]byte(diskstatsSeries)))
+	data, err := Parse([]byte(diskstatsSeries))
+	require.NoError(t, err)
+	assert.Len(t, data.Devices["sdb"], 3)
+
+	// a lone snapshot, as found in most support bundles, is not a capture
+	assert.False(t, Sniff([]byte(diskstatsLine(8, 0, "sda", 1)+"\n"+diskstatsLine(8, 1, "sda1", 1)+"\n")))
+	assert.False(t, Sniff([]byte("hello\nworld\n")))
+}
+
+func TestParseDateLine(t *testing.T) {
+	want := time.Date(2024, 3, 6, 10, 0, 0, 0, time.UTC)
+	for _, line := range []string{
+		"Wed Mar  6 10:00:00 UTC 2024",
+		"Wed Mar  6 10:00:00 2024",
+		"Wed 06 Mar 2024 10:00:00 AM UTC",
+		"Wed, 06 Mar 2024 10:00:00 +0000",
+		"1709719200",
+	} {
+		ts, ok := parseDateLine(line)
+		if assert.True(t, ok, line) {
+			assert.True(t, want.Equal(ts), "%s: got %s", line, ts)
+		}
+	}
+	for _, line := range []string{"", "12345", "8 0 sda 1 2 3"} {
+		_, ok := parseDateLine(line)
+		assert.False(t, ok, line)
+	}
+
+	// date -Is and date '+%F %T' are iostat layouts already
+	input := "2024-03-06T10:00:00+01:00\n" + diskstatsLine(8, 0, "sda", 1) + "\n" +
+		"2024-03-06T10:00:02+01:00\n" + diskstatsLine(8, 0, "sda", 3) + "\n"
+	data, err := ParseDiskstatsSeries([]byte(input))
+	require.NoError(t, err)
+	require.Len(t, data.Devices["sda"], 1)
+	assert.InDelta(t, 1, data.Devices["sda"][0].ReadsPerSec, 1e-9)
+}
+
+func TestParseDiskstatsSeriesErrors(t *testing.T) {
+	var perr *ParseError
+
+	_, err := ParseDiskstatsSeries([]byte(diskstatsLine(8, 0, "sda", 1) + "\n"))
+	require.ErrorAs(t, err, &perr)
+	assert.ErrorIs(t, err, ErrBadTimestamp)
+	assert.Equal(t, 1, perr.Line)
+
+	_, err = ParseDiskstatsSeries([]byte("1709719200\n" + diskstatsLine(8, 0, "sda", 1) + "\n"))
+	assert.ErrorIs(t, err, ErrNoSamples)
+
+	// a missing date line shows up as a device listed twice
+	twice := "1709719200\n" + diskstatsLine(8, 0, "sda", 1) + "\n" + diskstatsLine(8, 0, "sda", 2) + "\n"
+	_, err = ParseDiskstatsSeries([]byte(twice))
+	require.ErrorAs(t, err, &perr)
+	assert.Equal(t, 3, perr.Line)
+
+	garbled := strings.Replace(diskstatsSeries, diskstatsLine(259, 0, "nvme0n1", 60, 0, 480, 60, 0, 0, 0, 0, 0, 60, 60), "259 0 nvme0n1 6x 0", 1)
+	_, err = ParseDiskstatsSeries([]byte(garbled))
+	require.ErrorAs(t, err, &perr)
+	assert.ErrorIs(t, err, ErrMalformed)
+	assert.Equal(t, 12, perr.Line)
+
+	// lenient mode drops the garbled snapshot and goes on
+	data, err := ParseDiskstatsSeries([]byte(garbled), WithLenient())
+	require.NoError(t, err)
+	require.Len(t, data.Warnings, 1)
+	assert.Equal(t, 12, data.Warnings[0].Line)
+	assert.Len(t, data.Devices["sdb"], 2)
+}
+
+func TestCounterStep(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		prev, cur uint64
+		want      uint64
+		ok        bool
+	}{
+		{"grew", 100, 250, 150, true},
+		{"unchanged", 7, 7, 0, true},
+		{"32-bit wrap", math.MaxUint32 - 9, 5, 15, true},
+		{"64-bit wrap", math.MaxUint64 - 9, 5, 15, true},
+		{"reset", 1000, 10, 10, false},
+		{"reset of a large counter", 1 << 40, 10, 10, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := counterStep(tc.prev, tc.cur)
+			assert.Equal(t, tc.want, got)
+			assert.Equal(t, tc.ok, ok)
+		})
+	}
+}
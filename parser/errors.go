@@ -0,0 +1,55 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Reasons a ParseError may carry. Test for them with errors.Is.
+var (
+	ErrUnknownHeader = errors.New("unknown header")
+	ErrNonNumeric    = errors.New("non-numeric field")
+	ErrTruncated     = errors.New("truncated block")
+	ErrBadTimestamp  = errors.New("unrecognised timestamp")
+	ErrMalformed     = errors.New("malformed input")
+	ErrNoSamples     = errors.New("no iostat samples found")
+)
+
+// ParseError describes why part of the input could not be parsed. In lenient
+// mode the same values are collected in ParsedData.Warnings instead.
+type ParseError struct {
+	// File names the input when several are parsed together.
+	File string
+	// Line is the 1-based input line, or 0 when the problem is not tied to one.
+	Line int
+	// Text is the offending input, trimmed.
+	Text string
+	// Reason is one of the Err* values above.
+	Reason error
+	// Detail explains the problem in context.
+	Detail string
+
+	// offset is the byte position of the problem in streamed input
+	offset int64
+}
+
+func (e *ParseError) Error() string {
+	msg := e.Reason.Error()
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	if e.Text != "" {
+		msg += fmt.Sprintf(" in %q", e.Text)
+	}
+	if e.Line > 0 {
+		msg = fmt.Sprintf("line %d: %s", e.Line, msg)
+	}
+	if e.File != "" {
+		msg = e.File + ": " + msg
+	}
+	return msg
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Reason
+}
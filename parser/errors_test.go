@@ -0,0 +1,153 @@
+package parser
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const goodBlock = `09/04/24 12:07:20
+avg-cpu:  %user   %nice %system %iowait  %steal   %idle
+           2.36    0.00    0.40    0.04    0.01   97.20
+
+Device            r/s     w/s
+sda              2.08    9.58
+`
+
+// TestParseIostatOutputErrors checks the reason, line and text of strict-mode errors.
+func TestParseIostatOutputErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		reason error
+		line   int
+		text   string
+	}{
+		{
+			name:   "no timestamps",
+			input:  "Linux 5.15.0 (host) 09/04/24 _x86_64_ (16 CPU)\n\nsome other tool output\n",
+			reason: ErrNoSamples,
+		},
+		{
+			name:   "captured without -t",
+			input:  "avg-cpu:  %user   %nice %system %iowait  %steal   %idle\n 2.36 0.00 0.40 0.04 0.01 97.20\n",
+			reason: ErrNoSamples,
+			line:   1,
+			text:   "avg-cpu:  %user   %nice %system %iowait  %steal   %idle",
+		},
+		{
+			name:   "non-numeric device field",
+			input:  goodBlock + "\n09/04/24 12:07:21\nDevice r/s w/s\nsda 1.00 abc\n",
+			reason: ErrNonNumeric,
+			line:   10,
+			text:   "sda 1.00 abc",
+		},
+		{
+			name:   "missing device field",
+			input:  goodBlock + "\n09/04/24 12:07:21\nDevice r/s w/s\nsda 1.00\n",
+			reason: ErrTruncated,
+			line:   10,
+			text:   "sda 1.00",
+		},
+		{
+			name:   "timestamp with no block",
+			input:  goodBlock + "\n09/04/24 12:07:21\n",
+			reason: ErrTruncated,
+			line:   8,
+			text:   "09/04/24 12:07:21",
+		},
+		{
+			name:   "unknown header",
+			input:  "09/04/24 12:07:20\nDisk stats follow\n",
+			reason: ErrUnknownHeader,
+			line:   2,
+			text:   "Disk stats follow",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseIostatOutput([]byte(tt.input))
+			assert.True(t, errors.Is(err, tt.reason), "got %v", err)
+			var perr *ParseError
+			if assert.True(t, errors.As(err, &perr)) {
+				assert.Equal(t, tt.line, perr.Line)
+				assert.Equal(t, tt.text, perr.Text)
+			}
+		})
+	}
+}
+
+// TestParseIostatOutputLenient skips bad blocks and records a warning for each.
+func TestParseIostatOutputLenient(t *testing.T) {
+	input := goodBlock + `
+09/04/24 12:07:21
+avg-cpu:  %user   %nice %system %iowait  %steal   %idle
+           oops    0.00    0.40    0.04    0.01   97.20
+
+Device            r/s     w/s
+sda              1.00    1.00
+
+09/04/24 12:07:22
+avg-cpu:  %user   %nice %system %iowait  %steal   %idle
+           5.00    0.00    0.40    0.04    0.01   94.55
+
+Device            r/s     w/s
+sda              3.00    4.00
+
+09/04/24 12:07:23
+avg-cpu:  %user   %nice %system %iowait  %steal   %idle
+`
+	p, err := ParseIostatOutput([]byte(input), WithLenient())
+	assert.NoError(t, err)
+
+	assert.Len(t, p.CPUs, 2)
+	assert.Len(t, p.Devices["sda"], 2)
+	assert.InDelta(t, 3.00, p.Devices["sda"][1].ReadsPerSec, 1e-6)
+
+	if assert.Len(t, p.Warnings, 2) {
+		assert.Equal(t, 10, p.Warnings[0].Line)
+		assert.ErrorIs(t, p.Warnings[0].Reason, ErrNonNumeric)
+		assert.Equal(t, 22, p.Warnings[1].Line)
+		assert.ErrorIs(t, p.Warnings[1].Reason, ErrTruncated)
+	}
+}
+
+// TestParseErrorMessage checks the rendered message.
+func TestParseErrorMessage(t *testing.T) {
+	err := &ParseError{Line: 7, Text: "sda x", Reason: ErrNonNumeric, Detail: "column r/s has \"x\""}
+	assert.Equal(t, `line 7: non-numeric field: column r/s has "x" in "sda x"`, err.Error())
+
+	err = &ParseError{Reason: ErrNoSamples}
+	assert.Equal(t, "no iostat samples found", err.Error())
+}
+
+// TestParseIostatJSONLenient skips bad statistics entries.
+func TestParseIostatJSONLenient(t *testing.T) {
+	sample := `{"sysstat": {"hosts": [{"nodename": "h", "statistics": [
+		{"timestamp": "09/04/24 12:07:20", "avg-cpu": {"user": 1}},
+		{"timestamp": "not a time", "avg-cpu": {"user": 2}},
+		{"timestamp": "09/04/24 12:07:22", "disk": [{"disk_device": "sda", "r/s": "x"}]},
+		{"timestamp": "09/04/24 12:07:23", "avg-cpu": {"user": 4}}
+	]}]}}`
+
+	_, err := ParseIostatJSON([]byte(sample))
+	assert.ErrorIs(t, err, ErrBadTimestamp)
+
+	p, err := ParseIostatJSON([]byte(sample), WithLenient())
+	assert.NoError(t, err)
+	assert.Len(t, p.CPUs, 2)
+	if assert.Len(t, p.Warnings, 2) {
+		assert.Equal(t, "statistics[1]", p.Warnings[0].Text)
+		assert.ErrorIs(t, p.Warnings[1].Reason, ErrNonNumeric)
+	}
+}
+
+// TestParseIostatJSONSyntaxError reports the line of a truncated document.
+func TestParseIostatJSONSyntaxError(t *testing.T) {
+	_, err := ParseIostatJSON([]byte("{\"sysstat\": {\n\"hosts\": [\n{\"nodename\": }"))
+	var perr *ParseError
+	if assert.True(t, errors.As(err, &perr)) {
+		assert.Equal(t, 3, perr.Line)
+	}
+}
@@ -0,0 +1,240 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Format is an input format Stream can detect and read. Formats are
+// registered under a name with Register; the built-in ones are "iostat",
+// "iostat-json", "sar", "diskstats", "vmstat" and "mpstat".
+type Format interface {
+	// Detect returns how sure the format is that prefix, the decompressed
+	// start of an input, is its own, from 0 for not at all to 1 for
+	// certain. A score of at least MatchScore counts as a match when
+	// archives are searched for captures.
+	Detect(prefix []byte) float64
+	// Parse reads the whole input.
+	Parse(r io.Reader) (ParsedData, error)
+}
+
+// StreamingFormat is a Format that can also pass samples on one at a time
+// and honour Options. Stream uses it when available, so that memory stays
+// bounded; other formats are parsed whole and their samples replayed.
+type StreamingFormat interface {
+	Format
+	Stream(r io.Reader, fn func(Sample) error, opts ...Option) (StreamInfo, error)
+}
+
+// MatchScore is the Detect score from which input counts as a capture.
+const MatchScore = 0.5
+
+var (
+	formatsMu sync.RWMutex
+	// formats in registration order, which breaks ties between scores
+	formats     []namedFormat
+	formatNames = make(map[string]bool)
+)
+
+type namedFormat struct {
+	name string
+	Format
+}
+
+// Register makes a format available to Stream, Parse and Sniff under name.
+// It panics if name is empty or already taken, or f is nil.
+func Register(name string, f Format) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	if name == "" || f == nil {
+		panic("parser: Register needs a name and a format")
+	}
+	if formatNames[name] {
+		panic("parser: Register called twice for format " + name)
+	}
+	formatNames[name] = true
+	formats = append(formats, namedFormat{name: name, Format: f})
+}
+
+// Formats returns the names of the registered formats, sorted.
+func Formats() []string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	names := make([]string, 0, len(formats))
+	for _, f := range formats {
+		names = append(names, f.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupFormat returns the format registered under name.
+func LookupFormat(name string) (Format, bool) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	for _, f := range formats {
+		if f.name == name {
+			return f.Format, true
+		}
+	}
+	return nil, false
+}
+
+// DetectFormat returns the name and format that scores highest on prefix,
+// the decompressed start of an input, and its score. The name is empty
+// when no format scores above zero.
+func DetectFormat(prefix []byte) (string, Format, float64) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	var (
+		best  namedFormat
+		score float64
+	)
+	for _, f := range formats {
+		if s := f.Detect(prefix); s > score {
+			best, score = f, s
+		}
+	}
+	return best.name, best.Format, score
+}
+
+// streamFormat reads r with f, replaying the samples of a format that only
+// parses whole input.
+func streamFormat(f Format, r io.Reader, fn func(Sample) error, opts []Option) (StreamInfo, error) {
+	if sf, ok := f.(StreamingFormat); ok {
+		return sf.Stream(r, fn, opts...)
+	}
+	data, err := f.Parse(r)
+	if err != nil {
+		return StreamInfo{Host: data.Host, Warnings: data.Warnings}, err
+	}
+	return replay(data, fn)
+}
+
+// replay passes the series of data to fn as samples in time order, merging
+// what was taken at the same time.
+func replay(data ParsedData, fn func(Sample) error) (StreamInfo, error) {
+	var samples []Sample
+	index := make(map[time.Time]int)
+	sample := func(ts time.Time) *Sample {
+		i, ok := index[ts]
+		if !ok {
+			i = len(samples)
+			index[ts] = i
+			samples = append(samples, Sample{Timestamp: ts})
+		}
+		return &samples[i]
+	}
+	for _, cs := range data.CPUs {
+		cpu := cs
+		sample(cs.Timestamp).CPU = &cpu
+	}
+	for _, stats := range data.Devices {
+		for _, ds := range stats {
+			s := sample(ds.Timestamp)
+			s.Devices = append(s.Devices, ds)
+		}
+	}
+	for _, vm := range data.VM {
+		v := vm
+		sample(vm.Timestamp).VM = &v
+	}
+	for _, stats := range data.Cores {
+		for _, cs := range stats {
+			s := sample(cs.Timestamp)
+			s.Cores = append(s.Cores, cs)
+		}
+	}
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].Timestamp.Before(samples[j].Timestamp) })
+
+	info := StreamInfo{Host: data.Host, Warnings: data.Warnings, Interval: data.Interval, Segments: data.Segments}
+	timeline := NewTimeline()
+	for i := range samples {
+		s := samples[i]
+		s.SinceBoot = (s.CPU != nil && s.CPU.SinceBoot) || (s.VM != nil && s.VM.SinceBoot)
+		s.SinceBootUnsure = s.CPU != nil && s.CPU.SinceBootUnsure
+		timeline.Add(s)
+		if err := fn(s); err != nil {
+			return info, err
+		}
+	}
+	// formats that do not split their timeline get it worked out here
+	if len(info.Segments) == 0 {
+		info.setTimeline(timeline)
+	}
+	return info, nil
+}
+
+// builtinFormat adapts one of the parsers of this package to Format.
+type builtinFormat struct {
+	detect func(prefix []byte) float64
+	stream func(r io.Reader, fn func(Sample) error, opts ...Option) (StreamInfo, error)
+}
+
+func (f builtinFormat) Detect(prefix []byte) float64 {
+	return f.detect(prefix)
+}
+
+func (f builtinFormat) Parse(r io.Reader) (ParsedData, error) {
+	c := NewCollector(0)
+	info, err := f.stream(r, c.Add)
+	return c.Data(info), err
+}
+
+func (f builtinFormat) Stream(r io.Reader, fn func(Sample) error, opts ...Option) (StreamInfo, error) {
+	return f.stream(r, fn, opts...)
+}
+
+// scoreIf returns score when ok, and zero otherwise.
+func scoreIf(ok bool, score float64) float64 {
+	if ok {
+		return score
+	}
+	return 0
+}
+
+func init() {
+	// mpstat output is also sar-like, so it must outscore sar
+	Register("iostat-json", builtinFormat{detect: detectIostatJSON, stream: StreamIostatJSON})
+	Register("diskstats", builtinFormat{detect: func(p []byte) float64 { return scoreIf(sniffDiskstats(p), 0.9) }, stream: StreamDiskstatsSeries})
+	Register("vmstat", builtinFormat{detect: func(p []byte) float64 { return scoreIf(sniffVmstat(p), 0.9) }, stream: StreamVmstatOutput})
+	Register("mpstat", builtinFormat{detect: func(p []byte) float64 { return scoreIf(sniffMpstat(p), 0.9) }, stream: StreamMpstatOutput})
+	Register("sar", builtinFormat{detect: func(p []byte) float64 { return scoreIf(sniffSar(p), 0.8) }, stream: StreamSarOutput})
+	Register("iostat", builtinFormat{detect: detectIostatText, stream: StreamIostatOutput})
+}
+
+// detectIostatJSON is sure of a sysstat envelope. Any other JSON object
+// scores low, so that the JSON parser explains what is missing.
+func detectIostatJSON(prefix []byte) float64 {
+	trimmed := bytes.TrimLeft(prefix, " \t\r\n")
+	if len(trimmed) == 0 || trimmed[0] != '{' {
+		return 0
+	}
+	if bytes.Contains(prefix, []byte(`"sysstat"`)) {
+		return 1
+	}
+	return 0.2
+}
+
+// detectIostatText looks for the avg-cpu or Device header of iostat text.
+// Input nothing else claims scores low, so that the text parser explains
+// what is missing.
+func detectIostatText(prefix []byte) float64 {
+	for _, line := range strings.Split(string(prefix), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "avg-cpu:") || (isDeviceHeader(strings.Fields(line)) && strings.Contains(line, "/s")) {
+			return 0.7
+		}
+	}
+	return 0.1
+}
+
+// unknownFormat reports a format name nothing was registered under.
+func unknownFormat(name string) error {
+	return fmt.Errorf("unknown input format %q (want auto or one of %s)", name, strings.Join(Formats(), ", "))
+}
@@ -0,0 +1,115 @@
+package parser
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// csvFormat is a third-party style format that only parses whole input:
+// lines of "RFC 3339 time,device,util".
+type csvFormat struct{}
+
+func (csvFormat) Detect(prefix []byte) float64 {
+	if bytes.HasPrefix(prefix, []byte("#csv-util")) {
+		return 1
+	}
+	return 0
+}
+
+func (csvFormat) Parse(r io.Reader) (ParsedData, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return ParsedData{}, err
+	}
+	p := ParsedData{Devices: make(map[string][]DeviceStats)}
+	for _, line := range strings.Split(string(data), "\n")[1:] {
+		f := strings.Split(line, ",")
+		if len(f) != 3 {
+			continue
+		}
+		ts, _ := time.Parse(time.RFC3339, f[0])
+		util := 0.0
+		if f[2] == "busy" {
+			util = 100
+		}
+		p.Devices[f[1]] = append(p.Devices[f[1]], DeviceStats{Timestamp: ts, Name: f[1], UtilPct: util})
+	}
+	return p, nil
+}
+
+func TestDetectFormat(t *testing.T) {
+	for file, want := range map[string]string{
+		"sysstat-12.5.4.txt":     "iostat",
+		"sysstat-12.5.4.txt.zst": "iostat",
+		"sar-12.5.4.txt":         "sar",
+	} {
+		data, err := os.ReadFile(filepath.Join("testdata", file))
+		require.NoError(t, err)
+		name, _, score := DetectFormat(decompressPrefix(data))
+		assert.Equal(t, want, name, file)
+		assert.GreaterOrEqual(t, score, MatchScore, file)
+	}
+	for input, want := range map[string]string{
+		jsonSample:      "iostat-json",
+		diskstatsSeries: "diskstats",
+		vmstatSample:    "vmstat",
+		// mpstat rows are sar rows too
+		mpstatSample: "mpstat",
+	} {
+		name, _, _ := DetectFormat([]byte(input))
+		assert.Equal(t, want, name)
+	}
+
+	// anything else falls through to the iostat parser, below a match
+	name, _, score := DetectFormat([]byte("hello\n"))
+	assert.Equal(t, "iostat", name)
+	assert.Less(t, score, MatchScore)
+}
+
+func TestRegisterFormat(t *testing.T) {
+	// registered once per process, also with -count
+	if _, ok := LookupFormat("test-csv"); !ok {
+		Register("test-csv", csvFormat{})
+	}
+	assert.Contains(t, Formats(), "test-csv")
+	assert.Panics(t, func() { Register("test-csv", csvFormat{}) })
+	assert.Panics(t, func() { Register("", csvFormat{}) })
+
+	input := "#csv-util\n2024-09-04T12:00:00Z,sda,idle\n2024-09-04T12:00:01Z,sda,busy\n2024-09-04T12:00:02Z,sda,busy\n"
+	assert.True(t, Sniff([]byte(input)))
+
+	// samples of a format without Stream are replayed, so limits still apply
+	c := NewCollector(1)
+	info, err := Stream(strings.NewReader(input), c.Add)
+	require.NoError(t, err)
+	p := c.Data(info)
+	require.Len(t, p.Devices["sda"], 1)
+	assert.InDelta(t, 200.0/3, p.Devices["sda"][0].UtilPct, 1e-9)
+	assert.Equal(t, time.Second, p.Interval)
+	require.Len(t, p.Segments, 1)
+	assert.Equal(t, 3, p.Segments[0].Samples)
+}
+
+func TestWithFormat(t *testing.T) {
+	// forced, the iostat parser explains why sar output is not iostat
+	data, err := os.ReadFile(filepath.Join("testdata", "sar-12.5.4.txt"))
+	require.NoError(t, err)
+	_, err = Parse(data, WithFormat("iostat"))
+	assert.ErrorIs(t, err, ErrNoSamples)
+
+	p, err := Parse(data, WithFormat("sar"))
+	require.NoError(t, err)
+	assert.Len(t, p.CPUs, 3)
+
+	_, err = Parse(data, WithFormat("nope"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown input format "nope"`)
+}
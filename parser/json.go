@@ -0,0 +1,283 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// The iostat JSON envelope is
+//
+//	{"sysstat": {"hosts": [{"nodename": ..., "statistics": [...]}]}}
+//
+// It is walked token by token so that only one statistics entry is held in
+// memory at a time.
+
+type jsonStatistics struct {
+	Timestamp string                       `json:"timestamp"`
+	AvgCPU    map[string]float64           `json:"avg-cpu"`
+	Disk      []map[string]json.RawMessage `json:"disk"`
+}
+
+// ParseIostatJSON parses the output of `iostat -o JSON -x -c -t` into the same
+// structure ParseIostatOutput produces for the text layout.
+func ParseIostatJSON(data []byte, opts ...Option) (ParsedData, error) {
+	c := NewCollector(0)
+	info, err := StreamIostatJSON(bytes.NewReader(data), c.Add, opts...)
+	var perr *ParseError
+	if errors.As(err, &perr) && perr.offset > 0 {
+		// the whole document is at hand, so point at the line
+		perr.Line = 1 + bytes.Count(data[:min(perr.offset, int64(len(data)))], []byte("\n"))
+	}
+	return c.Data(info), err
+}
+
+// StreamIostatJSON is the streaming form of ParseIostatJSON: it calls fn for
+// each entry of the statistics array as soon as it has been decoded.
+func StreamIostatJSON(r io.Reader, fn func(Sample) error, opts ...Option) (StreamInfo, error) {
+	cfg := newConfig(opts)
+	timeline := NewTimeline()
+	s := &jsonStream{
+		cfg:        cfg,
+		timestamps: newTimestampDetector(cfg),
+		dec:        json.NewDecoder(r),
+		emit:       withTimeline(timeline, fn),
+	}
+	err := s.walk()
+	if err == nil {
+		err = s.first.release(time.Time{}, s.emit)
+	}
+	if err == nil && s.samples == 0 {
+		err = &ParseError{Reason: ErrNoSamples, Detail: "no statistics in sysstat envelope"}
+	}
+	s.info.setTimeline(timeline)
+	return s.info, err
+}
+
+type jsonStream struct {
+	cfg        config
+	timestamps *timestampDetector
+	dec        *json.Decoder
+	emit       func(Sample) error
+	info       StreamInfo
+	hosts      int
+	samples    int
+	// first holds back the first entry until the next one, see firstReport
+	first firstReport
+}
+
+// walk descends through the envelope to each host.
+func (s *jsonStream) walk() error {
+	return s.object(func(key string) error {
+		if key != "sysstat" {
+			return s.skip()
+		}
+		return s.object(func(key string) error {
+			if key != "hosts" {
+				return s.skip()
+			}
+			return s.array(func() error {
+				s.hosts++
+				// iostat only ever reports on the local machine
+				if s.hosts > 1 {
+					return s.skip()
+				}
+				return s.host()
+			})
+		})
+	})
+}
+
+// host reads one host object, emitting its statistics as they are decoded.
+func (s *jsonStream) host() error {
+	h := &s.info.Host
+	return s.object(func(key string) error {
+		switch key {
+		case "nodename":
+			return s.decode(&h.Hostname)
+		case "sysname":
+			return s.decode(&h.OS)
+		case "release":
+			return s.decode(&h.Kernel)
+		case "machine":
+			return s.decode(&h.Arch)
+		case "number-of-cpus":
+			return s.decode(&h.CPUCount)
+		case "date":
+			var date string
+			if err := s.decode(&date); err != nil {
+				return err
+			}
+			if d, err := parseDate(date, s.cfg.dateOrder); err == nil {
+				h.Date = d
+				s.timestamps.setDate(d)
+			}
+			return nil
+		case "statistics":
+			i := 0
+			return s.array(func() error {
+				defer func() { i++ }()
+				return s.statistics(i)
+			})
+		}
+		return s.skip()
+	})
+}
+
+// statistics decodes and emits entry i of the statistics array.
+func (s *jsonStream) statistics(i int) error {
+	var stat jsonStatistics
+	var perr *ParseError
+	var typeErr *json.UnmarshalTypeError
+	if err := s.dec.Decode(&stat); errors.As(err, &typeErr) {
+		// the decoder has consumed the whole entry, so the stream can go on
+		perr = &ParseError{Reason: ErrNonNumeric, Detail: fmt.Sprintf("field %s is not a %s", typeErr.Field, typeErr.Type)}
+	} else if err != nil {
+		return s.syntaxError(err)
+	} else if stat.Timestamp == "" {
+		return &ParseError{
+			Text:   fmt.Sprintf("statistics[%d]", i),
+			Reason: ErrBadTimestamp,
+			Detail: "entry has no timestamp (capture with iostat -t)",
+		}
+	}
+
+	var smp Sample
+	if perr == nil {
+		smp, perr = parseJSONStatistics(s.timestamps, stat)
+	}
+	if perr != nil {
+		perr.Text = fmt.Sprintf("statistics[%d]", i)
+		if !s.cfg.lenient {
+			return perr
+		}
+		s.info.Warnings = append(s.info.Warnings, *perr)
+		return nil
+	}
+	// the first entry is the since-boot report unless iostat ran with -y
+	if s.samples == 0 {
+		smp.RunStart = true
+		s.samples++
+		s.first.hold(smp, s.info.Host.Date)
+		return nil
+	}
+	s.samples++
+	if err := s.first.release(smp.Timestamp, s.emit); err != nil {
+		return err
+	}
+	return s.emit(smp)
+}
+
+// object reads a JSON object, calling fn with the decoder positioned at the
+// value of each key.
+func (s *jsonStream) object(fn func(key string) error) error {
+	if err := s.delim('{'); err != nil {
+		return err
+	}
+	for s.dec.More() {
+		tok, err := s.dec.Token()
+		if err != nil {
+			return s.syntaxError(err)
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return s.syntaxError(fmt.Errorf("expected object key, found %v", tok))
+		}
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return s.delim('}')
+}
+
+// array reads a JSON array, calling fn with the decoder positioned at each element.
+func (s *jsonStream) array(fn func() error) error {
+	if err := s.delim('['); err != nil {
+		return err
+	}
+	for s.dec.More() {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	return s.delim(']')
+}
+
+func (s *jsonStream) delim(want json.Delim) error {
+	tok, err := s.dec.Token()
+	if err != nil {
+		return s.syntaxError(err)
+	}
+	if tok != want {
+		return s.syntaxError(fmt.Errorf("expected %v, found %v", want, tok))
+	}
+	return nil
+}
+
+func (s *jsonStream) decode(v interface{}) error {
+	if err := s.dec.Decode(v); err != nil {
+		return s.syntaxError(err)
+	}
+	return nil
+}
+
+func (s *jsonStream) skip() error {
+	var raw json.RawMessage
+	return s.decode(&raw)
+}
+
+// syntaxError wraps a decoder error in a ParseError carrying the input offset.
+func (s *jsonStream) syntaxError(err error) error {
+	reason := ErrMalformed
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		reason = ErrTruncated
+	}
+	offset := s.dec.InputOffset()
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		offset = syntaxErr.Offset
+	}
+	return &ParseError{
+		Reason: reason,
+		Detail: fmt.Sprintf("invalid iostat JSON at byte %d: %v", offset, err),
+		offset: offset,
+	}
+}
+
+// parseJSONStatistics converts one entry of the statistics array.
+func parseJSONStatistics(timestamps *timestampDetector, stat jsonStatistics) (Sample, *ParseError) {
+	ts, ok := timestamps.parse(stat.Timestamp)
+	if !ok {
+		return Sample{}, &ParseError{Reason: ErrBadTimestamp, Detail: fmt.Sprintf("%q", stat.Timestamp)}
+	}
+	s := Sample{Timestamp: ts}
+	if stat.AvgCPU != nil {
+		cpu := cpuStatsFromMap(ts, stat.AvgCPU)
+		s.CPU = &cpu
+	}
+	for _, disk := range stat.Disk {
+		var name string
+		devMap := make(map[string]float64, len(disk))
+		for k, raw := range disk {
+			if k == "disk_device" {
+				if err := json.Unmarshal(raw, &name); err != nil {
+					return Sample{}, &ParseError{Reason: ErrNonNumeric, Detail: "disk_device is not a string"}
+				}
+				continue
+			}
+			var v float64
+			if err := json.Unmarshal(raw, &v); err != nil {
+				return Sample{}, &ParseError{Reason: ErrNonNumeric, Detail: fmt.Sprintf("field %s has %s", k, raw)}
+			}
+			devMap[k] = v
+		}
+		if name == "" {
+			return Sample{}, &ParseError{Reason: ErrTruncated, Detail: "disk entry without disk_device"}
+		}
+		s.Devices = append(s.Devices, deviceStatsFromMap(ts, name, devMap))
+	}
+	return s, nil
+}
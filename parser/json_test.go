@@ -0,0 +1,91 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const jsonSample = `{"sysstat": {
+	"hosts": [
+		{
+			"nodename": "ip-10-0-1-5",
+			"sysname": "Linux",
+			"release": "5.15.0-1051-aws",
+			"machine": "x86_64",
+			"number-of-cpus": 16,
+			"date": "09/04/24",
+			"statistics": [
+				{
+					"timestamp": "09/04/24 12:07:20",
+					"avg-cpu":  {"user": 2.36, "nice": 0.00, "system": 0.40, "iowait": 0.04, "steal": 0.01, "idle": 97.20},
+					"disk": [
+						{"disk_device": "sda", "r/s": 2.08, "w/s": 9.58, "rkB/s": 94.38, "wkB/s": 210.39, "rrqm/s": 0.31, "wrqm/s": 5.55, "rrqm": 13.07, "wrqm": 36.68, "r_await": 0.89, "w_await": 2.74, "aqu-sz": 0.03, "rareq-sz": 45.47, "wareq-sz": 21.96, "util": 1.39}
+					]
+				},
+				{
+					"timestamp": "09/04/24 12:07:21",
+					"avg-cpu":  {"user": 33.91, "nice": 0.00, "system": 7.67, "iowait": 2.72, "steal": 0.00, "idle": 55.69},
+					"disk": [
+						{"disk_device": "sda", "r/s": 0.00, "w/s": 395.00, "rkB/s": 0.00, "wkB/s": 38116.00, "rrqm/s": 0.00, "wrqm/s": 133.00, "rrqm": 0.00, "wrqm": 25.19, "r_await": 0.00, "w_await": 8.65, "aqu-sz": 3.42, "rareq-sz": 0.00, "wareq-sz": 96.50, "util": 39.20},
+						{"disk_device": "sdb", "r/s": 9.87, "w/s": 5.00, "rkB/s": 200.00, "wkB/s": 75.00, "rrqm/s": 0.00, "wrqm/s": 0.50, "rrqm": 0.00, "wrqm": 9.09, "r_await": 1.00, "w_await": 0.80, "aqu-sz": 0.01, "rareq-sz": 20.00, "wareq-sz": 15.00, "util": 2.00}
+					]
+				}
+			]
+		}
+	]
+}}`
+
+// TestParseIostatJSON checks CPU, device and host fields from the JSON envelope.
+func TestParseIostatJSON(t *testing.T) {
+	p, err := ParseIostatJSON([]byte(jsonSample))
+	assert.NoError(t, err)
+
+	assert.Equal(t, "ip-10-0-1-5", p.Host.Hostname)
+	assert.Equal(t, "5.15.0-1051-aws", p.Host.Kernel)
+	assert.Equal(t, "x86_64", p.Host.Arch)
+	assert.Equal(t, 16, p.Host.CPUCount)
+	assert.Equal(t, "2024-09-04", p.Host.Date.Format("2006-01-02"))
+
+	assert.Len(t, p.CPUs, 2)
+	assert.Equal(t, "2024-09-04 12:07:21", p.CPUs[1].Timestamp.Format("2006-01-02 15:04:05"))
+	assert.InDelta(t, 33.91, p.CPUs[1].User, 1e-6)
+	assert.InDelta(t, 55.69, p.CPUs[1].Idle, 1e-6)
+
+	assert.Len(t, p.Devices["sda"], 2)
+	assert.Len(t, p.Devices["sdb"], 1)
+	sda := p.Devices["sda"][1]
+	assert.Equal(t, "sda", sda.Name)
+	assert.InDelta(t, 395.00, sda.WritesPerSec, 1e-6)
+	assert.InDelta(t, 38116.00, sda.WriteKBPerSec, 1e-6)
+	assert.InDelta(t, 25.19, sda.WritePctMerged, 1e-6)
+	assert.InDelta(t, 96.50, sda.WriteReqSzKB, 1e-6)
+	assert.InDelta(t, 3.42, sda.QueueSize, 1e-6)
+}
+
+// TestParseIostatJSONMissingTimestamp rejects statistics captured without -t.
+func TestParseIostatJSONMissingTimestamp(t *testing.T) {
+	sample := `{"sysstat": {"hosts": [{"nodename": "h", "statistics": [{"avg-cpu": {"user": 1}}]}]}}`
+	_, err := ParseIostatJSON([]byte(sample))
+	assert.Error(t, err)
+}
+
+// TestParseDetectsFormat verifies Parse yields the same data for text and JSON input.
+func TestParseDetectsFormat(t *testing.T) {
+	text := `Linux 5.15.0-1051-aws (ip-10-0-1-5) 	09/04/24 	_x86_64_	(16 CPU)
+
+09/04/24 12:07:20
+avg-cpu:  %user   %nice %system %iowait  %steal   %idle
+           2.36    0.00    0.40    0.04    0.01   97.20
+
+Device            r/s     rkB/s   rrqm/s  %rrqm r_await rareq-sz     w/s     wkB/s   wrqm/s  %wrqm w_await wareq-sz  aqu-sz  %util
+sda              2.08     94.38     0.31  13.07    0.89    45.47    9.58    210.39     5.55  36.68    2.74    21.96    0.03   1.39
+`
+	fromText, err := Parse([]byte(text))
+	assert.NoError(t, err)
+	fromJSON, err := Parse([]byte(jsonSample))
+	assert.NoError(t, err)
+
+	assert.Equal(t, fromText.CPUs[0], fromJSON.CPUs[0])
+	assert.Equal(t, fromText.Devices["sda"][0], fromJSON.Devices["sda"][0])
+}
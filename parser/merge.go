@@ -0,0 +1,94 @@
+package parser
+
+import (
+	"slices"
+	"sort"
+	"time"
+)
+
+// Merge combines captures of one host, such as a capture split by logrotate,
+// into a single timeline ordered by each capture's first sample. A capture
+// that carries on where the previous one stopped continues its segment;
+// otherwise the boundary becomes a restart, gap or overlap.
+//
+// vmstat and mpstat captures taken alongside iostat only add their VM and
+// per-CPU series: the timeline and CPU averages come from the captures with
+// devices, whenever there are any.
+func Merge(sets ...ParsedData) ParsedData {
+	sorted := append([]ParsedData(nil), sets...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return firstTimestamp(sorted[i]).Before(firstTimestamp(sorted[j]))
+	})
+	hasDevices := slices.ContainsFunc(sorted, func(d ParsedData) bool { return len(d.Devices) > 0 })
+	// a device capture without CPU leaves it to the others
+	devicesWithCPU := slices.ContainsFunc(sorted, func(d ParsedData) bool { return len(d.Devices) > 0 && len(d.CPUs) > 0 })
+
+	out := ParsedData{Devices: make(map[string][]DeviceStats)}
+	most := -1
+	for _, d := range sorted {
+		if out.Host.Hostname == "" {
+			out.Host = d.Host
+		}
+		out.VM = append(out.VM, d.VM...)
+		for cpu, stats := range d.Cores {
+			if out.Cores == nil {
+				out.Cores = make(map[string][]CoreStats)
+			}
+			out.Cores[cpu] = append(out.Cores[cpu], stats...)
+		}
+		out.Warnings = append(out.Warnings, d.Warnings...)
+		primary := !hasDevices || len(d.Devices) > 0
+		if primary || !devicesWithCPU {
+			out.CPUs = append(out.CPUs, d.CPUs...)
+		}
+		if !primary {
+			continue
+		}
+		for name, stats := range d.Devices {
+			out.Devices[name] = append(out.Devices[name], stats...)
+		}
+		if len(d.CPUs) > most {
+			most = len(d.CPUs)
+			out.Interval = d.Interval
+		}
+		restart := (len(d.CPUs) > 0 && d.CPUs[0].SinceBoot) || (len(d.VM) > 0 && d.VM[0].SinceBoot)
+		out.Segments = appendSegments(out.Segments, d.Segments, restart)
+	}
+	return out
+}
+
+// appendSegments adds the segments of the next capture to segs. restart
+// says whether that capture starts with an iostat banner.
+func appendSegments(segs, next []Segment, restart bool) []Segment {
+	if len(segs) == 0 || len(next) == 0 {
+		return append(segs, next...)
+	}
+	last := &segs[len(segs)-1]
+	first := next[0]
+	gap := first.Start.Sub(last.End)
+	switch {
+	case gap < 0:
+		first.Break, first.Gap = BreakOverlap, gap
+	case restart:
+		first.Break, first.Gap = BreakRestart, gap
+	case last.Interval > 0 && first.Interval == last.Interval && gap <= 2*last.Interval:
+		last.End = first.End
+		last.Samples += first.Samples
+		return append(segs, next[1:]...)
+	default:
+		first.Break, first.Gap = BreakGap, gap
+	}
+	segs = append(segs, first)
+	return append(segs, next[1:]...)
+}
+
+// firstTimestamp returns when a capture starts.
+func firstTimestamp(d ParsedData) time.Time {
+	if len(d.Segments) > 0 {
+		return d.Segments[0].Start
+	}
+	if len(d.CPUs) > 0 {
+		return d.CPUs[0].Timestamp
+	}
+	return time.Time{}
+}
@@ -0,0 +1,83 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// continuation returns n banner-less reports starting at minute:second, like
+// the second half of a capture split by logrotate.
+func continuation(minute, second, n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "09/04/24 12:%02d:%02d\n", minute, second+i)
+		b.WriteString("avg-cpu:  %user   %nice %system %iowait  %steal   %idle\n")
+		b.WriteString("           1.00    0.00    0.00    0.00    0.00   99.00\n\n")
+		b.WriteString("Device            r/s     w/s  aqu-sz\n")
+		b.WriteString("sda              1.00    1.00    0.50\n\n")
+	}
+	return b.String()
+}
+
+func mustParse(t *testing.T, input string) ParsedData {
+	t.Helper()
+	p, err := ParseIostatOutput([]byte(input))
+	require.NoError(t, err)
+	return p
+}
+
+func TestMerge(t *testing.T) {
+	head := mustParse(t, syntheticCapture(10))
+
+	t.Run("logrotate continuation", func(t *testing.T) {
+		tail := mustParse(t, continuation(0, 10, 5))
+		// passed out of order, merged in time order
+		m := Merge(tail, head)
+		require.Len(t, m.CPUs, 15)
+		assert.Equal(t, head.CPUs[0].Timestamp, m.CPUs[0].Timestamp)
+		assert.Len(t, m.Devices["sda"], 15)
+		assert.Equal(t, "host", m.Host.Hostname)
+		assert.Equal(t, time.Second, m.Interval)
+		require.Len(t, m.Segments, 1)
+		assert.Equal(t, 15, m.Segments[0].Samples)
+	})
+
+	t.Run("collector paused between files", func(t *testing.T) {
+		m := Merge(head, mustParse(t, continuation(30, 0, 5)))
+		require.Len(t, m.Segments, 2)
+		assert.Equal(t, BreakGap, m.Segments[1].Break)
+		assert.Equal(t, 30*time.Minute-9*time.Second, m.Segments[1].Gap)
+	})
+
+	t.Run("overlapping files", func(t *testing.T) {
+		m := Merge(head, mustParse(t, continuation(0, 5, 2)))
+		require.Len(t, m.Segments, 2)
+		assert.Equal(t, BreakOverlap, m.Segments[1].Break)
+	})
+
+	t.Run("new iostat run", func(t *testing.T) {
+		restarted := mustParse(t, "Linux 5.15.0 (host) \t09/04/24 \t_x86_64_\t(16 CPU)\n\n"+continuation(0, 10, 3))
+		m := Merge(head, restarted)
+		require.Len(t, m.Segments, 2)
+		assert.Equal(t, BreakRestart, m.Segments[1].Break)
+	})
+	t.Run("vmstat and mpstat alongside", func(t *testing.T) {
+		vmstat, err := ParseVmstatOutput([]byte(vmstatSample))
+		require.NoError(t, err)
+		mpstat, err := ParseMpstatOutput([]byte(mpstatSample))
+		require.NoError(t, err)
+		m := Merge(vmstat, head, mpstat)
+		// the timeline and CPU averages are iostat's
+		assert.Len(t, m.CPUs, 10)
+		require.Len(t, m.Segments, 1)
+		assert.Equal(t, 10, m.Segments[0].Samples)
+		assert.Equal(t, "host", m.Host.Hostname)
+		assert.Len(t, m.VM, 3)
+		assert.Len(t, m.Cores["1"], 2)
+	})
+}
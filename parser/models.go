@@ -11,6 +11,16 @@ type CPUStats struct {
 	Iowait    float64
 	Steal     float64
 	Idle      float64
+	// SinceBoot marks the averages since boot iostat prints first unless -y is given
+	SinceBoot bool
+	// SinceBootUnsure marks a SinceBoot report that may be a regular one
+	SinceBootUnsure bool
+}
+
+// BusyPct returns the share of CPU time spent running work: user, nice,
+// system and steal. iowait counts as idle.
+func (c CPUStats) BusyPct() float64 {
+	return c.User + c.Nice + c.System + c.Steal
 }
 
 // DeviceStats holds the per‐device I/O metrics for one timestamp.
@@ -31,12 +41,115 @@ type DeviceStats struct {
 	WriteAwaitMs      float64
 	WriteReqSzKB      float64
 
+	DiscardsPerSec      float64
+	DiscardKBPerSec     float64
+	DiscardMergedPerSec float64
+	DiscardPctMerged    float64
+	DiscardAwaitMs      float64
+	DiscardReqSzKB      float64
+
+	FlushesPerSec float64
+	FlushAwaitMs  float64
+
+	// TransfersPerSec is the request rate across all directions (tps). sar -d
+	// and iostat without -x only report this, not reads and writes apart.
+	TransfersPerSec float64
+	// ReqSzKB is the average request size across all directions (areq-sz).
+	ReqSzKB float64
+
+	// AwaitMs is the average latency across reads and writes (await).
+	// Layouts without r_await/w_await only report this combined value.
+	AwaitMs float64
+	// SvcTimeMs is the deprecated average service time (svctm), only
+	// printed by older sysstat releases
+	SvcTimeMs float64
+
 	// QueueSize is the average queue length (aqu-sz)
 	QueueSize float64
+	// UtilPct is the percentage of elapsed time the device was busy (%util)
+	UtilPct float64
+
+	// SinceBoot marks the averages since boot iostat prints first unless -y is given
+	SinceBoot bool
+	// SinceBootUnsure marks a SinceBoot report that may be a regular one
+	SinceBootUnsure bool
+}
+
+// VMStats holds one vmstat report. Memory is in KB, as vmstat prints it
+// without -S.
+type VMStats struct {
+	Timestamp time.Time
+	// Running is the run queue: processes running or waiting for a CPU (r)
+	Running float64
+	// Blocked counts processes in uninterruptible sleep, usually on I/O (b)
+	Blocked float64
+
+	SwapUsedKB float64 // swpd
+	FreeKB     float64
+	BuffersKB  float64 // buff
+	CacheKB    float64
+
+	SwapInKBPerSec  float64 // si
+	SwapOutKBPerSec float64 // so
+
+	InterruptsPerSec      float64 // in
+	ContextSwitchesPerSec float64 // cs
+
+	// SinceBoot marks the averages since boot vmstat prints first
+	SinceBoot bool
+}
+
+// CoreStats holds the utilisation of one CPU, as mpstat -P ALL and
+// sar -P ALL print it.
+type CoreStats struct {
+	Timestamp time.Time
+	// CPU is the number of the CPU, e.g. "0"
+	CPU     string
+	User    float64
+	Nice    float64
+	System  float64
+	Iowait  float64
+	IRQ     float64
+	SoftIRQ float64
+	Steal   float64
+	Idle    float64
+}
+
+// BusyPct returns the share of time the CPU spent running work.
+func (c CoreStats) BusyPct() float64 {
+	return c.User + c.Nice + c.System + c.IRQ + c.SoftIRQ + c.Steal
+}
+
+// HostInfo describes the machine the capture was taken on.
+type HostInfo struct {
+	OS       string // e.g. "Linux"
+	Kernel   string // kernel release, e.g. "5.15.0-1051-aws"
+	Hostname string
+	Date     time.Time // capture date as printed by iostat
+	Arch     string    // e.g. "x86_64"
+	CPUCount int
+}
+
+// CoresBusy converts the busy percentage of c into the number of cores
+// busy on this host. It is zero when the CPU count is unknown.
+func (h HostInfo) CoresBusy(c CPUStats) float64 {
+	return c.BusyPct() / 100 * float64(h.CPUCount)
 }
 
 // ParsedData is the top‐level result of parsing iostat output.
 type ParsedData struct {
+	Host    HostInfo
 	CPUs    []CPUStats
 	Devices map[string][]DeviceStats
+	// VM holds the vmstat reports: run queue, blocked processes, memory and swap.
+	VM []VMStats
+	// Cores holds the utilisation of each CPU from mpstat or sar -P ALL,
+	// keyed by CPU number.
+	Cores map[string][]CoreStats
+	// Warnings lists the blocks skipped in lenient mode.
+	Warnings []ParseError
+	// Interval is the median step between samples.
+	Interval time.Duration
+	// Segments splits the capture at gaps, overlaps, clock jumps and restarts.
+	Segments []Segment
 }
@@ -0,0 +1,47 @@
+package parser
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"strings"
+)
+
+// mpstat -P ALL prints the same rows as sar -P ALL -u ALL, but interval by
+// interval, each under its own header:
+//
+//	Linux 5.15.0-1051-aws (ip-10-0-1-5) 	09/04/24 	_x86_64_	(2 CPU)
+//
+//	12:00:01     CPU    %usr   %nice    %sys %iowait    %irq   %soft  %steal  %guest  %gnice   %idle
+//	12:00:02     all    2.51    0.00    0.80    0.12    0.00    0.01    0.00    0.00    0.00   96.56
+//	12:00:02       0    3.00    0.00    1.00    0.00    0.00    0.02    0.00    0.00    0.00   95.98
+//	12:00:02       1    2.02    0.00    0.60    0.24    0.00    0.00    0.00    0.00    0.00   97.14
+
+// ParseMpstatOutput parses the output of `mpstat -P ALL` into the structure
+// ParseIostatOutput produces: CPU from the "all" rows and Cores from the
+// per-CPU rows. The Average lines at the end are skipped.
+func ParseMpstatOutput(data []byte, opts ...Option) (ParsedData, error) {
+	c := NewCollector(0)
+	info, err := StreamMpstatOutput(bytes.NewReader(data), c.Add, opts...)
+	return c.Data(info), err
+}
+
+// StreamMpstatOutput is the streaming form of ParseMpstatOutput: it calls fn
+// for each interval as soon as the next one starts.
+func StreamMpstatOutput(r io.Reader, fn func(Sample) error, opts ...Option) (StreamInfo, error) {
+	return streamSar(r, fn, newConfig(opts), true)
+}
+
+// sniffMpstat reports whether text contains an mpstat CPU header. mpstat
+// lists %irq before %steal, sar -u ALL after it.
+func sniffMpstat(text []byte) bool {
+	for _, line := range strings.Split(string(text), "\n") {
+		_, rest := splitSarClock(line)
+		if !isSarHeader(rest) || rest[0] != "CPU" {
+			continue
+		}
+		irq, steal := slices.Index(rest, "%irq"), slices.Index(rest, "%steal")
+		return irq >= 0 && steal >= 0 && irq < steal
+	}
+	return false
+}
@@ -0,0 +1,74 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const mpstatSample = `Linux 5.15.0-1051-aws (ip-10-0-1-5) 	09/04/24 	_x86_64_	(2 CPU)
+
+23:59:59     CPU    %usr   %nice    %sys %iowait    %irq   %soft  %steal  %guest  %gnice   %idle
+00:00:00     all   50.00    0.00   10.00    0.00    0.00    1.00    0.00    0.00    0.00   39.00
+00:00:00       0   98.00    0.00    1.00    0.00    0.00    1.00    0.00    0.00    0.00    0.00
+00:00:00       1    2.00    0.00   19.00    0.00    0.00    1.00    0.00    0.00    0.00   78.00
+
+00:00:00     CPU    %usr   %nice    %sys %iowait    %irq   %soft  %steal  %guest  %gnice   %idle
+00:00:01     all    5.00    0.00    1.00    0.00    0.00    0.00    0.00    0.00    0.00   94.00
+00:00:01       0    6.00    0.00    1.00    0.00    0.00    0.00    0.00    0.00    0.00   93.00
+00:00:01       1    4.00    0.00    1.00    0.00    0.00    0.00    0.00    0.00    0.00   95.00
+
+Average:     CPU    %usr   %nice    %sys %iowait    %irq   %soft  %steal  %guest  %gnice   %idle
+Average:     all   27.50    0.00    5.50    0.00    0.00    0.50    0.00    0.00    0.00   66.50
+`
+
+func TestParseMpstatOutput(t *testing.T) {
+	assert.True(t, Sniff([]byte(mpstatSample)))
+	p, err := Parse([]byte(mpstatSample))
+	require.NoError(t, err)
+	assert.Equal(t, 2, p.Host.CPUCount)
+
+	require.Len(t, p.CPUs, 2)
+	// the clock passes midnight after the banner date
+	assert.Equal(t, time.Date(2024, 9, 5, 0, 0, 0, 0, time.UTC), p.CPUs[0].Timestamp)
+	assert.InDelta(t, 50, p.CPUs[0].User, 1e-9)
+	assert.InDelta(t, 11, p.CPUs[0].System, 1e-9)
+
+	require.Len(t, p.Cores, 2)
+	hot := p.Cores["0"]
+	require.Len(t, hot, 2)
+	assert.Equal(t, p.CPUs[0].Timestamp, hot[0].Timestamp)
+	assert.InDelta(t, 98, hot[0].User, 1e-9)
+	assert.InDelta(t, 1, hot[0].SoftIRQ, 1e-9)
+	assert.InDelta(t, 100, hot[0].BusyPct(), 1e-9)
+	assert.InDelta(t, 19, p.Cores["1"][0].System, 1e-9)
+	assert.Equal(t, time.Second, p.Interval)
+}
+
+// TestParseMpstatOutputAllOnly reads mpstat without -P ALL as CPU averages.
+func TestParseMpstatOutputAllOnly(t *testing.T) {
+	var kept []string
+	for _, line := range strings.Split(mpstatSample, "\n") {
+		if f := strings.Fields(line); len(f) < 2 || (f[1] != "0" && f[1] != "1") {
+			kept = append(kept, line)
+		}
+	}
+	p, err := ParseMpstatOutput([]byte(strings.Join(kept, "\n")))
+	require.NoError(t, err)
+	assert.Len(t, p.CPUs, 2)
+	assert.Empty(t, p.Cores)
+}
+
+func TestParseMpstatOutputErrors(t *testing.T) {
+	// interrupt tables alone hold no utilisation
+	input := "Linux 5.15.0 (host) \t09/04/24 \t_x86_64_\t(2 CPU)\n\n" +
+		"12:00:01     CPU    intr/s\n12:00:02     all    250.00\n"
+	_, err := ParseMpstatOutput([]byte(input))
+	var perr *ParseError
+	require.ErrorAs(t, err, &perr)
+	assert.ErrorIs(t, err, ErrNoSamples)
+	assert.Contains(t, perr.Detail, "mpstat -P ALL")
+}
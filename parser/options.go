@@ -0,0 +1,43 @@
+package parser
+
+// config holds the settings shared by every parser front end.
+type config struct {
+	timeLayout string
+	dateOrder  DateOrder
+	lenient    bool
+	format     string
+}
+
+// Option customises how input is parsed.
+type Option func(*config)
+
+// WithTimeLayout forces timestamps to be parsed with the given Go time
+// layout instead of detecting the format.
+func WithTimeLayout(layout string) Option {
+	return func(c *config) { c.timeLayout = layout }
+}
+
+// WithDateOrder forces how ambiguous numeric dates such as 04/09/24 are read.
+func WithDateOrder(order DateOrder) Option {
+	return func(c *config) { c.dateOrder = order }
+}
+
+// WithLenient skips blocks that cannot be parsed and records a warning for
+// each in ParsedData.Warnings instead of failing on the first one.
+func WithLenient() Option {
+	return func(c *config) { c.lenient = true }
+}
+
+// WithFormat reads input as the registered format name instead of
+// detecting it, see Formats.
+func WithFormat(name string) Option {
+	return func(c *config) { c.format = name }
+}
+
+func newConfig(opts []Option) config {
+	var c config
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
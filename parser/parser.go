@@ -4,142 +4,380 @@ import (
 	"bufio"
 	"bytes"
 	"errors"
+	"fmt"
+	"io"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// Parse detects whether data is iostat text or JSON (`iostat -o JSON`) output,
+// sar text output, a /proc/diskstats snapshot series, or vmstat or mpstat
+// output, and parses it accordingly.
+func Parse(data []byte, opts ...Option) (ParsedData, error) {
+	return ParseReader(bytes.NewReader(data), opts...)
+}
+
 // ParseIostatOutput parses the full iostat -x -c output into structured data.
-func ParseIostatOutput(data []byte) (ParsedData, error) {
-	parsed := ParsedData{
-		Devices: make(map[string][]DeviceStats),
-	}
-	scanner := bufio.NewScanner(bytes.NewReader(data))
+// Timestamps may use any format sysstat prints, see WithTimeLayout and
+// WithDateOrder to override detection. The first problem found is returned as
+// a *ParseError unless WithLenient is given.
+func ParseIostatOutput(data []byte, opts ...Option) (ParsedData, error) {
+	c := NewCollector(0)
+	info, err := StreamIostatOutput(bytes.NewReader(data), c.Add, opts...)
+	return c.Data(info), err
+}
+
+// StreamIostatOutput is the streaming form of ParseIostatOutput: it calls fn
+// for each sample as soon as its block has been read.
+func StreamIostatOutput(r io.Reader, fn func(Sample) error, opts ...Option) (StreamInfo, error) {
+	timeline := NewTimeline()
+	p := newTextParser(newConfig(opts), withTimeline(timeline, fn))
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLength)
 
+	info := func() StreamInfo {
+		i := p.info()
+		i.setTimeline(timeline)
+		return i
+	}
 	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
-			continue
+		if err := p.line(scanner.Text()); err != nil {
+			return info(), err
 		}
-		// parse timestamp exactly MM/DD/YY HH:MM:SS
-		ts, err := time.Parse("01/02/06 15:04:05", line)
-		if err != nil {
-			// not a timestamp line
-			continue
-		}
-
-		// find and consume avg-cpu header
-		var cpuHeader []string
-		for scanner.Scan() {
-			text := strings.TrimSpace(scanner.Text())
-			if strings.HasPrefix(text, "avg-cpu:") {
-				parts := strings.Fields(text)
-				if len(parts) > 1 {
-					cpuHeader = parts[1:]
-				}
-				break
-			}
+	}
+	if err := scanner.Err(); err != nil {
+		return info(), err
+	}
+	err := p.finish()
+	return info(), err
+}
+
+// maxLineLength bounds a single input line.
+const maxLineLength = 1024 * 1024
+
+// textState tracks where the text parser is within a report block.
+type textState int
+
+const (
+	stateSeekTimestamp textState = iota // between blocks
+	stateSeekHeader                     // after a timestamp line
+	stateCPUValues                      // after the avg-cpu header
+	stateAfterCPU                       // after the CPU values
+	stateDevices                        // after the Device header
+)
+
+// textParser is a line-at-a-time state machine over iostat text output.
+// Each complete block is passed to emit.
+type textParser struct {
+	cfg        config
+	timestamps *timestampDetector
+	emit       func(Sample) error
+
+	state     textState
+	lineNo    int
+	cur       Sample
+	curLine   int // line of the current block's timestamp
+	curText   string
+	cpuHeader []string
+	devHeader []string
+	// devNameLast is set when rows end in the device name, as with -h since
+	// sysstat 12
+	devNameLast bool
+	// wrappedName is a device name printed on a line of its own, whose
+	// values follow on the next line
+	wrappedName string
+	samples     int
+	host        HostInfo
+	// runStart is set by a banner until the first report of that iostat run
+	// is flushed. Input without a banner, such as a logrotate continuation,
+	// does not start with a since-boot report.
+	runStart bool
+	// first holds that report back until the next one is flushed
+	first firstReport
+
+	// orphanLine is the first report header seen outside a timestamped block
+	orphanLine int
+	orphanText string
+	warnings   []ParseError
+}
+
+func newTextParser(cfg config, emit func(Sample) error) *textParser {
+	return &textParser{
+		cfg:        cfg,
+		timestamps: newTimestampDetector(cfg),
+		emit:       emit,
+	}
+}
+
+// line consumes the next input line.
+func (p *textParser) line(raw string) error {
+	p.lineNo++
+	line := strings.TrimSpace(raw)
+	if line == "" {
+		// a blank line ends the device list
+		if p.state == stateDevices {
+			return p.flush()
 		}
-		// find CPU values
-		var cpuValues []string
-		for scanner.Scan() {
-			text := strings.TrimSpace(scanner.Text())
-			if text == "" {
-				continue
-			}
-			cpuValues = strings.Fields(text)
-			break
-		}
-		// map CPU by header
-		cpuMap := make(map[string]float64, len(cpuHeader))
-		for i, h := range cpuHeader {
-			key := strings.TrimPrefix(h, "%")
-			if i < len(cpuValues) {
-				v, err := strconv.ParseFloat(cpuValues[i], 64)
-				if err != nil {
-					return parsed, err
-				}
-				cpuMap[key] = v
-			}
+		return nil
+	}
+
+	if ts, ok := p.timestamps.parse(line); ok {
+		if err := p.endBlock(); err != nil {
+			return err
 		}
-		parsed.CPUs = append(parsed.CPUs, CPUStats{
-			Timestamp: ts,
-			User:      cpuMap["user"],
-			Nice:      cpuMap["nice"],
-			System:    cpuMap["system"],
-			Iowait:    cpuMap["iowait"],
-			Steal:     cpuMap["steal"],
-			Idle:      cpuMap["idle"],
-		})
-
-		// find device header
-		var devHeader []string
-		for scanner.Scan() {
-			text := strings.TrimSpace(scanner.Text())
-			if text == "" {
-				continue
-			}
-			if strings.HasPrefix(text, "Device") {
-				devHeader = strings.Fields(text)
-				break
+		p.cur = Sample{Timestamp: ts}
+		p.curLine, p.curText = p.lineNo, line
+		p.state = stateSeekHeader
+		return nil
+	}
+
+	switch p.state {
+	case stateSeekTimestamp:
+		if host, ok := parseBanner(line, p.cfg.dateOrder); ok {
+			// a run of a single report has no interval to judge it by
+			if err := p.first.release(time.Time{}, p.emit); err != nil {
+				return err
 			}
+			p.host = host
+			p.runStart = true
+			p.timestamps.setDate(host.Date)
+			return nil
 		}
-		// parse device lines
-		for scanner.Scan() {
-			text := strings.TrimSpace(scanner.Text())
-			if text == "" {
-				break
-			}
-			fields := strings.Fields(text)
-			devMap := make(map[string]float64, len(devHeader))
-			for i, h := range devHeader {
-				if i == 0 || i >= len(fields) {
-					continue
-				}
-				key := strings.TrimPrefix(h, "%")
-				v, err := strconv.ParseFloat(fields[i], 64)
-				if err != nil {
-					return parsed, err
-				}
-				devMap[key] = v
+		if p.orphanLine == 0 && (strings.HasPrefix(line, "avg-cpu:") || isDeviceHeader(strings.Fields(line))) {
+			p.orphanLine, p.orphanText = p.lineNo, line
+		}
+	case stateSeekHeader, stateAfterCPU:
+		switch {
+		case p.state == stateSeekHeader && strings.HasPrefix(line, "avg-cpu:"):
+			p.cpuHeader = strings.Fields(line)[1:]
+			if len(p.cpuHeader) == 0 {
+				return p.fail(p.lineNo, line, ErrUnknownHeader, "avg-cpu header has no columns")
 			}
-			dev := DeviceStats{
-				Timestamp:         ts,
-				Name:              fields[0],
-				ReadsPerSec:       devMap["r/s"],
-				ReadKBPerSec:      devMap["rkB/s"],
-				ReadMergedPerSec:  devMap["rrqm/s"],
-				ReadPctMerged:     devMap["rrqm"],
-				ReadAwaitMs:       devMap["r_await"],
-				ReadReqSzKB:       devMap["rareq-sz"],
-				WritesPerSec:      devMap["w/s"],
-				WriteKBPerSec:     devMap["wkB/s"],
-				WriteMergedPerSec: devMap["wrqm/s"],
-				WritePctMerged:    devMap["wrqm"],
-				WriteAwaitMs:      devMap["w_await"],
-				WriteReqSzKB:      devMap["wareq-sz"],
-				// aqu-sz → queue length
-				QueueSize: devMap["aqu-sz"],
+			p.state = stateCPUValues
+		case isDeviceHeader(strings.Fields(line)):
+			p.devHeader = strings.Fields(line)
+			if len(p.devHeader) < 2 {
+				return p.fail(p.lineNo, line, ErrUnknownHeader, "Device header has no columns")
 			}
-			parsed.Devices[dev.Name] = append(parsed.Devices[dev.Name], dev)
+			p.devNameLast = !strings.HasPrefix(p.devHeader[0], "Device")
+			p.state = stateDevices
+		default:
+			return p.fail(p.lineNo, line, ErrUnknownHeader, "expected avg-cpu or Device header")
+		}
+	case stateCPUValues:
+		fields := strings.Fields(line)
+		if len(fields) != len(p.cpuHeader) {
+			return p.fail(p.lineNo, line, ErrTruncated, fmt.Sprintf("%d CPU values for %d columns", len(fields), len(p.cpuHeader)))
+		}
+		cpuMap, err := p.values(line, p.cpuHeader, fields)
+		if err != nil {
+			return err
+		}
+		if cpuMap == nil {
+			return nil
+		}
+		cpu := cpuStatsFromMap(p.cur.Timestamp, cpuMap)
+		p.cur.CPU = &cpu
+		p.state = stateAfterCPU
+	case stateDevices:
+		fields := strings.Fields(line)
+		// names too long for their column, and every name with -h before
+		// sysstat 12, are printed on a line of their own
+		if len(fields) == 1 && p.wrappedName == "" && !p.devNameLast {
+			p.wrappedName = fields[0]
+			return nil
+		}
+		if p.wrappedName != "" {
+			fields = append([]string{p.wrappedName}, fields...)
+			p.wrappedName = ""
+		}
+		if len(fields) != len(p.devHeader) {
+			return p.fail(p.lineNo, line, ErrTruncated, fmt.Sprintf("%d device fields for %d columns", len(fields), len(p.devHeader)))
+		}
+		name, header, values := fields[0], p.devHeader[1:], fields[1:]
+		if p.devNameLast {
+			last := len(fields) - 1
+			name, header, values = fields[last], p.devHeader[:last], fields[:last]
+		}
+		devMap, err := p.values(line, header, values)
+		if err != nil {
+			return err
 		}
+		if devMap == nil {
+			return nil
+		}
+		p.cur.Devices = append(p.cur.Devices, deviceStatsFromMap(p.cur.Timestamp, name, devMap))
 	}
+	return nil
+}
 
-	if err := scanner.Err(); err != nil {
-		return parsed, err
+// values maps each header column, with any leading "%" removed, to its
+// parsed field, see parseValue for -h output. A nil map with a nil error
+// means the block was skipped.
+func (p *textParser) values(line string, header, fields []string) (map[string]float64, error) {
+	m := make(map[string]float64, len(header))
+	for i, h := range header {
+		v, err := parseValue(h, fields[i])
+		if err != nil {
+			return nil, p.fail(p.lineNo, line, ErrNonNumeric, fmt.Sprintf("column %s has %q", h, fields[i]))
+		}
+		m[strings.TrimPrefix(h, "%")] = v
+	}
+	return m, nil
+}
+
+// endBlock closes the current block when a new one starts or input ends.
+func (p *textParser) endBlock() error {
+	switch p.state {
+	case stateSeekHeader:
+		return p.fail(p.curLine, p.curText, ErrTruncated, "timestamp without avg-cpu or Device block")
+	case stateCPUValues:
+		return p.fail(p.curLine, p.curText, ErrTruncated, "avg-cpu header without values")
+	case stateAfterCPU, stateDevices:
+		return p.flush()
+	}
+	return nil
+}
+
+// flush emits the current block.
+func (p *textParser) flush() error {
+	s := p.cur
+	p.cur = Sample{}
+	p.wrappedName = ""
+	p.state = stateSeekTimestamp
+	if s.CPU == nil && len(s.Devices) == 0 {
+		return nil
 	}
-	return parsed, nil
+	p.samples++
+	// iostat's first report averages since boot unless it ran with -y,
+	// which is judged once the next report shows the interval
+	if p.runStart {
+		s.RunStart = true
+		p.runStart = false
+		p.first.hold(s, p.host.Date)
+		return nil
+	}
+	if err := p.first.release(s.Timestamp, p.emit); err != nil {
+		return err
+	}
+	return p.emit(s)
+}
+
+// fail abandons the current block. In lenient mode the problem is recorded
+// as a warning and parsing continues at the next timestamp.
+func (p *textParser) fail(line int, text string, reason error, detail string) error {
+	perr := ParseError{Line: line, Text: text, Reason: reason, Detail: detail}
+	p.cur = Sample{}
+	p.wrappedName = ""
+	p.state = stateSeekTimestamp
+	if p.cfg.lenient {
+		p.warnings = append(p.warnings, perr)
+		return nil
+	}
+	return &perr
 }
 
-// parseTimestamp parses lines like "09/04/24 12:07:20".
-func parseTimestamp(line string) (time.Time, error) {
-	parts := strings.Fields(line)
-	if len(parts) < 2 {
-		return time.Time{}, errors.New("not a timestamp")
+// info returns what is known about the capture as a whole.
+func (p *textParser) info() StreamInfo {
+	return StreamInfo{Host: p.host, Warnings: p.warnings}
+}
+
+// finish closes the last block and reports input that yielded no samples.
+func (p *textParser) finish() error {
+	if err := p.endBlock(); err != nil {
+		return err
+	}
+	if err := p.first.release(time.Time{}, p.emit); err != nil {
+		return err
+	}
+	if p.samples > 0 {
+		return nil
+	}
+	if len(p.warnings) > 0 {
+		return &ParseError{
+			Reason: ErrNoSamples,
+			Detail: fmt.Sprintf("all %d blocks were skipped", len(p.warnings)),
+		}
+	}
+	if p.orphanLine > 0 {
+		return &ParseError{
+			Line:   p.orphanLine,
+			Text:   p.orphanText,
+			Reason: ErrNoSamples,
+			Detail: "report without a preceding timestamp line (capture with iostat -t)",
+		}
+	}
+	return &ParseError{
+		Reason: ErrNoSamples,
+		Detail: fmt.Sprintf("no timestamp lines recognised in %d lines", p.lineNo),
+	}
+}
+
+// isDeviceHeader reports whether fields are the column names of the device
+// report, which start with "Device", or end with it with -h since sysstat 12.
+func isDeviceHeader(fields []string) bool {
+	if len(fields) == 0 {
+		return false
+	}
+	return strings.HasPrefix(fields[0], "Device") || fields[len(fields)-1] == "Device"
+}
+
+// cpuStatsFromMap builds a CPUStats from values keyed by the iostat column
+// name with any leading "%" removed.
+func cpuStatsFromMap(ts time.Time, m map[string]float64) CPUStats {
+	return CPUStats{
+		Timestamp: ts,
+		User:      m["user"],
+		Nice:      m["nice"],
+		System:    m["system"],
+		Iowait:    m["iowait"],
+		Steal:     m["steal"],
+		Idle:      m["idle"],
+	}
+}
+
+// deviceStatsFromMap builds a DeviceStats from values keyed by the iostat
+// column name with any leading "%" removed. Legacy column names are
+// normalised first, see normaliseDeviceColumns.
+func deviceStatsFromMap(ts time.Time, name string, m map[string]float64) DeviceStats {
+	normaliseDeviceColumns(m)
+	return DeviceStats{
+		Timestamp:         ts,
+		Name:              name,
+		ReadsPerSec:       m["r/s"],
+		ReadKBPerSec:      m["rkB/s"],
+		ReadMergedPerSec:  m["rrqm/s"],
+		ReadPctMerged:     m["rrqm"],
+		ReadAwaitMs:       m["r_await"],
+		ReadReqSzKB:       m["rareq-sz"],
+		WritesPerSec:      m["w/s"],
+		WriteKBPerSec:     m["wkB/s"],
+		WriteMergedPerSec: m["wrqm/s"],
+		WritePctMerged:    m["wrqm"],
+		WriteAwaitMs:      m["w_await"],
+		WriteReqSzKB:      m["wareq-sz"],
+
+		DiscardsPerSec:      m["d/s"],
+		DiscardKBPerSec:     m["dkB/s"],
+		DiscardMergedPerSec: m["drqm/s"],
+		DiscardPctMerged:    m["drqm"],
+		DiscardAwaitMs:      m["d_await"],
+		DiscardReqSzKB:      m["dareq-sz"],
+
+		FlushesPerSec: m["f/s"],
+		FlushAwaitMs:  m["f_await"],
+
+		TransfersPerSec: m["tps"],
+		ReqSzKB:         m["areq-sz"],
+
+		AwaitMs:   m["await"],
+		SvcTimeMs: m["svctm"],
+
+		// aqu-sz → queue length
+		QueueSize: m["aqu-sz"],
+		UtilPct:   m["util"],
 	}
-	stamp := parts[0] + " " + parts[1]
-	// format: MM/DD/YY HH:MM:SS
-	return time.Parse("01/02/06 15:04:05", stamp)
 }
 
 // parseCPUStats takes a timestamp and six string fields, returns a CPUStats.
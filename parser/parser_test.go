@@ -217,3 +217,30 @@ sdb               9.87    200.00     0.00   0.00     1.00     20.00    5.00
 	assert.InDelta(t, 9.87, sdbRecs[0].ReadsPerSec, 1e-6)
 	assert.InDelta(t, 5.00, sdbRecs[0].WritesPerSec, 1e-6)
 }
+
+// TestParseIostatDiscardFlushUtil verifies the discard, flush and %util columns are kept.
+func TestParseIostatDiscardFlushUtil(t *testing.T) {
+	sample := `
+09/04/24 12:07:20
+avg-cpu:  %user   %nice %system %iowait  %steal   %idle
+           2.36    0.00    0.40    0.04    0.01   97.20
+
+Device            r/s     rkB/s   rrqm/s  %rrqm r_await rareq-sz     w/s     wkB/s   wrqm/s  %wrqm w_await wareq-sz     d/s     dkB/s   drqm/s  %drqm d_await dareq-sz     f/s f_await  aqu-sz  %util
+sda              2.08     94.38     0.31  13.07    0.89    45.47    9.58    210.39     5.55  36.68    2.74    21.96    0.09    377.20     0.01   1.50    0.95  4151.86    3.94    0.06    0.03   1.39
+`
+	p, err := ParseIostatOutput([]byte(sample))
+	assert.NoError(t, err)
+	recs := p.Devices["sda"]
+	assert.Len(t, recs, 1)
+	d := recs[0]
+	assert.InDelta(t, 0.09, d.DiscardsPerSec, 1e-6)
+	assert.InDelta(t, 377.20, d.DiscardKBPerSec, 1e-6)
+	assert.InDelta(t, 0.01, d.DiscardMergedPerSec, 1e-6)
+	assert.InDelta(t, 1.50, d.DiscardPctMerged, 1e-6)
+	assert.InDelta(t, 0.95, d.DiscardAwaitMs, 1e-6)
+	assert.InDelta(t, 4151.86, d.DiscardReqSzKB, 1e-6)
+	assert.InDelta(t, 3.94, d.FlushesPerSec, 1e-6)
+	assert.InDelta(t, 0.06, d.FlushAwaitMs, 1e-6)
+	assert.InDelta(t, 0.03, d.QueueSize, 1e-6)
+	assert.InDelta(t, 1.39, d.UtilPct, 1e-6)
+}
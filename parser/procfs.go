@@ -0,0 +1,239 @@
+package parser
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DiskCounters is one line of /proc/diskstats: cumulative counters since
+// boot, times in milliseconds and sizes in 512-byte sectors. Kernels before
+// 4.18 have no discard fields and kernels before 5.5 no flush fields.
+type DiskCounters struct {
+	Major, Minor int
+	Name         string
+
+	ReadIOs, ReadMerges, ReadSectors, ReadTicks             uint64
+	WriteIOs, WriteMerges, WriteSectors, WriteTicks         uint64
+	InFlight                                                uint64
+	IOTicks, TimeInQueue                                    uint64
+	DiscardIOs, DiscardMerges, DiscardSectors, DiscardTicks uint64
+	FlushIOs, FlushTicks                                    uint64
+}
+
+// ParseDiskstats reads a /proc/diskstats snapshot.
+func ParseDiskstats(r io.Reader) ([]DiskCounters, error) {
+	var out []DiskCounters
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		dc, err := parseDiskstatsLine(line)
+		if err != nil {
+			return nil, &ParseError{Line: lineNo, Text: line, Reason: ErrMalformed, Detail: err.Error()}
+		}
+		out = append(out, dc)
+	}
+	return out, scanner.Err()
+}
+
+// parseDiskstatsLine parses one line of /proc/diskstats.
+func parseDiskstatsLine(line string) (DiskCounters, error) {
+	fields := strings.Fields(line)
+	// major minor name and at least the 11 fields every 2.6+ kernel prints
+	if len(fields) < 14 {
+		return DiskCounters{}, fmt.Errorf("expected at least 14 fields, got %d", len(fields))
+	}
+	var dc DiskCounters
+	var err error
+	if dc.Major, err = strconv.Atoi(fields[0]); err != nil {
+		return DiskCounters{}, fmt.Errorf("major number %q", fields[0])
+	}
+	if dc.Minor, err = strconv.Atoi(fields[1]); err != nil {
+		return DiskCounters{}, fmt.Errorf("minor number %q", fields[1])
+	}
+	dc.Name = fields[2]
+	counters := []*uint64{
+		&dc.ReadIOs, &dc.ReadMerges, &dc.ReadSectors, &dc.ReadTicks,
+		&dc.WriteIOs, &dc.WriteMerges, &dc.WriteSectors, &dc.WriteTicks,
+		&dc.InFlight, &dc.IOTicks, &dc.TimeInQueue,
+		&dc.DiscardIOs, &dc.DiscardMerges, &dc.DiscardSectors, &dc.DiscardTicks,
+		&dc.FlushIOs, &dc.FlushTicks,
+	}
+	for i, f := range fields[3:] {
+		if i >= len(counters) {
+			break
+		}
+		v, err := strconv.ParseUint(f, 10, 64)
+		if err != nil {
+			return DiskCounters{}, fmt.Errorf("field %d is %q", i+4, f)
+		}
+		*counters[i] = v
+	}
+	return dc, nil
+}
+
+// Idle reports whether the device has never done any I/O; iostat leaves
+// such devices out.
+func (c DiskCounters) Idle() bool {
+	return c.ReadIOs == 0 && c.WriteIOs == 0 && c.DiscardIOs == 0 && c.FlushIOs == 0
+}
+
+// counterDelta returns how much a counter grew between two readings. A
+// counter that went backwards was reset, and has grown by its new value.
+func counterDelta(prev, cur uint64) uint64 {
+	d, _ := counterStep(prev, cur)
+	return d
+}
+
+// counterStep returns how much a counter grew between two readings and
+// whether it kept counting. Older kernels keep the tick counters in 32 bits
+// and 32-bit hosts all of them, so a counter that went backwards by less
+// than half its range wrapped around; any other decrease is a reset, after
+// which the counter has grown by its new value.
+func counterStep(prev, cur uint64) (uint64, bool) {
+	switch {
+	case cur >= prev:
+		return cur - prev, true
+	case prev <= math.MaxUint32:
+		if d := uint64(uint32(cur - prev)); d < 1<<31 {
+			return d, true
+		}
+	case cur-prev < 1<<63:
+		return cur - prev, true
+	}
+	return cur, false
+}
+
+// resetSince reports whether any counter of c went backwards since prev
+// without wrapping, as happens when the host reboots or a device is
+// removed and added again.
+func (c DiskCounters) resetSince(prev DiskCounters) bool {
+	pairs := [][2]uint64{
+		{prev.ReadIOs, c.ReadIOs}, {prev.ReadMerges, c.ReadMerges}, {prev.ReadSectors, c.ReadSectors}, {prev.ReadTicks, c.ReadTicks},
+		{prev.WriteIOs, c.WriteIOs}, {prev.WriteMerges, c.WriteMerges}, {prev.WriteSectors, c.WriteSectors}, {prev.WriteTicks, c.WriteTicks},
+		{prev.IOTicks, c.IOTicks}, {prev.TimeInQueue, c.TimeInQueue},
+		{prev.DiscardIOs, c.DiscardIOs}, {prev.DiscardMerges, c.DiscardMerges}, {prev.DiscardSectors, c.DiscardSectors}, {prev.DiscardTicks, c.DiscardTicks},
+		{prev.FlushIOs, c.FlushIOs}, {prev.FlushTicks, c.FlushTicks},
+	}
+	for _, p := range pairs {
+		if _, ok := counterStep(p[0], p[1]); !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// DeviceStatsBetween derives the iostat -x metrics of a device over the
+// elapsed time between two readings, the way sysstat does. Pass a zero prev
+// and the uptime as elapsed for the since-boot averages.
+func DeviceStatsBetween(prev, cur DiskCounters, elapsed time.Duration) DeviceStats {
+	ds := DeviceStats{Name: cur.Name}
+	ms := float64(elapsed) / float64(time.Millisecond)
+	if ms <= 0 {
+		return ds
+	}
+	secs := ms / 1000
+	d := func(p, c uint64) float64 { return float64(counterDelta(p, c)) }
+
+	rIOs, wIOs, dIOs, fIOs := d(prev.ReadIOs, cur.ReadIOs), d(prev.WriteIOs, cur.WriteIOs), d(prev.DiscardIOs, cur.DiscardIOs), d(prev.FlushIOs, cur.FlushIOs)
+	rMerges, wMerges, dMerges := d(prev.ReadMerges, cur.ReadMerges), d(prev.WriteMerges, cur.WriteMerges), d(prev.DiscardMerges, cur.DiscardMerges)
+	rKB, wKB, dKB := d(prev.ReadSectors, cur.ReadSectors)/2, d(prev.WriteSectors, cur.WriteSectors)/2, d(prev.DiscardSectors, cur.DiscardSectors)/2
+	rTicks, wTicks, dTicks, fTicks := d(prev.ReadTicks, cur.ReadTicks), d(prev.WriteTicks, cur.WriteTicks), d(prev.DiscardTicks, cur.DiscardTicks), d(prev.FlushTicks, cur.FlushTicks)
+
+	ds.ReadsPerSec, ds.ReadKBPerSec, ds.ReadMergedPerSec = rIOs/secs, rKB/secs, rMerges/secs
+	ds.WritesPerSec, ds.WriteKBPerSec, ds.WriteMergedPerSec = wIOs/secs, wKB/secs, wMerges/secs
+	ds.DiscardsPerSec, ds.DiscardKBPerSec, ds.DiscardMergedPerSec = dIOs/secs, dKB/secs, dMerges/secs
+	ds.FlushesPerSec = fIOs / secs
+	ds.ReadPctMerged, ds.WritePctMerged, ds.DiscardPctMerged = mergedPct(rMerges, rIOs), mergedPct(wMerges, wIOs), mergedPct(dMerges, dIOs)
+	ds.ReadAwaitMs, ds.WriteAwaitMs, ds.DiscardAwaitMs, ds.FlushAwaitMs = ratio(rTicks, rIOs), ratio(wTicks, wIOs), ratio(dTicks, dIOs), ratio(fTicks, fIOs)
+	ds.ReadReqSzKB, ds.WriteReqSzKB, ds.DiscardReqSzKB = ratio(rKB, rIOs), ratio(wKB, wIOs), ratio(dKB, dIOs)
+	ds.TransfersPerSec, ds.ReqSzKB = (rIOs+wIOs+dIOs)/secs, ratio(rKB+wKB+dKB, rIOs+wIOs+dIOs)
+	ds.AwaitMs = ratio(rTicks+wTicks, rIOs+wIOs)
+	ds.QueueSize = d(prev.TimeInQueue, cur.TimeInQueue) / ms
+	ds.UtilPct = math.Min(100, d(prev.IOTicks, cur.IOTicks)/ms*100)
+	if ios := rIOs + wIOs + dIOs; ios > 0 {
+		ds.SvcTimeMs = d(prev.IOTicks, cur.IOTicks) / ios
+	}
+	return ds
+}
+
+// ratio returns a/b, or 0 when nothing happened.
+func ratio(a, b float64) float64 {
+	if b == 0 {
+		return 0
+	}
+	return a / b
+}
+
+// CPUCounters is the aggregate cpu line of /proc/stat, in clock ticks.
+type CPUCounters struct {
+	User, Nice, System, Idle, Iowait, IRQ, SoftIRQ, Steal, Guest, GuestNice uint64
+}
+
+// ParseProcStat reads the aggregate CPU counters and the number of CPUs
+// from /proc/stat.
+func ParseProcStat(r io.Reader) (CPUCounters, int, error) {
+	var (
+		total CPUCounters
+		found bool
+		cpus  int
+	)
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "cpu") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if fields[0] != "cpu" {
+			cpus++
+			continue
+		}
+		counters := []*uint64{&total.User, &total.Nice, &total.System, &total.Idle, &total.Iowait, &total.IRQ, &total.SoftIRQ, &total.Steal, &total.Guest, &total.GuestNice}
+		for i, f := range fields[1:] {
+			if i >= len(counters) {
+				break
+			}
+			v, err := strconv.ParseUint(f, 10, 64)
+			if err != nil {
+				return CPUCounters{}, 0, &ParseError{Line: lineNo, Text: line, Reason: ErrNonNumeric, Detail: fmt.Sprintf("field %d is %q", i+1, f)}
+			}
+			*counters[i] = v
+		}
+		found = true
+	}
+	if err := scanner.Err(); err != nil {
+		return CPUCounters{}, 0, err
+	}
+	if !found {
+		return CPUCounters{}, 0, &ParseError{Reason: ErrMalformed, Detail: "no aggregate cpu line"}
+	}
+	return total, cpus, nil
+}
+
+// CPUStatsBetween derives the iostat avg-cpu percentages between two
+// readings. Like iostat, %system includes interrupt time; guest time is
+// already part of user time.
+func CPUStatsBetween(prev, cur CPUCounters) CPUStats {
+	d := func(p, c uint64) float64 { return float64(counterDelta(p, c)) }
+	user, nice := d(prev.User, cur.User), d(prev.Nice, cur.Nice)
+	system := d(prev.System, cur.System) + d(prev.IRQ, cur.IRQ) + d(prev.SoftIRQ, cur.SoftIRQ)
+	idle, iowait, steal := d(prev.Idle, cur.Idle), d(prev.Iowait, cur.Iowait), d(prev.Steal, cur.Steal)
+	total := user + nice + system + idle + iowait + steal
+	if total == 0 {
+		return CPUStats{}
+	}
+	pct := func(v float64) float64 { return v / total * 100 }
+	return CPUStats{User: pct(user), Nice: pct(nice), System: pct(system), Iowait: pct(iowait), Steal: pct(steal), Idle: pct(idle)}
+}
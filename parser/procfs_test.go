@@ -0,0 +1,100 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDiskstats(t *testing.T) {
+	input := `   8       0 sda 100 10 2000 50 200 20 4000 300 1 400 350 5 0 80 10 7 14
+   8       1 sda1 90 10 1800 45 190 20 3800 290 0 380 335
+ 259       0 nvme0n1 0 0 0 0 0 0 0 0 0 0 0 0 0 0 0
+`
+	disks, err := ParseDiskstats(strings.NewReader(input))
+	require.NoError(t, err)
+	require.Len(t, disks, 3)
+	assert.Equal(t, DiskCounters{
+		Major: 8, Minor: 0, Name: "sda",
+		ReadIOs: 100, ReadMerges: 10, ReadSectors: 2000, ReadTicks: 50,
+		WriteIOs: 200, WriteMerges: 20, WriteSectors: 4000, WriteTicks: 300,
+		InFlight: 1, IOTicks: 400, TimeInQueue: 350,
+		DiscardIOs: 5, DiscardSectors: 80, DiscardTicks: 10,
+		FlushIOs: 7, FlushTicks: 14,
+	}, disks[0])
+	// pre-4.18 kernels stop after the time in queue
+	assert.Equal(t, uint64(335), disks[1].TimeInQueue)
+	assert.True(t, disks[2].Idle())
+
+	_, err = ParseDiskstats(strings.NewReader("8 0 sda 1 2 x 4 5 6 7 8 9 10 11\n"))
+	var perr *ParseError
+	require.ErrorAs(t, err, &perr)
+	assert.Equal(t, 1, perr.Line)
+}
+
+// TestDeviceStatsBetween checks each derived column against iostat's formulas.
+func TestDeviceStatsBetween(t *testing.T) {
+	prev := DiskCounters{Name: "sda", ReadIOs: 100, ReadMerges: 10, ReadSectors: 2000, ReadTicks: 50, WriteIOs: 200, WriteSectors: 4000, WriteTicks: 300, IOTicks: 400, TimeInQueue: 350}
+	cur := prev
+	cur.ReadIOs += 40
+	cur.ReadMerges += 10
+	cur.ReadSectors += 640 // 320 kB
+	cur.ReadTicks += 80
+	cur.WriteIOs += 10
+	cur.WriteSectors += 200
+	cur.WriteTicks += 70
+	cur.IOTicks += 500
+	cur.TimeInQueue += 1500
+	cur.FlushIOs, cur.FlushTicks = 4, 2
+
+	ds := DeviceStatsBetween(prev, cur, 2*time.Second)
+	assert.Equal(t, "sda", ds.Name)
+	assert.InDelta(t, 20, ds.ReadsPerSec, 1e-9)
+	assert.InDelta(t, 160, ds.ReadKBPerSec, 1e-9)
+	assert.InDelta(t, 5, ds.ReadMergedPerSec, 1e-9)
+	assert.InDelta(t, 20, ds.ReadPctMerged, 1e-9)
+	assert.InDelta(t, 2, ds.ReadAwaitMs, 1e-9)
+	assert.InDelta(t, 8, ds.ReadReqSzKB, 1e-9)
+	assert.InDelta(t, 5, ds.WritesPerSec, 1e-9)
+	assert.InDelta(t, 7, ds.WriteAwaitMs, 1e-9)
+	assert.InDelta(t, 3, ds.AwaitMs, 1e-9)
+	assert.InDelta(t, 2, ds.FlushesPerSec, 1e-9)
+	assert.InDelta(t, 0.5, ds.FlushAwaitMs, 1e-9)
+	assert.InDelta(t, 0.75, ds.QueueSize, 1e-9)
+	assert.InDelta(t, 25, ds.UtilPct, 1e-9)
+
+	// no time passed, no rates
+	assert.Zero(t, DeviceStatsBetween(prev, cur, 0).ReadsPerSec)
+}
+
+func TestParseProcStat(t *testing.T) {
+	input := `cpu  100 10 50 800 20 5 5 10 0 0
+cpu0 50 5 25 400 10 2 3 5 0 0
+cpu1 50 5 25 400 10 3 2 5 0 0
+intr 12345
+`
+	total, cpus, err := ParseProcStat(strings.NewReader(input))
+	require.NoError(t, err)
+	assert.Equal(t, 2, cpus)
+	assert.Equal(t, CPUCounters{User: 100, Nice: 10, System: 50, Idle: 800, Iowait: 20, IRQ: 5, SoftIRQ: 5, Steal: 10}, total)
+
+	// a busy interval: 200 ticks, half of them idle
+	next := total
+	next.User += 60
+	next.System += 10
+	next.IRQ += 5
+	next.SoftIRQ += 5
+	next.Idle += 100
+	next.Iowait += 20
+	c := CPUStatsBetween(total, next)
+	assert.InDelta(t, 30, c.User, 1e-9)
+	assert.InDelta(t, 10, c.System, 1e-9)
+	assert.InDelta(t, 10, c.Iowait, 1e-9)
+	assert.InDelta(t, 50, c.Idle, 1e-9)
+
+	_, _, err = ParseProcStat(strings.NewReader("intr 1\n"))
+	assert.ErrorIs(t, err, ErrMalformed)
+}
@@ -0,0 +1,343 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sar prints one activity at a time for the whole file, each under its own
+// header, e.g. for `sar -d -p -u`:
+//
+//	Linux 5.15.0-1051-aws (ip-10-0-1-5) 	09/04/24 	_x86_64_	(16 CPU)
+//
+//	12:00:01        CPU     %user     %nice   %system   %iowait    %steal     %idle
+//	12:10:01        all      2.51      0.00      0.80      0.12      0.00     96.57
+//	Average:        all      2.51      0.00      0.80      0.12      0.00     96.57
+//
+//	12:00:01          DEV       tps     rkB/s     wkB/s     dkB/s   areq-sz    aqu-sz     await     %util
+//	12:10:01      nvme0n1      5.12      0.50     40.10      0.00      7.93      0.01      1.20      0.60
+//
+// so the CPU and disk rows of one interval are far apart in the input.
+
+// sarSection is the activity the rows under a sar header belong to.
+type sarSection int
+
+const (
+	sarNone  sarSection = iota // before the first header of a file
+	sarCPU                     // -u
+	sarDisk                    // -d
+	sarOther                   // any other activity, skipped
+)
+
+// ParseSarOutput parses the text report of `sar -u` and `sar -d`, such as
+// `sar -d -p -u -f /var/log/sa/saXX`, into the structure ParseIostatOutput
+// produces. CPU comes from the "all" rows of -u, also with -u ALL, the
+// per-CPU rows of -P ALL go to Cores, and devices come from -d. Other
+// activities and the Average lines are skipped.
+func ParseSarOutput(data []byte, opts ...Option) (ParsedData, error) {
+	c := NewCollector(0)
+	info, err := StreamSarOutput(bytes.NewReader(data), c.Add, opts...)
+	return c.Data(info), err
+}
+
+// StreamSarOutput is the streaming form of ParseSarOutput. As sar reports
+// each activity for the whole file before the next, the samples of one sar
+// file are held until the next banner or the end of input, then passed to
+// fn in time order.
+func StreamSarOutput(r io.Reader, fn func(Sample) error, opts ...Option) (StreamInfo, error) {
+	return streamSar(r, fn, newConfig(opts), false)
+}
+
+// streamSar reads sar-style text from r. ordered input, as mpstat prints it,
+// has every row of an interval together, so each sample is passed on as
+// soon as the next interval starts.
+func streamSar(r io.Reader, fn func(Sample) error, cfg config, ordered bool) (StreamInfo, error) {
+	timeline := NewTimeline()
+	p := &sarParser{
+		cfg:        cfg,
+		timestamps: newTimestampDetector(cfg),
+		emit:       withTimeline(timeline, fn),
+		ordered:    ordered,
+		index:      make(map[time.Time]int),
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+
+	info := func() StreamInfo {
+		i := StreamInfo{Host: p.host, Warnings: p.warnings}
+		i.setTimeline(timeline)
+		return i
+	}
+	for scanner.Scan() {
+		if err := p.line(scanner.Text()); err != nil {
+			return info(), err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return info(), err
+	}
+	err := p.finish()
+	return info(), err
+}
+
+// sarParser reads sar text a line at a time, assembling the rows of each
+// interval into one sample.
+type sarParser struct {
+	cfg        config
+	timestamps *timestampDetector
+	emit       func(Sample) error
+	ordered    bool
+
+	lineNo  int
+	host    HostInfo
+	section sarSection
+	header  []string // columns after the CPU or DEV column
+
+	// the current sar file, by time of first appearance
+	run      []Sample
+	index    map[time.Time]int
+	restarts []time.Time
+
+	samples  int
+	warnings []ParseError
+}
+
+func (p *sarParser) line(raw string) error {
+	p.lineNo++
+	line := strings.TrimSpace(raw)
+	if line == "" || strings.HasPrefix(line, "Average:") || strings.HasPrefix(line, "Summary") {
+		return nil
+	}
+	if host, ok := parseBanner(line, p.cfg.dateOrder); ok {
+		// a new sar file, as when several days are concatenated
+		if err := p.flush(); err != nil {
+			return err
+		}
+		p.host = host
+		p.section = sarNone
+		p.timestamps.setDate(host.Date)
+		return nil
+	}
+
+	clock, rest := splitSarClock(line)
+	if len(rest) == 0 {
+		return p.fail(ErrMalformed, line, "expected a sar row starting with a time")
+	}
+	if isSarHeader(rest) {
+		section := sarOther
+		switch {
+		case rest[0] == "CPU" && slices.Contains(rest, "%idle"):
+			// mpstat -A also prints interrupt tables under a CPU column
+			section = sarCPU
+		case rest[0] == "DEV":
+			section = sarDisk
+		}
+		// each activity goes through the file from its start again
+		if section != p.section && !p.ordered {
+			p.timestamps.setDate(p.host.Date)
+		}
+		p.section, p.header = section, rest[1:]
+	}
+	ts, ok := p.timestamps.parse(clock)
+	if !ok {
+		return p.fail(ErrBadTimestamp, line, fmt.Sprintf("%q is not a time", clock))
+	}
+	if len(rest) >= 2 && rest[0] == "LINUX" && rest[1] == "RESTART" {
+		p.restarts = append(p.restarts, ts)
+		return nil
+	}
+	if isSarHeader(rest) {
+		return nil
+	}
+	// mpstat prints each interval whole, so the previous one is complete
+	if p.ordered && len(p.run) > 0 && !p.run[len(p.run)-1].Timestamp.Equal(ts) {
+		if err := p.flush(); err != nil {
+			return err
+		}
+	}
+
+	switch p.section {
+	case sarCPU:
+		m, err := p.values(line, rest)
+		if err != nil || m == nil {
+			return err
+		}
+		// -P ALL adds a row per CPU after the "all" row
+		if rest[0] != "all" {
+			s := p.sample(ts)
+			s.Cores = append(s.Cores, coreStatsFromMap(ts, rest[0], m))
+			return nil
+		}
+		// -u ALL splits out interrupts, which %system includes
+		if _, ok := m["usr"]; ok {
+			m["user"] = m["usr"]
+			m["system"] = m["sys"] + m["irq"] + m["soft"]
+		}
+		cpu := cpuStatsFromMap(ts, m)
+		p.sample(ts).CPU = &cpu
+	case sarDisk:
+		m, err := p.values(line, rest)
+		if err != nil || m == nil {
+			return err
+		}
+		s := p.sample(ts)
+		s.Devices = append(s.Devices, deviceStatsFromMap(ts, rest[0], m))
+	}
+	return nil
+}
+
+// splitSarClock splits a sar row into its time, with any AM/PM, and the
+// remaining fields.
+func splitSarClock(line string) (string, []string) {
+	fields := strings.Fields(line)
+	if len(fields) >= 2 && (fields[1] == "AM" || fields[1] == "PM") {
+		return fields[0] + " " + fields[1], fields[2:]
+	}
+	if len(fields) == 0 {
+		return "", nil
+	}
+	return fields[0], fields[1:]
+}
+
+// isSarHeader reports whether the fields after the time are column names
+// rather than values: rows always end in a number.
+func isSarHeader(rest []string) bool {
+	if len(rest) < 2 {
+		return false
+	}
+	_, err := strconv.ParseFloat(rest[len(rest)-1], 64)
+	return err != nil && !(rest[0] == "LINUX" && rest[1] == "RESTART")
+}
+
+// values maps each header column, with any leading "%" removed, to the
+// value in that position of row, whose first field is the CPU or device. A
+// nil map with a nil error means the row was skipped.
+func (p *sarParser) values(line string, row []string) (map[string]float64, error) {
+	if len(row)-1 != len(p.header) {
+		return nil, p.fail(ErrTruncated, line, fmt.Sprintf("%d values for %d columns", len(row)-1, len(p.header)))
+	}
+	m := make(map[string]float64, len(p.header))
+	for i, h := range p.header {
+		v, err := strconv.ParseFloat(row[i+1], 64)
+		if err != nil {
+			return nil, p.fail(ErrNonNumeric, line, fmt.Sprintf("column %s has %q", h, row[i+1]))
+		}
+		m[strings.TrimPrefix(h, "%")] = v
+	}
+	return m, nil
+}
+
+// coreStatsFromMap builds a CoreStats from the values of a per-CPU row of
+// sar -P ALL or mpstat, keyed by column name with any leading "%" removed.
+func coreStatsFromMap(ts time.Time, cpu string, m map[string]float64) CoreStats {
+	c := CoreStats{
+		Timestamp: ts,
+		CPU:       cpu,
+		User:      m["user"],
+		Nice:      m["nice"],
+		System:    m["system"],
+		Iowait:    m["iowait"],
+		IRQ:       m["irq"],
+		SoftIRQ:   m["soft"],
+		Steal:     m["steal"],
+		Idle:      m["idle"],
+	}
+	// -u ALL and mpstat name them usr and sys, and list interrupts apart
+	if _, ok := m["usr"]; ok {
+		c.User, c.System = m["usr"], m["sys"]
+	}
+	return c
+}
+
+// sample returns the sample of the current file taken at ts.
+func (p *sarParser) sample(ts time.Time) *Sample {
+	i, ok := p.index[ts]
+	if !ok {
+		i = len(p.run)
+		p.index[ts] = i
+		p.run = append(p.run, Sample{Timestamp: ts})
+	}
+	return &p.run[i]
+}
+
+// fail skips a row. In lenient mode the problem is recorded as a warning
+// and parsing continues with the next row.
+func (p *sarParser) fail(reason error, text, detail string) error {
+	perr := ParseError{Line: p.lineNo, Text: text, Reason: reason, Detail: detail}
+	if p.cfg.lenient {
+		p.warnings = append(p.warnings, perr)
+		return nil
+	}
+	return &perr
+}
+
+// flush emits the samples of the current file, marking the first one after
+// each LINUX RESTART as the start of a new run.
+func (p *sarParser) flush() error {
+	run, restarts := p.run, p.restarts
+	p.run, p.restarts = nil, nil
+	p.index = make(map[time.Time]int)
+	for _, r := range restarts {
+		for i := range run {
+			if run[i].Timestamp.After(r) {
+				run[i].RunStart = true
+				break
+			}
+		}
+	}
+	for _, s := range run {
+		p.samples++
+		if err := p.emit(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finish emits the last file and reports input that yielded no samples.
+func (p *sarParser) finish() error {
+	if err := p.flush(); err != nil {
+		return err
+	}
+	if p.samples > 0 {
+		return nil
+	}
+	if len(p.warnings) > 0 {
+		return &ParseError{
+			Reason: ErrNoSamples,
+			Detail: fmt.Sprintf("all %d rows were skipped", len(p.warnings)),
+		}
+	}
+	if p.ordered {
+		return &ParseError{
+			Reason: ErrNoSamples,
+			Detail: fmt.Sprintf("no CPU utilisation in %d lines (capture with mpstat -P ALL)", p.lineNo),
+		}
+	}
+	return &ParseError{
+		Reason: ErrNoSamples,
+		Detail: fmt.Sprintf("no CPU or disk activity in %d lines (capture with sar -u -d)", p.lineNo),
+	}
+}
+
+// sniffSar reports whether text contains a sar -u or -d header.
+func sniffSar(text []byte) bool {
+	for _, line := range strings.Split(string(text), "\n") {
+		clock, rest := splitSarClock(line)
+		if !isSarHeader(rest) || (rest[0] != "CPU" && rest[0] != "DEV") {
+			continue
+		}
+		for _, layout := range clockLayouts {
+			if _, err := time.Parse(layout, clock); err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSarOutput(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "sar-12.5.4.txt"))
+	require.NoError(t, err)
+	assert.True(t, Sniff(data))
+
+	p, err := Parse(data)
+	require.NoError(t, err)
+	assert.Equal(t, "ip-10-0-1-5", p.Host.Hostname)
+	assert.Equal(t, 16, p.Host.CPUCount)
+
+	// the CPU and disk rows of each interval end up in one sample
+	require.Len(t, p.CPUs, 3)
+	assert.Equal(t, time.Date(2024, 9, 4, 12, 10, 1, 0, time.UTC), p.CPUs[0].Timestamp)
+	assert.InDelta(t, 4.50, p.CPUs[1].Iowait, 1e-9)
+	assert.InDelta(t, 98.40, p.CPUs[2].Idle, 1e-9)
+	assert.False(t, p.CPUs[0].SinceBoot)
+
+	require.Len(t, p.Devices, 2)
+	nvme := p.Devices["nvme1n1"]
+	require.Len(t, nvme, 3)
+	assert.Equal(t, p.CPUs[0].Timestamp, nvme[0].Timestamp)
+	assert.InDelta(t, 120, nvme[0].TransfersPerSec, 1e-9)
+	assert.InDelta(t, 4800, nvme[0].ReadKBPerSec, 1e-9)
+	assert.InDelta(t, 960, nvme[0].WriteKBPerSec, 1e-9)
+	assert.InDelta(t, 48, nvme[0].ReqSzKB, 1e-9)
+	assert.InDelta(t, 2.4, nvme[0].QueueSize, 1e-9)
+	assert.InDelta(t, 20, nvme[0].AwaitMs, 1e-9)
+	assert.InDelta(t, 85, nvme[0].UtilPct, 1e-9)
+	// sar does not split requests by direction
+	assert.Zero(t, nvme[0].ReadsPerSec)
+
+	// LINUX RESTART starts a new run
+	require.Len(t, p.Segments, 2)
+	assert.Equal(t, BreakRestart, p.Segments[1].Break)
+	assert.Equal(t, p.CPUs[2].Timestamp, p.Segments[1].Start)
+}
+
+// TestParseSarOutputVariants covers 12-hour clocks, -u ALL with -P ALL, the
+// sector columns of older releases and two days' files concatenated.
+func TestParseSarOutputVariants(t *testing.T) {
+	input := strings.Join([]string{
+		"Linux 3.10.0-1160.el7.x86_64 (db1) 	09/04/2024 	_x86_64_	(2 CPU)",
+		"",
+		"11:50:01 PM     CPU      %usr     %nice      %sys   %iowait    %steal      %irq     %soft    %guest    %gnice     %idle",
+		"11:55:01 PM     all     10.00      0.00      4.00      1.00      0.00      0.50      0.50      0.00      0.00     84.00",
+		"11:55:01 PM       0     12.00      0.00      5.00      1.00      0.00      1.00      1.00      0.00      0.00     80.00",
+		"11:55:01 PM       1      8.00      0.00      3.00      1.00      0.00      0.00      0.00      0.00      0.00     88.00",
+		"",
+		"11:50:01 PM       DEV       tps  rd_sec/s  wr_sec/s  avgrq-sz  avgqu-sz     await     svctm     %util",
+		"11:55:01 PM       sda     10.00    200.00   1800.00    200.00      0.20     20.00      2.00      2.00",
+		"",
+		"Linux 3.10.0-1160.el7.x86_64 (db1) 	09/05/2024 	_x86_64_	(2 CPU)",
+		"",
+		"12:00:01 AM     CPU      %usr     %nice      %sys   %iowait    %steal      %irq     %soft    %guest    %gnice     %idle",
+		"12:05:01 AM     all     20.00      0.00      8.00      2.00      0.00      1.00      1.00      0.00      0.00     68.00",
+		"",
+		"12:00:01 AM       DEV       tps  rd_sec/s  wr_sec/s  avgrq-sz  avgqu-sz     await     svctm     %util",
+		"12:05:01 AM       sda     20.00    400.00   3600.00    200.00      0.40     20.00      2.00      4.00",
+		"",
+	}, "\n")
+	p, err := ParseSarOutput([]byte(input))
+	require.NoError(t, err)
+
+	require.Len(t, p.CPUs, 2)
+	assert.Equal(t, time.Date(2024, 9, 4, 23, 55, 1, 0, time.UTC), p.CPUs[0].Timestamp)
+	assert.Equal(t, time.Date(2024, 9, 5, 0, 5, 1, 0, time.UTC), p.CPUs[1].Timestamp)
+	assert.InDelta(t, 10, p.CPUs[0].User, 1e-9)
+	// %system includes interrupts, as in -u without ALL
+	assert.InDelta(t, 5, p.CPUs[0].System, 1e-9)
+
+	// the -P ALL rows of each CPU
+	require.Len(t, p.Cores, 2)
+	require.Len(t, p.Cores["0"], 1)
+	assert.Equal(t, p.CPUs[0].Timestamp, p.Cores["0"][0].Timestamp)
+	assert.InDelta(t, 12, p.Cores["0"][0].User, 1e-9)
+	assert.InDelta(t, 5, p.Cores["0"][0].System, 1e-9)
+	assert.InDelta(t, 1, p.Cores["0"][0].IRQ, 1e-9)
+	assert.InDelta(t, 19, p.Cores["0"][0].BusyPct(), 1e-9)
+
+	sda := p.Devices["sda"]
+	require.Len(t, sda, 2)
+	assert.InDelta(t, 100, sda[0].ReadKBPerSec, 1e-9)
+	assert.InDelta(t, 900, sda[0].WriteKBPerSec, 1e-9)
+	assert.InDelta(t, 100, sda[0].ReqSzKB, 1e-9)
+	assert.InDelta(t, 0.2, sda[0].QueueSize, 1e-9)
+	assert.InDelta(t, 2, sda[0].SvcTimeMs, 1e-9)
+	assert.Equal(t, p.CPUs[1].Timestamp, sda[1].Timestamp)
+	assert.Len(t, p.Segments, 1)
+}
+
+func TestParseSarOutputErrors(t *testing.T) {
+	banner := "Linux 5.15.0 (host) \t09/04/24 \t_x86_64_\t(16 CPU)\n\n"
+
+	// only activities the report does not chart
+	_, err := ParseSarOutput([]byte(banner + "12:00:01 kbmemfree kbavail %memused\n12:10:01 100 200 50.00\n"))
+	assert.ErrorIs(t, err, ErrNoSamples)
+
+	bad := banner +
+		"12:00:01 CPU %user %nice %system %iowait %steal %idle\n" +
+		"12:10:01 all 1.00 0.00 1.00 x 0.00 98.00\n" +
+		"12:20:01 all 1.00 0.00 1.00 0.00 0.00 98.00\n"
+	_, err = ParseSarOutput([]byte(bad))
+	var perr *ParseError
+	require.ErrorAs(t, err, &perr)
+	assert.ErrorIs(t, err, ErrNonNumeric)
+	assert.Equal(t, 4, perr.Line)
+
+	p, err := ParseSarOutput([]byte(bad), WithLenient())
+	require.NoError(t, err)
+	assert.Len(t, p.Warnings, 1)
+	assert.Len(t, p.CPUs, 1)
+}
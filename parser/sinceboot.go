@@ -0,0 +1,60 @@
+package parser
+
+import "time"
+
+// clockSlack allows for the second resolution of report timestamps.
+const clockSlack = 2 * time.Second
+
+// judgeSinceBoot decides whether the first report of an iostat run, taken at
+// first, averages since boot. iostat prints that report as it starts, on the
+// day of its banner, and with -y leaves it out, so the first report comes an
+// interval after the start. The output never says which, but two cases tell:
+//
+//   - a first report well into a later day than the banner came after
+//     iostat had been waiting, so it ran with -y;
+//   - a first report less than an interval, the step to the next report,
+//     after the banner day's midnight was printed straight away, as with -y
+//     iostat would have started the day before.
+//
+// Otherwise the report is taken as since-boot, but not sure.
+func judgeSinceBoot(banner, first, next time.Time) (sinceBoot, sure bool) {
+	if banner.IsZero() {
+		return true, false
+	}
+	midnight := time.Date(banner.Year(), banner.Month(), banner.Day(), 0, 0, 0, 0, first.Location())
+	elapsed := first.Sub(midnight)
+	interval := next.Sub(first)
+	switch {
+	case elapsed >= 24*time.Hour+clockSlack:
+		return false, true
+	case !next.IsZero() && elapsed >= 0 && elapsed+clockSlack < interval:
+		return true, true
+	}
+	return true, false
+}
+
+// firstReport holds back the first report of an iostat run until the next
+// report shows the interval, see judgeSinceBoot.
+type firstReport struct {
+	pending *Sample
+	banner  time.Time
+}
+
+// hold keeps s, the first report after a banner dated banner.
+func (f *firstReport) hold(s Sample, banner time.Time) {
+	f.pending, f.banner = &s, banner
+}
+
+// release judges the held report against next, the time of the report after
+// it or zero when there is none, and passes it to emit.
+func (f *firstReport) release(next time.Time, emit func(Sample) error) error {
+	if f.pending == nil {
+		return nil
+	}
+	s := *f.pending
+	f.pending = nil
+	if sinceBoot, sure := judgeSinceBoot(f.banner, s.Timestamp, next); sinceBoot {
+		s.markSinceBoot(!sure)
+	}
+	return emit(s)
+}
@@ -0,0 +1,58 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJudgeSinceBoot(t *testing.T) {
+	banner := time.Date(2024, 9, 4, 0, 0, 0, 0, time.UTC)
+	at := func(day, h, m, s int) time.Time { return time.Date(2024, 9, day, h, m, s, 0, time.UTC) }
+	tests := []struct {
+		name            string
+		banner          time.Time
+		first, next     time.Time
+		sinceBoot, sure bool
+	}{
+		{"undecidable", banner, at(4, 12, 0, 0), at(4, 12, 0, 10), true, false},
+		{"no banner date", time.Time{}, at(5, 12, 0, 0), at(5, 12, 0, 10), true, false},
+		{"a later day than the banner", banner, at(5, 0, 10, 0), at(5, 0, 10, 10), false, true},
+		{"a later day without a next report", banner, at(5, 0, 10, 0), time.Time{}, false, true},
+		{"midnight itself may be straight away", banner, at(5, 0, 0, 0), at(5, 0, 0, 10), true, false},
+		{"within an interval of the banner's midnight", banner, at(4, 0, 0, 3), at(4, 0, 0, 13), true, true},
+		{"an interval after midnight", banner, at(4, 0, 0, 10), at(4, 0, 0, 20), true, false},
+		{"a single report after midnight", banner, at(4, 0, 0, 3), time.Time{}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sinceBoot, sure := judgeSinceBoot(tt.banner, tt.first, tt.next)
+			assert.Equal(t, tt.sinceBoot, sinceBoot)
+			assert.Equal(t, tt.sure, sure)
+		})
+	}
+}
+
+// TestParseIostatOutputSinceBootUnsure marks the first report unsure only
+// when its time cannot tell.
+func TestParseIostatOutputSinceBootUnsure(t *testing.T) {
+	banner := "Linux 5.15.0 (host) \t09/04/24 \t_x86_64_\t(16 CPU)\n\n"
+	block := func(ts string) string {
+		return ts + "\navg-cpu:  %user %idle\n 1.00 99.00\n\nDevice r/s\nsda 1.00\n\n"
+	}
+	for _, max := range []int{0, 10} {
+		c := NewCollector(max)
+		info, err := StreamIostatOutput(strings.NewReader(banner+block("09/04/24 12:00:00")+block("09/04/24 12:00:01")+
+			banner+block("09/04/24 00:00:03")+block("09/04/24 00:00:13")), c.Add)
+		assert.NoError(t, err)
+		p := c.Data(info)
+		if assert.Len(t, p.CPUs, 4) {
+			assert.True(t, p.CPUs[0].SinceBootUnsure)
+			assert.True(t, p.Devices["sda"][0].SinceBootUnsure)
+			assert.True(t, p.CPUs[2].SinceBoot)
+			assert.False(t, p.CPUs[2].SinceBootUnsure)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"time"
+)
+
+// Sample is one timestamped report: the CPU averages, when captured, one
+// entry per device, and whatever else the source reports.
+type Sample struct {
+	Timestamp time.Time
+	CPU       *CPUStats
+	Devices   []DeviceStats
+	// VM and Cores come from vmstat, and mpstat or sar -P ALL.
+	VM    *VMStats
+	Cores []CoreStats
+	// SinceBoot marks the first report of an iostat run, which averages
+	// everything since boot rather than the last interval.
+	SinceBoot bool
+	// SinceBootUnsure marks a SinceBoot report that may be a regular one,
+	// as nothing in the capture told whether iostat ran with -y.
+	SinceBootUnsure bool
+	// RunStart marks the first report after an iostat banner.
+	RunStart bool
+}
+
+// markSinceBoot flags s and its stats as the since-boot report, unsure when
+// it may be a regular one.
+func (s *Sample) markSinceBoot(unsure bool) {
+	s.SinceBoot, s.SinceBootUnsure = true, unsure
+	if s.CPU != nil {
+		s.CPU.SinceBoot, s.CPU.SinceBootUnsure = true, unsure
+	}
+	for i := range s.Devices {
+		s.Devices[i].SinceBoot, s.Devices[i].SinceBootUnsure = true, unsure
+	}
+	if s.VM != nil {
+		s.VM.SinceBoot = true
+	}
+}
+
+// StreamInfo describes the capture as a whole once a stream has been read.
+type StreamInfo struct {
+	Host     HostInfo
+	Warnings []ParseError
+	// Interval is the median step between samples.
+	Interval time.Duration
+	// Segments splits the capture at gaps, overlaps, clock jumps and restarts.
+	Segments []Segment
+}
+
+// withTimeline returns a callback that records each sample in t before
+// passing it on to fn.
+func withTimeline(t *Timeline, fn func(Sample) error) func(Sample) error {
+	return func(s Sample) error {
+		t.Add(s)
+		return fn(s)
+	}
+}
+
+// setTimeline copies what t learned into info.
+func (info *StreamInfo) setTimeline(t *Timeline) {
+	info.Interval = t.Interval()
+	info.Segments = t.Segments()
+}
+
+// Stream reads iostat text or JSON output, sar text output (see
+// StreamSarOutput), a /proc/diskstats snapshot series (see
+// StreamDiskstatsSeries), vmstat -t or mpstat -P ALL output, or any other
+// registered Format from r and calls fn for every sample in input order, so
+// memory use does not grow with the size of the capture. The format scoring
+// highest on the start of the input is used unless WithFormat names one.
+// Compressed input is decompressed on the fly, see Decompress. An error
+// returned by fn stops the stream and is returned as is.
+func Stream(r io.Reader, fn func(Sample) error, opts ...Option) (StreamInfo, error) {
+	rc, _, err := Decompress(r)
+	if err != nil {
+		return StreamInfo{}, err
+	}
+	defer func() { _ = rc.Close() }()
+	br := bufio.NewReaderSize(rc, SniffLen)
+
+	cfg := newConfig(opts)
+	var f Format
+	if cfg.format != "" {
+		var ok bool
+		if f, ok = LookupFormat(cfg.format); !ok {
+			return StreamInfo{}, unknownFormat(cfg.format)
+		}
+	} else {
+		// iostat text always scores a little, so that its parser explains
+		// what is missing from unrecognised input
+		prefix, _ := br.Peek(SniffLen)
+		_, f, _ = DetectFormat(prefix)
+	}
+	return streamFormat(f, br, fn, opts)
+}
+
+// ParseReader reads all of r, detecting the input format as Stream does, into
+// ParsedData.
+func ParseReader(r io.Reader, opts ...Option) (ParsedData, error) {
+	c := NewCollector(0)
+	info, err := Stream(r, c.Add, opts...)
+	return c.Data(info), err
+}
+
+// SniffLen is how much of the start of an input Sniff needs.
+const SniffLen = 64 << 10
+
+// Sniff reports whether prefix, the start of an input that may be compressed,
+// looks like a capture of any registered Format, such as iostat text or JSON
+// output, sar -u or -d text output, a /proc/diskstats snapshot series, or
+// vmstat or mpstat output.
+func Sniff(prefix []byte) bool {
+	_, _, score := DetectFormat(decompressPrefix(prefix))
+	return score >= MatchScore
+}
+
+// decompressPrefix decompresses as much of a possibly truncated compressed
+// prefix as it can, and returns plain input unchanged.
+func decompressPrefix(prefix []byte) []byte {
+	rc, kind, err := Decompress(bytes.NewReader(prefix))
+	if err != nil || kind == "" {
+		return prefix
+	}
+	defer func() { _ = rc.Close() }()
+	// the prefix usually cuts the stream short, keep what was decoded
+	text, _ := io.ReadAll(io.LimitReader(rc, 4*SniffLen))
+	return text
+}
@@ -0,0 +1,92 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// syntheticCapture returns n one-second iostat text blocks for two devices.
+func syntheticCapture(n int) string {
+	var b strings.Builder
+	b.WriteString("Linux 5.15.0 (host) \t09/04/24 \t_x86_64_\t(16 CPU)\n\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "09/04/24 12:%02d:%02d\n", i/60, i%60)
+		b.WriteString("avg-cpu:  %user   %nice %system %iowait  %steal   %idle\n")
+		fmt.Fprintf(&b, "           %d.00    0.00    0.00    0.00    0.00   %d.00\n\n", i, 100-i%100)
+		b.WriteString("Device            r/s     w/s  aqu-sz\n")
+		fmt.Fprintf(&b, "sda              %d.00    1.00    0.50\n", i)
+		fmt.Fprintf(&b, "sdb              1.00    %d.00    0.25\n\n", i)
+	}
+	return b.String()
+}
+
+// TestStreamCallsBackInOrder streams text and JSON and sees every sample once.
+func TestStreamCallsBackInOrder(t *testing.T) {
+	for name, input := range map[string]string{"text": syntheticCapture(5), "json": jsonSample} {
+		t.Run(name, func(t *testing.T) {
+			var got []Sample
+			_, err := Stream(strings.NewReader(input), func(s Sample) error {
+				got = append(got, s)
+				return nil
+			})
+			assert.NoError(t, err)
+			assert.NotEmpty(t, got)
+			for i := 1; i < len(got); i++ {
+				assert.True(t, got[i].Timestamp.After(got[i-1].Timestamp))
+			}
+			assert.NotNil(t, got[0].CPU)
+			assert.NotEmpty(t, got[len(got)-1].Devices)
+		})
+	}
+}
+
+// TestStreamStopsOnCallbackError returns the callback's error unchanged.
+func TestStreamStopsOnCallbackError(t *testing.T) {
+	stop := errors.New("stop")
+	for name, input := range map[string]string{"text": syntheticCapture(5), "json": jsonSample} {
+		t.Run(name, func(t *testing.T) {
+			calls := 0
+			_, err := Stream(strings.NewReader(input), func(Sample) error {
+				calls++
+				return stop
+			})
+			assert.Equal(t, stop, err)
+			assert.Equal(t, 1, calls)
+		})
+	}
+}
+
+// TestStreamIostatJSONHost returns host info alongside the samples.
+func TestStreamIostatJSONHost(t *testing.T) {
+	n := 0
+	info, err := StreamIostatJSON(strings.NewReader(jsonSample), func(Sample) error {
+		n++
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "ip-10-0-1-5", info.Host.Hostname)
+	assert.Equal(t, 16, info.Host.CPUCount)
+}
+
+// TestStreamIostatJSONTruncated reports a cut-off document as truncated.
+func TestStreamIostatJSONTruncated(t *testing.T) {
+	cut := jsonSample[:len(jsonSample)/2]
+	_, err := StreamIostatJSON(strings.NewReader(cut), func(Sample) error { return nil })
+	assert.ErrorIs(t, err, ErrTruncated)
+}
+
+// TestParseReaderMatchesParse checks the reader and byte-slice entry points agree.
+func TestParseReaderMatchesParse(t *testing.T) {
+	input := syntheticCapture(3)
+	fromReader, err := ParseReader(strings.NewReader(input))
+	assert.NoError(t, err)
+	fromBytes, err := Parse([]byte(input))
+	assert.NoError(t, err)
+	assert.Equal(t, fromBytes, fromReader)
+	assert.Len(t, fromReader.CPUs, 3)
+}
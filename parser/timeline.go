@@ -0,0 +1,265 @@
+package parser
+
+import (
+	"sort"
+	"time"
+)
+
+// BreakKind says why a new segment starts.
+type BreakKind int
+
+const (
+	// BreakNone marks the first segment.
+	BreakNone BreakKind = iota
+	// BreakGap is a pause longer than twice the sampling interval.
+	BreakGap
+	// BreakOverlap is a restarted run whose samples go back in time,
+	// typically from concatenated captures.
+	BreakOverlap
+	// BreakClockJump is time going backwards within one run.
+	BreakClockJump
+	// BreakRestart is a new iostat banner in the middle of the input.
+	BreakRestart
+)
+
+func (k BreakKind) String() string {
+	switch k {
+	case BreakGap:
+		return "gap"
+	case BreakOverlap:
+		return "overlap"
+	case BreakClockJump:
+		return "clock jump"
+	case BreakRestart:
+		return "restart"
+	}
+	return "start"
+}
+
+// Segment is a stretch of samples taken at a steady interval.
+type Segment struct {
+	Start    time.Time
+	End      time.Time
+	Samples  int
+	Interval time.Duration
+	// Break says why the segment starts, and Gap is the time since the last
+	// sample of the previous segment, negative when time went backwards.
+	Break BreakKind
+	Gap   time.Duration
+}
+
+// Timeline infers the sampling interval of a sample stream and splits it into
+// segments at gaps, overlaps, backwards clock jumps and restarts. Memory grows
+// with the number of irregularities, not with the number of samples.
+type Timeline struct {
+	// deltas counts every step between consecutive samples of a run
+	deltas map[time.Duration]int
+	runs   []timelineRun
+	last   time.Time
+	n      int
+}
+
+// timelineRun is the part of the stream produced by one iostat run.
+type timelineRun struct {
+	first    int // index of the first sample
+	start    time.Time
+	deltas   map[time.Duration]int
+	minDelta time.Duration
+	// candidates are the steps back in time and those longer than twice
+	// minDelta when taken, which may be gaps
+	candidates []timelineBreak
+	// spans hold the other steps, one span per stretch between candidates
+	// and drops of minDelta, as the interval may yet turn out shorter
+	spans    []timelineSpan
+	spanOpen bool
+	// restart is set when the run starts with a banner mid-stream
+	restart bool
+	gap     time.Duration
+}
+
+// timelineBreak is a step that may end a segment, confirmed once the
+// interval of its run is known.
+type timelineBreak struct {
+	index int // of the sample after the step
+	prev  time.Time
+	at    time.Time
+}
+
+// timelineSpan is a stretch of consecutive steps none of which was more
+// than twice the smallest step when taken.
+type timelineSpan struct {
+	index    int // of the sample after the first step
+	prev     time.Time
+	steps    int
+	min, max time.Duration
+	// longest is the longest step, the only one a gap is looked for in when
+	// the steps differ
+	longest timelineBreak
+}
+
+// NewTimeline returns an empty Timeline.
+func NewTimeline() *Timeline {
+	return &Timeline{deltas: make(map[time.Duration]int)}
+}
+
+// Add records the next sample in input order.
+func (t *Timeline) Add(s Sample) {
+	ts := s.Timestamp
+	defer func() {
+		t.last = ts
+		t.n++
+	}()
+	if t.n == 0 || s.RunStart {
+		run := timelineRun{first: t.n, start: ts, deltas: make(map[time.Duration]int)}
+		if t.n > 0 {
+			run.restart = true
+			run.gap = ts.Sub(t.last)
+		}
+		t.runs = append(t.runs, run)
+		return
+	}
+
+	run := &t.runs[len(t.runs)-1]
+	d := ts.Sub(t.last)
+	if d > 0 {
+		run.deltas[d]++
+		t.deltas[d]++
+	}
+	// the interval is at least the smallest step, so only a longer step than
+	// twice that can be a gap; a step taken before the smallest one turned
+	// up waits in a span to be judged against the run's interval in Segments
+	step := timelineBreak{index: t.n, prev: t.last, at: ts}
+	switch {
+	case d < 0 || run.minDelta == 0 && d > 0:
+		run.candidates = append(run.candidates, step)
+		run.spanOpen = false
+		if d > 0 {
+			run.minDelta = d
+		}
+		return
+	case d > 2*run.minDelta:
+		run.candidates = append(run.candidates, step)
+		run.spanOpen = false
+		return
+	case d > 0 && d < run.minDelta:
+		run.minDelta = d
+		run.spanOpen = false
+	}
+	run.addToSpan(step, d)
+}
+
+// addToSpan records a step of d into the sample at step.index.
+func (r *timelineRun) addToSpan(step timelineBreak, d time.Duration) {
+	if n := len(r.spans); r.spanOpen && n > 0 {
+		span := &r.spans[n-1]
+		span.steps++
+		span.min, span.max = min(span.min, d), max(span.max, d)
+		if d > span.longest.at.Sub(span.longest.prev) {
+			span.longest = step
+		}
+		return
+	}
+	r.spans = append(r.spans, timelineSpan{index: step.index, prev: step.prev, steps: 1, min: d, max: d, longest: step})
+	r.spanOpen = true
+}
+
+// spanBreaks returns the steps of the spans that may be gaps at interval:
+// each step of a span of equal steps longer than twice interval, or the
+// longest step of a span of unequal ones.
+func (r *timelineRun) spanBreaks(interval time.Duration) []timelineBreak {
+	var out []timelineBreak
+	for _, span := range r.spans {
+		if interval == 0 || span.max <= 2*interval {
+			continue
+		}
+		if span.min != span.max {
+			out = append(out, span.longest)
+			continue
+		}
+		for i := 0; i < span.steps; i++ {
+			prev := span.prev.Add(time.Duration(i) * span.max)
+			out = append(out, timelineBreak{index: span.index + i, prev: prev, at: prev.Add(span.max)})
+		}
+	}
+	return out
+}
+
+// Interval returns the median step between samples across the whole stream.
+func (t *Timeline) Interval() time.Duration {
+	return medianDelta(t.deltas)
+}
+
+// Segments splits the samples seen so far into segments.
+func (t *Timeline) Segments() []Segment {
+	var segs []Segment
+	for i, run := range t.runs {
+		interval := medianDelta(run.deltas)
+		first := Segment{Start: run.start, Interval: interval}
+		if run.restart {
+			first.Break, first.Gap = BreakRestart, run.gap
+			if run.gap < 0 {
+				first.Break = BreakOverlap
+			}
+		}
+		next := t.n
+		if i+1 < len(t.runs) {
+			next = t.runs[i+1].first
+		}
+
+		// steps kept in spans interleave with the candidates
+		candidates := append(run.spanBreaks(interval), run.candidates...)
+		sort.Slice(candidates, func(a, b int) bool { return candidates[a].index < candidates[b].index })
+
+		cur := first
+		curIndex := run.first
+		for _, c := range candidates {
+			gap := c.at.Sub(c.prev)
+			kind := BreakClockJump
+			if gap > 0 {
+				if interval == 0 || gap <= 2*interval {
+					continue
+				}
+				kind = BreakGap
+			}
+			cur.End, cur.Samples = c.prev, c.index-curIndex
+			segs = append(segs, cur)
+			cur = Segment{Start: c.at, Interval: interval, Break: kind, Gap: gap}
+			curIndex = c.index
+		}
+		cur.Samples = next - curIndex
+		cur.End = t.lastOf(i)
+		segs = append(segs, cur)
+	}
+	return segs
+}
+
+// lastOf returns the timestamp of the last sample of run i.
+func (t *Timeline) lastOf(i int) time.Time {
+	if i+1 < len(t.runs) {
+		// the step into the next run was recorded as its gap
+		return t.runs[i+1].start.Add(-t.runs[i+1].gap)
+	}
+	return t.last
+}
+
+// medianDelta returns the median of a histogram of steps.
+func medianDelta(hist map[time.Duration]int) time.Duration {
+	total := 0
+	keys := make([]time.Duration, 0, len(hist))
+	for d, n := range hist {
+		keys = append(keys, d)
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	seen := 0
+	for _, d := range keys {
+		seen += hist[d]
+		if 2*seen >= total {
+			return d
+		}
+	}
+	return keys[len(keys)-1]
+}
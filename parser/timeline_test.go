@@ -0,0 +1,172 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// timelineOf feeds offsets, in seconds from noon, to a Timeline, marking the
+// samples at runStarts as following a banner.
+func timelineOf(offsets []int, runStarts ...int) *Timeline {
+	base := time.Date(2024, 9, 4, 12, 0, 0, 0, time.UTC)
+	starts := map[int]bool{}
+	for _, i := range runStarts {
+		starts[i] = true
+	}
+	tl := NewTimeline()
+	for i, off := range offsets {
+		tl.Add(Sample{Timestamp: base.Add(time.Duration(off) * time.Second), RunStart: starts[i]})
+	}
+	return tl
+}
+
+func TestTimelineSegments(t *testing.T) {
+	tests := []struct {
+		name      string
+		offsets   []int
+		runStarts []int
+		interval  time.Duration
+		breaks    []BreakKind
+		samples   []int
+		gaps      []time.Duration
+	}{
+		{
+			name:     "steady",
+			offsets:  []int{0, 1, 2, 3, 4},
+			interval: time.Second,
+			breaks:   []BreakKind{BreakNone},
+			samples:  []int{5},
+			gaps:     []time.Duration{0},
+		},
+		{
+			name:     "second resolution jitter is not a gap",
+			offsets:  []int{0, 1, 1, 3, 4, 5, 7, 8},
+			interval: time.Second,
+			breaks:   []BreakKind{BreakNone},
+			samples:  []int{8},
+			gaps:     []time.Duration{0},
+		},
+		{
+			name:     "collector paused",
+			offsets:  []int{0, 5, 10, 15, 300, 305},
+			interval: 5 * time.Second,
+			breaks:   []BreakKind{BreakNone, BreakGap},
+			samples:  []int{4, 2},
+			gaps:     []time.Duration{0, 285 * time.Second},
+		},
+		{
+			name:     "gap before the first regular step",
+			offsets:  []int{0, 30, 40, 50, 60},
+			interval: 10 * time.Second,
+			breaks:   []BreakKind{BreakNone, BreakGap},
+			samples:  []int{1, 4},
+			gaps:     []time.Duration{0, 30 * time.Second},
+		},
+		{
+			name:     "gaps at the early minimum step",
+			offsets:  []int{0, 30, 60, 70, 80, 90, 100},
+			interval: 10 * time.Second,
+			breaks:   []BreakKind{BreakNone, BreakGap, BreakGap},
+			samples:  []int{1, 1, 5},
+			gaps:     []time.Duration{0, 30 * time.Second, 30 * time.Second},
+		},
+		{
+			name:     "clock stepped back",
+			offsets:  []int{0, 1, 2, 3, -57, -56},
+			interval: time.Second,
+			breaks:   []BreakKind{BreakNone, BreakClockJump},
+			samples:  []int{4, 2},
+			gaps:     []time.Duration{0, -60 * time.Second},
+		},
+		{
+			name:      "restart",
+			offsets:   []int{0, 1, 2, 600, 610, 620},
+			runStarts: []int{0, 3},
+			interval:  time.Second,
+			breaks:    []BreakKind{BreakNone, BreakRestart},
+			samples:   []int{3, 3},
+			gaps:      []time.Duration{0, 598 * time.Second},
+		},
+		{
+			name:      "overlapping captures",
+			offsets:   []int{0, 1, 2, 3, 1, 2},
+			runStarts: []int{4},
+			interval:  time.Second,
+			breaks:    []BreakKind{BreakNone, BreakOverlap},
+			samples:   []int{4, 2},
+			gaps:      []time.Duration{0, -2 * time.Second},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tl := timelineOf(tt.offsets, tt.runStarts...)
+			assert.Equal(t, tt.interval, tl.Interval())
+			segs := tl.Segments()
+			require.Len(t, segs, len(tt.breaks))
+			for i, seg := range segs {
+				assert.Equal(t, tt.breaks[i], seg.Break, "segment %d", i)
+				assert.Equal(t, tt.samples[i], seg.Samples, "segment %d", i)
+				assert.Equal(t, tt.gaps[i], seg.Gap, "segment %d", i)
+			}
+		})
+	}
+}
+
+// TestTimelineRunIntervals judges each run against its own interval.
+func TestTimelineRunIntervals(t *testing.T) {
+	tl := timelineOf([]int{0, 1, 2, 3, 10, 20, 30, 40}, 0, 4)
+	segs := tl.Segments()
+	require.Len(t, segs, 2)
+	assert.Equal(t, time.Second, segs[0].Interval)
+	assert.Equal(t, 10*time.Second, segs[1].Interval)
+	assert.Equal(t, BreakRestart, segs[1].Break)
+	assert.Equal(t, 3*time.Second, segs[0].End.Sub(segs[0].Start))
+}
+
+// TestTimelineJitterBounded keeps steps of one and two seconds, as
+// second-resolution timestamps jitter, without storing each of them.
+func TestTimelineJitterBounded(t *testing.T) {
+	offsets := make([]int, 0, 200001)
+	for i, off := 0, 0; i <= 200000; i++ {
+		offsets = append(offsets, off)
+		off += 1 + i%2
+	}
+	// one real gap in the middle
+	for i := 100000; i < len(offsets); i++ {
+		offsets[i] += 60
+	}
+	tl := timelineOf(offsets)
+	require.Len(t, tl.runs, 1)
+	assert.LessOrEqual(t, len(tl.runs[0].candidates)+len(tl.runs[0].spans), 4)
+
+	segs := tl.Segments()
+	require.Len(t, segs, 2)
+	assert.Equal(t, BreakGap, segs[1].Break)
+	assert.Equal(t, []int{100000, 100001}, []int{segs[0].Samples, segs[1].Samples})
+}
+
+// TestStreamReportsSegments splits concatenated iostat runs at the second banner.
+func TestStreamReportsSegments(t *testing.T) {
+	var b strings.Builder
+	b.WriteString(syntheticCapture(5))
+	b.WriteString("Linux 5.15.0 (host) \t09/04/24 \t_x86_64_\t(16 CPU)\n\n")
+	for i := 0; i < 3; i++ {
+		fmt.Fprintf(&b, "09/04/24 13:00:%02d\n", i)
+		b.WriteString("avg-cpu:  %user   %nice %system %iowait  %steal   %idle\n")
+		b.WriteString("           1.00    0.00    0.00    0.00    0.00   99.00\n\n")
+	}
+
+	p, err := ParseIostatOutput([]byte(b.String()))
+	require.NoError(t, err)
+	assert.Equal(t, time.Second, p.Interval)
+	require.Len(t, p.Segments, 2)
+	assert.Equal(t, BreakRestart, p.Segments[1].Break)
+	assert.Equal(t, 5, p.Segments[0].Samples)
+	assert.Equal(t, 3, p.Segments[1].Samples)
+	assert.True(t, p.CPUs[5].SinceBoot)
+}
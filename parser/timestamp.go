@@ -0,0 +1,180 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DateOrder selects how numeric dates are read when both month-first and
+// day-first interpretations are possible.
+type DateOrder int
+
+const (
+	// DateOrderAuto prefers month-first, as printed by the C and en_US
+	// locales, and switches to day-first once a date only fits that order.
+	DateOrderAuto DateOrder = iota
+	// DateOrderMDY reads 04/09/24 as April 9th.
+	DateOrderMDY
+	// DateOrderDMY reads 04/09/24 as September 4th.
+	DateOrderDMY
+)
+
+// ParseDateOrder converts "auto", "mdy" or "dmy" into a DateOrder.
+func ParseDateOrder(s string) (DateOrder, error) {
+	switch strings.ToLower(s) {
+	case "", "auto":
+		return DateOrderAuto, nil
+	case "mdy":
+		return DateOrderMDY, nil
+	case "dmy":
+		return DateOrderDMY, nil
+	}
+	return DateOrderAuto, fmt.Errorf("unknown date order %q (want auto, mdy or dmy)", s)
+}
+
+// Date layouts sysstat prints through strftime("%x") in common locales.
+var (
+	ymdDateLayouts = []string{"2006-01-02", "2006/01/02"}
+	mdyDateLayouts = []string{"01/02/06", "01/02/2006"}
+	dmyDateLayouts = []string{"02/01/06", "02/01/2006"}
+	// dotted and dashed day-first dates are never month-first
+	dmyOnlyDateLayouts = []string{"02.01.2006", "02.01.06", "02-01-2006"}
+)
+
+// Time-of-day layouts for 24-hour and 12-hour locales.
+var clockLayouts = []string{"15:04:05", "03:04:05 PM"}
+
+// isoLayouts are printed when S_TIME_FORMAT=ISO; any offset is kept.
+var isoLayouts = []string{
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05",
+}
+
+// dateLayouts returns the date-only layouts for order in preference order.
+func dateLayouts(order DateOrder) []string {
+	var out []string
+	out = append(out, ymdDateLayouts...)
+	switch order {
+	case DateOrderMDY:
+		out = append(out, mdyDateLayouts...)
+	case DateOrderDMY:
+		out = append(out, dmyDateLayouts...)
+	default:
+		out = append(out, mdyDateLayouts...)
+		out = append(out, dmyDateLayouts...)
+	}
+	return append(out, dmyOnlyDateLayouts...)
+}
+
+// timestampLayouts returns every timestamp layout for order in preference
+// order. Bare clocks come last; they are anchored to the banner date.
+func timestampLayouts(order DateOrder) []string {
+	out := append([]string(nil), isoLayouts...)
+	for _, d := range dateLayouts(order) {
+		for _, c := range clockLayouts {
+			out = append(out, d+" "+c)
+		}
+	}
+	return append(out, clockLayouts...)
+}
+
+// timestampDetector recognises iostat timestamp lines. It remembers the last
+// layout that matched so that a capture is read consistently and the common
+// case costs a single time.Parse.
+type timestampDetector struct {
+	layouts []string
+	current string
+	// day and lastClock place time-only timestamps on a date
+	day       time.Time
+	lastClock time.Time
+}
+
+func newTimestampDetector(c config) *timestampDetector {
+	if c.timeLayout != "" {
+		return &timestampDetector{layouts: []string{c.timeLayout}, current: c.timeLayout}
+	}
+	return &timestampDetector{layouts: timestampLayouts(c.dateOrder)}
+}
+
+// parse returns the timestamp on line, or false when line is not one.
+func (d *timestampDetector) parse(line string) (time.Time, bool) {
+	line = strings.TrimSpace(line)
+	// every known layout starts with a digit and has a clock
+	if line == "" || line[0] < '0' || line[0] > '9' || !strings.Contains(line, ":") {
+		return time.Time{}, false
+	}
+	if d.current != "" {
+		if ts, ok := d.try(d.current, line); ok {
+			return ts, true
+		}
+	}
+	for _, layout := range d.layouts {
+		if layout == d.current {
+			continue
+		}
+		if ts, ok := d.try(layout, line); ok {
+			d.current = layout
+			return ts, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// try parses line with layout, anchoring a bare clock to the current day.
+func (d *timestampDetector) try(layout, line string) (time.Time, bool) {
+	ts, err := time.Parse(layout, line)
+	if err != nil {
+		return time.Time{}, false
+	}
+	// time.Parse leaves year 0 when the layout has no date
+	if ts.Year() == 0 {
+		ts = d.anchor(ts)
+	}
+	return ts, true
+}
+
+// anchor places a time of day on the current day, moving to the next day
+// when the clock wraps past midnight. A step back of less than 12 hours is
+// taken as a clock jump rather than a new day, even one back across
+// midnight, which lands on the day before.
+func (d *timestampDetector) anchor(clock time.Time) time.Time {
+	ts := time.Date(d.day.Year(), d.day.Month(), d.day.Day(), clock.Hour(), clock.Minute(), clock.Second(), 0, time.UTC)
+	switch {
+	case d.lastClock.IsZero():
+	case d.lastClock.Sub(ts) > 12*time.Hour:
+		d.day = d.day.AddDate(0, 0, 1)
+		ts = ts.AddDate(0, 0, 1)
+	case ts.Sub(d.lastClock) > 12*time.Hour:
+		ts = ts.AddDate(0, 0, -1)
+	}
+	d.lastClock = ts
+	return ts
+}
+
+// setDate starts a new run of time-only timestamps on date, usually the
+// date from the iostat banner.
+func (d *timestampDetector) setDate(date time.Time) {
+	d.day = date
+	d.lastClock = time.Time{}
+}
+
+// parseTimestamp parses a single timestamp in any known sysstat format.
+func parseTimestamp(line string) (time.Time, error) {
+	ts, ok := newTimestampDetector(config{}).parse(line)
+	if !ok {
+		return time.Time{}, fmt.Errorf("not a timestamp: %q", line)
+	}
+	return ts, nil
+}
+
+// parseDate parses a date as printed in the iostat banner.
+func parseDate(s string, order DateOrder) (time.Time, error) {
+	for _, layout := range dateLayouts(order) {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognised date %q", s)
+}
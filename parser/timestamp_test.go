@@ -0,0 +1,156 @@
+package parser
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTimestampDetectorFormats covers the formats sysstat prints across locales.
+func TestTimestampDetectorFormats(t *testing.T) {
+	tests := []struct {
+		name  string
+		line  string
+		order DateOrder
+		want  string
+	}{
+		{"C locale", "09/04/24 12:07:20", DateOrderAuto, "2024-09-04T12:07:20Z"},
+		{"four digit year", "09/04/2024 12:07:20", DateOrderAuto, "2024-09-04T12:07:20Z"},
+		{"12-hour PM", "09/04/2024 12:07:20 PM", DateOrderAuto, "2024-09-04T12:07:20Z"},
+		{"12-hour AM", "09/04/24 01:07:20 AM", DateOrderAuto, "2024-09-04T01:07:20Z"},
+		{"12-hour evening", "09/04/2024 07:07:20 PM", DateOrderAuto, "2024-09-04T19:07:20Z"},
+		{"ISO with offset", "2024-09-04T12:07:20+0200", DateOrderAuto, "2024-09-04T12:07:20+02:00"},
+		{"ISO with colon offset", "2024-09-04T12:07:20-05:00", DateOrderAuto, "2024-09-04T12:07:20-05:00"},
+		{"ISO without offset", "2024-09-04T12:07:20", DateOrderAuto, "2024-09-04T12:07:20Z"},
+		{"ISO date with space", "2024-09-04 12:07:20", DateOrderAuto, "2024-09-04T12:07:20Z"},
+		{"German", "04.09.2024 12:07:20", DateOrderAuto, "2024-09-04T12:07:20Z"},
+		{"day-first only fits dmy", "13/09/2024 12:07:20", DateOrderAuto, "2024-09-13T12:07:20Z"},
+		{"forced dmy", "04/09/24 12:07:20", DateOrderDMY, "2024-09-04T12:07:20Z"},
+		{"forced mdy", "04/09/24 12:07:20", DateOrderMDY, "2024-04-09T12:07:20Z"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTimestampDetector(config{dateOrder: tt.order})
+			ts, ok := d.parse(tt.line)
+			assert.True(t, ok)
+			assert.Equal(t, tt.want, ts.Format("2006-01-02T15:04:05Z07:00"))
+		})
+	}
+}
+
+// TestTimestampDetectorRejects ensures CPU and device lines are not timestamps.
+func TestTimestampDetectorRejects(t *testing.T) {
+	d := newTimestampDetector(config{})
+	for _, line := range []string{
+		"",
+		"2.36    0.00    0.40    0.04    0.01   97.20",
+		"sda              2.08     94.38",
+		"avg-cpu:  %user   %nice %system %iowait  %steal   %idle",
+		"13/13/24 12:07:20",
+	} {
+		_, ok := d.parse(line)
+		assert.False(t, ok, line)
+	}
+}
+
+// TestTimestampDetectorForcedLayout only accepts the forced layout.
+func TestTimestampDetectorForcedLayout(t *testing.T) {
+	d := newTimestampDetector(config{timeLayout: "02/01/2006 15:04:05"})
+	ts, ok := d.parse("04/09/2024 12:07:20")
+	assert.True(t, ok)
+	assert.Equal(t, "2024-09-04", ts.Format("2006-01-02"))
+
+	_, ok = d.parse("2024-09-04T12:07:20+0000")
+	assert.False(t, ok)
+}
+
+// TestParseIostatOutputTimestampFormats parses whole captures in other locales.
+func TestParseIostatOutputTimestampFormats(t *testing.T) {
+	block := `
+avg-cpu:  %user   %nice %system %iowait  %steal   %idle
+           2.36    0.00    0.40    0.04    0.01   97.20
+
+Device            r/s     w/s
+sda              2.08    9.58
+`
+	tests := []struct {
+		name  string
+		first string
+		next  string
+		opts  []Option
+		want  []string
+	}{
+		{"iso", "2024-09-04T23:59:59+0100", "2024-09-05T00:00:00+0100", nil,
+			[]string{"2024-09-04T23:59:59+01:00", "2024-09-05T00:00:00+01:00"}},
+		{"12-hour", "09/04/2024 11:59:59 AM", "09/04/2024 12:00:00 PM", nil,
+			[]string{"2024-09-04T11:59:59Z", "2024-09-04T12:00:00Z"}},
+		{"european forced", "04/09/2024 12:07:20", "04/09/2024 12:07:21", []Option{WithDateOrder(DateOrderDMY)},
+			[]string{"2024-09-04T12:07:20Z", "2024-09-04T12:07:21Z"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sample := tt.first + "\n" + block + "\n" + tt.next + "\n" + block
+			p, err := ParseIostatOutput([]byte(sample), tt.opts...)
+			assert.NoError(t, err)
+			if !assert.Len(t, p.CPUs, 2) {
+				return
+			}
+			for i, want := range tt.want {
+				assert.Equal(t, want, p.CPUs[i].Timestamp.Format("2006-01-02T15:04:05Z07:00"))
+				assert.Equal(t, want, p.Devices["sda"][i].Timestamp.Format("2006-01-02T15:04:05Z07:00"))
+			}
+		})
+	}
+}
+
+// TestTimestampDetectorMidnightRollover moves bare clocks to the next day past
+// midnight, and back to the day before when the clock steps back across it.
+func TestTimestampDetectorMidnightRollover(t *testing.T) {
+	d := newTimestampDetector(config{})
+	d.setDate(time.Date(2024, 9, 4, 0, 0, 0, 0, time.UTC))
+	var got []string
+	for _, line := range []string{"23:59:58", "23:59:59", "00:00:00", "00:00:01", "23:59:59", "00:00:02"} {
+		ts, ok := d.parse(line)
+		assert.True(t, ok)
+		got = append(got, ts.Format("2006-01-02 15:04:05"))
+	}
+	assert.Equal(t, []string{
+		"2024-09-04 23:59:58", "2024-09-04 23:59:59",
+		"2024-09-05 00:00:00", "2024-09-05 00:00:01",
+		// a short step back is a clock jump, not another day
+		"2024-09-04 23:59:59", "2024-09-05 00:00:02",
+	}, got)
+}
+
+// TestParseIostatOutputClockOnly dates time-only captures from the banner.
+func TestParseIostatOutputClockOnly(t *testing.T) {
+	input := "Linux 5.15.0 (host) \t09/04/24 \t_x86_64_\t(4 CPU)\n\n" +
+		"11:59:59 PM\navg-cpu:  %user   %nice %system %iowait  %steal   %idle\n           1.00    0.00    0.00    0.00    0.00   99.00\n\n" +
+		"12:00:00 AM\navg-cpu:  %user   %nice %system %iowait  %steal   %idle\n           2.00    0.00    0.00    0.00    0.00   98.00\n\n"
+	p, err := ParseIostatOutput([]byte(input))
+	assert.NoError(t, err)
+	if assert.Len(t, p.CPUs, 2) {
+		assert.Equal(t, time.Date(2024, 9, 4, 23, 59, 59, 0, time.UTC), p.CPUs[0].Timestamp)
+		assert.Equal(t, time.Date(2024, 9, 5, 0, 0, 0, 0, time.UTC), p.CPUs[1].Timestamp)
+	}
+	assert.Len(t, p.Segments, 1)
+}
+
+// TestParseIostatOutputClockStepBackAcrossMidnight splits at a short step
+// back across midnight rather than dating the rest a day late.
+func TestParseIostatOutputClockStepBackAcrossMidnight(t *testing.T) {
+	input := "Linux 5.15.0 (host) \t09/04/24 \t_x86_64_\t(4 CPU)\n\n"
+	for _, clock := range []string{"11:59:58 PM", "12:00:01 AM", "11:59:59 PM", "12:00:02 AM"} {
+		input += clock + "\navg-cpu:  %user   %nice %system %iowait  %steal   %idle\n           1.00    0.00    0.00    0.00    0.00   99.00\n\n"
+	}
+	p, err := ParseIostatOutput([]byte(input))
+	assert.NoError(t, err)
+	if assert.Len(t, p.CPUs, 4) {
+		assert.Equal(t, time.Date(2024, 9, 4, 23, 59, 59, 0, time.UTC), p.CPUs[2].Timestamp)
+		assert.Equal(t, time.Date(2024, 9, 5, 0, 0, 2, 0, time.UTC), p.CPUs[3].Timestamp)
+	}
+	if assert.Len(t, p.Segments, 2) {
+		assert.Equal(t, BreakClockJump, p.Segments[1].Break)
+	}
+}
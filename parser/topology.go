@@ -0,0 +1,197 @@
+package parser
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// DeviceKind classifies a block device by how it stacks on other devices.
+type DeviceKind string
+
+const (
+	// KindUnknown is a name that neither lsblk nor its form explains.
+	KindUnknown DeviceKind = ""
+	// KindDisk is a whole disk, at the bottom of every stack.
+	KindDisk DeviceKind = "disk"
+	// KindPartition is a partition of a disk, or of an md array.
+	KindPartition DeviceKind = "part"
+	// KindDM is a device-mapper device: an LVM volume, dm-crypt or multipath.
+	KindDM DeviceKind = "dm"
+	// KindMD is an md software RAID array.
+	KindMD DeviceKind = "md"
+)
+
+var (
+	// nvme0n1p1, mmcblk0p1, md0p1 and loop0p1 separate the number with a p
+	numberedPartition = regexp.MustCompile(`^(.*\d)p\d+$`)
+	// sda1 and xvdf1 do not
+	letteredPartition = regexp.MustCompile(`^((?:s|h|v|xv)d[a-z]+)\d+$`)
+	diskName          = regexp.MustCompile(`^(?:(?:s|h|v|xv)d[a-z]+|nvme\d+n\d+|mmcblk\d+|loop\d+)$`)
+	mdName            = regexp.MustCompile(`^md(?:\d+|/.+)$`)
+)
+
+// Topology records how the block devices of a host stack: partitions on
+// their disk, and device-mapper and md devices on their members. What lsblk
+// reported wins; any other device is placed from its name, which finds the
+// disk of a partition but not the members of a dm or md device. The zero
+// value and a nil *Topology place every device from its name.
+type Topology struct {
+	kinds   map[string]DeviceKind
+	parents map[string][]string
+	// names maps kernel names such as dm-0 onto the names lsblk lists
+	names map[string]string
+}
+
+// lsblkDevice is an entry of the blockdevices tree `lsblk -J` prints.
+type lsblkDevice struct {
+	Name     string        `json:"name"`
+	KName    string        `json:"kname"`
+	Type     string        `json:"type"`
+	Children []lsblkDevice `json:"children"`
+}
+
+// ParseLsblk reads the output of `lsblk -J`, optionally with -p or with
+// KNAME among the columns so that dm-N names printed by iostat without -N
+// are recognised too.
+func ParseLsblk(data []byte) (*Topology, error) {
+	var doc struct {
+		BlockDevices *[]lsblkDevice `json:"blockdevices"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("lsblk: %w", err)
+	}
+	if doc.BlockDevices == nil {
+		return nil, errors.New("lsblk: no blockdevices array (capture with lsblk -J)")
+	}
+	t := &Topology{
+		kinds:   make(map[string]DeviceKind),
+		parents: make(map[string][]string),
+		names:   make(map[string]string),
+	}
+	for _, d := range *doc.BlockDevices {
+		t.addLsblk(d, "")
+	}
+	return t, nil
+}
+
+// addLsblk records d and its children. Devices built on several members,
+// such as a volume group spanning two disks, are listed under each.
+func (t *Topology) addLsblk(d lsblkDevice, parent string) {
+	name := deviceBaseName(d.Name)
+	if kname := deviceBaseName(d.KName); kname != "" && kname != name {
+		t.names[kname] = name
+	}
+	t.kinds[name] = lsblkKind(d.Type)
+	if parent != "" && !slices.Contains(t.parents[name], parent) {
+		t.parents[name] = append(t.parents[name], parent)
+	}
+	for _, c := range d.Children {
+		t.addLsblk(c, name)
+	}
+}
+
+// deviceBaseName strips the /dev/ or /dev/mapper/ prefix lsblk -p prints.
+func deviceBaseName(path string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(path, "/dev/mapper/"), "/dev/")
+}
+
+// lsblkKind converts the TYPE column of lsblk.
+func lsblkKind(typ string) DeviceKind {
+	switch {
+	case typ == "disk" || typ == "loop" || typ == "rom":
+		return KindDisk
+	case typ == "part":
+		return KindPartition
+	case typ == "lvm" || typ == "crypt" || typ == "mpath" || typ == "dm":
+		return KindDM
+	case strings.HasPrefix(typ, "raid") || typ == "linear" || typ == "md":
+		return KindMD
+	}
+	return KindUnknown
+}
+
+// Name returns the name lsblk lists a device under, given its name or its
+// kernel name.
+func (t *Topology) Name(name string) string {
+	if t != nil {
+		if n, ok := t.names[name]; ok {
+			return n
+		}
+	}
+	return name
+}
+
+// Kind classifies the device name.
+func (t *Topology) Kind(name string) DeviceKind {
+	name = t.Name(name)
+	if t != nil {
+		if k, ok := t.kinds[name]; ok {
+			return k
+		}
+	}
+	k, _ := inferDevice(name)
+	return k
+}
+
+// Parents returns the devices name is built on directly: the disk of a
+// partition, or the members of a dm or md device.
+func (t *Topology) Parents(name string) []string {
+	name = t.Name(name)
+	if t != nil {
+		if _, ok := t.kinds[name]; ok {
+			return t.parents[name]
+		}
+	}
+	if _, parent := inferDevice(name); parent != "" {
+		return []string{parent}
+	}
+	return nil
+}
+
+// Roots returns the devices at the bottom of the stack name is built on,
+// usually whole disks, or name itself when it is not built on anything.
+func (t *Topology) Roots(name string) []string {
+	var roots []string
+	seen := make(map[string]bool)
+	var walk func(string)
+	walk = func(n string) {
+		if seen[n] {
+			return
+		}
+		seen[n] = true
+		parents := t.Parents(n)
+		if len(parents) == 0 {
+			roots = append(roots, n)
+		}
+		for _, p := range parents {
+			walk(p)
+		}
+	}
+	walk(t.Name(name))
+	return roots
+}
+
+// inferDevice classifies a device from its name alone and returns the disk
+// of a partition.
+func inferDevice(name string) (DeviceKind, string) {
+	if m := numberedPartition.FindStringSubmatch(name); m != nil {
+		return KindPartition, m[1]
+	}
+	if m := letteredPartition.FindStringSubmatch(name); m != nil {
+		return KindPartition, m[1]
+	}
+	switch {
+	case diskName.MatchString(name):
+		return KindDisk, ""
+	case mdName.MatchString(name):
+		return KindMD, ""
+	case strings.HasPrefix(name, "dm-") || strings.Contains(name, "-"):
+		// iostat -N prints the dm name, such as vg-data
+		return KindDM, ""
+	}
+	return KindUnknown, ""
+}
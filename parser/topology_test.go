@@ -0,0 +1,75 @@
+package parser
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestTopologyFromNames places devices from their names alone.
+func TestTopologyFromNames(t *testing.T) {
+	var topo *Topology
+	tests := []struct {
+		name    string
+		kind    DeviceKind
+		parents []string
+	}{
+		{"sda", KindDisk, nil},
+		{"sda1", KindPartition, []string{"sda"}},
+		{"xvdf12", KindPartition, []string{"xvdf"}},
+		{"nvme0n1", KindDisk, nil},
+		{"nvme0n1p1", KindPartition, []string{"nvme0n1"}},
+		{"mmcblk0p2", KindPartition, []string{"mmcblk0"}},
+		{"md0", KindMD, nil},
+		{"md0p1", KindPartition, []string{"md0"}},
+		{"dm-0", KindDM, nil},
+		{"vg-data", KindDM, nil},
+		{"rbd0", KindUnknown, nil},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.kind, topo.Kind(tt.name), tt.name)
+		assert.Equal(t, tt.parents, topo.Parents(tt.name), tt.name)
+	}
+	assert.Equal(t, []string{"nvme0n1"}, topo.Roots("nvme0n1p1"))
+	assert.Equal(t, []string{"sda"}, topo.Roots("sda"))
+}
+
+// TestParseLsblk follows partitions, an LVM volume spanning two disks and
+// an md mirror, by name and by kernel name.
+func TestParseLsblk(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "lsblk.json"))
+	require.NoError(t, err)
+	topo, err := ParseLsblk(data)
+	require.NoError(t, err)
+
+	assert.Equal(t, KindPartition, topo.Kind("nvme0n1p2"))
+	assert.Equal(t, []string{"nvme0n1"}, topo.Parents("nvme0n1p2"))
+	assert.Equal(t, KindDM, topo.Kind("vg-data"))
+	assert.Equal(t, []string{"nvme0n1p2", "nvme1n1"}, topo.Parents("vg-data"))
+	assert.Equal(t, "vg-data", topo.Name("dm-0"))
+	assert.Equal(t, []string{"nvme0n1", "nvme1n1"}, topo.Roots("dm-0"))
+	assert.Equal(t, KindMD, topo.Kind("md0"))
+	assert.Equal(t, []string{"sdb", "sdc"}, topo.Roots("md0"))
+	assert.Empty(t, topo.Parents("sdb"))
+
+	// devices lsblk did not list are still placed from their names
+	assert.Equal(t, []string{"sdd"}, topo.Parents("sdd1"))
+
+	_, err = ParseLsblk([]byte(`{"devices": []}`))
+	assert.Error(t, err)
+	_, err = ParseLsblk([]byte(`NAME MAJ:MIN`))
+	assert.Error(t, err)
+}
+
+// TestParseLsblkPaths strips the /dev prefixes lsblk -p prints.
+func TestParseLsblkPaths(t *testing.T) {
+	topo, err := ParseLsblk([]byte(`{"blockdevices": [{"name": "/dev/sda", "type": "disk", "children": [
+		{"name": "/dev/sda1", "type": "part", "children": [{"name": "/dev/mapper/root", "type": "crypt"}]}]}]}`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sda1"}, topo.Parents("root"))
+	assert.Equal(t, KindDM, topo.Kind("root"))
+	assert.Equal(t, []string{"sda"}, topo.Roots("root"))
+}
@@ -0,0 +1,193 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// vmstat -t prints a two-line header, repeated every screenful unless -n is
+// given, and one row per interval ending in the time it was taken:
+//
+//	procs -----------memory---------- ---swap-- -----io---- -system-- ------cpu----- -----timestamp-----
+//	 r  b   swpd   free   buff  cache   si   so    bi    bo   in   cs us sy id wa st                 UTC
+//	 1  0      0 7123456 123456 2345678    0    0     5    20  150  300  2  1 97  0  0 2024-09-04 12:00:01
+//
+// The first row averages the rates since boot, like iostat's first report.
+
+// ParseVmstatOutput parses the output of `vmstat -t`, with or without -w
+// and -n, into ParsedData.VM. Only the run queue, blocked processes, memory,
+// swap and system columns are kept; CPU and block I/O come from iostat.
+func ParseVmstatOutput(data []byte, opts ...Option) (ParsedData, error) {
+	c := NewCollector(0)
+	info, err := StreamVmstatOutput(bytes.NewReader(data), c.Add, opts...)
+	return c.Data(info), err
+}
+
+// StreamVmstatOutput is the streaming form of ParseVmstatOutput: it calls fn
+// for each row as soon as it has been read.
+func StreamVmstatOutput(r io.Reader, fn func(Sample) error, opts ...Option) (StreamInfo, error) {
+	timeline := NewTimeline()
+	cfg := newConfig(opts)
+	p := &vmstatParser{
+		cfg:        cfg,
+		timestamps: newTimestampDetector(cfg),
+		emit:       withTimeline(timeline, fn),
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+
+	info := func() StreamInfo {
+		i := StreamInfo{Warnings: p.warnings}
+		i.setTimeline(timeline)
+		return i
+	}
+	for scanner.Scan() {
+		if err := p.line(scanner.Text()); err != nil {
+			return info(), err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return info(), err
+	}
+	err := p.finish()
+	return info(), err
+}
+
+// vmstatParser reads vmstat text a line at a time.
+type vmstatParser struct {
+	cfg        config
+	timestamps *timestampDetector
+	emit       func(Sample) error
+
+	lineNo int
+	header []string
+	// untimed counts rows without a timestamp, as printed without -t
+	untimed  int
+	samples  int
+	warnings []ParseError
+}
+
+func (p *vmstatParser) line(raw string) error {
+	p.lineNo++
+	line := strings.TrimSpace(raw)
+	// the group header, "--procs--" with -w
+	if line == "" || strings.HasPrefix(strings.TrimLeft(line, "-"), "procs") {
+		return nil
+	}
+	fields := strings.Fields(line)
+	if isVmstatHeader(fields) {
+		p.header = fields
+		return nil
+	}
+	if p.header == nil {
+		return p.fail(ErrUnknownHeader, line, "row before the r b swpd header")
+	}
+
+	// drop a zone name after the time, as some procps releases print
+	if last := fields[len(fields)-1]; !strings.ContainsAny(last, "0123456789") {
+		fields = fields[:len(fields)-1]
+	}
+	if len(fields) < 3 {
+		return p.fail(ErrTruncated, line, fmt.Sprintf("%d fields", len(fields)))
+	}
+	ts, ok := p.timestamps.parse(strings.Join(fields[len(fields)-2:], " "))
+	if !ok {
+		if _, err := strconv.ParseFloat(fields[len(fields)-1], 64); err == nil {
+			p.untimed++
+			return nil
+		}
+		return p.fail(ErrBadTimestamp, line, fmt.Sprintf("%q is not a time", strings.Join(fields[len(fields)-2:], " ")))
+	}
+	values := fields[:len(fields)-2]
+	if len(values) > len(p.header) {
+		return p.fail(ErrTruncated, line, fmt.Sprintf("%d values for %d columns", len(values), len(p.header)))
+	}
+	m := make(map[string]float64, len(values))
+	for i, v := range values {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return p.fail(ErrNonNumeric, line, fmt.Sprintf("column %s has %q", p.header[i], v))
+		}
+		m[p.header[i]] = f
+	}
+
+	vm := vmStatsFromMap(ts, m)
+	s := Sample{Timestamp: ts, VM: &vm}
+	if p.samples == 0 {
+		s.markSinceBoot(false)
+		s.RunStart = true
+	}
+	p.samples++
+	return p.emit(s)
+}
+
+// isVmstatHeader reports whether fields are the column names of vmstat.
+func isVmstatHeader(fields []string) bool {
+	return len(fields) >= 4 && fields[0] == "r" && fields[1] == "b" && fields[2] == "swpd" && fields[3] == "free"
+}
+
+// vmStatsFromMap builds a VMStats from values keyed by the vmstat column name.
+func vmStatsFromMap(ts time.Time, m map[string]float64) VMStats {
+	return VMStats{
+		Timestamp:             ts,
+		Running:               m["r"],
+		Blocked:               m["b"],
+		SwapUsedKB:            m["swpd"],
+		FreeKB:                m["free"],
+		BuffersKB:             m["buff"],
+		CacheKB:               m["cache"],
+		SwapInKBPerSec:        m["si"],
+		SwapOutKBPerSec:       m["so"],
+		InterruptsPerSec:      m["in"],
+		ContextSwitchesPerSec: m["cs"],
+	}
+}
+
+// fail skips a row. In lenient mode the problem is recorded as a warning
+// and parsing continues with the next row.
+func (p *vmstatParser) fail(reason error, text, detail string) error {
+	perr := ParseError{Line: p.lineNo, Text: text, Reason: reason, Detail: detail}
+	if p.cfg.lenient {
+		p.warnings = append(p.warnings, perr)
+		return nil
+	}
+	return &perr
+}
+
+// finish reports input that yielded no samples.
+func (p *vmstatParser) finish() error {
+	if p.samples > 0 {
+		return nil
+	}
+	if len(p.warnings) > 0 {
+		return &ParseError{
+			Reason: ErrNoSamples,
+			Detail: fmt.Sprintf("all %d rows were skipped", len(p.warnings)),
+		}
+	}
+	if p.untimed > 0 {
+		return &ParseError{
+			Reason: ErrNoSamples,
+			Detail: fmt.Sprintf("%d vmstat rows without a timestamp (capture with vmstat -t)", p.untimed),
+		}
+	}
+	return &ParseError{
+		Reason: ErrNoSamples,
+		Detail: fmt.Sprintf("no vmstat rows in %d lines", p.lineNo),
+	}
+}
+
+// sniffVmstat reports whether text contains the vmstat column header.
+func sniffVmstat(text []byte) bool {
+	for _, line := range strings.Split(string(text), "\n") {
+		if isVmstatHeader(strings.Fields(line)) {
+			return true
+		}
+	}
+	return false
+}
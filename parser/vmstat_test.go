@@ -0,0 +1,88 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const vmstatSample = `procs -----------memory---------- ---swap-- -----io---- -system-- ------cpu----- -----timestamp-----
+ r  b   swpd   free   buff  cache   si   so    bi    bo   in   cs us sy id wa st                 UTC
+ 1  0   1024 716800  10240 204800    0    0     5    20  150  300  2  1 97  0  0 2024-09-04 12:00:01
+ 9  3   2048 512000  10240 204800   64  128  4000  9000 2500 8000 60 20  5 15  0 2024-09-04 12:00:02
+procs -----------memory---------- ---swap-- -----io---- -system-- ------cpu----- -----timestamp-----
+ r  b   swpd   free   buff  cache   si   so    bi    bo   in   cs us sy id wa st                 UTC
+ 2  1   2048 512000  10240 204800    0    0    10    30  200  400  5  2 90  3  0 2024-09-04 12:00:03
+`
+
+func TestParseVmstatOutput(t *testing.T) {
+	assert.True(t, Sniff([]byte(vmstatSample)))
+	p, err := Parse([]byte(vmstatSample))
+	require.NoError(t, err)
+	assert.Empty(t, p.CPUs)
+	assert.Empty(t, p.Devices)
+
+	// the repeated header does not start a new run
+	require.Len(t, p.VM, 3)
+	assert.True(t, p.VM[0].SinceBoot)
+	assert.False(t, p.VM[1].SinceBoot)
+	vm := p.VM[1]
+	assert.Equal(t, time.Date(2024, 9, 4, 12, 0, 2, 0, time.UTC), vm.Timestamp)
+	assert.InDelta(t, 9, vm.Running, 1e-9)
+	assert.InDelta(t, 3, vm.Blocked, 1e-9)
+	assert.InDelta(t, 2048, vm.SwapUsedKB, 1e-9)
+	assert.InDelta(t, 512000, vm.FreeKB, 1e-9)
+	assert.InDelta(t, 10240, vm.BuffersKB, 1e-9)
+	assert.InDelta(t, 204800, vm.CacheKB, 1e-9)
+	assert.InDelta(t, 64, vm.SwapInKBPerSec, 1e-9)
+	assert.InDelta(t, 128, vm.SwapOutKBPerSec, 1e-9)
+	assert.InDelta(t, 2500, vm.InterruptsPerSec, 1e-9)
+	assert.InDelta(t, 8000, vm.ContextSwitchesPerSec, 1e-9)
+
+	assert.Equal(t, time.Second, p.Interval)
+	require.Len(t, p.Segments, 1)
+	assert.Equal(t, 3, p.Segments[0].Samples)
+}
+
+// TestParseVmstatOutputWide covers -w with the gu column of procps-ng 4.
+func TestParseVmstatOutputWide(t *testing.T) {
+	input := strings.Join([]string{
+		"--procs-- -----------------------memory---------------------- ---swap-- -----io---- -system-- -------cpu------- -----timestamp-----",
+		"   r    b         swpd         free         buff        cache   si   so    bi    bo   in   cs  us  sy  id  wa  st  gu                 CEST",
+		"   4    0            0     16000000       400000      8000000    0    0     1     2   10   20   1   0  99   0   0   0 2024-09-04 23:59:59",
+		"   5    0            0     15000000       400000      8000000    0    0     1     2   10   20   1   0  99   0   0   0 2024-09-05 00:00:00",
+	}, "\n")
+	p, err := ParseVmstatOutput([]byte(input))
+	require.NoError(t, err)
+	require.Len(t, p.VM, 2)
+	assert.Equal(t, time.Date(2024, 9, 5, 0, 0, 0, 0, time.UTC), p.VM[1].Timestamp)
+	assert.InDelta(t, 5, p.VM[1].Running, 1e-9)
+	assert.InDelta(t, 15000000, p.VM[1].FreeKB, 1e-9)
+}
+
+func TestParseVmstatOutputErrors(t *testing.T) {
+	header := " r  b   swpd   free   buff  cache   si   so    bi    bo   in   cs us sy id wa st\n"
+
+	// without -t there is nothing to place the rows in time
+	_, err := ParseVmstatOutput([]byte(header + " 1  0      0 716800  10240 204800    0    0     5    20  150  300  2  1 97  0  0\n"))
+	var perr *ParseError
+	require.ErrorAs(t, err, &perr)
+	assert.ErrorIs(t, err, ErrNoSamples)
+	assert.Contains(t, perr.Detail, "vmstat -t")
+
+	bad := header +
+		" 1  x      0 716800  10240 204800    0    0     5    20  150  300  2  1 97  0  0 2024-09-04 12:00:01\n" +
+		" 1  0      0 716800  10240 204800    0    0     5    20  150  300  2  1 97  0  0 2024-09-04 12:00:02\n"
+	_, err = ParseVmstatOutput([]byte(bad))
+	require.ErrorAs(t, err, &perr)
+	assert.ErrorIs(t, err, ErrNonNumeric)
+	assert.Equal(t, 2, perr.Line)
+
+	p, err := ParseVmstatOutput([]byte(bad), WithLenient())
+	require.NoError(t, err)
+	assert.Len(t, p.Warnings, 1)
+	assert.Len(t, p.VM, 1)
+}
@@ -0,0 +1,285 @@
+package reporter
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rsvihladremio/iostat-reporter/parser"
+)
+
+// DeviceView selects how stacked devices, such as partitions and LVM
+// volumes on a disk, are charted.
+type DeviceView int
+
+const (
+	// DeviceViewNested charts every device, each nested under the device it
+	// is built on. I/O to a partition is counted again in its disk.
+	DeviceViewNested DeviceView = iota
+	// DeviceViewRollup charts only the devices at the bottom of each stack,
+	// usually whole disks, so that every I/O is counted once. A disk that
+	// was not captured itself is summed from the devices on it.
+	DeviceViewRollup
+)
+
+// ParseDeviceView converts "nested" or "rollup" into a DeviceView.
+func ParseDeviceView(s string) (DeviceView, error) {
+	switch strings.ToLower(s) {
+	case "", "nested":
+		return DeviceViewNested, nil
+	case "rollup":
+		return DeviceViewRollup, nil
+	}
+	return DeviceViewNested, fmt.Errorf("unknown device view %q (want nested or rollup)", s)
+}
+
+// deviceEntry is one device to chart, in the order the report lists them.
+type deviceEntry struct {
+	name  string
+	stats []parser.DeviceStats
+	// depth nests the entry under the previous one of a lower depth
+	depth int
+	// note says what the device is built on, or what it includes
+	note string
+}
+
+// deviceEntries orders the devices of a capture as cfg.deviceView asks.
+func deviceEntries(devices map[string][]parser.DeviceStats, cfg config) []deviceEntry {
+	if cfg.deviceView == DeviceViewRollup {
+		return rollupEntries(devices, cfg.topology)
+	}
+	return nestedEntries(devices, cfg.topology)
+}
+
+// nestedEntries lists every device depth first, each under the nearest
+// captured device it is built on.
+func nestedEntries(devices map[string][]parser.DeviceStats, topo *parser.Topology) []deviceEntry {
+	children := make(map[string][]string)
+	var top []string
+	for _, dev := range sortedDevices(devices) {
+		if parent := capturedAncestor(dev, devices, topo); parent != "" {
+			children[parent] = append(children[parent], dev)
+		} else {
+			top = append(top, dev)
+		}
+	}
+
+	var entries []deviceEntry
+	var add func(dev string, depth int)
+	add = func(dev string, depth int) {
+		entries = append(entries, deviceEntry{name: dev, stats: devices[dev], depth: depth, note: builtOn(dev, topo)})
+		for _, c := range children[dev] {
+			add(c, depth+1)
+		}
+	}
+	for _, dev := range top {
+		add(dev, 0)
+	}
+	return entries
+}
+
+// rollupEntries lists the captured devices no other captured device sits
+// under, folding each into the single disk it is built on. Devices spread
+// over several disks none of which was captured are listed as they are.
+func rollupEntries(devices map[string][]parser.DeviceStats, topo *parser.Topology) []deviceEntry {
+	var (
+		roots   []string
+		members = make(map[string][]string)
+		// included lists the devices counted in a captured root
+		included = make(map[string][]string)
+	)
+	for _, dev := range sortedDevices(devices) {
+		if capturedAncestor(dev, devices, topo) != "" {
+			for _, r := range topo.Roots(dev) {
+				if _, ok := devices[r]; ok {
+					included[r] = append(included[r], dev)
+				}
+			}
+			continue
+		}
+		root := dev
+		if rs := topo.Roots(dev); len(rs) == 1 {
+			root = rs[0]
+		}
+		if _, ok := members[root]; !ok {
+			roots = append(roots, root)
+			members[root] = nil
+		}
+		if root != dev {
+			members[root] = append(members[root], dev)
+		}
+	}
+	sort.Slice(roots, func(i, j int) bool { return deviceLess(roots[i], roots[j]) })
+
+	entries := make([]deviceEntry, 0, len(roots))
+	for _, root := range roots {
+		e := deviceEntry{name: root, stats: devices[root]}
+		switch {
+		case len(members[root]) > 0:
+			series := make([][]parser.DeviceStats, 0, len(members[root]))
+			for _, m := range members[root] {
+				series = append(series, devices[m])
+			}
+			e.stats = sumDeviceStats(root, series)
+			e.note = "sum of " + strings.Join(members[root], ", ")
+		case len(included[root]) > 0:
+			e.note = "includes " + strings.Join(included[root], ", ")
+		default:
+			e.note = builtOn(root, topo)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// capturedAncestor returns the nearest device dev is built on that was
+// captured too, searching through devices that were not.
+func capturedAncestor(dev string, devices map[string][]parser.DeviceStats, topo *parser.Topology) string {
+	seen := map[string]bool{dev: true}
+	queue := topo.Parents(dev)
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+		if _, ok := devices[p]; ok {
+			return p
+		}
+		// the capture may use the kernel name, dm-0, of a device lsblk names
+		for name := range devices {
+			if name != dev && topo.Name(name) == p {
+				return name
+			}
+		}
+		queue = append(queue, topo.Parents(p)...)
+	}
+	return ""
+}
+
+// builtOn describes what dev is and what it is built on, such as
+// "partition of nvme0n1".
+func builtOn(dev string, topo *parser.Topology) string {
+	parents := topo.Parents(dev)
+	if len(parents) == 0 {
+		return ""
+	}
+	on := strings.Join(parents, ", ")
+	switch topo.Kind(dev) {
+	case parser.KindPartition:
+		return "partition of " + on
+	case parser.KindDM:
+		return "device-mapper on " + on
+	case parser.KindMD:
+		return "RAID on " + on
+	}
+	return "on " + on
+}
+
+// sortedDevices returns the device names in deviceLess order.
+func sortedDevices(devices map[string][]parser.DeviceStats) []string {
+	names := make([]string, 0, len(devices))
+	for dev := range devices {
+		names = append(names, dev)
+	}
+	sort.Slice(names, func(i, j int) bool { return deviceLess(names[i], names[j]) })
+	return names
+}
+
+// deviceLess orders device names with their trailing numbers compared as
+// numbers, so that sda2 comes before sda10.
+func deviceLess(a, b string) bool {
+	at, an := splitNumber(a)
+	bt, bn := splitNumber(b)
+	if at != bt || an == bn {
+		return a < b
+	}
+	return an < bn
+}
+
+// splitNumber splits name into its text and trailing number, -1 if none.
+func splitNumber(name string) (string, int) {
+	i := len(name)
+	for i > 0 && name[i-1] >= '0' && name[i-1] <= '9' {
+		i--
+	}
+	n, err := strconv.Atoi(name[i:])
+	if err != nil {
+		n = -1
+	}
+	return name[:i], n
+}
+
+// sumDeviceStats adds up the series of several devices sample by sample, as
+// the device they are built on would have reported them: rates and queue
+// sizes add up, latencies and request sizes are weighted by the requests
+// behind them, and utilisation is that of the busiest member.
+func sumDeviceStats(name string, series [][]parser.DeviceStats) []parser.DeviceStats {
+	index := make(map[time.Time]int)
+	var out []parser.DeviceStats
+	// weighted sums of the latencies, divided by the requests once all are in
+	type weights struct{ read, write, discard, flush, await, svc float64 }
+	var w []weights
+	for _, stats := range series {
+		for _, ds := range stats {
+			i, ok := index[ds.Timestamp]
+			if !ok {
+				i = len(out)
+				index[ds.Timestamp] = i
+				out = append(out, parser.DeviceStats{Timestamp: ds.Timestamp, Name: name})
+				w = append(w, weights{})
+			}
+			s := &out[i]
+			s.SinceBoot = s.SinceBoot || ds.SinceBoot
+			s.ReadsPerSec += ds.ReadsPerSec
+			s.ReadKBPerSec += ds.ReadKBPerSec
+			s.ReadMergedPerSec += ds.ReadMergedPerSec
+			s.WritesPerSec += ds.WritesPerSec
+			s.WriteKBPerSec += ds.WriteKBPerSec
+			s.WriteMergedPerSec += ds.WriteMergedPerSec
+			s.DiscardsPerSec += ds.DiscardsPerSec
+			s.DiscardKBPerSec += ds.DiscardKBPerSec
+			s.DiscardMergedPerSec += ds.DiscardMergedPerSec
+			s.FlushesPerSec += ds.FlushesPerSec
+			s.TransfersPerSec += ds.TransfersPerSec
+			s.QueueSize += ds.QueueSize
+			s.UtilPct = math.Max(s.UtilPct, ds.UtilPct)
+			w[i].read += ds.ReadAwaitMs * ds.ReadsPerSec
+			w[i].write += ds.WriteAwaitMs * ds.WritesPerSec
+			w[i].discard += ds.DiscardAwaitMs * ds.DiscardsPerSec
+			w[i].flush += ds.FlushAwaitMs * ds.FlushesPerSec
+			w[i].await += ds.AwaitMs * ds.TransfersPerSec
+			w[i].svc += ds.SvcTimeMs * ds.TransfersPerSec
+		}
+	}
+	for i := range out {
+		s := &out[i]
+		s.ReadAwaitMs = ratio(w[i].read, s.ReadsPerSec)
+		s.WriteAwaitMs = ratio(w[i].write, s.WritesPerSec)
+		s.DiscardAwaitMs = ratio(w[i].discard, s.DiscardsPerSec)
+		s.FlushAwaitMs = ratio(w[i].flush, s.FlushesPerSec)
+		s.AwaitMs = ratio(w[i].await, s.TransfersPerSec)
+		s.SvcTimeMs = ratio(w[i].svc, s.TransfersPerSec)
+		s.ReadReqSzKB = ratio(s.ReadKBPerSec, s.ReadsPerSec)
+		s.WriteReqSzKB = ratio(s.WriteKBPerSec, s.WritesPerSec)
+		s.DiscardReqSzKB = ratio(s.DiscardKBPerSec, s.DiscardsPerSec)
+		s.ReqSzKB = ratio(s.ReadKBPerSec+s.WriteKBPerSec+s.DiscardKBPerSec, s.TransfersPerSec)
+		s.ReadPctMerged = 100 * ratio(s.ReadMergedPerSec, s.ReadMergedPerSec+s.ReadsPerSec)
+		s.WritePctMerged = 100 * ratio(s.WriteMergedPerSec, s.WriteMergedPerSec+s.WritesPerSec)
+		s.DiscardPctMerged = 100 * ratio(s.DiscardMergedPerSec, s.DiscardMergedPerSec+s.DiscardsPerSec)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Timestamp.Before(out[j].Timestamp) })
+	return out
+}
+
+// ratio returns a/b, or zero when b is not positive.
+func ratio(a, b float64) float64 {
+	if b <= 0 {
+		return 0
+	}
+	return a / b
+}
@@ -0,0 +1,167 @@
+package reporter
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rsvihladremio/iostat-reporter/parser"
+)
+
+// devicesOf returns one sample of each named device.
+func devicesOf(names ...string) map[string][]parser.DeviceStats {
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	devices := make(map[string][]parser.DeviceStats, len(names))
+	for _, name := range names {
+		devices[name] = []parser.DeviceStats{{Timestamp: now, Name: name, ReadsPerSec: 1}}
+	}
+	return devices
+}
+
+// entryNames describes entries as name/depth pairs.
+func entryNames(entries []deviceEntry) string {
+	var parts []string
+	for _, e := range entries {
+		parts = append(parts, strings.Repeat(">", e.depth)+e.name)
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestNestedEntries(t *testing.T) {
+	devices := devicesOf("sda", "sda10", "sda2", "nvme0n1p1", "vg-data")
+	entries := deviceEntries(devices, config{})
+	if got, want := entryNames(entries), "nvme0n1p1 sda >sda2 >sda10 vg-data"; got != want {
+		t.Errorf("entries = %q, want %q", got, want)
+	}
+	if entries[2].note != "partition of sda" {
+		t.Errorf("note = %q", entries[2].note)
+	}
+}
+
+// TestNestedEntriesLsblk nests an LVM volume captured under its kernel name
+// through a partition that was not captured.
+func TestNestedEntriesLsblk(t *testing.T) {
+	topo, err := parser.ParseLsblk([]byte(`{"blockdevices": [{"name": "nvme0n1", "type": "disk", "children": [
+		{"name": "nvme0n1p1", "type": "part"},
+		{"name": "nvme0n1p2", "type": "part", "children": [{"name": "vg-data", "kname": "dm-0", "type": "lvm"}]}]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := deviceEntries(devicesOf("nvme0n1", "nvme0n1p1", "dm-0"), config{topology: topo})
+	if got, want := entryNames(entries), "nvme0n1 >dm-0 >nvme0n1p1"; got != want {
+		t.Errorf("entries = %q, want %q", got, want)
+	}
+	if entries[1].note != "device-mapper on nvme0n1p2" {
+		t.Errorf("note = %q", entries[1].note)
+	}
+}
+
+func TestRollupEntries(t *testing.T) {
+	devices := devicesOf("sda1", "sda2", "nvme0n1", "nvme0n1p1", "vg-data")
+	entries := deviceEntries(devices, config{deviceView: DeviceViewRollup})
+	if got, want := entryNames(entries), "nvme0n1 sda vg-data"; got != want {
+		t.Fatalf("entries = %q, want %q", got, want)
+	}
+	if entries[0].note != "includes nvme0n1p1" {
+		t.Errorf("nvme0n1 note = %q", entries[0].note)
+	}
+	if entries[1].note != "sum of sda1, sda2" {
+		t.Errorf("sda note = %q", entries[1].note)
+	}
+	if len(entries[1].stats) != 1 || entries[1].stats[0].ReadsPerSec != 2 {
+		t.Errorf("sda stats = %+v, want the reads of both partitions", entries[1].stats)
+	}
+}
+
+func TestSumDeviceStats(t *testing.T) {
+	now := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	a := []parser.DeviceStats{
+		{Timestamp: now, ReadsPerSec: 10, ReadKBPerSec: 40, ReadAwaitMs: 1, TransfersPerSec: 10, AwaitMs: 1, QueueSize: 0.5, UtilPct: 20},
+		{Timestamp: now.Add(time.Second), ReadsPerSec: 1, ReadKBPerSec: 4, TransfersPerSec: 1},
+	}
+	b := []parser.DeviceStats{
+		{Timestamp: now, ReadsPerSec: 30, ReadKBPerSec: 480, ReadAwaitMs: 5, TransfersPerSec: 30, AwaitMs: 5, QueueSize: 1.5, UtilPct: 60},
+	}
+	sum := sumDeviceStats("sda", [][]parser.DeviceStats{a, b})
+	if len(sum) != 2 {
+		t.Fatalf("got %d samples, want 2", len(sum))
+	}
+	s := sum[0]
+	checks := []struct {
+		name      string
+		got, want float64
+	}{
+		{"reads", s.ReadsPerSec, 40},
+		{"read KB", s.ReadKBPerSec, 520},
+		{"read await", s.ReadAwaitMs, 4},
+		{"await", s.AwaitMs, 4},
+		{"read size", s.ReadReqSzKB, 13},
+		{"queue", s.QueueSize, 2},
+		{"util", s.UtilPct, 60},
+		{"second sample", sum[1].ReadsPerSec, 1},
+	}
+	for _, c := range checks {
+		if math.Abs(c.got-c.want) > 1e-9 {
+			t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+		}
+	}
+	if s.Name != "sda" {
+		t.Errorf("name = %q", s.Name)
+	}
+}
+
+func TestParseDeviceView(t *testing.T) {
+	for in, want := range map[string]DeviceView{"": DeviceViewNested, "nested": DeviceViewNested, "ROLLUP": DeviceViewRollup} {
+		got, err := ParseDeviceView(in)
+		if err != nil || got != want {
+			t.Errorf("ParseDeviceView(%q) = %v, %v", in, got, err)
+		}
+	}
+	if _, err := ParseDeviceView("flat"); err == nil {
+		t.Error("expected an error for an unknown view")
+	}
+}
+
+// TestGenerateReport_DeviceTopology nests partitions under their disk, and
+// rolls them up into it on request.
+func TestGenerateReport_DeviceTopology(t *testing.T) {
+	parsed := makeDummyParsedData()
+	parsed.Devices["sda1"] = parsed.Devices["sda"]
+	dir := t.TempDir()
+
+	nested := filepath.Join(dir, "nested.html")
+	if err := GenerateReport(parsed, nested, "Nested", "", "iostat.txt", "abcdef123456", "v0.0"); err != nil {
+		t.Fatalf("GenerateReport failed: %v", err)
+	}
+	data, err := os.ReadFile(nested)
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	html := string(data)
+	sda, sda1 := strings.Index(html, `id="dev_sda_chart"`), strings.Index(html, `id="dev_sda1_chart"`)
+	if sda < 0 || sda1 < sda {
+		t.Errorf("expected sda1 charted after sda, at %d and %d", sda, sda1)
+	}
+	if !strings.Contains(html, "partition of sda") {
+		t.Error("expected sda1 to be labelled as a partition of sda")
+	}
+
+	rollup := filepath.Join(dir, "rollup.html")
+	if err := GenerateReport(parsed, rollup, "Rollup", "", "iostat.txt", "abcdef123456", "v0.0", WithDeviceView(DeviceViewRollup)); err != nil {
+		t.Fatalf("GenerateReport failed: %v", err)
+	}
+	data, err = os.ReadFile(rollup)
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	html = string(data)
+	if strings.Contains(html, "dev_sda1_chart") {
+		t.Error("rolled up report should not chart sda1")
+	}
+	if !strings.Contains(html, "includes sda1") {
+		t.Error("expected sda to list sda1 as included")
+	}
+}
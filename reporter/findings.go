@@ -0,0 +1,95 @@
+package reporter
+
+import (
+	"time"
+
+	"github.com/rsvihladremio/iostat-reporter/analysis"
+)
+
+// utilChartMetrics are the device metrics shown on the utilisation chart
+// rather than the main one.
+var utilChartMetrics = map[string]bool{"%util": true, "d/s": true, "f/s": true, "dkB/s": true}
+
+// memChartMetrics are the vmstat metrics shown on the memory chart.
+var memChartMetrics = map[string]bool{"swpd": true, "free": true, "si": true, "so": true}
+
+// finding is an analysis.Finding with links to where its chart shows it.
+type finding struct {
+	analysis.Finding
+	// ChartID is the chart showing the metric, empty when none does
+	ChartID  string
+	Evidence []evidence
+}
+
+// evidence is a time range of a finding, zoomed to when clicked.
+type evidence struct {
+	Label string
+	// Start and End are chart milliseconds, an interval wider each side so
+	// that a single sample shows
+	Start, End int64
+}
+
+// Class returns the Bootstrap colour of the finding's severity.
+func (f finding) Class() string {
+	switch f.Severity {
+	case analysis.SeverityCritical:
+		return "danger"
+	case analysis.SeverityWarning:
+		return "warning"
+	}
+	return "info"
+}
+
+// sectionFindings links findings to the charts of sec.
+func sectionFindings(findings []analysis.Finding, sec section, interval time.Duration, zone *time.Location) []finding {
+	charts := make(map[string]deviceChart, len(sec.DeviceCharts))
+	for _, dc := range sec.DeviceCharts {
+		charts[dc.DeviceName] = dc
+	}
+
+	out := make([]finding, 0, len(findings))
+	for _, f := range findings {
+		linked := finding{Finding: f}
+		switch f.Scope {
+		case analysis.ScopeCPU:
+			linked.ChartID = sec.CPUChartID
+		case analysis.ScopeVM:
+			linked.ChartID = sec.VMChartID
+			if memChartMetrics[f.Metric] {
+				linked.ChartID = sec.MemChartID
+			}
+		case analysis.ScopeDevice:
+			if dc, ok := charts[f.Device]; ok {
+				linked.ChartID = dc.ChartID
+				switch {
+				case f.Metric == creditsMetric:
+					linked.ChartID = dc.CreditsChartID
+				case utilChartMetrics[f.Metric]:
+					linked.ChartID = dc.UtilChartID
+				}
+			}
+		}
+		pad := interval.Milliseconds()
+		for _, r := range f.Ranges {
+			linked.Evidence = append(linked.Evidence, evidence{
+				Label: rangeLabel(r, zone),
+				Start: chartMillis(r.Start, zone) - pad,
+				End:   chartMillis(r.End, zone) + pad,
+			})
+		}
+		out = append(out, linked)
+	}
+	return out
+}
+
+// rangeLabel describes r, leaving out the end date when it is the start's.
+func rangeLabel(r analysis.TimeRange, zone *time.Location) string {
+	start, end := displayTime(r.Start, zone), displayTime(r.End, zone)
+	switch {
+	case start == end:
+		return start
+	case start[:10] == end[:10]:
+		return start + " – " + end[11:]
+	}
+	return start + " – " + end
+}
@@ -0,0 +1,147 @@
+package reporter
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rsvihladremio/iostat-reporter/analysis"
+	"github.com/rsvihladremio/iostat-reporter/cloud"
+	"github.com/rsvihladremio/iostat-reporter/parser"
+)
+
+// SinceBootMode selects how the since-boot report iostat prints first is shown.
+type SinceBootMode int
+
+const (
+	// SinceBootExclude leaves since-boot samples out of the charts.
+	SinceBootExclude SinceBootMode = iota
+	// SinceBootShade charts them on a shaded background.
+	SinceBootShade
+	// SinceBootInclude charts them like any other sample.
+	SinceBootInclude
+	// SinceBootAuto leaves out the samples known to be since-boot and shades
+	// those that may be regular ones, see parser.CPUStats.SinceBootUnsure.
+	SinceBootAuto
+)
+
+// ParseSinceBootMode converts "auto", "exclude", "shade" or "include" into a
+// SinceBootMode.
+func ParseSinceBootMode(s string) (SinceBootMode, error) {
+	switch strings.ToLower(s) {
+	case "", "auto":
+		return SinceBootAuto, nil
+	case "exclude":
+		return SinceBootExclude, nil
+	case "shade":
+		return SinceBootShade, nil
+	case "include":
+		return SinceBootInclude, nil
+	}
+	return SinceBootAuto, fmt.Errorf("unknown since-boot mode %q (want auto, exclude, shade or include)", s)
+}
+
+// shades reports whether the since-boot samples left in the charts are shaded.
+func (m SinceBootMode) shades() bool {
+	return m == SinceBootShade || m == SinceBootAuto
+}
+
+// ParseTimezone converts "local", an IANA name such as "Europe/Berlin" or a
+// fixed offset such as "+02:00" into a location. An empty string returns nil,
+// which shows times as captured.
+func ParseTimezone(s string) (*time.Location, error) {
+	switch {
+	case s == "":
+		return nil, nil
+	case strings.EqualFold(s, "local"):
+		return time.Local, nil
+	case strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-"):
+		t, err := time.Parse("-07:00", s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid offset %q (want e.g. +02:00)", s)
+		}
+		_, off := t.Zone()
+		return time.FixedZone("UTC"+s, off), nil
+	}
+	return time.LoadLocation(s)
+}
+
+// config holds the optional report settings.
+type config struct {
+	sinceBoot  SinceBootMode
+	zone       *time.Location
+	topology   *parser.Topology
+	deviceView DeviceView
+	thresholds analysis.Thresholds
+	rules      []analysis.Rule
+	volumes    map[string]cloud.Volume
+	// burstBalance is how full, in percent, burst buckets start
+	burstBalance float64
+}
+
+// Option customises the generated report.
+type Option func(*config)
+
+// WithSinceBoot selects how since-boot samples are shown. By default those
+// known to be since-boot are excluded and those that may not be are shaded.
+func WithSinceBoot(mode SinceBootMode) Option {
+	return func(c *config) { c.sinceBoot = mode }
+}
+
+// WithTimezone shows every time in loc. By default times are shown as
+// captured: in the offset iostat printed, or as plain wall-clock times.
+func WithTimezone(loc *time.Location) Option {
+	return func(c *config) { c.zone = loc }
+}
+
+// WithTopology places devices using what lsblk reported, see
+// parser.ParseLsblk. Without it, devices are placed from their names.
+func WithTopology(t *parser.Topology) Option {
+	return func(c *config) { c.topology = t }
+}
+
+// WithDeviceView selects how stacked devices are charted. They are nested
+// by default.
+func WithDeviceView(view DeviceView) Option {
+	return func(c *config) { c.deviceView = view }
+}
+
+// WithThresholds sets when samples count as saturated in the summary. The
+// defaults are analysis.DefaultThresholds.
+func WithThresholds(t analysis.Thresholds) Option {
+	return func(c *config) { c.thresholds = t }
+}
+
+// WithRules sets the rules findings are drawn from. The defaults are
+// analysis.BuiltinRules; pass analysis.MergeRules of them to add more.
+func WithRules(rules []analysis.Rule) Option {
+	return func(c *config) { c.rules = rules }
+}
+
+// WithVolumes compares the devices named in volumes with the caps of their
+// cloud volume, see cloud.ParseMapping.
+func WithVolumes(volumes map[string]cloud.Volume) Option {
+	return func(c *config) { c.volumes = volumes }
+}
+
+// WithBurstBalance sets how full, in percent, the burst-credit buckets of
+// cloud volumes are at the start of the capture. They start full by default.
+func WithBurstBalance(pct float64) Option {
+	return func(c *config) { c.burstBalance = pct }
+}
+
+// zoneName describes the timezone times are shown in.
+func (c config) zoneName() string {
+	if c.zone == nil {
+		return "as captured"
+	}
+	return c.zone.String()
+}
+
+func newConfig(opts []Option) config {
+	c := config{sinceBoot: SinceBootAuto, thresholds: analysis.DefaultThresholds(), rules: analysis.BuiltinRules(), burstBalance: 100}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
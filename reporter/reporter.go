@@ -4,16 +4,168 @@ import (
 	"encoding/json"
 	"fmt"
 	"html/template"
+	"math"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/rsvihladremio/iostat-reporter/analysis"
+	"github.com/rsvihladremio/iostat-reporter/cloud"
 	"github.com/rsvihladremio/iostat-reporter/parser"
 )
 
-func GenerateReport(parsedData parser.ParsedData, outputFile string, reportTitle string, metadata string, fileName string, fileHash string, version string) error {
+// maxListedWarnings caps the rows of the "Parse warnings" panel.
+const maxListedWarnings = 100
+
+// File is an input listed in the report header with its SHA-256 hash.
+type File struct {
+	Name string
+	Hash string
+}
+
+// Host is one data set of a report, named after its host or input.
+type Host struct {
+	Name string
+	Data parser.ParsedData
+	// Streamed, when set, was accumulated while Data was streamed, before it
+	// was averaged down; otherwise the statistics and summary are worked out
+	// from Data.
+	Streamed *Streamed
+}
+
+// deviceChart holds the charts of one device.
+type deviceChart struct {
+	DeviceName     string
+	ChartID        string
+	OptionJSON     template.JS
+	UtilChartID    string
+	UtilOptionJSON template.JS
+	// Depth nests the device under the one it is built on, Note says which
+	Depth int
+	Note  string
+	Stats analysis.DeviceMetrics
+	// Volume is the cloud volume the device was mapped to, empty for none
+	Volume string
+	// the credits chart is only set for a volume that bursts
+	CreditsChartID    string
+	CreditsOptionJSON template.JS
+}
+
+// section holds the charts and details of one Host.
+type section struct {
+	// ID prefixes the element IDs of the section
+	ID         string
+	Name       string
+	Host       parser.HostInfo
+	Interval   time.Duration
+	Breaks     []string
+	CPUChartID string
+	CpuOption  template.JS
+	// the vmstat and mpstat charts are only set when that data was captured
+	VMChartID    string
+	VMOption     template.JS
+	MemChartID   string
+	MemOption    template.JS
+	CoresChartID string
+	CoresOption  template.JS
+	DeviceCharts []deviceChart
+	Summary      analysis.Summary
+	Volumes      []volumeRow
+	Findings     []finding
+	Warnings     []parser.ParseError
+	WarningCount int
+}
+
+func GenerateReport(parsedData parser.ParsedData, outputFile string, reportTitle string, metadata string, fileName string, fileHash string, version string, opts ...Option) error {
+	return GenerateHostsReport([]Host{{Data: parsedData}}, []File{{Name: fileName, Hash: fileHash}}, outputFile, reportTitle, metadata, version, opts...)
+}
+
+// GenerateHostsReport writes one report with a section per host. files are
+// the inputs the data came from.
+func GenerateHostsReport(hosts []Host, files []File, outputFile string, reportTitle string, metadata string, version string, opts ...Option) error {
+	cfg := newConfig(opts)
+	sections := make([]section, 0, len(hosts))
+	for i, h := range hosts {
+		// chart IDs of later hosts are prefixed to stay unique
+		prefix := ""
+		if i > 0 {
+			prefix = fmt.Sprintf("host%d_", i)
+		}
+		sec, err := buildSection(h, cfg, prefix)
+		if err != nil {
+			return err
+		}
+		sections = append(sections, sec)
+	}
+
+	// Render the template
+	templatePath := "templates/report.html"
+	if _, statErr := os.Stat(templatePath); os.IsNotExist(statErr) {
+		templatePath = filepath.Join("..", "templates", "report.html")
+	}
+	tmpl, err := template.New("report.html").Funcs(template.FuncMap{
+		"safeJS": func(s string) template.JS { return template.JS(s) }, // #nosec G203
+		"abbr": func(s string) string {
+			if len(s) > 6 {
+				return s[:6] + ".."
+			}
+			return s
+		},
+		// nested devices are indented a step per level, as far as Bootstrap goes
+		"indent": func(depth int) string { return fmt.Sprintf("ps-%d", min(3+depth, 5)) },
+	}).ParseFiles(templatePath)
+	if err != nil {
+		return fmt.Errorf("failed to parse template %q: %w", templatePath, err)
+	}
+
+	data := struct {
+		Title    string
+		Metadata string
+		Files    []File
+		Version  string
+		Timezone string
+		Sections []section
+	}{
+		Title:    reportTitle,
+		Metadata: metadata,
+		Files:    files,
+		Version:  version,
+		Timezone: cfg.zoneName(),
+		Sections: sections,
+	}
+
+	f, err := os.Create(outputFile) // #nosec G304
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to close output file: %v\n", cerr)
+		}
+	}()
+
+	if err := tmpl.Execute(f, data); err != nil {
+		return fmt.Errorf("failed to execute template: %w", err)
+	}
+
+	return nil
+}
+
+// buildSection builds the charts of one host, prefixing their element IDs.
+func buildSection(h Host, cfg config, prefix string) (section, error) {
+	parsedData := h.Data
+	switch cfg.sinceBoot {
+	case SinceBootExclude:
+		parsedData = withoutSinceBoot(parsedData, false)
+	case SinceBootAuto:
+		parsedData = withoutSinceBoot(parsedData, true)
+	}
+
+	span := sectionSpan(parsedData, cfg.zone)
+
 	// Build time axis and CPU series
-	times := make([]string, len(parsedData.CPUs))
+	times := make([]time.Time, len(parsedData.CPUs))
 	users := make([]float64, len(parsedData.CPUs))
 	systems := make([]float64, len(parsedData.CPUs))
 	idles := make([]float64, len(parsedData.CPUs))
@@ -21,8 +173,10 @@ func GenerateReport(parsedData parser.ParsedData, outputFile string, reportTitle
 	nices := make([]float64, len(parsedData.CPUs))
 	steals := make([]float64, len(parsedData.CPUs))
 
+	cpuSinceBoot := make([]bool, len(parsedData.CPUs))
 	for i, cs := range parsedData.CPUs {
-		times[i] = cs.Timestamp.Format("15:04:05")
+		times[i] = cs.Timestamp
+		cpuSinceBoot[i] = cs.SinceBoot
 		users[i] = cs.User
 		systems[i] = cs.System
 		idles[i] = cs.Idle
@@ -31,7 +185,9 @@ func GenerateReport(parsedData parser.ParsedData, outputFile string, reportTitle
 		steals[i] = cs.Steal
 	}
 
+	cpuLine := newTimeSeries(times, parsedData.Segments, cfg.zone)
 	cpuOption := map[string]interface{}{
+		"useUTC":  true,
 		"tooltip": map[string]interface{}{"trigger": "axis"},
 		"legend":  map[string]interface{}{"data": []string{"User", "System", "Idle", "IOWait", "Nice", "Steal"}, "bottom": 0},
 		"toolbox": map[string]interface{}{
@@ -44,32 +200,57 @@ func GenerateReport(parsedData parser.ParsedData, outputFile string, reportTitle
 				"restore":     map[string]interface{}{},
 			},
 		},
-		"xAxis": map[string]interface{}{"type": "category", "data": times},
+		"xAxis": timeAxis(span),
 		"yAxis": map[string]interface{}{"type": "value", "name": "% CPU"},
 		"series": []map[string]interface{}{
-			{"name": "User", "type": "line", "data": users},
-			{"name": "System", "type": "line", "data": systems},
-			{"name": "Idle", "type": "line", "data": idles},
-			{"name": "IOWait", "type": "line", "data": iowaits},
-			{"name": "Nice", "type": "line", "data": nices},
-			{"name": "Steal", "type": "line", "data": steals},
+			{"name": "User", "type": "line", "data": cpuLine.data(users)},
+			{"name": "System", "type": "line", "data": cpuLine.data(systems)},
+			{"name": "Idle", "type": "line", "data": cpuLine.data(idles)},
+			{"name": "IOWait", "type": "line", "data": cpuLine.data(iowaits)},
+			{"name": "Nice", "type": "line", "data": cpuLine.data(nices)},
+			{"name": "Steal", "type": "line", "data": cpuLine.data(steals)},
 		},
 	}
 
+	if cfg.sinceBoot.shades() {
+		shadeSinceBoot(cpuOption, cpuLine.ms, cpuSinceBoot)
+	}
+	markBreaks(cpuOption, parsedData.Segments, cfg.zone)
+
+	// With a known CPU count, also show how many cores were busy
+	if cpuCount := parsedData.Host.CPUCount; cpuCount > 0 {
+		cores := make([]float64, len(parsedData.CPUs))
+		for i, cs := range parsedData.CPUs {
+			cores[i] = math.Round(parsedData.Host.CoresBusy(cs)*100) / 100
+		}
+		cpuOption["legend"] = map[string]interface{}{"data": []string{"User", "System", "Idle", "IOWait", "Nice", "Steal", "Cores Busy"}, "bottom": 0}
+		cpuOption["yAxis"] = []map[string]interface{}{
+			{"type": "value", "name": "% CPU"},
+			{
+				"type":      "value",
+				"name":      "Cores",
+				"position":  "right",
+				"min":       0,
+				"max":       cpuCount,
+				"splitLine": map[string]interface{}{"show": false},
+			},
+		}
+		cpuOption["series"] = append(cpuOption["series"].([]map[string]interface{}),
+			map[string]interface{}{"name": "Cores Busy", "type": "line", "data": cpuLine.data(cores), "yAxisIndex": 1})
+	}
+
 	cpuJSON, err := json.Marshal(cpuOption)
 	if err != nil {
-		return fmt.Errorf("failed to marshal CPU options: %w", err)
+		return section{}, fmt.Errorf("failed to marshal CPU options: %w", err)
 	}
 
 	// Build per-device charts
-	type DeviceChart struct {
-		DeviceName string
-		ChartID    string
-		OptionJSON template.JS
-	}
-
-	var deviceCharts []DeviceChart
-	for dev, stats := range parsedData.Devices {
+	entries := deviceEntries(parsedData.Devices, cfg)
+	var deviceCharts []deviceChart
+	var volumes []volumeRow
+	var volumeFindings []analysis.Finding
+	for _, entry := range entries {
+		dev, stats := entry.name, entry.stats
 		reqReads := make([]float64, len(stats))
 		reqWrites := make([]float64, len(stats))
 		kbReads := make([]float64, len(stats))
@@ -77,7 +258,19 @@ func GenerateReport(parsedData parser.ParsedData, outputFile string, reportTitle
 		latReads := make([]float64, len(stats))
 		latWrites := make([]float64, len(stats))
 		queueSizes := make([]float64, len(stats))
+		reqDiscards := make([]float64, len(stats))
+		reqFlushes := make([]float64, len(stats))
+		kbDiscards := make([]float64, len(stats))
+		latDiscards := make([]float64, len(stats))
+		latFlushes := make([]float64, len(stats))
+		utils := make([]float64, len(stats))
+		transfers := make([]float64, len(stats))
+		awaits := make([]float64, len(stats))
+		devSinceBoot := make([]bool, len(stats))
+		devTimes := make([]time.Time, len(stats))
 		for i, ds := range stats {
+			devTimes[i] = ds.Timestamp
+			devSinceBoot[i] = ds.SinceBoot
 			reqReads[i] = ds.ReadsPerSec
 			reqWrites[i] = ds.WritesPerSec
 			kbReads[i] = ds.ReadKBPerSec / 1024.0
@@ -85,19 +278,64 @@ func GenerateReport(parsedData parser.ParsedData, outputFile string, reportTitle
 			latReads[i] = ds.ReadAwaitMs
 			latWrites[i] = ds.WriteAwaitMs
 			queueSizes[i] = ds.QueueSize
+			reqDiscards[i] = ds.DiscardsPerSec
+			reqFlushes[i] = ds.FlushesPerSec
+			kbDiscards[i] = ds.DiscardKBPerSec / 1024.0
+			latDiscards[i] = ds.DiscardAwaitMs
+			latFlushes[i] = ds.FlushAwaitMs
+			utils[i] = ds.UtilPct
+			transfers[i] = ds.TransfersPerSec
+			awaits[i] = ds.AwaitMs
+		}
+		devLine := newTimeSeries(devTimes, parsedData.Segments, cfg.zone)
+
+		// sar -d only reports requests and latency across both directions
+		reqNames, reqVals := []string{"Read Req/s", "Write Req/s"}, [][]float64{reqReads, reqWrites}
+		latNames, latVals := []string{"Read Latency (ms)", "Write Latency (ms)"}, [][]float64{latReads, latWrites}
+		if combinedOnly(stats) {
+			reqNames, reqVals = []string{"Transfers/s"}, [][]float64{transfers}
+			latNames, latVals = []string{"Latency (ms)"}, [][]float64{awaits}
+		}
+
+		// A device on a cloud volume also charts its totals against the caps
+		kbVals := [][]float64{kbReads, kbWrites}
+		reqScale, kbScale := reqVals, kbVals
+		vol, onVolume := cfg.volumes[dev]
+		var limits cloud.Limits
+		var totalIOPS, totalMBps []float64
+		var sims []cloud.Simulation
+		if onVolume {
+			usage, err := h.Streamed.capUsage(dev, vol, stats, cfg.burstBalance)
+			if err != nil {
+				return section{}, fmt.Errorf("volume of %s: %w", dev, err)
+			}
+			if sims, err = simulateCredits(vol, stats, parsedData.Segments, cfg.burstBalance); err != nil {
+				return section{}, fmt.Errorf("volume of %s: %w", dev, err)
+			}
+			volumes = append(volumes, volumeRow{CapUsage: usage, Credits: creditRows(sims, cfg.zone)})
+			volumeFindings = append(volumeFindings, creditFindings(dev, vol, sims, cfg.zone)...)
+			limits = usage.Limits
+			totalIOPS, totalMBps = volumeTotals(stats)
+			reqNames, reqVals = append(reqNames, "Total Req/s"), append(reqVals, totalIOPS)
+			kbVals = append(kbVals, totalMBps)
+			// keep the caps in view
+			reqScale = append(reqVals, []float64{limits.MaxIOPS()})
+			kbScale = append(kbVals, []float64{limits.MaxThroughputMBps()})
 		}
+
 		const numSplits = 5
 		// Compute min, max, and interval for each axis group with 5 splits
-		reqMin, reqMax, reqInterval := CalcScale(numSplits, reqReads, reqWrites)
-		kbMin, kbMax, kbInterval := CalcScale(numSplits, kbReads, kbWrites)
-		latMin, latMax, latInterval := CalcScale(numSplits, latReads, latWrites)
+		reqMin, reqMax, reqInterval := CalcScale(numSplits, reqScale...)
+		kbMin, kbMax, kbInterval := CalcScale(numSplits, kbScale...)
+		latMin, latMax, latInterval := CalcScale(numSplits, latVals...)
 		qMin, qMax, qInterval := CalcScale(numSplits, queueSizes)
-		chartID := "dev_" + strings.ReplaceAll(dev, "-", "_") + "_chart"
+		chartID := prefix + "dev_" + strings.ReplaceAll(dev, "-", "_") + "_chart"
 		option := map[string]interface{}{
+			"useUTC":  true,
 			"grid":    map[string]interface{}{"containLabel": true},
 			"tooltip": map[string]interface{}{"trigger": "axis"},
 			"legend": map[string]interface{}{
-				"data":   []string{"Read Req/s", "Write Req/s", "Read MB/s", "Write MB/s", "Read Latency (ms)", "Write Latency (ms)", "Queue Size"},
+				"data":   append(append(append(reqNames, mbNames(onVolume)...), latNames...), "Queue Size"),
 				"bottom": 0,
 			},
 			"toolbox": map[string]interface{}{
@@ -110,7 +348,7 @@ func GenerateReport(parsedData parser.ParsedData, outputFile string, reportTitle
 					"restore":     map[string]interface{}{},
 				},
 			},
-			"xAxis": map[string]interface{}{"type": "category", "data": times},
+			"xAxis": timeAxis(span),
 			"yAxis": []map[string]interface{}{
 				{
 					"type":      "value",
@@ -156,78 +394,276 @@ func GenerateReport(parsedData parser.ParsedData, outputFile string, reportTitle
 					"axisLabel": map[string]interface{}{"formatter": "{value}"},
 				},
 			},
+		}
+		var series []map[string]interface{}
+		for i, name := range reqNames {
+			series = append(series, map[string]interface{}{"name": name, "type": "line", "data": devLine.data(reqVals[i]), "yAxisIndex": 0})
+		}
+		series = append(series,
+			map[string]interface{}{"name": "Read MB/s", "type": "line", "data": devLine.data(kbReads), "yAxisIndex": 1},
+			map[string]interface{}{"name": "Write MB/s", "type": "line", "data": devLine.data(kbWrites), "yAxisIndex": 1},
+		)
+		if onVolume {
+			series[len(reqNames)-1]["markLine"] = limitLines(limits.IOPS, limits.BurstIOPS, "IOPS")
+			series = append(series, map[string]interface{}{"name": "Total MB/s", "type": "line", "data": devLine.data(totalMBps), "yAxisIndex": 1,
+				"markLine": limitLines(limits.ThroughputMBps, limits.BurstThroughputMBps, "MiB/s")})
+		}
+		for i, name := range latNames {
+			series = append(series, map[string]interface{}{"name": name, "type": "line", "data": devLine.data(latVals[i]), "yAxisIndex": 2})
+		}
+		option["series"] = append(series,
+			map[string]interface{}{"name": "Queue Size", "type": "line", "data": devLine.data(queueSizes), "yAxisIndex": 3})
+
+		if cfg.sinceBoot.shades() {
+			shadeSinceBoot(option, devLine.ms, devSinceBoot)
+		}
+		markBreaks(option, parsedData.Segments, cfg.zone)
+
+		js, err := json.Marshal(option)
+		if err != nil {
+			return section{}, fmt.Errorf("failed to marshal device chart for %s: %w", dev, err)
+		}
+
+		// Discard, flush and utilisation get their own chart so the main one stays readable
+		dReqMin, dReqMax, dReqInterval := CalcScale(numSplits, reqDiscards, reqFlushes)
+		dKbMin, dKbMax, dKbInterval := CalcScale(numSplits, kbDiscards)
+		dLatMin, dLatMax, dLatInterval := CalcScale(numSplits, latDiscards, latFlushes)
+		utilChartID := prefix + "dev_" + strings.ReplaceAll(dev, "-", "_") + "_util_chart"
+		utilOption := map[string]interface{}{
+			"useUTC":  true,
+			"grid":    map[string]interface{}{"containLabel": true},
+			"tooltip": map[string]interface{}{"trigger": "axis"},
+			"legend": map[string]interface{}{
+				"data":   []string{"Discard Req/s", "Flush Req/s", "Discard MB/s", "Discard Latency (ms)", "Flush Latency (ms)", "% Util"},
+				"bottom": 0,
+			},
+			"toolbox": map[string]interface{}{
+				"show": true,
+				"top":  -7,
+				"feature": map[string]interface{}{
+					"saveAsImage": map[string]interface{}{},
+					"dataZoom":    map[string]interface{}{},
+					"dataView":    map[string]interface{}{"readOnly": false},
+					"restore":     map[string]interface{}{},
+				},
+			},
+			"xAxis": timeAxis(span),
+			"yAxis": []map[string]interface{}{
+				{
+					"type":      "value",
+					"name":      "Req/s",
+					"position":  "left",
+					"min":       dReqMin,
+					"max":       dReqMax,
+					"interval":  dReqInterval,
+					"splitLine": map[string]interface{}{"show": true},
+					"axisLabel": map[string]interface{}{"formatter": "{value} req/s"},
+				},
+				{
+					"type":      "value",
+					"name":      "MB/s",
+					"position":  "left",
+					"offset":    80,
+					"min":       dKbMin,
+					"max":       dKbMax,
+					"interval":  dKbInterval,
+					"splitLine": map[string]interface{}{"show": true},
+					"axisLabel": map[string]interface{}{"formatter": "{value} MB/s"},
+				},
+				{
+					"type":      "value",
+					"name":      "ms",
+					"position":  "right",
+					"offset":    40,
+					"min":       dLatMin,
+					"max":       dLatMax,
+					"interval":  dLatInterval,
+					"splitLine": map[string]interface{}{"show": true},
+					"axisLabel": map[string]interface{}{"formatter": "{value} ms"},
+				},
+				{
+					"type":      "value",
+					"name":      "% Util",
+					"position":  "right",
+					"offset":    120,
+					"min":       0,
+					"max":       100,
+					"interval":  20,
+					"splitLine": map[string]interface{}{"show": true},
+					"axisLabel": map[string]interface{}{"formatter": "{value} %"},
+				},
+			},
 			"series": []map[string]interface{}{
-				{"name": "Read Req/s", "type": "line", "data": reqReads, "yAxisIndex": 0},
-				{"name": "Write Req/s", "type": "line", "data": reqWrites, "yAxisIndex": 0},
-				{"name": "Read MB/s", "type": "line", "data": kbReads, "yAxisIndex": 1},
-				{"name": "Write MB/s", "type": "line", "data": kbWrites, "yAxisIndex": 1},
-				{"name": "Read Latency (ms)", "type": "line", "data": latReads, "yAxisIndex": 2},
-				{"name": "Write Latency (ms)", "type": "line", "data": latWrites, "yAxisIndex": 2},
-				{"name": "Queue Size", "type": "line", "data": queueSizes, "yAxisIndex": 3},
+				{"name": "Discard Req/s", "type": "line", "data": devLine.data(reqDiscards), "yAxisIndex": 0},
+				{"name": "Flush Req/s", "type": "line", "data": devLine.data(reqFlushes), "yAxisIndex": 0},
+				{"name": "Discard MB/s", "type": "line", "data": devLine.data(kbDiscards), "yAxisIndex": 1},
+				{"name": "Discard Latency (ms)", "type": "line", "data": devLine.data(latDiscards), "yAxisIndex": 2},
+				{"name": "Flush Latency (ms)", "type": "line", "data": devLine.data(latFlushes), "yAxisIndex": 2},
+				{"name": "% Util", "type": "line", "data": devLine.data(utils), "yAxisIndex": 3},
 			},
 		}
 
-		js, err := json.Marshal(option)
+		if cfg.sinceBoot.shades() {
+			shadeSinceBoot(utilOption, devLine.ms, devSinceBoot)
+		}
+		markBreaks(utilOption, parsedData.Segments, cfg.zone)
+
+		utilJS, err := json.Marshal(utilOption)
 		if err != nil {
-			return fmt.Errorf("failed to marshal device chart for %s: %w", dev, err)
+			return section{}, fmt.Errorf("failed to marshal utilisation chart for %s: %w", dev, err)
 		}
 
-		deviceCharts = append(deviceCharts, DeviceChart{
-			DeviceName: dev,
-			ChartID:    chartID,
-			OptionJSON: template.JS(js), // #nosec G203
-		})
-	}
+		// a device rolled up from others was never streamed on its own
+		metrics, ok := h.Streamed.device(dev)
+		if _, captured := parsedData.Devices[dev]; !ok || !captured {
+			metrics, _ = analysis.DeviceStatisticsOf(map[string][]parser.DeviceStats{dev: stats}).Device(dev)
+		}
 
-	// Render the template
-	templatePath := "templates/report.html"
-	if _, statErr := os.Stat(templatePath); os.IsNotExist(statErr) {
-		templatePath = filepath.Join("..", "templates", "report.html")
-	}
-	tmpl, err := template.New("report.html").Funcs(template.FuncMap{
-		"safeJS": func(s string) template.JS { return template.JS(s) }, // #nosec G203
-		"abbr": func(s string) string {
-			if len(s) > 6 {
-				return s[:6] + ".."
+		var creditsID string
+		var creditsJS template.JS
+		if len(sims) > 0 {
+			creditsID = prefix + "dev_" + strings.ReplaceAll(dev, "-", "_") + "_credits_chart"
+			if creditsJS, err = creditsChart(sims, parsedData.Segments, cfg, span); err != nil {
+				return section{}, fmt.Errorf("credits chart of %s: %w", dev, err)
 			}
-			return s
-		},
-	}).ParseFiles(templatePath)
-	if err != nil {
-		return fmt.Errorf("failed to parse template %q: %w", templatePath, err)
+		}
+
+		deviceCharts = append(deviceCharts, deviceChart{
+			DeviceName:        dev,
+			Depth:             entry.depth,
+			Note:              entry.note,
+			Stats:             metrics,
+			Volume:            vol.String(),
+			CreditsChartID:    creditsID,
+			CreditsOptionJSON: creditsJS,
+			ChartID:           chartID,
+			OptionJSON:        template.JS(js), // #nosec G203
+			UtilChartID:       utilChartID,
+			UtilOptionJSON:    template.JS(utilJS), // #nosec G203
+		})
 	}
 
-	data := struct {
-		Title        string
-		Metadata     string
-		FileName     string
-		FileHash     string
-		Version      string
-		CpuOption    template.JS
-		DeviceCharts []DeviceChart
-	}{
-		Title:        reportTitle,
-		Metadata:     metadata,
-		FileName:     fileName,
-		FileHash:     fileHash,
-		Version:      version,
+	sec := section{
+		ID:           prefix,
+		Name:         h.Name,
+		Host:         parsedData.Host,
+		Interval:     parsedData.Interval,
+		CPUChartID:   prefix + "cpuChart",
 		CpuOption:    template.JS(cpuJSON), // #nosec G203
 		DeviceCharts: deviceCharts,
+		Volumes:      volumes,
+		WarningCount: len(parsedData.Warnings),
 	}
 
-	f, err := os.Create(outputFile) // #nosec G304
+	// The summary and findings cover the devices as charted, so a rollup counts each I/O once
+	summaryData := parsedData
+	summaryData.Devices = make(map[string][]parser.DeviceStats, len(entries))
+	for _, e := range entries {
+		summaryData.Devices[e.name] = e.stats
+	}
+	sec.Summary = h.Streamed.summarize(summaryData, parsedData.Devices, cfg.thresholds)
+	if len(parsedData.VM) > 0 {
+		sec.VMChartID, sec.MemChartID = prefix+"vmChart", prefix+"memChart"
+		if sec.VMOption, sec.MemOption, err = vmCharts(parsedData, cfg, span); err != nil {
+			return section{}, err
+		}
+	}
+	if len(parsedData.Cores) > 0 {
+		sec.CoresChartID = prefix + "coresChart"
+		if sec.CoresOption, err = coresChart(parsedData, cfg, span); err != nil {
+			return section{}, err
+		}
+	}
+
+	findings, err := h.Streamed.evaluate(summaryData, cfg.rules)
 	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
+		return section{}, fmt.Errorf("failed to evaluate rules: %w", err)
 	}
-	defer func() {
-		if cerr := f.Close(); cerr != nil {
-			fmt.Fprintf(os.Stderr, "warning: failed to close output file: %v\n", cerr)
+	// burst buckets running empty come first, they explain the rest
+	sec.Findings = sectionFindings(append(volumeFindings, findings...), sec, parsedData.Interval, cfg.zone)
+
+	// Only the first warnings are listed, a long tail adds nothing
+	sec.Warnings = parsedData.Warnings
+	if len(sec.Warnings) > maxListedWarnings {
+		sec.Warnings = sec.Warnings[:maxListedWarnings]
+	}
+
+	// Describe each break in the timeline for the header
+	for _, seg := range parsedData.Segments {
+		if seg.Break != parser.BreakNone {
+			sec.Breaks = append(sec.Breaks, fmt.Sprintf("%s at %s", breakLabel(seg), displayTime(seg.Start, cfg.zone)))
 		}
-	}()
+	}
+	return sec, nil
+}
 
-	if err := tmpl.Execute(f, data); err != nil {
-		return fmt.Errorf("failed to execute template: %w", err)
+// withoutSinceBoot returns a copy of data without since-boot samples, keeping
+// those that may be regular ones when keepUnsure is set.
+func withoutSinceBoot(data parser.ParsedData, keepUnsure bool) parser.ParsedData {
+	out := data
+	out.CPUs = nil
+	for _, cs := range data.CPUs {
+		if !cs.SinceBoot || keepUnsure && cs.SinceBootUnsure {
+			out.CPUs = append(out.CPUs, cs)
+		}
+	}
+	out.Devices = make(map[string][]parser.DeviceStats, len(data.Devices))
+	for dev, stats := range data.Devices {
+		var kept []parser.DeviceStats
+		for _, ds := range stats {
+			if !ds.SinceBoot || keepUnsure && ds.SinceBootUnsure {
+				kept = append(kept, ds)
+			}
+		}
+		out.Devices[dev] = kept
+	}
+	out.VM = nil
+	for _, vm := range data.VM {
+		if !vm.SinceBoot {
+			out.VM = append(out.VM, vm)
+		}
 	}
+	return out
+}
 
-	return nil
+// shadeSinceBoot adds a grey band over each since-boot sample to the first
+// series of a chart option.
+func shadeSinceBoot(option map[string]interface{}, times []int64, sinceBoot []bool) {
+	var areas [][]map[string]interface{}
+	for i, sb := range sinceBoot {
+		if !sb || i >= len(times) {
+			continue
+		}
+		end := times[i]
+		if i+1 < len(times) {
+			end = times[i+1]
+		}
+		areas = append(areas, []map[string]interface{}{
+			{"name": "since boot", "xAxis": times[i]},
+			{"xAxis": end},
+		})
+	}
+	series, ok := option["series"].([]map[string]interface{})
+	if len(areas) == 0 || !ok || len(series) == 0 {
+		return
+	}
+	series[0]["markArea"] = map[string]interface{}{
+		"silent":    true,
+		"itemStyle": map[string]interface{}{"color": "rgba(128, 128, 128, 0.2)"},
+		"label":     map[string]interface{}{"position": "insideTop", "color": "#666"},
+		"data":      areas,
+	}
+}
+
+// combinedOnly reports whether stats carry request rates and latency only
+// across all directions, as sar -d and iostat without -x print them.
+func combinedOnly(stats []parser.DeviceStats) bool {
+	combined := false
+	for _, ds := range stats {
+		if ds.ReadsPerSec > 0 || ds.WritesPerSec > 0 || ds.DiscardsPerSec > 0 || ds.FlushesPerSec > 0 {
+			return false
+		}
+		combined = combined || ds.TransfersPerSec > 0
+	}
+	return combined
 }
@@ -9,6 +9,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/rsvihladremio/iostat-reporter/analysis"
+	"github.com/rsvihladremio/iostat-reporter/cloud"
 	"github.com/rsvihladremio/iostat-reporter/parser"
 )
 
@@ -22,13 +24,46 @@ func makeDummyParsedData() parser.ParsedData {
 		},
 		Devices: map[string][]parser.DeviceStats{
 			"sda": {
-				{Timestamp: now, ReadsPerSec: 1, WritesPerSec: 2, ReadKBPerSec: 1024, WriteKBPerSec: 2048, ReadAwaitMs: 0.5, WriteAwaitMs: 1.5, QueueSize: 0.1},
-				{Timestamp: now.Add(time.Second), ReadsPerSec: 3, WritesPerSec: 4, ReadKBPerSec: 512, WriteKBPerSec: 1024, ReadAwaitMs: 0.2, WriteAwaitMs: 0.8, QueueSize: 0.05},
+				{Timestamp: now, ReadsPerSec: 1, WritesPerSec: 2, ReadKBPerSec: 1024, WriteKBPerSec: 2048, ReadAwaitMs: 0.5, WriteAwaitMs: 1.5, QueueSize: 0.1, DiscardKBPerSec: 2048, UtilPct: 12.5},
+				{Timestamp: now.Add(time.Second), ReadsPerSec: 3, WritesPerSec: 4, ReadKBPerSec: 512, WriteKBPerSec: 1024, ReadAwaitMs: 0.2, WriteAwaitMs: 0.8, QueueSize: 0.05, DiscardKBPerSec: 0, UtilPct: 40},
 			},
 		},
 	}
 }
 
+// pointValue returns the value of a [timestamp, value] series point.
+func pointValue(p interface{}) (float64, bool) {
+	pair, ok := p.([]interface{})
+	if !ok || len(pair) != 2 {
+		return 0, false
+	}
+	v, ok := pair[1].(float64)
+	return v, ok
+}
+
+// seriesData extracts the data of the named series from the first chart
+// option following marker in html.
+func seriesData(t *testing.T, html, marker, name string) []interface{} {
+	t.Helper()
+	parts := strings.Split(html, marker)
+	if len(parts) < 2 {
+		t.Fatalf("cannot locate %s", marker)
+	}
+	raw := strings.SplitN(parts[1], ");", 2)[0]
+	var opt map[string]interface{}
+	if err := json.Unmarshal([]byte(strings.TrimSuffix(raw, "\n")), &opt); err != nil {
+		t.Fatalf("chart JSON invalid: %v", err)
+	}
+	for _, s := range opt["series"].([]interface{}) {
+		m := s.(map[string]interface{})
+		if m["name"] == name {
+			return m["data"].([]interface{})
+		}
+	}
+	t.Fatalf("no series %q", name)
+	return nil
+}
+
 func TestGenerateReport_Minimal(t *testing.T) {
 	parsed := makeDummyParsedData()
 	dir := t.TempDir()
@@ -156,10 +191,10 @@ func TestGenerateReport_MultipleDevices(t *testing.T) {
 		t.Error("expected both sda and sdb in output")
 	}
 
-	// There should be one CPU chart, two device charts, and the emphasis call -> 4 setOption calls
+	// There should be one CPU chart, two charts per device, the emphasis and tooltip calls, and the findings zoom -> 8 setOption calls
 	count := strings.Count(html, ".setOption(")
-	if count != 4 {
-		t.Errorf("expected 4 setOption calls, got %d", count)
+	if count != 8 {
+		t.Errorf("expected 8 setOption calls, got %d", count)
 	}
 }
 
@@ -229,8 +264,8 @@ func TestGenerateReport_DeviceMBConversion(t *testing.T) {
 		t.Fatalf("third series data not a slice")
 	}
 	// First sample: 1024KB → 1MB; second: 512KB → 0.5MB
-	first, ok0 := dataArr[0].(float64)
-	sec, ok1 := dataArr[1].(float64)
+	first, ok0 := pointValue(dataArr[0])
+	sec, ok1 := pointValue(dataArr[1])
 	if !ok0 || !ok1 {
 		t.Fatalf("unexpected data types: %#v", dataArr)
 	}
@@ -241,3 +276,780 @@ func TestGenerateReport_DeviceMBConversion(t *testing.T) {
 		t.Errorf("expected second MB value ~0.5, got %v", sec)
 	}
 }
+
+// TestGenerateReport_CombinedRequests charts tps and await for devices from
+// sar -d, which does not split reads from writes.
+func TestGenerateReport_CombinedRequests(t *testing.T) {
+	parsed := makeDummyParsedData()
+	for i := range parsed.Devices["sda"] {
+		ds := &parsed.Devices["sda"][i]
+		ds.ReadsPerSec, ds.WritesPerSec, ds.ReadAwaitMs, ds.WriteAwaitMs = 0, 0, 0, 0
+		ds.TransfersPerSec, ds.AwaitMs = float64(10*(i+1)), 2.5
+	}
+	out := filepath.Join(t.TempDir(), "sar.html")
+	if err := GenerateReport(parsed, out, "Sar", "", "sar.txt", "hashhash", ""); err != nil {
+		t.Fatalf("GenerateReport failed: %v", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	html := string(data)
+
+	tps := seriesData(t, html, "c.setOption(", "Transfers/s")
+	if v, ok := pointValue(tps[1]); !ok || v != 20 {
+		t.Errorf("expected 20 transfers/s, got %v", tps[1])
+	}
+	await := seriesData(t, html, "c.setOption(", "Latency (ms)")
+	if v, ok := pointValue(await[0]); !ok || v != 2.5 {
+		t.Errorf("expected 2.5 ms await, got %v", await[0])
+	}
+	if strings.Contains(html, `"Read Req/s"`) {
+		t.Error("expected no read/write split for combined-only data")
+	}
+}
+
+// TestGenerateReport_DeviceUtilChart verifies the discard/flush/%util chart series.
+func TestGenerateReport_DeviceUtilChart(t *testing.T) {
+	parsed := makeDummyParsedData()
+	dir := t.TempDir()
+	out := filepath.Join(dir, "util.html")
+
+	if err := GenerateReport(parsed, out, "Util", "", "util.log", "hashhash", ""); err != nil {
+		t.Fatalf("GenerateReport failed: %v", err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	html := string(data)
+
+	parts := strings.Split(html, "u.setOption(")
+	if len(parts) < 2 {
+		t.Fatal("cannot locate utilisation JSON")
+	}
+	raw := strings.SplitN(parts[1], ");", 2)[0]
+	var opt map[string]interface{}
+	if err := json.Unmarshal([]byte(strings.TrimSuffix(raw, "\n")), &opt); err != nil {
+		t.Fatalf("utilisation JSON invalid: %v", err)
+	}
+	series, ok := opt["series"].([]interface{})
+	if !ok || len(series) != 6 {
+		t.Fatalf("unexpected series: %#v", opt["series"])
+	}
+	want := map[string][]float64{
+		"Discard MB/s": {2, 0},
+		"% Util":       {12.5, 40},
+	}
+	for _, s := range series {
+		m := s.(map[string]interface{})
+		exp, ok := want[m["name"].(string)]
+		if !ok {
+			continue
+		}
+		dataArr := m["data"].([]interface{})
+		for i, v := range exp {
+			if got, _ := pointValue(dataArr[i]); math.Abs(got-v) > 1e-9 {
+				t.Errorf("%s[%d] = %v; want %v", m["name"], i, got, v)
+			}
+		}
+		delete(want, m["name"].(string))
+	}
+	if len(want) != 0 {
+		t.Errorf("missing series: %v", want)
+	}
+}
+
+// TestGenerateReport_ParseWarnings lists lenient-mode warnings in their own panel.
+func TestGenerateReport_ParseWarnings(t *testing.T) {
+	parsed := makeDummyParsedData()
+	dir := t.TempDir()
+
+	out := filepath.Join(dir, "clean.html")
+	if err := GenerateReport(parsed, out, "Clean", "", "f.log", "hashhash", ""); err != nil {
+		t.Fatalf("GenerateReport failed: %v", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	if strings.Contains(string(data), "Parse warnings") {
+		t.Error("did not expect a warnings panel without warnings")
+	}
+
+	for i := 0; i < maxListedWarnings+5; i++ {
+		parsed.Warnings = append(parsed.Warnings, parser.ParseError{
+			Line: 42 + i, Text: "sda 1.00 abc", Reason: parser.ErrNonNumeric, Detail: `column w/s has "abc"`,
+		})
+	}
+	out = filepath.Join(dir, "warn.html")
+	if err := GenerateReport(parsed, out, "Warn", "", "f.log", "hashhash", ""); err != nil {
+		t.Fatalf("GenerateReport failed: %v", err)
+	}
+	data, err = os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	html := string(data)
+	if !strings.Contains(html, "Parse warnings") {
+		t.Fatal("expected a warnings panel")
+	}
+	for _, want := range []string{"<td>42</td>", "non-numeric field", "sda 1.00 abc", "Showing the first 100"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("expected %q in warnings panel", want)
+		}
+	}
+	if strings.Contains(html, "<td>142</td>") {
+		t.Error("expected the warnings list to be capped")
+	}
+}
+
+// TestGenerateReport_HostInfo shows the banner details and a cores-busy series.
+func TestGenerateReport_HostInfo(t *testing.T) {
+	parsed := makeDummyParsedData()
+	parsed.Host = parser.HostInfo{
+		OS: "Linux", Kernel: "5.15.0-1051-aws", Hostname: "ip-10-0-1-5", Arch: "x86_64", CPUCount: 16,
+		Date: time.Date(2024, 9, 4, 0, 0, 0, 0, time.UTC),
+	}
+	dir := t.TempDir()
+	out := filepath.Join(dir, "host.html")
+
+	if err := GenerateReport(parsed, out, "Host", "", "f.log", "hashhash", ""); err != nil {
+		t.Fatalf("GenerateReport failed: %v", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	html := string(data)
+	for _, want := range []string{"Host: ip-10-0-1-5", "Kernel: Linux 5.15.0-1051-aws", "Arch: x86_64", "CPUs: 16", "Captured: 2024-09-04"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("expected %q in header", want)
+		}
+	}
+
+	parts := strings.Split(html, "cpuChart.setOption(")
+	if len(parts) < 2 {
+		t.Fatal("cannot locate CPU JSON")
+	}
+	raw := strings.SplitN(parts[1], ");", 2)[0]
+	var cpuOpt map[string]interface{}
+	if err := json.Unmarshal([]byte(strings.TrimSuffix(raw, "\n")), &cpuOpt); err != nil {
+		t.Fatalf("CPU JSON invalid: %v", err)
+	}
+	series := cpuOpt["series"].([]interface{})
+	cores := series[len(series)-1].(map[string]interface{})
+	if cores["name"] != "Cores Busy" {
+		t.Fatalf("expected Cores Busy series last, got %v", cores["name"])
+	}
+	// 15% and 22% busy of 16 CPUs
+	vals := cores["data"].([]interface{})
+	if v0, _ := pointValue(vals[0]); v0 != 2.4 {
+		t.Errorf("unexpected cores busy: %v", vals)
+	}
+	if v1, _ := pointValue(vals[1]); v1 != 3.52 {
+		t.Errorf("unexpected cores busy: %v", vals)
+	}
+}
+
+// TestGenerateReport_SinceBoot checks each way of showing the since-boot sample.
+func TestGenerateReport_SinceBoot(t *testing.T) {
+	cpuOption := func(t *testing.T, unsure bool, opts ...Option) map[string]interface{} {
+		parsed := makeDummyParsedData()
+		parsed.CPUs[0].SinceBoot, parsed.CPUs[0].SinceBootUnsure = true, unsure
+		parsed.Devices["sda"][0].SinceBoot, parsed.Devices["sda"][0].SinceBootUnsure = true, unsure
+		out := filepath.Join(t.TempDir(), "boot.html")
+		if err := GenerateReport(parsed, out, "Boot", "", "f.log", "hashhash", "", opts...); err != nil {
+			t.Fatalf("GenerateReport failed: %v", err)
+		}
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatalf("reading output: %v", err)
+		}
+		raw := strings.SplitN(strings.Split(string(data), "cpuChart.setOption(")[1], ");", 2)[0]
+		var opt map[string]interface{}
+		if err := json.Unmarshal([]byte(strings.TrimSuffix(raw, "\n")), &opt); err != nil {
+			t.Fatalf("CPU JSON invalid: %v", err)
+		}
+		return opt
+	}
+	xLen := func(opt map[string]interface{}) int {
+		return len(opt["series"].([]interface{})[0].(map[string]interface{})["data"].([]interface{}))
+	}
+	markArea := func(opt map[string]interface{}) interface{} {
+		return opt["series"].([]interface{})[0].(map[string]interface{})["markArea"]
+	}
+
+	t.Run("default excludes", func(t *testing.T) {
+		opt := cpuOption(t, false)
+		if xLen(opt) != 1 {
+			t.Errorf("expected the since-boot sample to be dropped, got %d samples", xLen(opt))
+		}
+	})
+	t.Run("default shades an unsure one", func(t *testing.T) {
+		opt := cpuOption(t, true)
+		if xLen(opt) != 2 || markArea(opt) == nil {
+			t.Errorf("expected both samples with the first shaded, got %d", xLen(opt))
+		}
+	})
+	t.Run("exclude drops an unsure one", func(t *testing.T) {
+		opt := cpuOption(t, true, WithSinceBoot(SinceBootExclude))
+		if xLen(opt) != 1 {
+			t.Errorf("expected the since-boot sample to be dropped, got %d samples", xLen(opt))
+		}
+	})
+	t.Run("shade", func(t *testing.T) {
+		opt := cpuOption(t, false, WithSinceBoot(SinceBootShade))
+		if xLen(opt) != 2 {
+			t.Errorf("expected both samples, got %d", xLen(opt))
+		}
+		if markArea(opt) == nil {
+			t.Error("expected a shaded area for the since-boot sample")
+		}
+	})
+	t.Run("include", func(t *testing.T) {
+		opt := cpuOption(t, false, WithSinceBoot(SinceBootInclude))
+		if xLen(opt) != 2 || markArea(opt) != nil {
+			t.Error("expected both samples without shading")
+		}
+	})
+}
+
+// TestGenerateReport_TimelineBreaks plots real timestamps and breaks the lines between segments.
+func TestGenerateReport_TimelineBreaks(t *testing.T) {
+	parsed := makeDummyParsedData()
+	start := parsed.CPUs[0].Timestamp
+	resumed := start.Add(10 * time.Minute)
+	parsed.CPUs[1].Timestamp = resumed
+	parsed.Devices["sda"][1].Timestamp = resumed
+	parsed.Interval = time.Second
+	parsed.Segments = []parser.Segment{
+		{Start: start, End: start, Samples: 1, Interval: time.Second},
+		{Start: resumed, End: resumed, Samples: 1, Interval: time.Second, Break: parser.BreakGap, Gap: 10 * time.Minute},
+	}
+	out := filepath.Join(t.TempDir(), "breaks.html")
+	if err := GenerateReport(parsed, out, "Breaks", "", "f.log", "hashhash", ""); err != nil {
+		t.Fatalf("GenerateReport failed: %v", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	html := string(data)
+
+	for _, want := range []string{"Interval: 1s", "1 break(s) in the timeline", "gap 10m0s at 2023-01-01 12:10:00"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("expected %q in header", want)
+		}
+	}
+
+	for _, chart := range []struct{ marker, series string }{
+		{"cpuChart.setOption(", "User"},
+		{"c.setOption(", "Read Req/s"},
+		{"u.setOption(", "% Util"},
+	} {
+		points := seriesData(t, html, chart.marker, chart.series)
+		if len(points) != 3 {
+			t.Fatalf("%s: expected 2 points and a break, got %v", chart.series, points)
+		}
+		first := points[0].([]interface{})
+		gap := points[1].([]interface{})
+		if first[0].(float64) != float64(start.UnixMilli()) {
+			t.Errorf("%s: expected epoch milliseconds on the x axis, got %v", chart.series, first[0])
+		}
+		if gap[0].(float64) != float64(resumed.UnixMilli()) || gap[1] != nil {
+			t.Errorf("%s: expected a null point at the break, got %v", chart.series, gap)
+		}
+	}
+}
+
+// TestGenerateReport_VMAndCores adds the vmstat and mpstat sections on the
+// x axis of the iostat charts, and leaves them out without that data.
+func TestGenerateReport_VMAndCores(t *testing.T) {
+	render := func(t *testing.T, parsed parser.ParsedData) string {
+		out := filepath.Join(t.TempDir(), "vm.html")
+		if err := GenerateReport(parsed, out, "VM", "", "f.log", "hashhash", ""); err != nil {
+			t.Fatalf("GenerateReport failed: %v", err)
+		}
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatalf("reading output: %v", err)
+		}
+		return string(data)
+	}
+	chartOption := func(t *testing.T, html, marker string) map[string]interface{} {
+		raw := strings.SplitN(strings.Split(html, marker)[1], ");", 2)[0]
+		var opt map[string]interface{}
+		if err := json.Unmarshal([]byte(strings.TrimSuffix(raw, "\n")), &opt); err != nil {
+			t.Fatalf("chart JSON invalid: %v", err)
+		}
+		return opt
+	}
+
+	if html := render(t, makeDummyParsedData()); strings.Contains(html, "vmChart") || strings.Contains(html, "coresChart") {
+		t.Error("expected no vmstat or per-CPU charts without that data")
+	}
+
+	parsed := makeDummyParsedData()
+	start := parsed.CPUs[0].Timestamp
+	parsed.Host.CPUCount = 2
+	parsed.VM = []parser.VMStats{
+		{Timestamp: start.Add(-time.Second), Running: 1, SinceBoot: true},
+		{Timestamp: start, Running: 3, Blocked: 2, FreeKB: 2048, SwapInKBPerSec: 1024},
+	}
+	parsed.Cores = map[string][]parser.CoreStats{
+		"10": {{Timestamp: start, CPU: "10", User: 90, System: 5}},
+		"2":  {{Timestamp: start, CPU: "2", User: 10}},
+	}
+	html := render(t, parsed)
+
+	for _, want := range []string{`id="vmChart"`, `id="memChart"`, `id="coresChart"`, "Run Queue &amp; Blocked Processes", "Per-CPU Usage"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("expected %q in output", want)
+		}
+	}
+	// the since-boot report is excluded as for iostat
+	if running := seriesData(t, html, "vmChart.setOption(", "Running"); len(running) != 1 {
+		t.Errorf("expected the since-boot vmstat row to be dropped, got %v", running)
+	}
+	if free, _ := pointValue(seriesData(t, html, "memChart.setOption(", "Free MB")[0]); free != 2 {
+		t.Errorf("expected 2 MB free, got %v", free)
+	}
+	if busy, _ := pointValue(seriesData(t, html, "coresChart.setOption(", "CPU 10")[0]); busy != 95 {
+		t.Errorf("expected CPU 10 95%% busy, got %v", busy)
+	}
+	cores := chartOption(t, html, "coresChart.setOption(")
+	if names := cores["legend"].(map[string]interface{})["data"].([]interface{}); names[0] != "CPU 2" || names[1] != "CPU 10" {
+		t.Errorf("expected CPUs in numeric order, got %v", names)
+	}
+
+	// every chart of the host spans the same time
+	end := float64(start.Add(time.Second).UnixMilli())
+	for _, marker := range []string{"cpuChart.setOption(", "vmChart.setOption(", "memChart.setOption(", "coresChart.setOption(", "c.setOption("} {
+		axis := chartOption(t, html, marker)["xAxis"].(map[string]interface{})
+		if axis["min"] != float64(start.UnixMilli()) || axis["max"] != end {
+			t.Errorf("%s: expected the x axis to span the capture, got %v to %v", marker, axis["min"], axis["max"])
+		}
+	}
+}
+
+// TestGenerateReport_Timezone shows times as captured by default, or in the chosen zone.
+func TestGenerateReport_Timezone(t *testing.T) {
+	firstPoint := func(t *testing.T, opts ...Option) (float64, string) {
+		parsed := makeDummyParsedData()
+		// captured with S_TIME_FORMAT=ISO on a host two hours ahead of UTC
+		parsed.CPUs[0].Timestamp = time.Date(2023, 1, 1, 23, 59, 59, 0, time.FixedZone("", 2*3600))
+		out := filepath.Join(t.TempDir(), "tz.html")
+		if err := GenerateReport(parsed, out, "TZ", "", "f.log", "hashhash", "", opts...); err != nil {
+			t.Fatalf("GenerateReport failed: %v", err)
+		}
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatalf("reading output: %v", err)
+		}
+		html := string(data)
+		if !strings.Contains(html, `"useUTC":true`) {
+			t.Error("expected charts to render in UTC")
+		}
+		return seriesData(t, html, "cpuChart.setOption(", "User")[0].([]interface{})[0].(float64), html
+	}
+
+	ms, html := firstPoint(t)
+	if want := time.Date(2023, 1, 1, 23, 59, 59, 0, time.UTC).UnixMilli(); ms != float64(want) {
+		t.Errorf("expected the captured wall clock, got %v", time.UnixMilli(int64(ms)).UTC())
+	}
+	if !strings.Contains(html, "Times: as captured") {
+		t.Error("expected the timezone in the header")
+	}
+
+	loc, err := ParseTimezone("-05:00")
+	if err != nil {
+		t.Fatalf("ParseTimezone: %v", err)
+	}
+	ms, html = firstPoint(t, WithTimezone(loc))
+	if want := time.Date(2023, 1, 1, 16, 59, 59, 0, time.UTC).UnixMilli(); ms != float64(want) {
+		t.Errorf("expected the wall clock at -05:00, got %v", time.UnixMilli(int64(ms)).UTC())
+	}
+	if !strings.Contains(html, "Times: UTC-05:00") {
+		t.Error("expected the chosen timezone in the header")
+	}
+}
+
+// TestGenerateReport_Summary shows the saturation summary, honouring the
+// thresholds given.
+func TestGenerateReport_Summary(t *testing.T) {
+	render := func(t *testing.T, opts ...Option) string {
+		parsed := makeDummyParsedData()
+		parsed.CPUs[1].Iowait = 15
+		parsed.Devices["sda"][1].QueueSize = 2
+		out := filepath.Join(t.TempDir(), "summary.html")
+		if err := GenerateReport(parsed, out, "Summary", "", "f.log", "hashhash", "", opts...); err != nil {
+			t.Fatalf("GenerateReport failed: %v", err)
+		}
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatalf("reading output: %v", err)
+		}
+		html := string(data)
+		start := strings.Index(html, `id="summary"`)
+		if start < 0 {
+			t.Fatal("expected a saturation summary")
+		}
+		end := strings.Index(html[start:], "Total CPU Usage")
+		if end < 0 {
+			t.Fatal("expected the summary before the CPU chart")
+		}
+		return html[start : start+end]
+	}
+
+	summary := render(t)
+	for _, want := range []string{"iowait &gt; 10%", "50.0%", "aqu-sz &gt; 1", "<td>sda</td>"} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("summary lacks %q", want)
+		}
+	}
+
+	summary = render(t, WithThresholds(analysis.Thresholds{Iowait: 20, CPUBusyLow: 50, CPUBusy: 90, QueueDepth: 5}))
+	if !strings.Contains(summary, "iowait &gt; 20%") || strings.Contains(summary, "50.0%") {
+		t.Error("expected the summary to use the given thresholds")
+	}
+}
+
+// TestGenerateReport_Streamed counts the summary, findings and cap usage from
+// every streamed sample, not from the points averaged down for the charts.
+func TestGenerateReport_Streamed(t *testing.T) {
+	vol, err := cloud.ParseVolume("gp3:16iops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := []Option{WithVolumes(map[string]cloud.Volume{"sda": vol})}
+	start := time.Date(2024, 9, 4, 12, 0, 0, 0, time.UTC)
+	collector := parser.NewCollector(4)
+	streamed, err := NewStreamed(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		// every other sample waits on I/O with a deep queue, at the IOPS cap
+		busy := float64(i % 2)
+		ts := start.Add(time.Duration(i) * time.Second)
+		s := parser.Sample{
+			Timestamp: ts,
+			CPU:       &parser.CPUStats{Timestamp: ts, User: 10, Iowait: 20 * busy, Idle: 90 - 20*busy},
+			Devices:   []parser.DeviceStats{{Timestamp: ts, Name: "sda", TransfersPerSec: 20 * busy, AwaitMs: 1, QueueSize: 2 * busy}},
+		}
+		for _, add := range []func(parser.Sample) error{streamed.Add, collector.Add} {
+			if err := add(s); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	render := func(t *testing.T, h Host) string {
+		out := filepath.Join(t.TempDir(), "summary.html")
+		if err := GenerateHostsReport([]Host{h}, []File{{Name: "f.log", Hash: "hashhash"}}, out, "Summary", "", "", opts...); err != nil {
+			t.Fatalf("GenerateHostsReport failed: %v", err)
+		}
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatalf("reading output: %v", err)
+		}
+		return string(data)
+	}
+	summary := func(t *testing.T, html string) string {
+		start := strings.Index(html, `id="summary"`)
+		end := strings.Index(html, `id="volumes"`)
+		if start < 0 || end < start {
+			t.Fatal("expected a saturation summary before the caps table")
+		}
+		return html[start:end]
+	}
+	volumes := func(t *testing.T, html string) string {
+		start := strings.Index(html, `id="volumes"`)
+		if start < 0 {
+			t.Fatal("expected a cloud volume caps table")
+		}
+		return html[start : start+strings.Index(html[start:], "</table>")]
+	}
+
+	data := collector.Data(parser.StreamInfo{})
+	averaged := render(t, Host{Data: data})
+	if strings.Contains(summary(t, averaged), "50.0%") || strings.Contains(averaged, "the host was I/O bound") {
+		t.Error("expected the averaged points to hide the saturated samples")
+	}
+	if table := volumes(t, averaged); strings.Contains(table, "50.0%") || !strings.Contains(table, "<td>10</td>") {
+		t.Errorf("expected the averaged points to stay below the cap, got %s", table)
+	}
+	html := render(t, Host{Data: data, Streamed: streamed})
+	if got := summary(t, html); strings.Count(got, "50.0%") != 2 {
+		t.Errorf("expected iowait and the queue saturated half the time, got %s", got)
+	}
+	if !strings.Contains(html, "the host was I/O bound 50% of the time") {
+		t.Error("expected the iowait finding of the streamed samples")
+	}
+	if table := volumes(t, html); !strings.Contains(table, "50.0%") || !strings.Contains(table, "<td>20</td>") {
+		t.Errorf("expected sda at its IOPS cap half the time, peaking at 20, got %s", table)
+	}
+
+	// a CPU-only capture leaves the CPU to the one with devices, as parser.Merge does
+	cpuOnly, err := NewStreamed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cpuOnly.Add(parser.Sample{CPU: &parser.CPUStats{Iowait: 90}}); err != nil {
+		t.Fatal(err)
+	}
+	if n := MergeStreamed(streamed, cpuOnly).summary.Summary().CPUSamples; n != 20 {
+		t.Errorf("expected the 20 CPU samples of the device capture, got %d", n)
+	}
+}
+
+// TestGenerateReport_DeviceStatistics renders the sortable statistics table
+// of each device, preferring statistics streamed before averaging.
+func TestGenerateReport_DeviceStatistics(t *testing.T) {
+	render := func(t *testing.T, h Host) string {
+		out := filepath.Join(t.TempDir(), "stats.html")
+		if err := GenerateHostsReport([]Host{h}, []File{{Name: "f.log", Hash: "hashhash"}}, out, "Stats", "", ""); err != nil {
+			t.Fatalf("GenerateHostsReport failed: %v", err)
+		}
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatalf("reading output: %v", err)
+		}
+		return string(data)
+	}
+
+	html := render(t, Host{Data: makeDummyParsedData()})
+	if !strings.Contains(html, `sortable mb-0" id="dev_sda_chart_stats"`) {
+		t.Error("expected a sortable statistics table for sda")
+	}
+	// write latency 1.5 and 0.8 ms
+	for _, want := range []string{"Write await", `data-value="1.5">1.50`, "p99"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("statistics lack %q", want)
+		}
+	}
+
+	streamed, err := NewStreamed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := streamed.Add(parser.Sample{Devices: []parser.DeviceStats{{Name: "sda", WritesPerSec: 1, WriteAwaitMs: 42}}}); err != nil {
+		t.Fatal(err)
+	}
+	html = render(t, Host{Data: makeDummyParsedData(), Streamed: streamed})
+	if !strings.Contains(html, `data-value="42">42.00`) {
+		t.Error("expected the streamed statistics")
+	}
+}
+
+func TestParseTimezone(t *testing.T) {
+	for _, s := range []string{"", "local", "UTC", "+02:00", "-05:30"} {
+		if _, err := ParseTimezone(s); err != nil {
+			t.Errorf("ParseTimezone(%q): %v", s, err)
+		}
+	}
+	for _, s := range []string{"Mars/Olympus", "+25:00"} {
+		if _, err := ParseTimezone(s); err == nil {
+			t.Errorf("ParseTimezone(%q): expected an error", s)
+		}
+	}
+}
+
+// TestGenerateHostsReport renders a section per host and lists every input file.
+func TestGenerateHostsReport(t *testing.T) {
+	a := makeDummyParsedData()
+	a.Host = parser.HostInfo{Hostname: "node-a"}
+	b := makeDummyParsedData()
+	b.Host = parser.HostInfo{Hostname: "node-b"}
+	hosts := []Host{{Name: "node-a/iostat.txt", Data: a}, {Name: "node-b/iostat.txt", Data: b}}
+	files := []File{{Name: "node-a/iostat.txt", Hash: "aaaaaaaa11"}, {Name: "node-b/iostat.txt", Hash: "bbbbbbbb22"}}
+	out := filepath.Join(t.TempDir(), "hosts.html")
+	if err := GenerateHostsReport(hosts, files, out, "Cluster", "", "v1"); err != nil {
+		t.Fatalf("GenerateHostsReport failed: %v", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	html := string(data)
+
+	for _, want := range []string{
+		"File: node-a/iostat.txt", "File: node-b/iostat.txt", "aaaaaa..", "bbbbbb..",
+		`echo "aaaaaaaa11  node-a/iostat.txt" | shasum -a 256 -c --`,
+		`echo "bbbbbbbb22  node-b/iostat.txt" | shasum -a 256 -c --`,
+		"Host: node-a", "Host: node-b",
+		`id="cpuChart"`, `id="host1_cpuChart"`, `id="host1_dev_sda_chart"`, `id="host1_dev_sda_util_chart"`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("expected %q in output", want)
+		}
+	}
+	if n := strings.Count(html, "cpuChart.setOption("); n != 2 {
+		t.Errorf("expected a CPU chart per host, got %d", n)
+	}
+}
+
+// TestGenerateReport_Findings lists findings above the summary, linking each
+// time range to the chart that shows it.
+func TestGenerateReport_Findings(t *testing.T) {
+	render := func(t *testing.T, opts ...Option) string {
+		parsed := makeDummyParsedData()
+		parsed.Interval = time.Second
+		parsed.Devices["sda"][1].QueueSize = 2
+		out := filepath.Join(t.TempDir(), "findings.html")
+		if err := GenerateReport(parsed, out, "Findings", "", "f.log", "hashhash", "", opts...); err != nil {
+			t.Fatalf("GenerateReport failed: %v", err)
+		}
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatalf("reading output: %v", err)
+		}
+		return string(data)
+	}
+
+	html := render(t)
+	start := strings.Index(html, `id="findings"`)
+	if start < 0 || start > strings.Index(html, `id="summary"`) {
+		t.Fatal("expected findings above the summary")
+	}
+	for _, want := range []string{
+		"sda was saturated 50% of the time (aqu-sz &gt; 1)",
+		"device-saturated",
+		`data-chart="dev_sda_chart"`,
+		">2023-01-01 12:00:01<",
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("findings lack %q", want)
+		}
+	}
+
+	rules := []analysis.Rule{{Name: "busy-sda", Scope: analysis.ScopeDevice, Metric: "%util", Threshold: 10,
+		Severity: analysis.SeverityCritical, Message: "{{.Device}} busy at worst {{num .Max}}%"}}
+	html = render(t, WithRules(rules))
+	if strings.Contains(html, "device-saturated") {
+		t.Error("expected only the given rules")
+	}
+	if !strings.Contains(html, "sda busy at worst 40%") || !strings.Contains(html, `data-chart="dev_sda_util_chart"`) {
+		t.Error("expected the %util finding to link to the utilisation chart")
+	}
+	if !strings.Contains(html, ">2023-01-01 12:00:00 – 12:00:01<") {
+		t.Error("expected the range to leave out the end date")
+	}
+
+	parsed := makeDummyParsedData()
+	bad := []analysis.Rule{{Name: "bad", Scope: analysis.ScopeCPU, Metric: "steal", Severity: analysis.SeverityInfo, Message: "{{.Nope}}"}}
+	parsed.CPUs[1].Steal = 5
+	if err := GenerateReport(parsed, filepath.Join(t.TempDir(), "bad.html"), "Bad", "", "f.log", "hashhash", "", WithRules(bad)); err == nil {
+		t.Error("expected a broken message to fail the report")
+	}
+}
+
+// TestGenerateReport_Volumes charts the totals of a device on a cloud volume
+// against its caps and sums up the time spent at them.
+func TestGenerateReport_Volumes(t *testing.T) {
+	vol, err := cloud.ParseVolume("gp3:5iops:3MBps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "volumes.html")
+	if err := GenerateReport(makeDummyParsedData(), out, "Volumes", "", "f.log", "hashhash", "", WithVolumes(map[string]cloud.Volume{"sda": vol})); err != nil {
+		t.Fatalf("GenerateReport failed: %v", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("reading output: %v", err)
+	}
+	html := string(data)
+
+	// reads and writes are 3 and 7 per second, 3 and 1.5 MiB/s
+	iops := seriesData(t, html, "c.setOption(", "Total Req/s")
+	if v, ok := pointValue(iops[1]); !ok || v != 7 {
+		t.Errorf("expected total IOPS 7 at the second sample, got %v", iops[1])
+	}
+	for _, want := range []string{`"name":"cap 5 IOPS","yAxis":5`, `"name":"cap 3 MiB/s","yAxis":3`, `"Total MB/s"`} {
+		if !strings.Contains(html, want) {
+			t.Errorf("device chart lacks %s", want)
+		}
+	}
+
+	start := strings.Index(html, `id="volumes"`)
+	if start < 0 {
+		t.Fatal("expected a cloud volume caps table")
+	}
+	table := html[start : start+strings.Index(html[start:], "</table>")]
+	for _, want := range []string{"<td>sda</td>", "gp3:5iops:3MBps", "50.0%", "<td>7</td>"} {
+		if !strings.Contains(table, want) {
+			t.Errorf("caps table lacks %q", want)
+		}
+	}
+	if !strings.Contains(html, `<i class="bi bi-cloud me-1"></i>gp3:5iops:3MBps`) {
+		t.Error("expected the device card to name its volume")
+	}
+
+	if err := GenerateReport(makeDummyParsedData(), out, "Volumes", "", "f.log", "hashhash", "", WithVolumes(map[string]cloud.Volume{"sda": {Type: "floppy"}})); err == nil {
+		t.Error("expected an unknown volume type to fail the report")
+	}
+}
+
+// TestGenerateReport_BurstCredits charts the simulated credits of a volume
+// that bursts and flags when they ran out.
+func TestGenerateReport_BurstCredits(t *testing.T) {
+	render := func(t *testing.T, balance float64) string {
+		parsed := makeDummyParsedData()
+		for i := range parsed.Devices["sda"] {
+			parsed.Devices["sda"][i].ReadsPerSec, parsed.Devices["sda"][i].WritesPerSec = 200, 0
+		}
+		vol, err := cloud.ParseVolume("gp2:10GiB")
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := filepath.Join(t.TempDir(), "credits.html")
+		err = GenerateReport(parsed, out, "Credits", "", "f.log", "hashhash", "",
+			WithVolumes(map[string]cloud.Volume{"sda": vol}), WithBurstBalance(balance))
+		if err != nil {
+			t.Fatalf("GenerateReport failed: %v", err)
+		}
+		data, err := os.ReadFile(out)
+		if err != nil {
+			t.Fatalf("reading output: %v", err)
+		}
+		return string(data)
+	}
+
+	// a full bucket of 5.4 million credits lasts far longer than two seconds
+	html := render(t, 100)
+	if !strings.Contains(html, `id="dev_sda_credits_chart"`) || !strings.Contains(html, `"IOPS credits %"`) {
+		t.Fatal("expected a credits chart")
+	}
+	if strings.Contains(html, "burst-credits") || strings.Contains(html, "credits depleted") {
+		t.Error("expected a full bucket not to run out")
+	}
+
+	// 5.4 credits are gone 54 ms into the second sample, spent at 100 IOPS above the baseline
+	html = render(t, 0.0001)
+	for _, want := range []string{
+		"sda (gp2:10GiB) ran out of IOPS burst credits at 2023-01-01 12:00:00",
+		`data-chart="dev_sda_credits_chart"`,
+		`"name":"IOPS credits depleted","xAxis":1672574400054`,
+		"IOPS: empty at 2023-01-01 12:00:00",
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("report lacks %s", want)
+		}
+	}
+	if strings.Index(html, "burst-credits") > strings.Index(html, `id="summary"`) {
+		t.Error("expected the depletion among the findings")
+	}
+
+	// gp3 does not burst
+	out := filepath.Join(t.TempDir(), "gp3.html")
+	if err := GenerateReport(makeDummyParsedData(), out, "Credits", "", "f.log", "hashhash", "",
+		WithVolumes(map[string]cloud.Volume{"sda": {Type: "gp3"}})); err != nil {
+		t.Fatalf("GenerateReport failed: %v", err)
+	}
+	if data, _ := os.ReadFile(out); strings.Contains(string(data), "credits_chart") {
+		t.Error("expected no credits chart for a volume that does not burst")
+	}
+}
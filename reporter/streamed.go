@@ -0,0 +1,160 @@
+package reporter
+
+import (
+	"fmt"
+
+	"github.com/rsvihladremio/iostat-reporter/analysis"
+	"github.com/rsvihladremio/iostat-reporter/cloud"
+	"github.com/rsvihladremio/iostat-reporter/parser"
+)
+
+// Streamed accumulates what the report works out from every sample of a
+// capture while it streams, before parser.Collector averages it down to the
+// points charted: averaged points smooth away the peaks and saturated
+// samples the statistics, summary, findings and cap usage count.
+type Streamed struct {
+	stats    *analysis.DeviceStatistics
+	summary  *analysis.Summarizer
+	findings *analysis.Evaluator
+	// usage counts each device on a cloud volume against its caps
+	usage map[string]*cloud.UsageCounter
+	// hasCPU and hasDevices say what the capture reports, see MergeStreamed
+	hasCPU, hasDevices bool
+}
+
+// NewStreamed returns an empty Streamed counting as the report built with
+// opts will. It fails only on an invalid rule or volume.
+func NewStreamed(opts ...Option) (*Streamed, error) {
+	cfg := newConfig(opts)
+	findings, err := analysis.NewEvaluator(cfg.rules)
+	if err != nil {
+		return nil, err
+	}
+	usage := make(map[string]*cloud.UsageCounter, len(cfg.volumes))
+	for dev, vol := range cfg.volumes {
+		if usage[dev], err = cloud.NewUsageCounter(dev, vol, cfg.burstBalance); err != nil {
+			return nil, fmt.Errorf("volume of %s: %w", dev, err)
+		}
+	}
+	// device percentiles of findings come from the statistics
+	stats := analysis.NewDeviceStatistics()
+	findings.ShareDeviceStatistics(stats)
+	return &Streamed{
+		stats:    stats,
+		summary:  analysis.NewSummarizer(cfg.thresholds),
+		findings: findings,
+		usage:    usage,
+	}, nil
+}
+
+// Add records one sample. Its signature suits parser.Stream.
+func (s *Streamed) Add(sample parser.Sample) error {
+	s.hasCPU = s.hasCPU || sample.CPU != nil
+	s.hasDevices = s.hasDevices || len(sample.Devices) > 0
+	if err := s.stats.Add(sample); err != nil {
+		return err
+	}
+	if err := s.summary.Add(sample); err != nil {
+		return err
+	}
+	for _, ds := range sample.Devices {
+		if u, ok := s.usage[ds.Name]; ok {
+			u.Add(ds)
+		}
+	}
+	return s.findings.Add(sample)
+}
+
+// MergeStreamed combines the Streamed of captures merged with parser.Merge,
+// leaving out the CPU of a capture without devices when one with devices
+// reports it, as parser.Merge does. The first part sets the thresholds,
+// rules and volumes.
+func MergeStreamed(parts ...*Streamed) *Streamed {
+	if len(parts) == 0 {
+		return nil
+	}
+	var hasDevices, devicesWithCPU bool
+	for _, p := range parts {
+		hasDevices = hasDevices || p.hasDevices
+		devicesWithCPU = devicesWithCPU || p.hasDevices && p.hasCPU
+	}
+	out := &Streamed{
+		stats:    analysis.NewDeviceStatistics(),
+		summary:  analysis.NewSummarizer(parts[0].summary.Thresholds()),
+		findings: parts[0].findings.Empty(),
+		usage:    make(map[string]*cloud.UsageCounter, len(parts[0].usage)),
+	}
+	out.findings.ShareDeviceStatistics(out.stats)
+	for dev, u := range parts[0].usage {
+		merged := *u
+		for _, p := range parts[1:] {
+			merged.Merge(p.usage[dev])
+		}
+		out.usage[dev] = &merged
+	}
+	for _, p := range parts {
+		primary := !hasDevices || p.hasDevices
+		withCPU := primary || !devicesWithCPU
+		out.stats.Merge(p.stats)
+		out.summary.Merge(p.summary, withCPU)
+		out.findings.Merge(p.findings, withCPU)
+		out.hasCPU = out.hasCPU || withCPU && p.hasCPU
+		out.hasDevices = out.hasDevices || p.hasDevices
+	}
+	return out
+}
+
+// device returns the statistics of a streamed device.
+func (s *Streamed) device(name string) (analysis.DeviceMetrics, bool) {
+	if s == nil {
+		return analysis.DeviceMetrics{}, false
+	}
+	return s.stats.Device(name)
+}
+
+// summarize works out the Summary of the devices charted, data holding their
+// averaged-down samples. The CPU and each device streamed on its own are
+// counted from the streamed samples; a device rolled up from others, never
+// streamed, from data.
+func (s *Streamed) summarize(data parser.ParsedData, captured map[string][]parser.DeviceStats, t analysis.Thresholds) analysis.Summary {
+	sum := analysis.Summarize(data, t)
+	if s == nil {
+		return sum
+	}
+	streamed := s.summary.Summary()
+	sum.CPUSamples, sum.IowaitPct = streamed.CPUSamples, streamed.IowaitPct
+	sum.CPUBusyLowPct, sum.CPUBusyPct = streamed.CPUBusyLowPct, streamed.CPUBusyPct
+	for i, d := range sum.Devices {
+		if _, ok := captured[d.Name]; !ok {
+			continue
+		}
+		if sd, ok := s.summary.Device(d.Name); ok {
+			sum.Devices[i] = sd
+		}
+	}
+	return sum
+}
+
+// evaluate checks the rules against the devices charted, data holding their
+// averaged-down samples. The CPU, vmstat and each device streamed on its own
+// are judged on the streamed samples; a device rolled up from others, never
+// streamed, on data.
+func (s *Streamed) evaluate(data parser.ParsedData, rules []analysis.Rule) ([]analysis.Finding, error) {
+	if s == nil {
+		return analysis.Evaluate(data, rules)
+	}
+	return s.findings.Findings(data.Devices)
+}
+
+// capUsage returns the CapUsage of dev on vol, whose burst buckets start
+// balancePct full, stats holding its averaged-down samples. A device
+// streamed on its own is counted from the streamed samples; a device rolled
+// up from others, never streamed, from stats.
+func (s *Streamed) capUsage(dev string, vol cloud.Volume, stats []parser.DeviceStats, balancePct float64) (cloud.CapUsage, error) {
+	if _, streamed := s.device(dev); streamed {
+		if u, ok := s.usage[dev]; ok {
+			return u.Usage(), nil
+		}
+	}
+	return cloud.Usage(dev, vol, stats, balancePct)
+}
@@ -0,0 +1,183 @@
+package reporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/rsvihladremio/iostat-reporter/parser"
+)
+
+// vmCharts builds the run queue and memory charts from vmstat reports.
+func vmCharts(data parser.ParsedData, cfg config, span timeSpan) (template.JS, template.JS, error) {
+	n := len(data.VM)
+	times := make([]time.Time, n)
+	sinceBoot := make([]bool, n)
+	running := make([]float64, n)
+	blocked := make([]float64, n)
+	switches := make([]float64, n)
+	interrupts := make([]float64, n)
+	free := make([]float64, n)
+	buffers := make([]float64, n)
+	cache := make([]float64, n)
+	swapUsed := make([]float64, n)
+	swapIn := make([]float64, n)
+	swapOut := make([]float64, n)
+	for i, vm := range data.VM {
+		times[i] = vm.Timestamp
+		sinceBoot[i] = vm.SinceBoot
+		running[i] = vm.Running
+		blocked[i] = vm.Blocked
+		switches[i] = vm.ContextSwitchesPerSec
+		interrupts[i] = vm.InterruptsPerSec
+		free[i] = vm.FreeKB / 1024.0
+		buffers[i] = vm.BuffersKB / 1024.0
+		cache[i] = vm.CacheKB / 1024.0
+		swapUsed[i] = vm.SwapUsedKB / 1024.0
+		swapIn[i] = vm.SwapInKBPerSec / 1024.0
+		swapOut[i] = vm.SwapOutKBPerSec / 1024.0
+	}
+	line := newTimeSeries(times, data.Segments, cfg.zone)
+
+	queueOption := map[string]interface{}{
+		"useUTC":  true,
+		"grid":    map[string]interface{}{"containLabel": true},
+		"tooltip": map[string]interface{}{"trigger": "axis"},
+		"legend":  map[string]interface{}{"data": []string{"Running", "Blocked", "Context Switches/s", "Interrupts/s"}, "bottom": 0},
+		"toolbox": toolbox(),
+		"xAxis":   timeAxis(span),
+		"yAxis": []map[string]interface{}{
+			{"type": "value", "name": "Processes", "minInterval": 1},
+			{"type": "value", "name": "per second", "position": "right", "splitLine": map[string]interface{}{"show": false}},
+		},
+		"series": []map[string]interface{}{
+			{"name": "Running", "type": "line", "data": line.data(running)},
+			{"name": "Blocked", "type": "line", "data": line.data(blocked)},
+			{"name": "Context Switches/s", "type": "line", "data": line.data(switches), "yAxisIndex": 1},
+			{"name": "Interrupts/s", "type": "line", "data": line.data(interrupts), "yAxisIndex": 1},
+		},
+	}
+	// a run queue above the CPU count means work waits for a CPU
+	if cpuCount := data.Host.CPUCount; cpuCount > 0 {
+		queueOption["series"].([]map[string]interface{})[1]["markLine"] = map[string]interface{}{
+			"silent":    true,
+			"symbol":    "none",
+			"lineStyle": map[string]interface{}{"type": "dotted", "color": "#888"},
+			"label":     map[string]interface{}{"formatter": "{b}", "color": "#888"},
+			"data":      []map[string]interface{}{{"name": fmt.Sprintf("%d CPUs", cpuCount), "yAxis": cpuCount}},
+		}
+	}
+
+	memOption := map[string]interface{}{
+		"useUTC":  true,
+		"grid":    map[string]interface{}{"containLabel": true},
+		"tooltip": map[string]interface{}{"trigger": "axis"},
+		"legend":  map[string]interface{}{"data": []string{"Free MB", "Buffers MB", "Cache MB", "Swap Used MB", "Swap In MB/s", "Swap Out MB/s"}, "bottom": 0},
+		"toolbox": toolbox(),
+		"xAxis":   timeAxis(span),
+		"yAxis": []map[string]interface{}{
+			{"type": "value", "name": "MB"},
+			{"type": "value", "name": "MB/s", "position": "right", "splitLine": map[string]interface{}{"show": false}},
+		},
+		"series": []map[string]interface{}{
+			{"name": "Free MB", "type": "line", "data": line.data(free)},
+			{"name": "Buffers MB", "type": "line", "data": line.data(buffers)},
+			{"name": "Cache MB", "type": "line", "data": line.data(cache)},
+			{"name": "Swap Used MB", "type": "line", "data": line.data(swapUsed)},
+			{"name": "Swap In MB/s", "type": "line", "data": line.data(swapIn), "yAxisIndex": 1},
+			{"name": "Swap Out MB/s", "type": "line", "data": line.data(swapOut), "yAxisIndex": 1},
+		},
+	}
+
+	for _, option := range []map[string]interface{}{queueOption, memOption} {
+		if cfg.sinceBoot.shades() {
+			shadeSinceBoot(option, line.ms, sinceBoot)
+		}
+		markBreaks(option, data.Segments, cfg.zone)
+	}
+
+	queueJSON, err := json.Marshal(queueOption)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to marshal run queue chart: %w", err)
+	}
+	memJSON, err := json.Marshal(memOption)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to marshal memory chart: %w", err)
+	}
+	return template.JS(queueJSON), template.JS(memJSON), nil // #nosec G203
+}
+
+// coresChart builds a chart of the busy percentage of each CPU from mpstat
+// or sar -P ALL, so that a single hot core stands out.
+func coresChart(data parser.ParsedData, cfg config, span timeSpan) (template.JS, error) {
+	cpus := make([]string, 0, len(data.Cores))
+	for cpu := range data.Cores {
+		cpus = append(cpus, cpu)
+	}
+	// numerically, so CPU 10 follows CPU 9
+	sort.Slice(cpus, func(i, j int) bool {
+		a, aerr := strconv.Atoi(cpus[i])
+		b, berr := strconv.Atoi(cpus[j])
+		if aerr != nil || berr != nil {
+			return cpus[i] < cpus[j]
+		}
+		return a < b
+	})
+
+	names := make([]string, 0, len(cpus))
+	series := make([]map[string]interface{}, 0, len(cpus))
+	for _, cpu := range cpus {
+		stats := data.Cores[cpu]
+		times := make([]time.Time, len(stats))
+		busy := make([]float64, len(stats))
+		for i, cs := range stats {
+			times[i] = cs.Timestamp
+			busy[i] = cs.BusyPct()
+		}
+		line := newTimeSeries(times, data.Segments, cfg.zone)
+		name := "CPU " + cpu
+		names = append(names, name)
+		series = append(series, map[string]interface{}{"name": name, "type": "line", "showSymbol": false, "data": line.data(busy)})
+	}
+
+	option := map[string]interface{}{
+		"useUTC":  true,
+		"grid":    map[string]interface{}{"containLabel": true},
+		"tooltip": map[string]interface{}{"trigger": "axis"},
+		"legend":  map[string]interface{}{"type": "scroll", "data": names, "bottom": 0},
+		"toolbox": toolbox(),
+		"xAxis":   timeAxis(span),
+		"yAxis": map[string]interface{}{
+			"type":      "value",
+			"name":      "% Busy",
+			"min":       0,
+			"max":       100,
+			"axisLabel": map[string]interface{}{"formatter": "{value} %"},
+		},
+		"series": series,
+	}
+	markBreaks(option, data.Segments, cfg.zone)
+
+	js, err := json.Marshal(option)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal per-CPU chart: %w", err)
+	}
+	return template.JS(js), nil // #nosec G203
+}
+
+// toolbox returns the save, zoom, data view and restore buttons of a chart.
+func toolbox() map[string]interface{} {
+	return map[string]interface{}{
+		"show": true,
+		"top":  -7,
+		"feature": map[string]interface{}{
+			"saveAsImage": map[string]interface{}{},
+			"dataZoom":    map[string]interface{}{},
+			"dataView":    map[string]interface{}{"readOnly": false},
+			"restore":     map[string]interface{}{},
+		},
+	}
+}
@@ -0,0 +1,165 @@
+package reporter
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rsvihladremio/iostat-reporter/parser"
+)
+
+// timeSeries holds the x values of a chart on a time axis and where the
+// line has to break between segments.
+type timeSeries struct {
+	ms []int64
+	// breaks[i] is set when point i starts a new segment
+	breaks []bool
+}
+
+func newTimeSeries(times []time.Time, segments []parser.Segment, zone *time.Location) timeSeries {
+	ts := timeSeries{ms: make([]int64, len(times)), breaks: make([]bool, len(times))}
+	for i, t := range times {
+		ts.ms[i] = chartMillis(t, zone)
+		if i == 0 {
+			continue
+		}
+		prev := times[i-1]
+		if t.Before(prev) {
+			ts.breaks[i] = true
+			continue
+		}
+		for _, seg := range segments {
+			if seg.Break != parser.BreakNone && seg.Start.After(prev) && !seg.Start.After(t) {
+				ts.breaks[i] = true
+				break
+			}
+		}
+	}
+	return ts
+}
+
+// chartMillis returns the wall clock of t in zone, or in t's own zone when
+// zone is nil, as milliseconds since the epoch. Charts run with useUTC so
+// every viewer sees the same times whatever their browser's timezone.
+func chartMillis(t time.Time, zone *time.Location) int64 {
+	if zone != nil {
+		t = t.In(zone)
+	}
+	_, offset := t.Zone()
+	return t.UnixMilli() + int64(offset)*1000
+}
+
+// timeSpan is the first and last time charted for a host, in chart
+// milliseconds. Every chart of a section spans it, so iostat, vmstat and
+// mpstat charts line up.
+type timeSpan struct {
+	min, max int64
+	ok       bool
+}
+
+// sectionSpan returns the time span of every series in data.
+func sectionSpan(data parser.ParsedData, zone *time.Location) timeSpan {
+	var span timeSpan
+	add := func(t time.Time) {
+		ms := chartMillis(t, zone)
+		if !span.ok || ms < span.min {
+			span.min = ms
+		}
+		if !span.ok || ms > span.max {
+			span.max = ms
+		}
+		span.ok = true
+	}
+	for _, cs := range data.CPUs {
+		add(cs.Timestamp)
+	}
+	for _, stats := range data.Devices {
+		for _, ds := range stats {
+			add(ds.Timestamp)
+		}
+	}
+	for _, vm := range data.VM {
+		add(vm.Timestamp)
+	}
+	for _, stats := range data.Cores {
+		for _, cs := range stats {
+			add(cs.Timestamp)
+		}
+	}
+	return span
+}
+
+// timeAxis returns the x axis of every chart over span: day boundaries are
+// labelled with the date, everything else with the time of day.
+func timeAxis(span timeSpan) map[string]interface{} {
+	axis := map[string]interface{}{
+		"type": "time",
+		"axisLabel": map[string]interface{}{
+			"hideOverlap": true,
+			"formatter": map[string]interface{}{
+				"year":   "{yyyy}-{MM}-{dd}",
+				"month":  "{yyyy}-{MM}-{dd}",
+				"day":    "{yyyy}-{MM}-{dd}",
+				"hour":   "{HH}:{mm}",
+				"minute": "{HH}:{mm}",
+				"second": "{HH}:{mm}:{ss}",
+			},
+		},
+	}
+	if span.ok {
+		axis["min"], axis["max"] = span.min, span.max
+	}
+	return axis
+}
+
+// data pairs vals with their timestamps, with a null point before each break
+// so ECharts does not join the segments.
+func (ts timeSeries) data(vals []float64) []interface{} {
+	out := make([]interface{}, 0, len(vals))
+	for i, v := range vals {
+		if ts.breaks[i] {
+			out = append(out, []interface{}{ts.ms[i], nil})
+		}
+		out = append(out, []interface{}{ts.ms[i], v})
+	}
+	return out
+}
+
+// markBreaks draws a dashed line where each segment after the first starts,
+// labelled with the reason.
+func markBreaks(option map[string]interface{}, segments []parser.Segment, zone *time.Location) {
+	var lines []map[string]interface{}
+	for _, seg := range segments {
+		if seg.Break == parser.BreakNone {
+			continue
+		}
+		lines = append(lines, map[string]interface{}{"name": breakLabel(seg), "xAxis": chartMillis(seg.Start, zone)})
+	}
+	series, ok := option["series"].([]map[string]interface{})
+	if len(lines) == 0 || !ok || len(series) == 0 {
+		return
+	}
+	series[0]["markLine"] = map[string]interface{}{
+		"silent":    true,
+		"symbol":    "none",
+		"lineStyle": map[string]interface{}{"type": "dashed", "color": "#c0392b"},
+		"label":     map[string]interface{}{"formatter": "{b}", "color": "#c0392b"},
+		"data":      lines,
+	}
+}
+
+// breakLabel describes why a segment starts, e.g. "gap 5m0s".
+func breakLabel(seg parser.Segment) string {
+	switch seg.Break {
+	case parser.BreakGap, parser.BreakOverlap, parser.BreakClockJump:
+		return fmt.Sprintf("%s %s", seg.Break, seg.Gap)
+	}
+	return seg.Break.String()
+}
+
+// displayTime formats t in zone, or in its own zone when zone is nil.
+func displayTime(t time.Time, zone *time.Location) string {
+	if zone != nil {
+		t = t.In(zone)
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
@@ -0,0 +1,198 @@
+package reporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"math"
+	"time"
+
+	"github.com/rsvihladremio/iostat-reporter/analysis"
+	"github.com/rsvihladremio/iostat-reporter/cloud"
+	"github.com/rsvihladremio/iostat-reporter/parser"
+)
+
+// volumeTotals returns the IOPS and MiB/s of stats as counted against the
+// caps of a cloud volume.
+func volumeTotals(stats []parser.DeviceStats) (iops, mbps []float64) {
+	iops = make([]float64, len(stats))
+	mbps = make([]float64, len(stats))
+	for i, ds := range stats {
+		iops[i] = cloud.IOPS(ds)
+		mbps[i] = cloud.ThroughputMBps(ds)
+	}
+	return iops, mbps
+}
+
+// limitLines marks the baseline and, for volumes that burst, the burst cap
+// of a volume on the axis of the series they are added to.
+func limitLines(baseline, burst float64, unit string) map[string]interface{} {
+	var lines []map[string]interface{}
+	if baseline > 0 {
+		lines = append(lines, map[string]interface{}{
+			"name":  fmt.Sprintf("cap %g %s", baseline, unit),
+			"yAxis": baseline,
+		})
+	}
+	if burst > baseline {
+		lines = append(lines, map[string]interface{}{
+			"name":      fmt.Sprintf("burst %g %s", burst, unit),
+			"yAxis":     burst,
+			"lineStyle": map[string]interface{}{"type": "dotted"},
+		})
+	}
+	return map[string]interface{}{
+		"silent":    true,
+		"symbol":    "none",
+		"lineStyle": map[string]interface{}{"type": "solid", "color": "#e67e22", "width": 2},
+		"label":     map[string]interface{}{"formatter": "{b}", "position": "insideEndTop", "color": "#e67e22"},
+		"data":      lines,
+	}
+}
+
+// mbNames returns the throughput series of a device chart, with the total
+// for a device on a cloud volume.
+func mbNames(onVolume bool) []string {
+	if onVolume {
+		return []string{"Read MB/s", "Write MB/s", "Total MB/s"}
+	}
+	return []string{"Read MB/s", "Write MB/s"}
+}
+
+// creditsMetric marks the findings of burst-credit simulations, which link
+// to the credits chart.
+const creditsMetric = "credits"
+
+// volumeRow is a device of the cloud volume caps table.
+type volumeRow struct {
+	cloud.CapUsage
+	Credits []creditRow
+}
+
+// creditRow is how one burst bucket of a volume fared.
+type creditRow struct {
+	Dimension cloud.Dimension
+	MinPct    float64
+	// Depleted is when the bucket first ran empty, empty if it never did
+	Depleted string
+}
+
+// simulateCredits replays stats through the burst buckets of vol, each
+// starting balancePct full.
+func simulateCredits(vol cloud.Volume, stats []parser.DeviceStats, segments []parser.Segment, balancePct float64) ([]cloud.Simulation, error) {
+	buckets, err := vol.Buckets()
+	if err != nil {
+		return nil, err
+	}
+	sims := make([]cloud.Simulation, 0, len(buckets))
+	for _, b := range buckets {
+		sims = append(sims, cloud.Simulate(b, b.Capacity*balancePct/100, stats, segments))
+	}
+	return sims, nil
+}
+
+// creditRows sums up sims for the caps table.
+func creditRows(sims []cloud.Simulation, zone *time.Location) []creditRow {
+	rows := make([]creditRow, 0, len(sims))
+	for _, sim := range sims {
+		row := creditRow{Dimension: sim.Bucket.Dimension, MinPct: sim.MinBalance / sim.Bucket.Capacity * 100}
+		if !sim.Depleted.IsZero() {
+			row.Depleted = displayTime(sim.Depleted, zone)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// creditFindings flags each bucket of a device that ran empty, from the
+// moment it did until it earned credits again.
+func creditFindings(device string, vol cloud.Volume, sims []cloud.Simulation, zone *time.Location) []analysis.Finding {
+	var findings []analysis.Finding
+	for _, sim := range sims {
+		if sim.Depleted.IsZero() {
+			continue
+		}
+		empty := analysis.TimeRange{Start: sim.Depleted, End: sim.Depleted}
+		for _, p := range sim.Points {
+			if p.Timestamp.Before(sim.Depleted) {
+				continue
+			}
+			if p.Balance > 0 {
+				break
+			}
+			empty.End = p.Timestamp
+		}
+		findings = append(findings, analysis.Finding{
+			Rule:     "burst-credits",
+			Severity: analysis.SeverityCritical,
+			Scope:    analysis.ScopeDevice,
+			Metric:   creditsMetric,
+			Device:   device,
+			Message: fmt.Sprintf("%s (%s) ran out of %s burst credits at %s, so it was held to its baseline of %g %s",
+				device, vol, sim.Bucket.Dimension, displayTime(sim.Depleted, zone), sim.Bucket.Baseline, sim.Bucket.Dimension),
+			Ranges: []analysis.TimeRange{empty},
+		})
+	}
+	return findings
+}
+
+// creditsChart charts the balance of each burst bucket of a device as a
+// share of its capacity, with a line where it ran empty.
+func creditsChart(sims []cloud.Simulation, segments []parser.Segment, cfg config, span timeSpan) (template.JS, error) {
+	names := make([]string, 0, len(sims))
+	series := make([]map[string]interface{}, 0, len(sims))
+	for _, sim := range sims {
+		times := make([]time.Time, len(sim.Points))
+		pcts := make([]float64, len(sim.Points))
+		for i, p := range sim.Points {
+			times[i] = p.Timestamp
+			pcts[i] = math.Round(p.Pct*100) / 100
+		}
+		name := fmt.Sprintf("%s credits %%", sim.Bucket.Dimension)
+		names = append(names, name)
+		series = append(series, map[string]interface{}{
+			"name": name, "type": "line", "showSymbol": false,
+			"data": newTimeSeries(times, segments, cfg.zone).data(pcts),
+		})
+	}
+	option := map[string]interface{}{
+		"useUTC":  true,
+		"grid":    map[string]interface{}{"containLabel": true},
+		"tooltip": map[string]interface{}{"trigger": "axis"},
+		"legend":  map[string]interface{}{"data": names, "bottom": 0},
+		"toolbox": toolbox(),
+		"xAxis":   timeAxis(span),
+		"yAxis":   map[string]interface{}{"type": "value", "name": "% credits", "min": 0, "max": 100},
+		"series":  series,
+	}
+	markBreaks(option, segments, cfg.zone)
+
+	// each depletion joins the break lines of its series
+	for i, sim := range sims {
+		if sim.Depleted.IsZero() {
+			continue
+		}
+		markLine, ok := series[i]["markLine"].(map[string]interface{})
+		if !ok {
+			markLine = map[string]interface{}{
+				"silent": true,
+				"symbol": "none",
+				"label":  map[string]interface{}{"formatter": "{b}"},
+				"data":   []map[string]interface{}{},
+			}
+			series[i]["markLine"] = markLine
+		}
+		markLine["data"] = append(markLine["data"].([]map[string]interface{}), map[string]interface{}{
+			"name":      fmt.Sprintf("%s credits depleted", sim.Bucket.Dimension),
+			"xAxis":     chartMillis(sim.Depleted, cfg.zone),
+			"lineStyle": map[string]interface{}{"type": "solid", "color": "#c0392b", "width": 2},
+			"label":     map[string]interface{}{"color": "#c0392b"},
+		})
+	}
+
+	js, err := json.Marshal(option)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal credits chart: %w", err)
+	}
+	return template.JS(js), nil // #nosec G203
+}